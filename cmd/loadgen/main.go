@@ -0,0 +1,111 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/gw-tester/pgw/internal/simulators/loadgen"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type arguments struct {
+	Log          logLevel      `arg:"env:LOG_LEVEL" default:"info" help:"Defines the level of logging for this program."`
+	PgwAddress   string        `arg:"env:PGW_ADDRESS,required" help:"Specifies the P-GW S5-C address."`
+	LocalAddress string        `arg:"env:LOCAL_ADDRESS" default:"0.0.0.0:2123" help:"Defines the simulated S-GW S5-C address."`
+	AttachRate   float64       `arg:"env:ATTACH_RATE" default:"10" help:"Defines the Create Session Requests sent per second."`
+	DetachRate   float64       `arg:"env:DETACH_RATE" default:"10" help:"Defines the Delete Session Requests sent per second."`
+	MaxSessions  int           `arg:"env:MAX_SESSIONS" help:"Limits the number of attached subscribers (0 means no limit)."`
+	Duration     time.Duration `arg:"env:DURATION" default:"1m" help:"Defines how long the load is generated."`
+	Timeout      time.Duration `arg:"env:TIMEOUT" default:"3s" help:"Defines how long a response is awaited."`
+	ImsiBase     string        `arg:"env:IMSI_BASE" default:"001010000000001" help:"Defines the first synthetic IMSI."`
+	MsisdnBase   string        `arg:"env:MSISDN_BASE" default:"814000000001" help:"Defines the first synthetic MSISDN."`
+	Apn          string        `arg:"env:APN" default:"internet" help:"Defines the requested Access Point Name."`
+	UeSubnet     string        `arg:"env:UE_SUBNET" default:"10.0.0.0/16" help:"Defines the subnet of the requested UE addresses."`
+}
+
+type logLevel struct {
+	Level log.Level
+}
+
+func (n *logLevel) UnmarshalText(b []byte) error {
+	s := string(b)
+
+	logLevel, err := log.ParseLevel(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the log level")
+	}
+
+	n.Level = logLevel
+
+	return nil
+}
+
+func (arguments) Version() string {
+	return "loadgen 0.0.1"
+}
+
+func (arguments) Description() string {
+	return "this program drives attach and detach rates against a PDN Gateway over S5-C."
+}
+
+func main() {
+	var args arguments
+
+	arg.MustParse(&args)
+	log.SetLevel(args.Log.Level)
+
+	_, subnet, err := net.ParseCIDR(args.UeSubnet)
+	if err != nil {
+		log.WithError(err).Panic("Failed to parse the UE subnet")
+	}
+
+	sgw, err := sgwsim.New(context.Background(), args.LocalAddress, args.PgwAddress)
+	if err != nil {
+		log.WithError(err).Panic("Failed to connect to the P-GW")
+	}
+	defer sgw.Close()
+
+	sgw.Timeout = args.Timeout
+
+	generator, err := loadgen.New(sgw, &loadgen.Config{
+		AttachRate:  args.AttachRate,
+		DetachRate:  args.DetachRate,
+		Duration:    args.Duration,
+		MaxSessions: args.MaxSessions,
+		DetachAll:   true,
+		IMSIBase:    args.ImsiBase,
+		MSISDNBase:  args.MsisdnBase,
+		APN:         args.Apn,
+		Subnet:      subnet,
+	})
+	if err != nil {
+		log.WithError(err).Panic("Failed to create the load generator")
+	}
+
+	// The remaining sessions are still released when the load is interrupted
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Infof("Generating load against %s for %s", args.PgwAddress, args.Duration)
+	generator.Run(ctx).Print(os.Stdout)
+}
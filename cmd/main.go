@@ -14,60 +14,192 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	stdlog "log"
 	"time"
 
 	"github.com/InVisionApp/go-health/v2"
 	arg "github.com/alexflint/go-arg"
+	"github.com/go-redis/redis"
 	"github.com/gw-tester/ip-discover/pkg/discover"
 	"github.com/gw-tester/pgw/internal/core/domain"
 	"github.com/gw-tester/pgw/internal/core/ports"
 	service "github.com/gw-tester/pgw/internal/core/services/pgwsrv"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
+	"github.com/gw-tester/pgw/internal/repositories/aaarepo"
+	"github.com/gw-tester/pgw/internal/repositories/configrepo"
+	"github.com/gw-tester/pgw/internal/repositories/eventrepo"
 	repository "github.com/gw-tester/pgw/internal/repositories/pgwrepo"
 	router "github.com/gw-tester/pgw/internal/routers/pgwrouter"
-	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
 )
 
+const (
+	defaultEventsStream = "pgw:sessions"
+	eventsQueueCapacity = 1024
+)
+
+// arguments override the values of the configuration file, they are nil when they aren't provided.
 type arguments struct {
-	Log           logLevel `arg:"env:LOG_LEVEL" default:"info" help:"Defines the level of logging for this program."`
-	RedisURL      string   `arg:"env:REDIS_URL" help:"Specifies the Redis URL connection string."`
-	RedisPassword string   `arg:"env:REDIS_PASSWORD" help:"Specifies the Redis user password."`
-	EtcdURL       string   `arg:"env:ETCD_URL" help:"Specifies the ETCD URL connection string."`
-	S5uNetwork    string   `arg:"env:S5U_NETWORK,required" help:"Defines the S5 User plane network."`
-	S5cNetwork    string   `arg:"env:S5C_NETWORK,required" help:"Defines the S5 Control plane network."`
-	SgiNic        string   `arg:"env:SGI_NIC,required" help:"Defines the SGi network interface."`
-	SgiSubnet     string   `arg:"env:SGI_SUBNET,required" help:"Defines the SGi subnet."`
+	Config        string         `arg:"--config,env:CONFIG_FILE" help:"Specifies the YAML configuration file."`
+	Log           *string        `arg:"env:LOG_LEVEL" help:"Defines the level of logging for this program [default: info]."`
+	LogFormat     *string        `arg:"env:LOG_FORMAT" help:"Defines the format of logging for this program (text or json) [default: text]."`
+	RedisURL      *string        `arg:"env:REDIS_URL" help:"Specifies the Redis URL connection string."`
+	RedisPassword *string        `arg:"env:REDIS_PASSWORD" help:"Specifies the Redis user password."`
+	EtcdURL       *string        `arg:"env:ETCD_URL" help:"Specifies the ETCD URL connection string."`
+	S5uNetwork    *string        `arg:"env:S5U_NETWORK" help:"Defines the S5 User plane network (required by combined and user functions)."`
+	S5cNetwork    *string        `arg:"env:S5C_NETWORK" help:"Defines the S5 Control plane network (required by combined and control functions)."`
+	SgiNic        *string        `arg:"env:SGI_NIC" help:"Defines the SGi network interface (required)."`
+	SgiSubnet     *string        `arg:"env:SGI_SUBNET" help:"Defines the SGi subnet (required)."`
+	AaaURL        *string        `arg:"env:AAA_URL" help:"Specifies the RADIUS server address used for subscriber authentication."`
+	AaaSecret     *string        `arg:"env:AAA_SECRET" help:"Specifies the RADIUS shared secret."`
+	AaaApns       []string       `arg:"env:AAA_APNS" help:"Defines the APNs which require subscriber authentication."`
+	Datapath      *string        `arg:"env:DATAPATH" help:"Defines the User plane datapath mode (kernel, tft, userspace or auto) [default: kernel]."`
+	Function      *string        `arg:"env:PGW_FUNCTION" help:"Defines the P-GW function (combined, control or user) [default: combined]."`
+	SxbAddress    *string        `arg:"env:SXB_ADDRESS" help:"Defines the PFCP Sxb listening address [default: :8805]."`
+	SxbPeer       *string        `arg:"env:SXB_PEER" help:"Specifies the PGW-U PFCP address used by the control function."`
+	Traces        *string        `arg:"env:TRACES_EXPORTER" help:"Defines the exporter of the GTP-C transaction spans (none, otlp or file) [default: none]."`
+	TracesTarget  *string        `arg:"env:TRACES_ENDPOINT" help:"Specifies the OTLP/HTTP collector endpoint or the file where the spans are written."`
+	CaptureDir    *string        `arg:"env:CAPTURE_DIR" help:"Defines the directory of the packet capture files [default: /tmp/captures]."`
+	CaptureSize   *int64         `arg:"env:CAPTURE_FILE_SIZE" help:"Defines the size in bytes which rotates the packet capture files [default: 10485760]."`
+	CaptureFiles  *int           `arg:"env:CAPTURE_FILES" help:"Defines the number of packet capture files kept [default: 5]."`
+	EventsSink    *string        `arg:"env:EVENTS_SINK" help:"Defines the sink of the session events (none, redis, webhook or file) [default: none]."`
+	EventsTarget  *string        `arg:"env:EVENTS_TARGET" help:"Specifies the Redis stream, the webhook URL or the file of the session events."`
+	DrainTimeout  *time.Duration `arg:"env:DRAIN_TIMEOUT" help:"Defines the time given to the sessions for being drained on SIGTERM [default: 20s]."`
+	DrainDelete   *bool          `arg:"env:DRAIN_DELETE_BEARERS" help:"Sends Delete Bearer Requests for the active sessions on SIGTERM."`
 }
 
-type logLevel struct {
-	Level log.Level
+// apply overrides the values of the configuration with the provided arguments.
+func (a arguments) apply(config *configrepo.Config) {
+	for _, override := range []struct {
+		value *string
+		field *string
+	}{
+		{a.Log, &config.Log.Level},
+		{a.LogFormat, &config.Log.Format},
+		{a.RedisURL, &config.Datastore.RedisURL},
+		{a.RedisPassword, &config.Datastore.RedisPassword},
+		{a.EtcdURL, &config.Datastore.EtcdURL},
+		{a.S5uNetwork, &config.Networks.S5u},
+		{a.S5cNetwork, &config.Networks.S5c},
+		{a.SgiNic, &config.Sgi.Nic},
+		{a.SgiSubnet, &config.Sgi.Subnet},
+		{a.AaaURL, &config.Aaa.URL},
+		{a.AaaSecret, &config.Aaa.Secret},
+		{a.Datapath, &config.Datapath},
+		{a.Function, &config.Function},
+		{a.SxbAddress, &config.Sxb.Address},
+		{a.SxbPeer, &config.Sxb.Peer},
+		{a.Traces, &config.Traces.Exporter},
+		{a.TracesTarget, &config.Traces.Endpoint},
+		{a.CaptureDir, &config.Capture.Directory},
+		{a.EventsSink, &config.Events.Sink},
+		{a.EventsTarget, &config.Events.Target},
+	} {
+		if override.value != nil {
+			*override.field = *override.value
+		}
+	}
+
+	if a.CaptureSize != nil {
+		config.Capture.FileSize = *a.CaptureSize
+	}
+
+	if a.CaptureFiles != nil {
+		config.Capture.Files = *a.CaptureFiles
+	}
+
+	if a.DrainTimeout != nil {
+		config.Drain.Timeout = *a.DrainTimeout
+	}
+
+	if a.DrainDelete != nil {
+		config.Drain.DeleteBearers = *a.DrainDelete
+	}
+
+	for _, name := range a.AaaApns {
+		config.Authenticate(name)
+	}
 }
 
-func (n *logLevel) UnmarshalText(b []byte) error {
-	s := string(b)
+// getConfig loads the configuration file, when it's provided, and applies the argument overrides.
+func getConfig(a arguments) (*configrepo.Config, error) {
+	config := configrepo.Default()
 
-	logLevel, err := log.ParseLevel(s)
-	if err != nil {
-		return errors.Wrap(err, "failed to parse the log level")
+	if a.Config != "" {
+		var err error
+		if config, err = configrepo.Load(a.Config); err != nil {
+			return nil, err
+		}
 	}
 
-	n.Level = logLevel
+	a.apply(config)
 
-	return nil
+	return config, config.Validate()
 }
 
-func getRepository(a arguments) ports.IPRepository {
-	if a.RedisURL != "" {
-		return repository.NewRedis(a.RedisURL, a.RedisPassword)
+func getRepository(config *configrepo.Config, redisClient *redis.Client) ports.IPRepository {
+	if redisClient != nil {
+		return repository.NewRedis(redisClient)
 	}
 
-	if a.EtcdURL != "" {
-		return repository.NewETCD(a.EtcdURL)
+	if config.Datastore.EtcdURL != "" {
+		return repository.NewETCD(config.Datastore.EtcdURL)
 	}
 
 	return repository.NewMemKVS()
 }
 
+func getEventPublisher(config *configrepo.Config, redisClient *redis.Client) ports.EventPublisher {
+	var (
+		sink ports.EventPublisher
+		err  error
+	)
+
+	switch config.Events.Sink {
+	case "none":
+		return nil
+	case "redis":
+		stream := config.Events.Target
+		if stream == "" {
+			stream = defaultEventsStream
+		}
+
+		sink = eventrepo.NewRedisStream(redisClient, stream)
+	case "webhook":
+		sink = eventrepo.NewWebhook(config.Events.Target)
+	case "file":
+		if sink, err = eventrepo.NewFile(config.Events.Target); err != nil {
+			log.WithError(err).Panic("Failed to create the session events sink")
+		}
+	}
+
+	return eventrepo.NewAsync(sink, eventsQueueCapacity)
+}
+
+// getAuthenticator creates the RADIUS client, the configuration validation ensures that it's
+// provided when an APN requires authentication.
+func getAuthenticator(config *configrepo.Config) ports.Authenticator {
+	if config.Aaa.URL == "" {
+		return nil
+	}
+
+	return aaarepo.NewRadius(config.Aaa.URL, config.Aaa.Secret)
+}
+
+func discoverIP(network, name string) string {
+	ip, err := discover.GetIPFromNetwork(network)
+	if err != nil {
+		log.WithError(err).Panicf("Failed to discovery first IP address of %s network", name)
+	}
+
+	return ip.IP.String()
+}
+
 func (arguments) Version() string {
 	return "pgw 0.0.3"
 }
@@ -80,22 +212,63 @@ func main() {
 	var args arguments
 
 	arg.MustParse(&args)
-	log.SetLevel(args.Log.Level)
-	repository := getRepository(args)
-	service := service.New(getRepository(args))
 
-	// The discovery process requires specific order
-	s5uIP, err := discover.GetIPFromNetwork(args.S5uNetwork)
+	config, err := getConfig(args)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid configuration")
+	}
+
+	level, _ := log.ParseLevel(config.Log.Level)
+	formatter, _ := config.Log.Formatter()
+
+	log.SetLevel(level)
+	log.SetFormatter(formatter)
+
+	// The GTP libraries report the messages which can't be parsed or handled, the latter are also
+	// logged with their context by the handlers.
+	gtpv1.SetLogger(stdlog.New(log.StandardLogger().WriterLevel(log.DebugLevel), "", 0))
+	gtpv2.SetLogger(stdlog.New(log.StandardLogger().WriterLevel(log.DebugLevel), "", 0))
+
+	shutdownTracing, err := tracehdl.Setup(context.Background(), config.Traces.Exporter, config.Traces.Endpoint)
 	if err != nil {
-		log.WithError(err).Panic("Failed to discovery first IP address of S5-U network")
+		log.WithError(err).Panic("Failed to setup the tracing")
+	}
+
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.WithError(err).Warn("Failed to flush the pending spans")
+		}
+	}()
+
+	var redisClient *redis.Client
+	if config.Datastore.RedisURL != "" {
+		redisClient = repository.NewRedisClient(config.Datastore.RedisURL, config.Datastore.RedisPassword)
+	}
+
+	metrics := counterhdl.NewMetrics(prometheus.DefaultRegisterer)
+	datastore := repository.NewInstrumented(getRepository(config, redisClient), metrics.DatastoreErrors)
+	policies := repository.NewPolicies(datastore)
+	addresses := repository.NewAddresses(datastore)
+	service := service.New(datastore)
+
+	// The discovery process requires specific order
+	var s5uIP, s5cIP string
+	if config.Function != domain.FunctionControlPlane {
+		s5uIP = discoverIP(config.Networks.S5u, "S5-U")
 	}
 
-	s5cIP, err := discover.GetIPFromNetwork(args.S5cNetwork)
+	if config.Function != domain.FunctionUserPlane {
+		s5cIP = discoverIP(config.Networks.S5c, "S5-C")
+	}
+
+	pgw, err := config.NewPgw(s5cIP, s5uIP)
 	if err != nil {
-		log.WithError(err).Panic("Failed to discovery first IP address of S5-C network")
+		log.WithError(err).Fatal("Invalid P-GW configuration")
 	}
 
-	pgw := domain.New(s5cIP.IP.String(), s5uIP.IP.String(), args.SgiNic, args.SgiSubnet)
+	if err := pgw.Validate(); err != nil {
+		log.WithError(err).Fatal("Invalid P-GW configuration")
+	}
 
 	if err := service.Create(pgw); err != nil {
 		log.WithError(err).Panic("Failed to store P-GW information")
@@ -106,7 +279,7 @@ func main() {
 	if err := h.AddChecks([]*health.Config{
 		{
 			Name:     "datastore-check",
-			Checker:  repository,
+			Checker:  datastore,
 			Interval: time.Duration(2) * time.Second,
 			Fatal:    true,
 		},
@@ -114,7 +287,14 @@ func main() {
 		log.WithError(err).Warn("Add datastore check error")
 	}
 
-	router := router.New(pgw, h)
+	events := getEventPublisher(config, redisClient)
+	if events != nil {
+		defer events.Close()
+	}
+
+	reloader := configrepo.NewReloader(args.Config, config, args.apply, pgw)
+
+	router := router.New(pgw, h, getAuthenticator(config), policies, addresses, metrics, events, reloader)
 	if router == nil {
 		log.Panic("Failed to initialize P-GW service")
 	}
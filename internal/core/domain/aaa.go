@@ -0,0 +1,62 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ErrAuthenticationRejected indicates that the AAA server refused the subscriber credentials.
+var ErrAuthenticationRejected = errors.New("authentication rejected")
+
+// AuthenticationProtocol identifies the PPP protocol used by the UE to provide its credentials.
+type AuthenticationProtocol uint8
+
+const (
+	// AuthenticationNone is used when the UE didn't provide credentials.
+	AuthenticationNone AuthenticationProtocol = iota
+	// AuthenticationPAP is used for Password Authentication Protocol credentials.
+	AuthenticationPAP
+	// AuthenticationCHAP is used for Challenge Handshake Authentication Protocol credentials.
+	AuthenticationCHAP
+)
+
+// Apn stores the Access Point Name settings supported by the PDN Gateway.
+type Apn struct {
+	Name         string
+	Authenticate bool
+	// DNS servers offered to the UEs through the Protocol Configuration Options.
+	DNS []net.IP
+}
+
+// Credentials stores the subscriber information sent to the AAA server.
+type Credentials struct {
+	Protocol   AuthenticationProtocol
+	Username   string
+	Password   string
+	ChapID     uint8
+	Challenge  []byte
+	Response   []byte
+	IMSI       string
+	MSISDN     string
+	Apn        string
+	NasAddress string
+}
+
+// Authorization stores the values granted by the AAA server.
+type Authorization struct {
+	FramedIP net.IP
+}
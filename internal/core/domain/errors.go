@@ -0,0 +1,71 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Reasons of the validation errors raised for the PGW domain fields.
+var (
+	// ErrMissingValue indicates that a required field is empty.
+	ErrMissingValue = errors.New("missing value")
+	// ErrInvalidAddress indicates that a field doesn't contain an IP address.
+	ErrInvalidAddress = errors.New("invalid IP address")
+	// ErrInvalidSubnet indicates that a field doesn't contain a CIDR notation.
+	ErrInvalidSubnet = errors.New("invalid subnet")
+	// ErrLinkNotFound indicates that the network interface can't be retrieved from the host.
+	ErrLinkNotFound = errors.New("link not found")
+	// ErrOverlappingNetworks indicates that the SGi pool includes addresses of the S5 networks.
+	ErrOverlappingNetworks = errors.New("overlapping networks")
+)
+
+// ValidationError describes the PGW domain field that can't be used. Cause is the error
+// returned by the host, if any, while checking the field.
+type ValidationError struct {
+	Field string
+	Value string
+	Err   error
+	Cause error
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s %q: %s", e.Field, e.Value, e.Err)
+	if e.Value == "" {
+		msg = fmt.Sprintf("%s: %s", e.Field, e.Err)
+	}
+
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns the reason of the validation error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports every validation error as an invalid PGW domain error, and matches its cause.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidPgw || (e.Cause != nil && errors.Is(e.Cause, target))
+}
+
+// As finds the first error of the cause that matches the target.
+func (e *ValidationError) As(target interface{}) bool {
+	return e.Cause != nil && errors.As(e.Cause, target)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import "time"
+
+// Lifecycle events of the sessions.
+const (
+	// SessionCreated is published once the session was accepted and its user plane established.
+	SessionCreated = "created"
+	// SessionRejected is published when a Create Session Request isn't accepted.
+	SessionRejected = "rejected"
+	// SessionModified is published when the bearers of the session are modified or added.
+	SessionModified = "modified"
+	// SessionDeleted is published once the session was removed.
+	SessionDeleted = "deleted"
+)
+
+// SessionEvent describes a change in the lifecycle of a subscriber session.
+type SessionEvent struct {
+	Type      string
+	Time      time.Time
+	IMSI      string
+	MSISDN    string
+	APN       string
+	UEAddress string
+	SGW       string
+	EBI       uint8
+	Cause     uint8
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import "net"
+
+// Actions taken on the Create Session Requests of the peers which aren't allowed.
+const (
+	// FirewallActionReject answers the request with the Request Rejected (Reason not specified) cause.
+	FirewallActionReject = "reject"
+	// FirewallActionDrop discards the request silently.
+	FirewallActionDrop = "drop"
+)
+
+// PeerRule allows the S-GWs of the given networks to serve a PLMN.
+type PeerRule struct {
+	// PLMN is the MCC and MNC of the Serving Network, any PLMN matches when it's empty.
+	PLMN     string
+	Networks []*net.IPNet
+}
+
+// Firewall filters the S5/S8 peers which can create sessions.
+type Firewall struct {
+	Action string
+	// ValidateSender requires the Sender F-TEID address to match the source of the request.
+	ValidateSender bool
+	Peers          []*PeerRule
+}
+
+// Allows checks if the peer can create sessions in the given PLMN, every peer is allowed
+// when there are no rules.
+func (f *Firewall) Allows(peer net.IP, plmn string) bool {
+	if len(f.Peers) == 0 {
+		return true
+	}
+
+	for _, rule := range f.Peers {
+		if rule.PLMN != "" && rule.PLMN != plmn {
+			continue
+		}
+
+		for _, network := range rule.Networks {
+			if network.Contains(peer) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Drops indicates if the requests of the peers which aren't allowed are discarded silently.
+func (f *Firewall) Drops() bool {
+	return f.Action == FirewallActionDrop
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Firewall", func() {
+	var firewall *domain.Firewall
+
+	mustParseCIDR := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		Expect(err).NotTo(HaveOccurred())
+
+		return network
+	}
+
+	BeforeEach(func() {
+		firewall = &domain.Firewall{Action: domain.FirewallActionReject}
+	})
+
+	It("should allow any peer without rules", func() {
+		Expect(firewall.Allows(net.ParseIP("192.0.2.1"), "44010")).To(BeTrue())
+		Expect(firewall.Drops()).To(BeFalse())
+	})
+
+	Context("when the peers are restricted", func() {
+		BeforeEach(func() {
+			firewall.Peers = []*domain.PeerRule{
+				{PLMN: "44010", Networks: []*net.IPNet{mustParseCIDR("172.25.0.0/24")}},
+				{Networks: []*net.IPNet{mustParseCIDR("172.26.0.0/24")}},
+			}
+		})
+		It("should allow the peers of the PLMN", func() {
+			Expect(firewall.Allows(net.ParseIP("172.25.0.3"), "44010")).To(BeTrue())
+		})
+		It("should refuse the peers serving another PLMN", func() {
+			Expect(firewall.Allows(net.ParseIP("172.25.0.3"), "310260")).To(BeFalse())
+		})
+		It("should allow the peers of any PLMN", func() {
+			Expect(firewall.Allows(net.ParseIP("172.26.0.3"), "310260")).To(BeTrue())
+		})
+		It("should refuse unknown peers", func() {
+			Expect(firewall.Allows(net.ParseIP("192.0.2.1"), "44010")).To(BeFalse())
+		})
+	})
+})
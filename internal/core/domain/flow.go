@@ -0,0 +1,151 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidFlowDescription indicates that an IPFilterRule can't be decoded.
+var ErrInvalidFlowDescription = errors.New("invalid flow description")
+
+const (
+	anyAddress      = "any"
+	assignedAddress = "assigned"
+	anyProtocol     = "ip"
+)
+
+// FlowDescription encodes the downlink match of the packet filter as an IPFilterRule (RFC 6733),
+// which is the format used by the PFCP SDF filters.
+func (f *PacketFilter) FlowDescription() string {
+	protocol := anyProtocol
+	if f.Protocol != nil {
+		protocol = strconv.Itoa(int(*f.Protocol))
+	}
+
+	return fmt.Sprintf("permit out %s from %s to %s", protocol,
+		formatEndpoint(f.RemoteAddress, f.RemotePorts, anyAddress),
+		formatEndpoint(f.LocalAddress, f.LocalPorts, assignedAddress))
+}
+
+func formatEndpoint(address *net.IPNet, ports *PortRange, wildcard string) string {
+	endpoint := wildcard
+	if address != nil {
+		endpoint = address.String()
+	}
+
+	switch {
+	case ports == nil:
+		return endpoint
+	case ports.Low == ports.High:
+		return fmt.Sprintf("%s %d", endpoint, ports.Low)
+	default:
+		return fmt.Sprintf("%s %d-%d", endpoint, ports.Low, ports.High)
+	}
+}
+
+// ParseFlowDescription decodes an IPFilterRule into a downlink packet filter.
+func ParseFlowDescription(description string) (*PacketFilter, error) {
+	fields := strings.Fields(description)
+	if len(fields) < 7 || fields[0] != "permit" || fields[1] != "out" || fields[3] != "from" {
+		return nil, errors.Wrapf(ErrInvalidFlowDescription, "unsupported %q rule", description)
+	}
+
+	filter := &PacketFilter{Direction: DirectionDownlink}
+
+	if fields[2] != anyProtocol {
+		protocol, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidFlowDescription, "invalid %q protocol", fields[2])
+		}
+
+		value := uint8(protocol)
+		filter.Protocol = &value
+	}
+
+	var err error
+
+	rest := fields[4:]
+	if filter.RemoteAddress, filter.RemotePorts, rest, err = parseEndpoint(rest); err != nil {
+		return nil, err
+	}
+
+	if len(rest) < 2 || rest[0] != "to" {
+		return nil, errors.Wrapf(ErrInvalidFlowDescription, "missing destination in %q rule", description)
+	}
+
+	if filter.LocalAddress, filter.LocalPorts, rest, err = parseEndpoint(rest[1:]); err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.Wrapf(ErrInvalidFlowDescription, "unsupported %v options", rest)
+	}
+
+	return filter, nil
+}
+
+func parseEndpoint(fields []string) (*net.IPNet, *PortRange, []string, error) {
+	var address *net.IPNet
+
+	switch fields[0] {
+	case anyAddress, assignedAddress:
+	default:
+		cidr := fields[0]
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(ErrInvalidFlowDescription, "invalid %q address", fields[0])
+		}
+
+		address = network
+	}
+
+	if len(fields) < 2 || fields[1] == "to" {
+		return address, nil, fields[1:], nil
+	}
+
+	ports, err := parsePorts(fields[1])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return address, ports, fields[2:], nil
+}
+
+func parsePorts(value string) (*PortRange, error) {
+	bounds := strings.SplitN(value, "-", 2)
+
+	low, err := strconv.ParseUint(bounds[0], 10, 16)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidFlowDescription, "invalid %q port", value)
+	}
+
+	high := low
+	if len(bounds) == 2 {
+		if high, err = strconv.ParseUint(bounds[1], 10, 16); err != nil || high < low {
+			return nil, errors.Wrapf(ErrInvalidFlowDescription, "invalid %q port range", value)
+		}
+	}
+
+	return &PortRange{Low: uint16(low), High: uint16(high)}, nil
+}
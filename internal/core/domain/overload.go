@@ -0,0 +1,81 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import "time"
+
+// Default values of the overload reported to the S-GWs.
+const (
+	DefaultOverloadThreshold = 80
+	DefaultOverloadValidity  = time.Duration(1) * time.Minute
+)
+
+// Overload stores the admission limits of the new sessions and how the load is reported to the
+// S-GWs, the limits are disabled when they are zero.
+type Overload struct {
+	MaxSessions    int
+	MaxApnSessions int
+	// MaxCreateRate is the number of Create Session Requests accepted per second.
+	MaxCreateRate int
+	// MaxPending is the number of requests which can be handled at the same time, the Create
+	// Session Requests received beyond it are rejected.
+	MaxPending int
+	// Threshold is the load percentage from which the S-GWs are asked to reduce their traffic.
+	Threshold int
+	Validity  time.Duration
+}
+
+// Reports indicates if the load is reported, which requires a session or pending requests limit.
+func (o *Overload) Reports() bool {
+	return o.MaxSessions > 0 || o.MaxPending > 0
+}
+
+// Load returns the percentage of the session or pending requests limits in use, the highest of them.
+func (o *Overload) Load(sessions, pending int) int {
+	load := 0
+
+	if o.MaxSessions > 0 {
+		load = percentage(sessions, o.MaxSessions)
+	}
+
+	if o.MaxPending > 0 {
+		if queue := percentage(pending, o.MaxPending); queue > load {
+			load = queue
+		}
+	}
+
+	return load
+}
+
+// Reduction returns the percentage of traffic which the S-GWs are asked to stop sending, it grows
+// from zero at the threshold to a hundred at full load.
+func (o *Overload) Reduction(load int) int {
+	switch {
+	case load < o.Threshold:
+		return 0
+	case o.Threshold >= 100:
+		return 100
+	}
+
+	return percentage(load-o.Threshold, 100-o.Threshold)
+}
+
+// percentage returns the ratio of the values as a percentage up to a hundred.
+func percentage(value, limit int) int {
+	if value >= limit {
+		return 100
+	}
+
+	return value * 100 / limit
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain_test
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Overload", func() {
+	var overload *domain.Overload
+
+	BeforeEach(func() {
+		overload = &domain.Overload{Threshold: domain.DefaultOverloadThreshold, Validity: domain.DefaultOverloadValidity}
+	})
+
+	It("should not report the load without limits", func() {
+		Expect(overload.Reports()).To(BeFalse())
+		Expect(overload.Load(1000, 1000)).To(BeZero())
+	})
+
+	Context("when the sessions and pending requests are limited", func() {
+		BeforeEach(func() {
+			overload.MaxSessions = 200
+			overload.MaxPending = 10
+		})
+		It("should report the occupancy", func() {
+			Expect(overload.Reports()).To(BeTrue())
+			Expect(overload.Load(50, 1)).To(Equal(25))
+		})
+		It("should report the queue depth when it's higher", func() {
+			Expect(overload.Load(50, 5)).To(Equal(50))
+		})
+		It("should not exceed the full load", func() {
+			Expect(overload.Load(300, 0)).To(Equal(100))
+		})
+	})
+
+	expectReduction := func(load, reduction int) {
+		Expect(overload.Reduction(load)).To(Equal(reduction))
+	}
+
+	It("should not ask for a reduction below the threshold", func() {
+		expectReduction(79, 0)
+	})
+	It("should ask for a growing reduction above the threshold", func() {
+		expectReduction(80, 0)
+		expectReduction(90, 50)
+		expectReduction(100, 100)
+	})
+	It("should ask for a full reduction with the highest threshold", func() {
+		overload.Threshold = 100
+		expectReduction(100, 100)
+	})
+})
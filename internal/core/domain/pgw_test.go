@@ -14,12 +14,14 @@ limitations under the License.
 package domain_test
 
 import (
+	"net"
 	"strconv"
 	"strings"
 
 	"github.com/gw-tester/pgw/internal/core/domain"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
 	"github.com/wmnsk/go-gtp/gtpv2"
 )
 
@@ -27,7 +29,9 @@ var _ = Describe("Pgw", func() {
 	var pgw *domain.Pgw
 
 	BeforeEach(func() {
-		pgw = domain.New("127.0.0.1", "127.0.0.1", "lo", "10.0.0.0/24")
+		var err error
+		pgw, err = domain.New("127.0.0.1", "127.0.0.1", "lo", "10.0.0.0/24")
+		Expect(err).NotTo(HaveOccurred())
 	})
 
 	Describe("discovering Addresses", func() {
@@ -60,12 +64,105 @@ var _ = Describe("Pgw", func() {
 		})
 		Context("when control and user plane IP addresses are provided", func() {
 			BeforeEach(func() {
-				pgw = domain.New("", "", "", "")
+				var err error
+				pgw, err = domain.New("", "", "", "")
+				Expect(err).NotTo(HaveOccurred())
 			})
 			It("should raise an error", func() {
 				err := pgw.Validate()
 				Expect(err).To(HaveOccurred())
 			})
 		})
+		Context("when an unknown datapath mode is provided", func() {
+			BeforeEach(func() {
+				pgw.UserPlane.Datapath = "dpdk"
+			})
+			It("should raise an error", func() {
+				err := pgw.Validate()
+				Expect(err).To(MatchError(ContainSubstring("unsupported \"dpdk\" datapath")))
+			})
+		})
+		Context("when the control function has no PGW-U address", func() {
+			BeforeEach(func() {
+				pgw.Function = domain.FunctionControlPlane
+			})
+			It("should raise an error", func() {
+				err := pgw.Validate()
+				Expect(err).To(MatchError(domain.ErrInvalidPgw))
+			})
+		})
+		Context("when the user function has no control plane address", func() {
+			BeforeEach(func() {
+				pgw.Function = domain.FunctionUserPlane
+				pgw.ControlPlane.IP = ""
+			})
+			It("should not error", func() {
+				err := pgw.Validate()
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("validating the SGi configuration", func() {
+		It("should refuse unknown SGi interfaces", func() {
+			_, err := domain.New("127.0.0.1", "127.0.0.1", "sgi-missing", "10.0.0.0/24")
+			Expect(err).To(MatchError(domain.ErrLinkNotFound))
+			Expect(err).To(MatchError(domain.ErrInvalidPgw))
+		})
+		It("should keep the reason of the unknown SGi interfaces", func() {
+			_, err := domain.New("127.0.0.1", "127.0.0.1", "sgi-missing", "10.0.0.0/24")
+
+			var opErr *net.OpError
+			Expect(errors.As(err, &opErr)).To(BeTrue())
+			Expect(err.Error()).To(HavePrefix(`sgi.nic "sgi-missing": link not found: `))
+			Expect(err.Error()).To(ContainSubstring("no such network interface"))
+		})
+		It("should refuse malformed SGi subnets", func() {
+			_, err := domain.New("127.0.0.1", "127.0.0.1", "lo", "10.0.0.0")
+			Expect(err).To(MatchError(domain.ErrInvalidSubnet))
+		})
+		It("should refuse user planes without SGi interface", func() {
+			pgw.Sgi.Link = nil
+			Expect(pgw.Validate()).To(MatchError(domain.ErrMissingValue))
+		})
+		It("should refuse user planes without SGi subnet", func() {
+			pgw.Sgi.Subnet = nil
+			Expect(pgw.Validate()).To(MatchError(domain.ErrMissingValue))
+		})
+		It("should ignore the SGi configuration of the control function", func() {
+			pgw.Function = domain.FunctionControlPlane
+			pgw.Sxb.Peer = "127.0.0.2"
+			pgw.Sgi = &domain.Sgi{}
+			Expect(pgw.Validate()).To(Succeed())
+		})
+		It("should refuse SGi subnets including S5 addresses", func() {
+			pgw.UserPlane.IP = "10.0.0.10"
+			err := pgw.Validate()
+			Expect(err).To(MatchError(domain.ErrOverlappingNetworks))
+			Expect(err).To(MatchError(ContainSubstring("sgi.subnet")))
+		})
+		It("should refuse malformed S5 addresses", func() {
+			pgw.ControlPlane.IP = "127.0.0"
+			err := pgw.Validate()
+			Expect(err).To(MatchError(domain.ErrInvalidAddress))
+			Expect(err).To(MatchError(`networks.s5c "127.0.0": invalid IP address`))
+		})
+	})
+
+	Describe("selecting the datapath", func() {
+		Context("when the kernel datapath is used", func() {
+			It("should handle tunnels with Kernel GTP", func() {
+				Expect(pgw.UserPlane.UsesKernelGTP()).To(BeTrue())
+			})
+		})
+		Context("when the userspace datapath is used", func() {
+			BeforeEach(func() {
+				pgw.UserPlane.Datapath = domain.DatapathUserspace
+			})
+			It("should not handle tunnels with Kernel GTP", func() {
+				Expect(pgw.UserPlane.Validate()).NotTo(HaveOccurred())
+				Expect(pgw.UserPlane.UsesKernelGTP()).To(BeFalse())
+			})
+		})
 	})
 })
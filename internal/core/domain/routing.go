@@ -0,0 +1,43 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import "net"
+
+// Link identifies a network interface of the host.
+type Link struct {
+	Name  string
+	Index int
+}
+
+// Route stores a static route used to forward the user plane traffic.
+type Route struct {
+	Destination *net.IPNet
+	LinkIndex   int
+	Table       int
+}
+
+// Rule stores a policy routing rule which selects the route table of the traffic received
+// from a link.
+type Rule struct {
+	InputInterface string
+	Destination    *net.IPNet
+	Table          int
+}
+
+// Equal checks if both rules match the same traffic with the same table.
+func (r *Rule) Equal(other *Rule) bool {
+	return r.InputInterface == other.InputInterface && r.Table == other.Table &&
+		r.Destination.String() == other.Destination.String()
+}
@@ -15,25 +15,108 @@ package domain
 
 import (
 	"net"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
-	"github.com/vishvananda/netlink"
 	"github.com/wmnsk/go-gtp/gtpv2"
 )
 
 // ErrInvalidPgw indicates that an invalid PGW domain field was provided.
 var ErrInvalidPgw = errors.New("invalid PGW domain")
 
-// Pgw stores User and Control Plane information about PDN Gateway.
+// Datapath modes supported by the User Plane.
+const (
+	// DatapathKernel uses the Linux kernel GTP module for both directions.
+	DatapathKernel = "kernel"
+	// DatapathTFT steers the downlink traffic in userspace using the bearer Traffic Flow Templates.
+	DatapathTFT = "tft"
+	// DatapathUserspace encapsulates and decapsulates GTP-U packets in userspace through a TUN device.
+	DatapathUserspace = "userspace"
+	// DatapathAuto uses the Linux kernel GTP module when it's available and userspace otherwise.
+	DatapathAuto = "auto"
+)
+
+// Functions performed by the PDN Gateway when its control and user planes are separated.
+const (
+	// FunctionCombined serves the control and user planes in the same process.
+	FunctionCombined = "combined"
+	// FunctionControlPlane serves S5/S8-C and programs a remote PGW-U through the Sxb interface.
+	FunctionControlPlane = "control"
+	// FunctionUserPlane serves S5/S8-U and applies the rules received through the Sxb interface.
+	FunctionUserPlane = "user"
+)
+
+// PFCPPort is the UDP port number used by the Sxb interface.
+const PFCPPort = ":8805"
+
+// Default settings of the packet captures.
+const (
+	DefaultCaptureDirectory   = "/tmp/captures"
+	DefaultCaptureMaxFileSize = 10 << 20
+	DefaultCaptureMaxFiles    = 5
+)
+
+// DefaultDrainTimeout is the time given to the sessions for being deactivated on shutdown.
+const DefaultDrainTimeout = time.Duration(20) * time.Second
+
+// Default values of the GTP-C retransmission timer and counter of the S-GWs.
+const (
+	DefaultT3 = time.Duration(3) * time.Second
+	DefaultN3 = 3
+)
+
+// Pgw stores User and Control Plane information about PDN Gateway. The APNs and the SGi subnet
+// can be changed while the sessions are processed, so they have to be accessed through its methods.
 type Pgw struct {
-	ControlPlane *ControlPlane
-	UserPlane    *UserPlane
-	Sgi          *Sgi
+	mutex sync.RWMutex
+
+	ControlPlane   *ControlPlane
+	UserPlane      *UserPlane
+	Sgi            *Sgi
+	Sxb            *Sxb
+	Capture        *Capture
+	Shutdown       *Shutdown
+	Retransmission *Retransmission
+	Overload       *Overload
+	Firewall       *Firewall
+	Apns           map[string]*Apn
+	Function       string
+}
+
+// Capture stores the settings of the packet captures requested through the management API.
+type Capture struct {
+	Directory   string
+	MaxFileSize int64
+	MaxFiles    int
+}
+
+// Shutdown stores how the sessions are drained when the PDN Gateway is terminated.
+type Shutdown struct {
+	DrainTimeout       time.Duration
+	DeactivateSessions bool
+}
+
+// Retransmission stores the T3-RESPONSE timer and the N3-REQUESTS counter used by the S-GWs to
+// retransmit their requests.
+type Retransmission struct {
+	T3 time.Duration
+	N3 int
+}
+
+// Window returns the time while the S-GWs can retransmit a request.
+func (r *Retransmission) Window() time.Duration {
+	return r.T3 * time.Duration(r.N3)
+}
+
+// Sxb stores information related to the PFCP interface between PGW-C and PGW-U.
+type Sxb struct {
+	Address string
+	Peer    string
 }
 
 type Sgi struct {
-	Link   netlink.Link
+	Link   *Link
 	Subnet *net.IPNet
 }
 
@@ -44,7 +127,8 @@ type ControlPlane struct {
 
 // UserPlane stores information related to User Plane.
 type UserPlane struct {
-	IP string
+	IP       string
+	Datapath string
 }
 
 // GetAddress retrieves the IP address of the Control Plane Network interface.
@@ -101,53 +185,205 @@ func (p *UserPlane) String() string {
 	return addr.String()
 }
 
-// New creates a PGW instance.
-func New(s5cIP, s5uIP, sgiLink, sgiSubnet string) (pgw *Pgw) {
-	link, err := netlink.LinkByName(sgiLink)
-	if err != nil {
-		log.WithError(err).Warnf("SGI %s link retrieve error", sgiLink)
+// GetPeerAddress retrieves the PFCP address of the PGW-U, using the default port when it's omitted.
+func (s *Sxb) GetPeerAddress() (*net.UDPAddr, error) {
+	peer := s.Peer
+	if _, _, err := net.SplitHostPort(peer); err != nil {
+		peer += PFCPPort
 	}
 
-	_, subnet, err := net.ParseCIDR(sgiSubnet)
+	addr, err := net.ResolveUDPAddr("udp", peer)
 	if err != nil {
-		log.WithError(err).Warnf("SGI %s subnet parse error", subnet)
+		return nil, errors.Wrap(err, "failed to generate the PGW-U Sxb address")
 	}
 
-	pgw = &Pgw{
+	return addr, nil
+}
+
+// New creates a PGW instance. The SGi link and subnet can be empty when the
+// function doesn't forward user plane traffic.
+func New(s5cIP, s5uIP, sgiLink, sgiSubnet string) (*Pgw, error) {
+	sgi := &Sgi{}
+
+	if sgiLink != "" {
+		link, err := net.InterfaceByName(sgiLink)
+		if err != nil {
+			return nil, &ValidationError{Field: "sgi.nic", Value: sgiLink, Err: ErrLinkNotFound, Cause: err}
+		}
+
+		sgi.Link = &Link{Name: link.Name, Index: link.Index}
+	}
+
+	if sgiSubnet != "" {
+		_, subnet, err := net.ParseCIDR(sgiSubnet)
+		if err != nil {
+			return nil, &ValidationError{Field: "sgi.subnet", Value: sgiSubnet, Err: ErrInvalidSubnet}
+		}
+
+		sgi.Subnet = subnet
+	}
+
+	return &Pgw{
 		ControlPlane: &ControlPlane{
 			IP: s5cIP,
 		},
 		UserPlane: &UserPlane{
-			IP: s5uIP,
+			IP:       s5uIP,
+			Datapath: DatapathKernel,
+		},
+		Sgi: sgi,
+		Sxb: &Sxb{
+			Address: PFCPPort,
+		},
+		Capture: &Capture{
+			Directory:   DefaultCaptureDirectory,
+			MaxFileSize: DefaultCaptureMaxFileSize,
+			MaxFiles:    DefaultCaptureMaxFiles,
 		},
-		Sgi: &Sgi{
-			Link:   link,
-			Subnet: subnet,
+		Shutdown: &Shutdown{
+			DrainTimeout: DefaultDrainTimeout,
 		},
+		Retransmission: &Retransmission{
+			T3: DefaultT3,
+			N3: DefaultN3,
+		},
+		Overload: &Overload{
+			Threshold: DefaultOverloadThreshold,
+			Validity:  DefaultOverloadValidity,
+		},
+		Firewall: &Firewall{
+			Action: FirewallActionReject,
+		},
+		Apns:     map[string]*Apn{},
+		Function: FunctionCombined,
+	}, nil
+}
+
+// HasControlPlane indicates if the PDN Gateway serves the S5/S8 control plane.
+func (p *Pgw) HasControlPlane() bool {
+	return p.Function != FunctionUserPlane
+}
+
+// HasUserPlane indicates if the PDN Gateway forwards the S5/S8 user plane traffic.
+func (p *Pgw) HasUserPlane() bool {
+	return p.Function != FunctionControlPlane
+}
+
+// AddApn registers the settings of an Access Point Name.
+func (p *Pgw) AddApn(apn *Apn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Apns == nil {
+		p.Apns = map[string]*Apn{}
 	}
 
-	return
+	p.Apns[apn.Name] = apn
 }
 
-// Validate the IP address value of the Control Plane Network Interface.
-func (p *ControlPlane) Validate() error {
-	if p.IP == "" {
-		return errors.Wrap(ErrInvalidPgw, "empty control plane IP address")
+// ReplaceApns replaces the settings of all the Access Point Names.
+func (p *Pgw) ReplaceApns(apns ...*Apn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Apns = map[string]*Apn{}
+
+	for _, apn := range apns {
+		p.Apns[apn.Name] = apn
 	}
+}
 
-	return nil
+// GetApn retrieves the settings of an Access Point Name, nil when they aren't defined.
+func (p *Pgw) GetApn(name string) *Apn {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.Apns[name]
+}
+
+// RequiresAuthentication checks if the subscribers of the given APN have to be authenticated.
+func (p *Pgw) RequiresAuthentication(apn string) bool {
+	if settings := p.GetApn(apn); settings != nil {
+		return settings.Authenticate
+	}
+
+	return false
+}
+
+// GetSubnet retrieves the SGi subnet of the UE addresses.
+func (p *Pgw) GetSubnet() *net.IPNet {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.Sgi.Subnet
+}
+
+// SetSubnet changes the SGi subnet of the UE addresses used by the new sessions.
+func (p *Pgw) SetSubnet(subnet *net.IPNet) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Sgi.Subnet = subnet
+}
+
+// GetFirewall retrieves the rules applied to the S5/S8 peers.
+func (p *Pgw) GetFirewall() *Firewall {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.Firewall
+}
+
+// SetFirewall replaces the rules applied to the S5/S8 peers.
+func (p *Pgw) SetFirewall(firewall *Firewall) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Firewall = firewall
+}
+
+// GetOverload retrieves the admission limits of the new sessions.
+func (p *Pgw) GetOverload() *Overload {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.Overload
+}
+
+// SetOverload replaces the admission limits of the new sessions.
+func (p *Pgw) SetOverload(overload *Overload) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Overload = overload
+}
+
+// Validate the IP address value of the Control Plane Network Interface.
+func (p *ControlPlane) Validate() error {
+	return validateAddress("networks.s5c", p.IP)
 }
 
 // Validate the IP address value of the User Plane Network Interface.
 func (p *UserPlane) Validate() error {
-	if p.IP == "" {
-		return errors.Wrap(ErrInvalidPgw, "empty user plane IP address")
+	if err := validateAddress("networks.s5u", p.IP); err != nil {
+		return err
+	}
+
+	switch p.Datapath {
+	case "", DatapathKernel, DatapathTFT, DatapathUserspace, DatapathAuto:
+	default:
+		return errors.Wrapf(ErrInvalidPgw, "unsupported %q datapath", p.Datapath)
 	}
 
 	return nil
 }
 
-// Validate checks if the fields don't have empty values assigned.
+// UsesKernelGTP indicates if the GTP-U tunnels are handled by the Linux kernel GTP module.
+func (p *UserPlane) UsesKernelGTP() bool {
+	return p.Datapath != DatapathUserspace
+}
+
+// Validate checks if the fields have usable values assigned.
 func (p *Pgw) Validate() error {
 	if p.ControlPlane == nil {
 		return errors.Wrap(ErrInvalidPgw, "no control plane")
@@ -157,9 +393,69 @@ func (p *Pgw) Validate() error {
 		return errors.Wrap(ErrInvalidPgw, "no user plane")
 	}
 
-	if err := p.ControlPlane.Validate(); err != nil {
-		return err
+	switch p.Function {
+	case "", FunctionCombined:
+	case FunctionControlPlane:
+		if p.Sxb == nil || p.Sxb.Peer == "" {
+			return errors.Wrap(ErrInvalidPgw, "no PGW-U address for the Sxb interface")
+		}
+	case FunctionUserPlane:
+		if p.Sxb == nil || p.Sxb.Address == "" {
+			return errors.Wrap(ErrInvalidPgw, "no listening address for the Sxb interface")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidPgw, "unsupported %q function", p.Function)
+	}
+
+	if p.HasControlPlane() {
+		if err := p.ControlPlane.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if p.HasUserPlane() {
+		if err := p.UserPlane.Validate(); err != nil {
+			return err
+		}
+
+		return p.validateSgi()
+	}
+
+	return nil
+}
+
+// validateSgi checks that the UE traffic can be forwarded through the SGi
+// interface without taking addresses of the S5 networks.
+func (p *Pgw) validateSgi() error {
+	if p.Sgi == nil || p.Sgi.Link == nil {
+		return &ValidationError{Field: "sgi.nic", Err: ErrMissingValue}
+	}
+
+	subnet := p.GetSubnet()
+	if subnet == nil {
+		return &ValidationError{Field: "sgi.subnet", Err: ErrMissingValue}
 	}
 
-	return p.UserPlane.Validate()
+	for _, ip := range []string{p.ControlPlane.IP, p.UserPlane.IP} {
+		if address := net.ParseIP(ip); address != nil && subnet.Contains(address) {
+			return &ValidationError{
+				Field: "sgi.subnet", Value: subnet.String(),
+				Err: errors.Wrapf(ErrOverlappingNetworks, "%s S5 address is in the pool", ip),
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateAddress(field, value string) error {
+	if value == "" {
+		return &ValidationError{Field: field, Err: ErrMissingValue}
+	}
+
+	if net.ParseIP(value) == nil {
+		return &ValidationError{Field: field, Value: value, Err: ErrInvalidAddress}
+	}
+
+	return nil
 }
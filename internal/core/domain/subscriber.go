@@ -0,0 +1,161 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Actions applied to the subscribers matched by a policy.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+var (
+	// ErrInvalidPolicy indicates that a subscriber policy has wrong values.
+	ErrInvalidPolicy = errors.New("invalid subscriber policy")
+	// ErrSubscriberDenied indicates that a policy doesn't allow the subscriber to attach.
+	ErrSubscriberDenied = errors.New("subscriber denied")
+	// ErrApnNotAllowed indicates that a policy restricts the access point names of the subscriber.
+	ErrApnNotAllowed = errors.New("APN not allowed")
+	// ErrInvalidReservation indicates that a static UE address has wrong values.
+	ErrInvalidReservation = errors.New("invalid address reservation")
+	// ErrAddressReserved indicates that the UE address is reserved for another subscriber.
+	ErrAddressReserved = errors.New("address reserved")
+	// ErrAddressInUse indicates that the UE address is used by the active session of another subscriber.
+	ErrAddressInUse = errors.New("address in use")
+)
+
+// digitsPattern matches the IMSI and MSISDN values used by the policies.
+var digitsPattern = regexp.MustCompile(`^[0-9]{0,15}$`)
+
+// QoSOverride replaces the QoS values requested for the default bearer, zero values are kept.
+type QoSOverride struct {
+	QCI uint8 `json:"qci,omitempty"`
+	// PL is the Priority Level of the Allocation and Retention Priority.
+	PL uint8 `json:"pl,omitempty"`
+	// AMBRUplink and AMBRDownlink cap the APN-AMBR in kbps.
+	AMBRUplink   uint32 `json:"ambrUplink,omitempty"`
+	AMBRDownlink uint32 `json:"ambrDownlink,omitempty"`
+}
+
+// SubscriberPolicy decides if the subscribers matched by IMSI prefix, IMSI range or MSISDN
+// prefix can attach and what they get. A policy without criteria matches every subscriber.
+type SubscriberPolicy struct {
+	Name         string `json:"name"`
+	IMSIPrefix   string `json:"imsiPrefix,omitempty"`
+	IMSIFirst    string `json:"imsiFirst,omitempty"`
+	IMSILast     string `json:"imsiLast,omitempty"`
+	MSISDNPrefix string `json:"msisdnPrefix,omitempty"`
+	Action       string `json:"action"`
+	// Apns restricts the access point names of the subscribers, any APN is allowed when it's empty.
+	Apns []string     `json:"apns,omitempty"`
+	QoS  *QoSOverride `json:"qos,omitempty"`
+}
+
+// Matches checks if the subscriber fulfills all the criteria of the policy.
+func (p *SubscriberPolicy) Matches(imsi, msisdn string) bool {
+	if !strings.HasPrefix(imsi, p.IMSIPrefix) || !strings.HasPrefix(msisdn, p.MSISDNPrefix) {
+		return false
+	}
+
+	if p.IMSIFirst == "" {
+		return true
+	}
+
+	return len(imsi) == len(p.IMSIFirst) && imsi >= p.IMSIFirst && imsi <= p.IMSILast
+}
+
+// Authorize checks if the subscriber can attach to the access point name.
+func (p *SubscriberPolicy) Authorize(apn string) error {
+	if p.Action == PolicyDeny {
+		return errors.Wrapf(ErrSubscriberDenied, "%q policy", p.Name)
+	}
+
+	if len(p.Apns) == 0 {
+		return nil
+	}
+
+	for _, allowed := range p.Apns {
+		if allowed == apn {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrApnNotAllowed, "%q APN by %q policy", apn, p.Name)
+}
+
+// Validate checks the criteria and the action of the policy, the errors refer to the JSON fields.
+func (p *SubscriberPolicy) Validate() error {
+	if p.Name == "" {
+		return errors.Wrap(ErrInvalidPolicy, "name: required")
+	}
+
+	for field, value := range map[string]string{
+		"imsiPrefix": p.IMSIPrefix, "imsiFirst": p.IMSIFirst, "imsiLast": p.IMSILast, "msisdnPrefix": p.MSISDNPrefix,
+	} {
+		if !digitsPattern.MatchString(value) {
+			return errors.Wrapf(ErrInvalidPolicy, "%s: invalid %q digits", field, value)
+		}
+	}
+
+	if len(p.IMSIFirst) != len(p.IMSILast) || p.IMSIFirst > p.IMSILast {
+		return errors.Wrapf(ErrInvalidPolicy, "imsiLast: %q doesn't end the range started at %q",
+			p.IMSILast, p.IMSIFirst)
+	}
+
+	switch p.Action {
+	case PolicyAllow, PolicyDeny:
+	default:
+		return errors.Wrapf(ErrInvalidPolicy, "action: unsupported %q action", p.Action)
+	}
+
+	return nil
+}
+
+// MatchPolicy returns the first policy matching the subscriber, nil when there is none.
+func MatchPolicy(policies []*SubscriberPolicy, imsi, msisdn string) *SubscriberPolicy {
+	for _, policy := range policies {
+		if policy.Matches(imsi, msisdn) {
+			return policy
+		}
+	}
+
+	return nil
+}
+
+// StaticAddress reserves an UE address for a subscriber, for any of its APNs when it's empty.
+type StaticAddress struct {
+	IMSI string `json:"imsi"`
+	APN  string `json:"apn,omitempty"`
+	IP   string `json:"ip"`
+}
+
+// Validate checks the subscriber and the address of the reservation, the errors refer to the JSON fields.
+func (a *StaticAddress) Validate() error {
+	if a.IMSI == "" || !digitsPattern.MatchString(a.IMSI) {
+		return errors.Wrapf(ErrInvalidReservation, "imsi: invalid %q IMSI", a.IMSI)
+	}
+
+	if ip := net.ParseIP(a.IP); ip == nil || ip.To4() == nil {
+		return errors.Wrapf(ErrInvalidReservation, "ip: invalid %q IPv4 address", a.IP)
+	}
+
+	return nil
+}
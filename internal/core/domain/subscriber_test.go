@@ -0,0 +1,86 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain_test
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SubscriberPolicy", func() {
+	policies := []*domain.SubscriberPolicy{
+		{Name: "barred", IMSIPrefix: "00101", MSISDNPrefix: "3466", Action: domain.PolicyDeny},
+		{Name: "lab", IMSIFirst: "001010000000100", IMSILast: "001010000000199", Action: domain.PolicyAllow},
+		{Name: "default", Action: domain.PolicyAllow, Apns: []string{"internet"}},
+	}
+
+	DescribeTable("should match the first policy of the subscriber",
+		func(imsi, msisdn, expected string) {
+			Expect(domain.MatchPolicy(policies, imsi, msisdn).Name).To(Equal(expected))
+		},
+		Entry("IMSI and MSISDN prefixes", "001010000000001", "34660000001", "barred"),
+		Entry("IMSI range", "001010000000150", "34770000001", "lab"),
+		Entry("IMSI outside the range", "001010000000200", "34770000001", "default"),
+		Entry("IMSI with another length", "00101000000015", "34770000001", "default"),
+	)
+
+	It("should match nothing without policies", func() {
+		Expect(domain.MatchPolicy(nil, "001010000000001", "34660000001")).To(BeNil())
+	})
+
+	Describe("authorizing a subscriber", func() {
+		It("should refuse the denied subscribers", func() {
+			Expect(policies[0].Authorize("internet")).To(MatchError(domain.ErrSubscriberDenied))
+		})
+		It("should allow any APN without restrictions", func() {
+			Expect(policies[1].Authorize("ims")).To(Succeed())
+		})
+		It("should refuse the APNs outside the restrictions", func() {
+			Expect(policies[2].Authorize("internet")).To(Succeed())
+			Expect(policies[2].Authorize("ims")).To(MatchError(domain.ErrApnNotAllowed))
+		})
+	})
+
+	DescribeTable("should reject wrong values referring their field",
+		func(policy *domain.SubscriberPolicy, field string) {
+			err := policy.Validate()
+			Expect(err).To(MatchError(domain.ErrInvalidPolicy))
+			Expect(err).To(MatchError(HavePrefix(field + ":")))
+		},
+		Entry("missing name", &domain.SubscriberPolicy{Action: domain.PolicyAllow}, "name"),
+		Entry("IMSI prefix", &domain.SubscriberPolicy{Name: "a", IMSIPrefix: "0010x", Action: domain.PolicyAllow},
+			"imsiPrefix"),
+		Entry("IMSI range", &domain.SubscriberPolicy{
+			Name: "a", IMSIFirst: "001010000000199", IMSILast: "001010000000100", Action: domain.PolicyAllow,
+		}, "imsiLast"),
+		Entry("action", &domain.SubscriberPolicy{Name: "a", Action: "reject"}, "action"),
+	)
+
+	Describe("validating a static address", func() {
+		It("should accept IPv4 addresses", func() {
+			Expect((&domain.StaticAddress{IMSI: "001010000000001", IP: "10.0.1.20"}).Validate()).To(Succeed())
+		})
+		It("should refuse missing subscribers", func() {
+			err := (&domain.StaticAddress{IP: "10.0.1.20"}).Validate()
+			Expect(err).To(MatchError(domain.ErrInvalidReservation))
+			Expect(err).To(MatchError(HavePrefix("imsi:")))
+		})
+		It("should refuse IPv6 addresses", func() {
+			err := (&domain.StaticAddress{IMSI: "001010000000001", IP: "2001:db8::1"}).Validate()
+			Expect(err).To(MatchError(HavePrefix("ip:")))
+		})
+	})
+})
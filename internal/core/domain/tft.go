@@ -0,0 +1,426 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTFT indicates that a Traffic Flow Template can't be decoded.
+var ErrInvalidTFT = errors.New("invalid traffic flow template")
+
+// TFT operation codes (3GPP TS 24.008 table 10.5.162).
+const (
+	TFTOperationCreate         uint8 = 1
+	TFTOperationDelete         uint8 = 2
+	TFTOperationAddFilters     uint8 = 3
+	TFTOperationReplaceFilters uint8 = 4
+	TFTOperationDeleteFilters  uint8 = 5
+	TFTOperationNoOperation    uint8 = 6
+)
+
+// Packet filter component types (3GPP TS 24.008 table 10.5.162).
+const (
+	componentIPv4RemoteAddress uint8 = 0x10
+	componentIPv4LocalAddress  uint8 = 0x11
+	componentProtocol          uint8 = 0x30
+	componentLocalPort         uint8 = 0x40
+	componentLocalPortRange    uint8 = 0x41
+	componentRemotePort        uint8 = 0x50
+	componentRemotePortRange   uint8 = 0x51
+	componentSPI               uint8 = 0x60
+	componentTypeOfService     uint8 = 0x70
+)
+
+// MaxPacketFilters is the number of packet filters which can be encoded in a Traffic Flow Template.
+const MaxPacketFilters = 15
+
+const (
+	protocolTCP  uint8 = 6
+	protocolUDP  uint8 = 17
+	protocolESP  uint8 = 50
+	protocolAH   uint8 = 51
+	ipv4HdrLen         = 20
+	ipv4Version        = 4
+	ipv4AddrSize       = 4
+)
+
+// PacketFilterDirection defines the traffic direction where a packet filter applies.
+type PacketFilterDirection uint8
+
+const (
+	// DirectionPreRelease7 is used by filters defined before Release 7, which apply in both directions.
+	DirectionPreRelease7 PacketFilterDirection = iota
+	// DirectionDownlink is used by filters which only apply to downlink traffic.
+	DirectionDownlink
+	// DirectionUplink is used by filters which only apply to uplink traffic.
+	DirectionUplink
+	// DirectionBidirectional is used by filters which apply in both directions.
+	DirectionBidirectional
+)
+
+// PortRange stores an inclusive range of transport ports.
+type PortRange struct {
+	Low  uint16
+	High uint16
+}
+
+// PacketFilter stores the packet filter components of a Traffic Flow Template. Remote values
+// refer to the network side and local values refer to the UE side.
+type PacketFilter struct {
+	ID            uint8
+	Direction     PacketFilterDirection
+	Precedence    uint8
+	RemoteAddress *net.IPNet
+	LocalAddress  *net.IPNet
+	Protocol      *uint8
+	LocalPorts    *PortRange
+	RemotePorts   *PortRange
+	SPI           *uint32
+	TypeOfService *uint8
+	ToSMask       uint8
+}
+
+// TrafficFlowTemplate stores the packet filters used to bind traffic to a bearer.
+type TrafficFlowTemplate struct {
+	Operation uint8
+	Filters   []*PacketFilter
+}
+
+// BearerBinding associates a bearer GTP-U tunnel with its Traffic Flow Template.
+type BearerBinding struct {
+	EBI          uint8
+	IncomingTEID uint32
+	OutgoingTEID uint32
+	Peer         net.Addr
+	TFT          *TrafficFlowTemplate
+}
+
+// ParseTFT decodes the value of a Bearer TFT IE.
+func ParseTFT(b []byte) (*TrafficFlowTemplate, error) {
+	if len(b) < 1 {
+		return nil, errors.Wrap(ErrInvalidTFT, "empty value")
+	}
+
+	tft := &TrafficFlowTemplate{Operation: b[0] >> 5}
+	count := int(b[0] & 0x0f)
+	offset := 1
+
+	for i := 0; i < count; i++ {
+		if tft.Operation == TFTOperationDeleteFilters {
+			if offset >= len(b) {
+				return nil, errors.Wrap(ErrInvalidTFT, "truncated packet filter identifier")
+			}
+
+			tft.Filters = append(tft.Filters, &PacketFilter{ID: b[offset] & 0x0f})
+			offset++
+
+			continue
+		}
+
+		if offset+3 > len(b) {
+			return nil, errors.Wrap(ErrInvalidTFT, "truncated packet filter")
+		}
+
+		filter := &PacketFilter{
+			ID:         b[offset] & 0x0f,
+			Direction:  PacketFilterDirection((b[offset] >> 4) & 0x03),
+			Precedence: b[offset+1],
+		}
+		length := int(b[offset+2])
+		offset += 3
+
+		if offset+length > len(b) {
+			return nil, errors.Wrap(ErrInvalidTFT, "truncated packet filter contents")
+		}
+
+		if err := filter.unmarshalComponents(b[offset : offset+length]); err != nil {
+			return nil, err
+		}
+
+		tft.Filters = append(tft.Filters, filter)
+		offset += length
+	}
+
+	return tft, nil
+}
+
+func (f *PacketFilter) unmarshalComponents(b []byte) error {
+	sizes := map[uint8]int{
+		componentIPv4RemoteAddress: 2 * ipv4AddrSize,
+		componentIPv4LocalAddress:  2 * ipv4AddrSize,
+		componentProtocol:          1,
+		componentLocalPort:         2,
+		componentLocalPortRange:    4,
+		componentRemotePort:        2,
+		componentRemotePortRange:   4,
+		componentSPI:               4,
+		componentTypeOfService:     2,
+	}
+
+	for len(b) > 0 {
+		size, ok := sizes[b[0]]
+		if !ok {
+			return errors.Wrapf(ErrInvalidTFT, "unsupported %#x component", b[0])
+		}
+
+		if len(b) < 1+size {
+			return errors.Wrapf(ErrInvalidTFT, "truncated %#x component", b[0])
+		}
+
+		value := b[1 : 1+size]
+
+		switch b[0] {
+		case componentIPv4RemoteAddress:
+			f.RemoteAddress = &net.IPNet{IP: net.IP(append([]byte{}, value[:4]...)), Mask: net.IPMask(append([]byte{}, value[4:]...))}
+		case componentIPv4LocalAddress:
+			f.LocalAddress = &net.IPNet{IP: net.IP(append([]byte{}, value[:4]...)), Mask: net.IPMask(append([]byte{}, value[4:]...))}
+		case componentProtocol:
+			protocol := value[0]
+			f.Protocol = &protocol
+		case componentLocalPort:
+			port := binary.BigEndian.Uint16(value)
+			f.LocalPorts = &PortRange{Low: port, High: port}
+		case componentLocalPortRange:
+			f.LocalPorts = &PortRange{Low: binary.BigEndian.Uint16(value), High: binary.BigEndian.Uint16(value[2:])}
+		case componentRemotePort:
+			port := binary.BigEndian.Uint16(value)
+			f.RemotePorts = &PortRange{Low: port, High: port}
+		case componentRemotePortRange:
+			f.RemotePorts = &PortRange{Low: binary.BigEndian.Uint16(value), High: binary.BigEndian.Uint16(value[2:])}
+		case componentSPI:
+			spi := binary.BigEndian.Uint32(value)
+			f.SPI = &spi
+		case componentTypeOfService:
+			tos := value[0]
+			f.TypeOfService = &tos
+			f.ToSMask = value[1]
+		}
+
+		b = b[1+size:]
+	}
+
+	return nil
+}
+
+// Marshal encodes the Traffic Flow Template as the value of a Bearer TFT IE. Only IPv4 addresses
+// can be encoded.
+func (t *TrafficFlowTemplate) Marshal() ([]byte, error) {
+	if len(t.Filters) > MaxPacketFilters {
+		return nil, errors.Wrapf(ErrInvalidTFT, "%d packet filters", len(t.Filters))
+	}
+
+	b := []byte{t.Operation<<5 | uint8(len(t.Filters))}
+
+	for _, filter := range t.Filters {
+		if filter.ID > MaxPacketFilters {
+			return nil, errors.Wrapf(ErrInvalidTFT, "%d packet filter identifier", filter.ID)
+		}
+
+		contents, err := filter.marshalComponents()
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, uint8(filter.Direction)<<4|filter.ID, filter.Precedence, uint8(len(contents)))
+		b = append(b, contents...)
+	}
+
+	return b, nil
+}
+
+func appendAddress(b []byte, component uint8, address *net.IPNet) ([]byte, error) {
+	ip := address.IP.To4()
+	if _, bits := address.Mask.Size(); ip == nil || bits != 8*ipv4AddrSize {
+		return nil, errors.Wrapf(ErrInvalidTFT, "%s isn't an IPv4 network", address)
+	}
+
+	b = append(b, component)
+	b = append(b, ip...)
+
+	return append(b, address.Mask...), nil
+}
+
+func appendPorts(b []byte, single, rangeComponent uint8, ports *PortRange) []byte {
+	if ports.Low == ports.High {
+		return append(b, single, byte(ports.Low>>8), byte(ports.Low))
+	}
+
+	return append(b, rangeComponent, byte(ports.Low>>8), byte(ports.Low), byte(ports.High>>8), byte(ports.High))
+}
+
+func (f *PacketFilter) marshalComponents() ([]byte, error) {
+	var err error
+
+	b := []byte{}
+
+	if f.RemoteAddress != nil {
+		if b, err = appendAddress(b, componentIPv4RemoteAddress, f.RemoteAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.LocalAddress != nil {
+		if b, err = appendAddress(b, componentIPv4LocalAddress, f.LocalAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Protocol != nil {
+		b = append(b, componentProtocol, *f.Protocol)
+	}
+
+	if f.LocalPorts != nil {
+		b = appendPorts(b, componentLocalPort, componentLocalPortRange, f.LocalPorts)
+	}
+
+	if f.RemotePorts != nil {
+		b = appendPorts(b, componentRemotePort, componentRemotePortRange, f.RemotePorts)
+	}
+
+	if f.SPI != nil {
+		spi := make([]byte, 4)
+		binary.BigEndian.PutUint32(spi, *f.SPI)
+		b = append(append(b, componentSPI), spi...)
+	}
+
+	if f.TypeOfService != nil {
+		b = append(b, componentTypeOfService, *f.TypeOfService, f.ToSMask)
+	}
+
+	return b, nil
+}
+
+type packetInfo struct {
+	source, destination net.IP
+	protocol, tos       uint8
+	sourcePort          uint16
+	destinationPort     uint16
+	spi                 *uint32
+}
+
+func parsePacket(packet []byte) (*packetInfo, bool) {
+	if len(packet) < ipv4HdrLen || packet[0]>>4 != ipv4Version {
+		return nil, false
+	}
+
+	headerLength := int(packet[0]&0x0f) * 4
+	if headerLength < ipv4HdrLen || len(packet) < headerLength {
+		return nil, false
+	}
+
+	info := &packetInfo{
+		tos:         packet[1],
+		protocol:    packet[9],
+		source:      net.IP(packet[12:16]),
+		destination: net.IP(packet[16:20]),
+	}
+	payload := packet[headerLength:]
+
+	switch info.protocol {
+	case protocolTCP, protocolUDP:
+		if len(payload) >= 4 {
+			info.sourcePort = binary.BigEndian.Uint16(payload)
+			info.destinationPort = binary.BigEndian.Uint16(payload[2:])
+		}
+	case protocolESP:
+		if len(payload) >= 4 {
+			spi := binary.BigEndian.Uint32(payload)
+			info.spi = &spi
+		}
+	case protocolAH:
+		if len(payload) >= 8 {
+			spi := binary.BigEndian.Uint32(payload[4:])
+			info.spi = &spi
+		}
+	}
+
+	return info, true
+}
+
+func (r *PortRange) contains(port uint16) bool {
+	return port >= r.Low && port <= r.High
+}
+
+// matchDownlink checks if a downlink IPv4 packet sent to the UE satisfies all filter components.
+func (f *PacketFilter) matchDownlink(info *packetInfo) bool {
+	if f.Direction == DirectionUplink {
+		return false
+	}
+
+	switch {
+	case f.RemoteAddress != nil && !f.RemoteAddress.Contains(info.source),
+		f.LocalAddress != nil && !f.LocalAddress.Contains(info.destination),
+		f.Protocol != nil && *f.Protocol != info.protocol,
+		f.RemotePorts != nil && !f.RemotePorts.contains(info.sourcePort),
+		f.LocalPorts != nil && !f.LocalPorts.contains(info.destinationPort),
+		f.SPI != nil && (info.spi == nil || *f.SPI != *info.spi),
+		f.TypeOfService != nil && info.tos&f.ToSMask != *f.TypeOfService&f.ToSMask:
+		return false
+	}
+
+	return true
+}
+
+// ClassifyDownlink selects the bearer whose packet filters match the downlink packet, evaluating
+// the filters of all bearers in precedence order. The bearer without TFT is used by default.
+func ClassifyDownlink(bindings []*BearerBinding, packet []byte) *BearerBinding {
+	var fallback *BearerBinding
+
+	type candidate struct {
+		filter  *PacketFilter
+		binding *BearerBinding
+	}
+
+	candidates := []candidate{}
+
+	for _, binding := range bindings {
+		if binding.TFT == nil || len(binding.TFT.Filters) == 0 {
+			if fallback == nil || binding.EBI < fallback.EBI {
+				fallback = binding
+			}
+
+			continue
+		}
+
+		for _, filter := range binding.TFT.Filters {
+			candidates = append(candidates, candidate{filter: filter, binding: binding})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return fallback
+	}
+
+	info, ok := parsePacket(packet)
+	if !ok {
+		return fallback
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].filter.Precedence < candidates[j].filter.Precedence
+	})
+
+	for _, candidate := range candidates {
+		if candidate.filter.matchDownlink(info) {
+			return candidate.binding
+		}
+	}
+
+	return fallback
+}
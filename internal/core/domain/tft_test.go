@@ -0,0 +1,143 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// newPacket creates an IPv4 header followed by the source and destination ports.
+func newPacket(src, dst string, protocol uint8, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = protocol
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	packet[20], packet[21] = byte(srcPort>>8), byte(srcPort)
+	packet[22], packet[23] = byte(dstPort>>8), byte(dstPort)
+
+	return packet
+}
+
+var _ = Describe("TrafficFlowTemplate", func() {
+	var (
+		udp      uint8 = 17
+		sip            = &domain.PortRange{Low: 5060, High: 5060}
+		tft      *domain.TrafficFlowTemplate
+		bindings []*domain.BearerBinding
+	)
+
+	BeforeEach(func() {
+		_, remote, _ := net.ParseCIDR("192.168.0.0/24")
+		tft = &domain.TrafficFlowTemplate{
+			Operation: domain.TFTOperationCreate,
+			Filters: []*domain.PacketFilter{{
+				ID:            1,
+				Direction:     domain.DirectionDownlink,
+				Precedence:    10,
+				RemoteAddress: remote,
+				Protocol:      &udp,
+				RemotePorts:   sip,
+			}},
+		}
+		bindings = []*domain.BearerBinding{
+			{EBI: 5, OutgoingTEID: 100},
+			{EBI: 6, OutgoingTEID: 200, TFT: tft},
+		}
+	})
+
+	Describe("encoding and decoding packet filters", func() {
+		It("should keep all the components", func() {
+			encoded, err := tft.Marshal()
+			Expect(err).NotTo(HaveOccurred())
+
+			decoded, err := domain.ParseTFT(encoded)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded.Operation).To(Equal(domain.TFTOperationCreate))
+			Expect(decoded.Filters).To(HaveLen(1))
+			Expect(decoded.Filters[0].Precedence).To(Equal(uint8(10)))
+			Expect(decoded.Filters[0].Direction).To(Equal(domain.DirectionDownlink))
+			Expect(decoded.Filters[0].RemoteAddress.String()).To(Equal("192.168.0.0/24"))
+			Expect(*decoded.Filters[0].Protocol).To(Equal(udp))
+			Expect(decoded.Filters[0].RemotePorts).To(Equal(sip))
+		})
+		Context("when the value is truncated", func() {
+			It("should raise an error", func() {
+				encoded, err := tft.Marshal()
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = domain.ParseTFT(encoded[:len(encoded)-1])
+				Expect(err).To(MatchError(domain.ErrInvalidTFT))
+			})
+		})
+		Context("when there are more packet filters than the identifiers", func() {
+			It("should raise an error", func() {
+				for id := uint8(2); id <= domain.MaxPacketFilters+1; id++ {
+					tft.Filters = append(tft.Filters, &domain.PacketFilter{ID: id % 16})
+				}
+
+				_, err := tft.Marshal()
+				Expect(err).To(MatchError(domain.ErrInvalidTFT))
+			})
+		})
+		Context("when a packet filter uses an IPv6 network", func() {
+			It("should raise an error", func() {
+				_, tft.Filters[0].LocalAddress, _ = net.ParseCIDR("2001:db8::/64")
+
+				_, err := tft.Marshal()
+				Expect(err).To(MatchError(domain.ErrInvalidTFT))
+			})
+		})
+	})
+
+	Describe("encoding packet filters as flow descriptions", func() {
+		It("should describe the downlink match", func() {
+			Expect(tft.Filters[0].FlowDescription()).To(Equal("permit out 17 from 192.168.0.0/24 5060 to assigned"))
+		})
+		It("should keep the match components", func() {
+			decoded, err := domain.ParseFlowDescription(tft.Filters[0].FlowDescription())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded.Direction).To(Equal(domain.DirectionDownlink))
+			Expect(decoded.RemoteAddress.String()).To(Equal("192.168.0.0/24"))
+			Expect(decoded.LocalAddress).To(BeNil())
+			Expect(*decoded.Protocol).To(Equal(udp))
+			Expect(decoded.RemotePorts).To(Equal(sip))
+		})
+		Context("when the rule isn't supported", func() {
+			It("should raise an error", func() {
+				_, err := domain.ParseFlowDescription("deny in ip from any to any")
+				Expect(err).To(MatchError(domain.ErrInvalidFlowDescription))
+			})
+		})
+	})
+
+	Describe("classifying downlink packets", func() {
+		Context("when the packet matches the dedicated bearer filters", func() {
+			It("should select the dedicated bearer", func() {
+				packet := newPacket("192.168.0.10", "10.0.1.2", udp, 5060, 40000)
+				Expect(domain.ClassifyDownlink(bindings, packet).EBI).To(Equal(uint8(6)))
+			})
+		})
+		Context("when the packet doesn't match any filter", func() {
+			It("should select the default bearer", func() {
+				packet := newPacket("192.168.0.10", "10.0.1.2", udp, 53, 40000)
+				Expect(domain.ClassifyDownlink(bindings, packet).EBI).To(Equal(uint8(5)))
+			})
+		})
+	})
+})
@@ -0,0 +1,45 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import "net"
+
+// TrafficCounters stores the user plane traffic forwarded for an UE.
+type TrafficCounters struct {
+	UplinkPackets   uint64
+	UplinkBytes     uint64
+	DownlinkPackets uint64
+	DownlinkBytes   uint64
+}
+
+// Add accumulates the given counters.
+func (c *TrafficCounters) Add(other *TrafficCounters) {
+	c.UplinkPackets += other.UplinkPackets
+	c.UplinkBytes += other.UplinkBytes
+	c.DownlinkPackets += other.DownlinkPackets
+	c.DownlinkBytes += other.DownlinkBytes
+}
+
+// TrafficClass shapes and accounts the IPv4 traffic of an UE address sent through a link.
+type TrafficClass struct {
+	Link *Link
+	ID   uint16
+	// Address is matched with the source address of the packets when Source is set, and with
+	// their destination address otherwise.
+	Address net.IP
+	Source  bool
+	// Rate and Ceil are the guaranteed and maximum bit rates in bits per second.
+	Rate uint64
+	Ceil uint64
+}
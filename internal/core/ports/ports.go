@@ -13,7 +13,11 @@ limitations under the License.
 
 package ports
 
-import "github.com/gw-tester/pgw/internal/core/domain"
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+)
 
 // IPRepository exposes methods to save, get and drop IP address information.
 type IPRepository interface {
@@ -29,3 +33,58 @@ type PGWService interface {
 	Get() (*domain.Pgw, error)
 	Remove()
 }
+
+// Authenticator exposes methods to verify subscriber credentials against an AAA server.
+type Authenticator interface {
+	Authenticate(credentials *domain.Credentials) (*domain.Authorization, error)
+	Status() (interface{}, error)
+}
+
+// EventPublisher exposes methods to send the session lifecycle events to external consumers.
+type EventPublisher interface {
+	Publish(event *domain.SessionEvent) error
+	Close() error
+}
+
+// PolicyRepository exposes methods to share the subscriber policies between the PDN Gateways.
+type PolicyRepository interface {
+	List() ([]*domain.SubscriberPolicy, error)
+	Replace(policies []*domain.SubscriberPolicy) error
+}
+
+// AddressRepository exposes methods to reserve static UE addresses for the subscribers.
+type AddressRepository interface {
+	// Get retrieves the address of the subscriber for the APN or, otherwise, for any APN.
+	Get(imsi, apn string) (*domain.StaticAddress, error)
+	// Owner retrieves the reservation of an address, nil when it isn't reserved.
+	Owner(ip string) (*domain.StaticAddress, error)
+	Reserve(address *domain.StaticAddress) error
+	Release(imsi, apn string) error
+}
+
+// ConfigReloader exposes methods to apply the changes of the configuration to the running PDN Gateway.
+type ConfigReloader interface {
+	Reload() ([]*domain.ConfigChange, error)
+}
+
+// Datapath exposes methods to program the GTP-U tunnels, routes and rules of the user plane.
+type Datapath interface {
+	AddTunnel(peer, ms net.IP, otei, itei uint32) error
+	DeleteTunnel(itei uint32) error
+	ReplaceRoute(route *domain.Route) error
+	DeleteRoute(route *domain.Route) error
+	AddRule(rule *domain.Rule) error
+	DeleteRule(rule *domain.Rule) error
+	ListRules() ([]*domain.Rule, error)
+	LinkByName(name string) (*domain.Link, error)
+}
+
+// TrafficControl exposes methods to program the classes which shape and account the UE traffic
+// sent through the links.
+type TrafficControl interface {
+	ReplaceQdisc(link *domain.Link) error
+	DeleteQdisc(link *domain.Link) error
+	ReplaceClass(class *domain.TrafficClass) error
+	DeleteClass(class *domain.TrafficClass) error
+	ClassStatistics(class *domain.TrafficClass) (packets, bytes uint64, err error)
+}
@@ -50,16 +50,20 @@ func (srv *Service) Create(pgw *domain.Pgw) error {
 		return errors.Wrap(err, "invalid PGW domain object")
 	}
 
-	if err := srv.ipRepository.Save(s5uIP, pgw.UserPlane.IP); err != nil {
-		log.WithError(err).Panic("S5-U IP Address storage error")
+	if pgw.HasUserPlane() {
+		if err := srv.ipRepository.Save(s5uIP, pgw.UserPlane.IP); err != nil {
+			log.WithError(err).Panic("S5-U IP Address storage error")
 
-		return fmt.Errorf("S5-U IP %q: %w", pgw.UserPlane.IP, ErrSaveIP)
+			return fmt.Errorf("S5-U IP %q: %w", pgw.UserPlane.IP, ErrSaveIP)
+		}
 	}
 
-	if err := srv.ipRepository.Save(s5cIP, pgw.ControlPlane.IP); err != nil {
-		log.WithError(err).Panic("S5-C IP Address storage error")
+	if pgw.HasControlPlane() {
+		if err := srv.ipRepository.Save(s5cIP, pgw.ControlPlane.IP); err != nil {
+			log.WithError(err).Panic("S5-C IP Address storage error")
 
-		return fmt.Errorf("S5-C IP %q: %w", pgw.ControlPlane.IP, ErrSaveIP)
+			return fmt.Errorf("S5-C IP %q: %w", pgw.ControlPlane.IP, ErrSaveIP)
+		}
 	}
 
 	return nil
@@ -77,7 +81,12 @@ func (srv *Service) Get() (*domain.Pgw, error) {
 		return nil, errors.Wrap(err, "fail to get control plane IP address")
 	}
 
-	return domain.New(controlPlaneIP, userPlaneIP, "", ""), nil
+	pgw, err := domain.New(controlPlaneIP, userPlaneIP, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to create the PGW domain object")
+	}
+
+	return pgw, nil
 }
 
 // Remove deletes the PGW information from the repository.
@@ -39,7 +39,9 @@ var _ = Describe("Service", func() {
 
 	Describe("storing user and control plane IP addresses", func() {
 		BeforeEach(func() {
-			pgw = domain.New(s5cIPAddress, s5uIPAddress, "", "")
+			var err error
+			pgw, err = domain.New(s5cIPAddress, s5uIPAddress, "lo", "10.0.0.0/24")
+			Expect(err).NotTo(HaveOccurred())
 		})
 		Context("when information is valid", func() {
 			It("should store the user and plane information", func() {
@@ -60,7 +62,9 @@ var _ = Describe("Service", func() {
 
 	Describe("avoiding to store invalid control plane IP addresses", func() {
 		BeforeEach(func() {
-			pgw = domain.New("", s5uIPAddress, "", "")
+			var err error
+			pgw, err = domain.New("", s5uIPAddress, "", "")
+			Expect(err).NotTo(HaveOccurred())
 		})
 		Context("when control plane is invalid", func() {
 			It("should raise an error", func() {
@@ -72,7 +76,9 @@ var _ = Describe("Service", func() {
 
 	Describe("avoiding to store invalid user plane IP addresses", func() {
 		BeforeEach(func() {
-			pgw = domain.New(s5cIPAddress, "", "", "")
+			var err error
+			pgw, err = domain.New(s5cIPAddress, "", "", "")
+			Expect(err).NotTo(HaveOccurred())
 		})
 		Context("when user plane is invalid", func() {
 			It("should raise an error", func() {
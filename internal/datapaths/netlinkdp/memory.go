@@ -0,0 +1,341 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netlinkdp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	// ErrNotFound indicates that the tunnel, route, rule or link doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrExists indicates that the rule was already added.
+	ErrExists = errors.New("already exists")
+	// ErrInvalidAddress indicates that the traffic of an address can't be classified.
+	ErrInvalidAddress = errors.New("invalid address")
+)
+
+// Tunnel stores the endpoints of a GTP-U tunnel.
+type Tunnel struct {
+	Peer         net.IP
+	MS           net.IP
+	OutgoingTEID uint32
+	IncomingTEID uint32
+}
+
+// Memory is a datapath which only records the programmed state, so the user plane can be
+// exercised without privileges.
+type Memory struct {
+	mutex   sync.Mutex
+	links   map[string]*domain.Link
+	tunnels map[uint32]*Tunnel
+	routes  map[string]*domain.Route
+	rules   []*domain.Rule
+
+	// qdiscs are keyed by link index, and the classes by link index and ID. The classes are
+	// also encoded as the HTB classes and u32 filters programmed in the Linux kernel.
+	qdiscs  map[int]*domain.Link
+	classes map[string]*domain.TrafficClass
+	htb     map[string]*netlink.HtbClass
+	u32     map[string]*netlink.U32
+}
+
+// NewMemory creates an empty in-memory datapath which knows the given links.
+func NewMemory(links ...*domain.Link) *Memory {
+	memory := &Memory{
+		links:   map[string]*domain.Link{},
+		tunnels: map[uint32]*Tunnel{},
+		routes:  map[string]*domain.Route{},
+		rules:   []*domain.Rule{},
+		qdiscs:  map[int]*domain.Link{},
+		classes: map[string]*domain.TrafficClass{},
+		htb:     map[string]*netlink.HtbClass{},
+		u32:     map[string]*netlink.U32{},
+	}
+
+	for _, link := range links {
+		memory.links[link.Name] = link
+	}
+
+	return memory
+}
+
+// NewLink creates a link which can be registered in the in-memory datapath.
+func NewLink(name string, index int) *domain.Link {
+	return &domain.Link{Name: name, Index: index}
+}
+
+func routeKey(route *domain.Route) string {
+	return fmt.Sprintf("%s@%d", route.Destination, route.Table)
+}
+
+// AddTunnel adds or replaces a GTP-U tunnel.
+func (m *Memory) AddTunnel(peer, ms net.IP, otei, itei uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tunnels[itei] = &Tunnel{Peer: peer, MS: ms, OutgoingTEID: otei, IncomingTEID: itei}
+
+	return nil
+}
+
+// DeleteTunnel removes a GTP-U tunnel.
+func (m *Memory) DeleteTunnel(itei uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.tunnels[itei]; !ok {
+		return errors.Wrapf(ErrNotFound, "%d tunnel", itei)
+	}
+
+	delete(m.tunnels, itei)
+
+	return nil
+}
+
+// ReplaceRoute adds or replaces a static route.
+func (m *Memory) ReplaceRoute(route *domain.Route) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.routes[routeKey(route)] = route
+
+	return nil
+}
+
+// DeleteRoute removes a static route.
+func (m *Memory) DeleteRoute(route *domain.Route) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.routes[routeKey(route)]; !ok {
+		return errors.Wrapf(ErrNotFound, "%s route", route.Destination)
+	}
+
+	delete(m.routes, routeKey(route))
+
+	return nil
+}
+
+// AddRule adds a policy routing rule.
+func (m *Memory) AddRule(rule *domain.Rule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, added := range m.rules {
+		if added.Equal(rule) {
+			return errors.Wrapf(ErrExists, "%s rule", rule.Destination)
+		}
+	}
+
+	m.rules = append(m.rules, rule)
+
+	return nil
+}
+
+// DeleteRule removes a policy routing rule.
+func (m *Memory) DeleteRule(rule *domain.Rule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, added := range m.rules {
+		if added.Equal(rule) {
+			m.rules = append(m.rules[:i], m.rules[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrNotFound, "%s rule", rule.Destination)
+}
+
+// ListRules retrieves the policy routing rules.
+func (m *Memory) ListRules() ([]*domain.Rule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]*domain.Rule{}, m.rules...), nil
+}
+
+// LinkByName retrieves one of the known links.
+func (m *Memory) LinkByName(name string) (*domain.Link, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	link, ok := m.links[name]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "%s link", name)
+	}
+
+	return link, nil
+}
+
+// Tunnels retrieves the GTP-U tunnels indexed by their incoming TEID.
+func (m *Memory) Tunnels() map[uint32]*Tunnel {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tunnels := make(map[uint32]*Tunnel, len(m.tunnels))
+	for itei, tunnel := range m.tunnels {
+		tunnels[itei] = tunnel
+	}
+
+	return tunnels
+}
+
+// Routes retrieves the static routes.
+func (m *Memory) Routes() []*domain.Route {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	routes := make([]*domain.Route, 0, len(m.routes))
+	for _, route := range m.routes {
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+func classKey(link *domain.Link, id uint16) string {
+	return fmt.Sprintf("%d:%x", link.Index, id)
+}
+
+// ReplaceQdisc adds or replaces the root queueing discipline of a link.
+func (m *Memory) ReplaceQdisc(link *domain.Link) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.qdiscs[link.Index] = link
+
+	return nil
+}
+
+// DeleteQdisc removes the root queueing discipline of a link.
+func (m *Memory) DeleteQdisc(link *domain.Link) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.qdiscs[link.Index]; !ok {
+		return errors.Wrapf(ErrNotFound, "%s qdisc", link.Name)
+	}
+
+	delete(m.qdiscs, link.Index)
+
+	return nil
+}
+
+// ReplaceClass adds or replaces the class of an UE address.
+func (m *Memory) ReplaceClass(class *domain.TrafficClass) error {
+	if class.Address.To4() == nil {
+		return errors.Wrapf(ErrInvalidAddress, "%s UE address", class.Address)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := classKey(class.Link, class.ID)
+	replaced := *class
+	m.classes[key] = &replaced
+	m.htb[key] = newClass(class)
+	m.u32[key] = newFilter(class)
+
+	return nil
+}
+
+// DeleteClass removes the class of an UE address.
+func (m *Memory) DeleteClass(class *domain.TrafficClass) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := classKey(class.Link, class.ID)
+	if _, ok := m.classes[key]; !ok {
+		return errors.Wrapf(ErrNotFound, "%s class", key)
+	}
+
+	delete(m.classes, key)
+	delete(m.htb, key)
+	delete(m.u32, key)
+
+	return nil
+}
+
+// ClassStatistics retrieves the traffic recorded for the class of an UE address.
+func (m *Memory) ClassStatistics(class *domain.TrafficClass) (packets, bytes uint64, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	classes := []netlink.Class{}
+	if htb, ok := m.htb[classKey(class.Link, class.ID)]; ok {
+		classes = append(classes, htb)
+	}
+
+	packets, bytes = getStatistics(classes, class.ID)
+
+	return packets, bytes, nil
+}
+
+// Qdiscs retrieves the links which have a root queueing discipline.
+func (m *Memory) Qdiscs() []*domain.Link {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	links := make([]*domain.Link, 0, len(m.qdiscs))
+	for _, link := range m.qdiscs {
+		links = append(links, link)
+	}
+
+	return links
+}
+
+// Class retrieves a traffic control class of a link.
+func (m *Memory) Class(link *domain.Link, id uint16) *domain.TrafficClass {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.classes[classKey(link, id)]
+}
+
+// HtbClass retrieves the HTB class which encodes a traffic control class of a link.
+func (m *Memory) HtbClass(link *domain.Link, id uint16) *netlink.HtbClass {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.htb[classKey(link, id)]
+}
+
+// U32Filter retrieves the u32 filter which classifies the traffic of a class of a link.
+func (m *Memory) U32Filter(link *domain.Link, id uint16) *netlink.U32 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.u32[classKey(link, id)]
+}
+
+// Account records the traffic sent through a class of a link.
+func (m *Memory) Account(link *domain.Link, id uint16, packets, bytes uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if htb, ok := m.htb[classKey(link, id)]; ok {
+		htb.Statistics = &netlink.ClassStatistics{Basic: &netlink.GnetStatsBasic{
+			Packets: uint32(packets), Bytes: bytes,
+		}}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netlinkdp_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("Memory", func() {
+	var (
+		ms       = net.ParseIP("10.0.1.2")
+		tunnel   = netlinkdp.NewLink("gtp-pgw", 10)
+		sgi      = netlinkdp.NewLink("eth2", 2)
+		datapath *netlinkdp.Memory
+	)
+
+	BeforeEach(func() {
+		datapath = netlinkdp.NewMemory(tunnel, sgi)
+	})
+
+	Describe("programming the traffic control classes", func() {
+		It("should encode a HTB class with the rates in bytes per second", func() {
+			Expect(datapath.ReplaceClass(&domain.TrafficClass{
+				Link: tunnel, ID: 1, Address: ms, Rate: 8000, Ceil: 32000,
+			})).To(Succeed())
+
+			class := datapath.HtbClass(tunnel, 1)
+			Expect(class.LinkIndex).To(Equal(10))
+			Expect(class.Parent).To(Equal(netlink.MakeHandle(1, 0)))
+			Expect(class.Handle).To(Equal(netlink.MakeHandle(1, 1)))
+			Expect(class.Rate).To(Equal(uint64(1000)))
+			Expect(class.Ceil).To(Equal(uint64(4000)))
+		})
+		It("should classify the traffic by destination address", func() {
+			Expect(datapath.ReplaceClass(&domain.TrafficClass{Link: tunnel, ID: 1, Address: ms})).To(Succeed())
+
+			filter := datapath.U32Filter(tunnel, 1)
+			Expect(filter.Handle).To(Equal(uint32(0x800<<20 | 1)))
+			Expect(filter.ClassId).To(Equal(netlink.MakeHandle(1, 1)))
+			Expect(filter.Protocol).To(Equal(uint16(0x0800)))
+			Expect(filter.Sel.Keys).To(Equal([]netlink.TcU32Key{{Mask: 0xffffffff, Val: 0x0a000102, Off: 16}}))
+		})
+		It("should classify the traffic by source address", func() {
+			Expect(datapath.ReplaceClass(&domain.TrafficClass{
+				Link: sgi, ID: 2, Address: ms, Source: true,
+			})).To(Succeed())
+
+			Expect(datapath.U32Filter(sgi, 2).Sel.Keys).To(Equal([]netlink.TcU32Key{
+				{Mask: 0xffffffff, Val: 0x0a000102, Off: 12},
+			}))
+		})
+		It("should reject IPv6 addresses", func() {
+			Expect(datapath.ReplaceClass(&domain.TrafficClass{
+				Link: tunnel, ID: 1, Address: net.ParseIP("2001:db8::2"),
+			})).To(MatchError(netlinkdp.ErrInvalidAddress))
+			Expect(datapath.Class(tunnel, 1)).To(BeNil())
+		})
+		It("should read the statistics of the HTB class", func() {
+			class := &domain.TrafficClass{Link: tunnel, ID: 1, Address: ms}
+			Expect(datapath.ReplaceClass(class)).To(Succeed())
+
+			datapath.Account(tunnel, 1, 3, 300)
+
+			packets, bytes, err := datapath.ClassStatistics(class)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(packets).To(Equal(uint64(3)))
+			Expect(bytes).To(Equal(uint64(300)))
+		})
+		It("should delete the HTB class and its u32 filter", func() {
+			class := &domain.TrafficClass{Link: tunnel, ID: 1, Address: ms}
+			Expect(datapath.ReplaceClass(class)).To(Succeed())
+
+			Expect(datapath.DeleteClass(class)).To(Succeed())
+			Expect(datapath.HtbClass(tunnel, 1)).To(BeNil())
+			Expect(datapath.U32Filter(tunnel, 1)).To(BeNil())
+			Expect(datapath.DeleteClass(class)).To(MatchError(netlinkdp.ErrNotFound))
+		})
+	})
+
+	Describe("retrieving the links", func() {
+		It("should return the known links", func() {
+			Expect(datapath.LinkByName("eth2")).To(Equal(sgi))
+			_, err := datapath.LinkByName("eth3")
+			Expect(err).To(MatchError(netlinkdp.ErrNotFound))
+		})
+	})
+})
@@ -0,0 +1,243 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netlinkdp
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"github.com/wmnsk/go-gtp/gtpv1"
+)
+
+const (
+	scopeLink   = netlink.SCOPE_LINK
+	protoStatic = 4
+	metric      = 1
+
+	qdiscMajor uint16 = 1
+	// u32 filters are stored in the 800: hash table, the class ID is their node identifier.
+	u32Table      uint32 = 0x800 << 20
+	etherTypeIPv4 uint16 = 0x0800
+	// IPv4 header offsets used by the u32 classifier.
+	srcAddrOffset int32 = 12
+	dstAddrOffset int32 = 16
+)
+
+type kernel struct {
+	connection *gtpv1.UPlaneConn
+}
+
+// NewNetlink creates a datapath which programs the Linux kernel through netlink. Tunnels are
+// handled by the Kernel GTP module enabled in the given connection.
+func NewNetlink(conn *gtpv1.UPlaneConn) ports.Datapath {
+	return &kernel{connection: conn}
+}
+
+// AddTunnel adds or replaces a GTP-U tunnel of the Kernel GTP module.
+func (k *kernel) AddTunnel(peer, ms net.IP, otei, itei uint32) error {
+	return errors.Wrap(k.connection.AddTunnelOverride(peer, ms, otei, itei), "failed to add a GTP-U tunnel")
+}
+
+// DeleteTunnel removes a GTP-U tunnel of the Kernel GTP module.
+func (k *kernel) DeleteTunnel(itei uint32) error {
+	return errors.Wrap(k.connection.DelTunnelByITEI(itei), "failed to delete a GTP-U tunnel")
+}
+
+func newRoute(route *domain.Route) *netlink.Route {
+	return &netlink.Route{
+		Dst:       route.Destination,
+		LinkIndex: route.LinkIndex,
+		Table:     route.Table,
+		Scope:     scopeLink,
+		Protocol:  protoStatic,
+		Priority:  metric,
+	}
+}
+
+// ReplaceRoute adds or replaces a static route.
+func (k *kernel) ReplaceRoute(route *domain.Route) error {
+	return errors.Wrapf(netlink.RouteReplace(newRoute(route)), "failed to add %s route", route.Destination)
+}
+
+// DeleteRoute removes a static route.
+func (k *kernel) DeleteRoute(route *domain.Route) error {
+	return errors.Wrapf(netlink.RouteDel(newRoute(route)), "failed to delete %s route", route.Destination)
+}
+
+func newRule(rule *domain.Rule) *netlink.Rule {
+	r := netlink.NewRule()
+	r.IifName = rule.InputInterface
+	r.Dst = rule.Destination
+	r.Table = rule.Table
+
+	return r
+}
+
+// AddRule adds a policy routing rule.
+func (k *kernel) AddRule(rule *domain.Rule) error {
+	return errors.Wrapf(netlink.RuleAdd(newRule(rule)), "failed to add %s rule", rule.Destination)
+}
+
+// DeleteRule removes a policy routing rule.
+func (k *kernel) DeleteRule(rule *domain.Rule) error {
+	return errors.Wrapf(netlink.RuleDel(newRule(rule)), "failed to delete %s rule", rule.Destination)
+}
+
+// ListRules retrieves the IPv4 policy routing rules.
+func (k *kernel) ListRules() ([]*domain.Rule, error) {
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list rules")
+	}
+
+	result := make([]*domain.Rule, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, &domain.Rule{
+			InputInterface: rule.IifName,
+			Destination:    rule.Dst,
+			Table:          rule.Table,
+		})
+	}
+
+	return result, nil
+}
+
+// LinkByName retrieves a network interface.
+func (k *kernel) LinkByName(name string) (*domain.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s link", name)
+	}
+
+	return &domain.Link{Name: link.Attrs().Name, Index: link.Attrs().Index}, nil
+}
+
+type trafficControl struct{}
+
+// NewTrafficControl creates a traffic control which programs HTB classes and u32 filters in the
+// Linux kernel.
+func NewTrafficControl() ports.TrafficControl {
+	return &trafficControl{}
+}
+
+func newQdisc(link *domain.Link) *netlink.Htb {
+	return netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: link.Index,
+		Handle:    netlink.MakeHandle(qdiscMajor, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	})
+}
+
+func newClass(class *domain.TrafficClass) *netlink.HtbClass {
+	return netlink.NewHtbClass(netlink.ClassAttrs{
+		LinkIndex: class.Link.Index,
+		Parent:    netlink.MakeHandle(qdiscMajor, 0),
+		Handle:    netlink.MakeHandle(qdiscMajor, class.ID),
+	}, netlink.HtbClassAttrs{Rate: class.Rate, Ceil: class.Ceil})
+}
+
+func newFilter(class *domain.TrafficClass) *netlink.U32 {
+	offset := dstAddrOffset
+	if class.Source {
+		offset = srcAddrOffset
+	}
+
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: class.Link.Index,
+			Parent:    netlink.MakeHandle(qdiscMajor, 0),
+			Handle:    u32Table | uint32(class.ID),
+			Priority:  1,
+			Protocol:  etherTypeIPv4,
+		},
+		ClassId: netlink.MakeHandle(qdiscMajor, class.ID),
+	}
+
+	if ip := class.Address.To4(); ip != nil {
+		filter.Sel = &netlink.TcU32Sel{
+			Flags: netlink.TC_U32_TERMINAL,
+			Keys:  []netlink.TcU32Key{{Mask: 0xffffffff, Val: binary.BigEndian.Uint32(ip), Off: offset}},
+		}
+	}
+
+	return filter
+}
+
+// ReplaceQdisc adds or replaces the root HTB queueing discipline of a link.
+func (t *trafficControl) ReplaceQdisc(link *domain.Link) error {
+	return errors.Wrapf(netlink.QdiscReplace(newQdisc(link)), "failed to add %s HTB qdisc", link.Name)
+}
+
+// DeleteQdisc removes the root HTB queueing discipline of a link.
+func (t *trafficControl) DeleteQdisc(link *domain.Link) error {
+	return errors.Wrapf(netlink.QdiscDel(newQdisc(link)), "failed to delete %s HTB qdisc", link.Name)
+}
+
+// ReplaceClass adds or replaces the HTB class of an UE address and the u32 filter which
+// classifies its traffic.
+func (t *trafficControl) ReplaceClass(class *domain.TrafficClass) error {
+	if class.Address.To4() == nil {
+		return errors.Wrapf(ErrInvalidAddress, "%s UE address", class.Address)
+	}
+
+	if err := netlink.ClassReplace(newClass(class)); err != nil {
+		return errors.Wrapf(err, "failed to add %d HTB class", class.ID)
+	}
+
+	return errors.Wrapf(netlink.FilterReplace(newFilter(class)), "failed to add %s u32 filter", class.Address)
+}
+
+// DeleteClass removes the HTB class of an UE address and its u32 filter.
+func (t *trafficControl) DeleteClass(class *domain.TrafficClass) error {
+	filterErr := netlink.FilterDel(newFilter(class))
+
+	if err := netlink.ClassDel(newClass(class)); err != nil {
+		return errors.Wrapf(err, "failed to delete %d HTB class", class.ID)
+	}
+
+	return errors.Wrapf(filterErr, "failed to delete %d u32 filter", class.ID)
+}
+
+// ClassStatistics retrieves the packets and bytes sent through the HTB class of an UE address.
+func (t *trafficControl) ClassStatistics(class *domain.TrafficClass) (packets, bytes uint64, err error) {
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: class.Link.Name, Index: class.Link.Index}}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(qdiscMajor, 0))
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to list %s classes", class.Link.Name)
+	}
+
+	packets, bytes = getStatistics(classes, class.ID)
+
+	return packets, bytes, nil
+}
+
+// getStatistics retrieves the packets and bytes sent through the HTB class with the given ID.
+func getStatistics(classes []netlink.Class, id uint16) (packets, bytes uint64) {
+	for _, class := range classes {
+		attrs := class.Attrs()
+		if attrs.Handle != netlink.MakeHandle(qdiscMajor, id) || attrs.Statistics == nil ||
+			attrs.Statistics.Basic == nil {
+			continue
+		}
+
+		return uint64(attrs.Statistics.Basic.Packets), attrs.Statistics.Basic.Bytes
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdp
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+)
+
+// Datapath programs the host for the userspace forwarding. The GTP-U tunnels are bound to the
+// forwarder instead of the Linux kernel. The routes of a provisioned TUN device are left to the
+// operator and its traffic classes are policed and accounted by the forwarder, so the P-GW
+// doesn't need the NET_ADMIN capability.
+type Datapath struct {
+	host      ports.Datapath
+	control   ports.TrafficControl
+	forwarder *Forwarder
+}
+
+// NewDatapath creates the datapath of the userspace forwarding on top of the routes and the
+// traffic control of the host.
+func NewDatapath(forwarder *Forwarder, host ports.Datapath, control ports.TrafficControl) *Datapath {
+	return &Datapath{host: host, control: control, forwarder: forwarder}
+}
+
+// AddTunnel does nothing, the tunnels are bound through the forwarder.
+func (d *Datapath) AddTunnel(peer, ms net.IP, otei, itei uint32) error {
+	return nil
+}
+
+// DeleteTunnel does nothing, the tunnels are released through the forwarder.
+func (d *Datapath) DeleteTunnel(itei uint32) error {
+	return nil
+}
+
+// ReplaceRoute adds or replaces a route unless the TUN device is provisioned.
+func (d *Datapath) ReplaceRoute(route *domain.Route) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.host.ReplaceRoute(route)
+}
+
+// DeleteRoute removes a route unless the TUN device is provisioned.
+func (d *Datapath) DeleteRoute(route *domain.Route) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.host.DeleteRoute(route)
+}
+
+// AddRule adds a routing rule unless the TUN device is provisioned.
+func (d *Datapath) AddRule(rule *domain.Rule) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.host.AddRule(rule)
+}
+
+// DeleteRule removes a routing rule unless the TUN device is provisioned.
+func (d *Datapath) DeleteRule(rule *domain.Rule) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.host.DeleteRule(rule)
+}
+
+// ListRules retrieves the routing rules of the host.
+func (d *Datapath) ListRules() ([]*domain.Rule, error) {
+	return d.host.ListRules()
+}
+
+// LinkByName retrieves a link of the host.
+func (d *Datapath) LinkByName(name string) (*domain.Link, error) {
+	return d.host.LinkByName(name)
+}
+
+// ReplaceQdisc adds or replaces a queueing discipline unless the TUN device is provisioned.
+func (d *Datapath) ReplaceQdisc(link *domain.Link) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.control.ReplaceQdisc(link)
+}
+
+// DeleteQdisc removes a queueing discipline unless the TUN device is provisioned.
+func (d *Datapath) DeleteQdisc(link *domain.Link) error {
+	if d.forwarder.Provisioned() {
+		return nil
+	}
+
+	return d.control.DeleteQdisc(link)
+}
+
+// ReplaceClass adds or replaces a traffic control class, which is metered by the forwarder when
+// the TUN device is provisioned.
+func (d *Datapath) ReplaceClass(class *domain.TrafficClass) error {
+	if d.forwarder.Provisioned() {
+		d.forwarder.Meter(class.Address, class.Source, class.Ceil)
+
+		return nil
+	}
+
+	return d.control.ReplaceClass(class)
+}
+
+// DeleteClass removes a traffic control class, or the meters of its UE address when the TUN
+// device is provisioned.
+func (d *Datapath) DeleteClass(class *domain.TrafficClass) error {
+	if d.forwarder.Provisioned() {
+		d.forwarder.Unmeter(class.Address)
+
+		return nil
+	}
+
+	return d.control.DeleteClass(class)
+}
+
+// ClassStatistics retrieves the traffic of a class, accounted by the forwarder when the TUN
+// device is provisioned.
+func (d *Datapath) ClassStatistics(class *domain.TrafficClass) (packets, bytes uint64, err error) {
+	if d.forwarder.Provisioned() {
+		packets, bytes = d.forwarder.Statistics(class.Address, class.Source)
+
+		return packets, bytes, nil
+	}
+
+	return d.control.ClassStatistics(class)
+}
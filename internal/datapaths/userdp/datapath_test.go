@@ -0,0 +1,89 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdp_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/datapaths/userdp"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv1"
+)
+
+var _ = Describe("Datapath", func() {
+	var (
+		ms        = net.ParseIP("10.0.1.2").To4()
+		sgw       = &net.UDPAddr{IP: net.ParseIP("172.25.0.3"), Port: 2152}
+		config    *domain.Pgw
+		host      *netlinkdp.Memory
+		tun       net.Conn
+		userPlane *pgwhdl.UserPlane
+		shaper    *pgwhdl.TrafficShaper
+	)
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+		sgi := netlinkdp.NewLink("eth2", 2)
+		link := netlinkdp.NewLink("userspace-pgw", 20)
+
+		var err error
+
+		config, err = domain.New("172.25.1.2", "172.25.0.2", "", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		config.Sgi = &domain.Sgi{Link: sgi, Subnet: subnet}
+		config.UserPlane.Datapath = domain.DatapathAuto
+
+		var device net.Conn
+
+		device, tun = net.Pipe()
+		conn := gtpv1.NewUPlaneConn(sgw)
+		forwarder := userdp.NewForwarder(conn, device, link)
+		host = netlinkdp.NewMemory(sgi, link)
+		datapath := userdp.NewDatapath(forwarder, host, netlinkdp.NewTrafficControl())
+		shaper = pgwhdl.NewTrafficShaper(datapath)
+		userPlane = pgwhdl.NewUserPlane(conn, datapath, config, shaper, forwarder)
+
+		Expect(userPlane.Establish(ms, &domain.BearerBinding{EBI: 5, OutgoingTEID: 100, IncomingTEID: 200, Peer: sgw},
+			pgwhdl.BitRates{}, pgwhdl.BitRates{Uplink: 1000, Downlink: 2000})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(tun.Close()).To(Succeed())
+	})
+
+	Context("when the TUN device is provisioned", func() {
+		It("should leave the host untouched", func() {
+			Expect(host.Tunnels()).To(BeEmpty())
+			Expect(host.Routes()).To(BeEmpty())
+			Expect(host.ListRules()).To(BeEmpty())
+			Expect(host.Qdiscs()).To(BeEmpty())
+		})
+		It("should keep the configured datapath mode", func() {
+			Expect(config.UserPlane.Datapath).To(Equal(domain.DatapathAuto))
+		})
+		It("should account the traffic of the UEs", func() {
+			Expect(userPlane.Counters(ms)).To(Equal(&domain.TrafficCounters{}))
+		})
+		It("should release the PDN connections", func() {
+			Expect(userPlane.Release(ms)).To(Succeed())
+			Expect(userPlane.Close()).To(Succeed())
+			Expect(shaper.Close()).To(Succeed())
+		})
+	})
+})
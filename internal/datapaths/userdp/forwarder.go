@@ -0,0 +1,373 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdp
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	bufferSize  = 1500
+	ipv4HdrLen  = 20
+	ipv4Version = 4
+)
+
+// ErrNoDevice indicates that the TUN device wasn't created.
+var ErrNoDevice = errors.New("no TUN device")
+
+// meterKey identifies the meter of an UE address in one direction.
+type meterKey struct {
+	ms     string
+	uplink bool
+}
+
+// Forwarder moves UE traffic between a TUN device and GTP-U tunnels, selecting the bearer
+// of every downlink packet with the Traffic Flow Templates of the PDN connection. Uplink
+// T-PDUs not handled by the Linux kernel GTP module are decapsulated in userspace. The
+// traffic of the metered UE addresses is policed and accounted in userspace too.
+type Forwarder struct {
+	mutex       sync.RWMutex
+	connection  *gtpv1.UPlaneConn
+	device      io.ReadWriteCloser
+	link        *domain.Link
+	provisioned bool
+	bindings    map[string][]*domain.BearerBinding
+	teids       map[uint32]string
+	meters      map[meterKey]*meter
+}
+
+// New opens the TUN device used to forward the UE traffic in userspace. A device created
+// beforehand, owned by the user of the P-GW and up, is used without the NET_ADMIN capability.
+// Otherwise the device is created and it's removed once the forwarder is closed.
+func New(conn *gtpv1.UPlaneConn, name string) (*Forwarder, error) {
+	link, err := netlink.LinkByName(name)
+	provisioned := err == nil
+
+	device, err := openTUN(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s TUN device", name)
+	}
+
+	if !provisioned {
+		if link, err = netlink.LinkByName(name); err != nil {
+			_ = device.Close()
+
+			return nil, errors.Wrapf(err, "failed to get %s TUN device", name)
+		}
+	}
+
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err := netlink.LinkSetUp(link); err != nil {
+			_ = device.Close()
+
+			return nil, errors.Wrapf(err, "failed to set %s TUN device up", name)
+		}
+	}
+
+	attrs := link.Attrs()
+	forwarder := NewForwarder(conn, device, &domain.Link{Name: attrs.Name, Index: attrs.Index})
+	forwarder.provisioned = provisioned
+
+	return forwarder, nil
+}
+
+// NewForwarder creates a forwarder which uses a TUN device opened and set up by the caller.
+func NewForwarder(conn *gtpv1.UPlaneConn, device io.ReadWriteCloser, link *domain.Link) *Forwarder {
+	// unknown T-PDUs have to be passed to ReadFromGTP instead of being rejected.
+	conn.DisableErrorIndication()
+
+	return &Forwarder{
+		connection:  conn,
+		device:      device,
+		link:        link,
+		provisioned: true,
+		bindings:    map[string][]*domain.BearerBinding{},
+		teids:       map[uint32]string{},
+		meters:      map[meterKey]*meter{},
+	}
+}
+
+// openTUN attaches to the TUN device with the given name, which is created when it doesn't exist.
+// A created device is removed once it's closed.
+func openTUN(name string) (*os.File, error) {
+	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the TUN clone device")
+	}
+
+	request, err := unix.NewIfreq(name)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, errors.Wrapf(err, "invalid %q device name", name)
+	}
+
+	request.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+
+	// the descriptor is kept non-blocking, so closing the file stops the pending reads.
+	conn, err := file.SyscallConn()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, errors.Wrap(err, "failed to get the TUN descriptor")
+	}
+
+	var ioctlErr error
+
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlIfreq(int(fd), unix.TUNSETIFF, request)
+	}); err != nil {
+		ioctlErr = err
+	}
+
+	if ioctlErr != nil {
+		_ = file.Close()
+
+		return nil, errors.Wrap(ioctlErr, "failed to attach to the TUN device")
+	}
+
+	return file, nil
+}
+
+// Provisioned indicates if the TUN device was set up outside the P-GW, which doesn't program
+// its routes nor its traffic control then.
+func (f *Forwarder) Provisioned() bool {
+	return f.provisioned
+}
+
+// Link retrieves the TUN device used for routing the downlink traffic.
+func (f *Forwarder) Link() *domain.Link {
+	return f.link
+}
+
+// Bind adds or replaces the bearer tunnel used for the given UE IP address.
+func (f *Forwarder) Bind(ms net.IP, binding *domain.BearerBinding) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bindings := []*domain.BearerBinding{binding}
+
+	for _, current := range f.bindings[ms.String()] {
+		if current.EBI == binding.EBI {
+			delete(f.teids, current.IncomingTEID)
+
+			continue
+		}
+
+		bindings = append(bindings, current)
+	}
+
+	f.bindings[ms.String()] = bindings
+	f.teids[binding.IncomingTEID] = ms.String()
+
+	log.WithFields(log.Fields{
+		"ms":   ms,
+		"ebi":  binding.EBI,
+		"teid": binding.OutgoingTEID,
+	}).Debug("Bearer bound")
+}
+
+// Unbind removes the bearer tunnel identified by its EPS Bearer ID.
+func (f *Forwarder) Unbind(ms net.IP, ebi uint8) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bindings := []*domain.BearerBinding{}
+
+	for _, current := range f.bindings[ms.String()] {
+		if current.EBI == ebi {
+			delete(f.teids, current.IncomingTEID)
+
+			continue
+		}
+
+		bindings = append(bindings, current)
+	}
+
+	f.bindings[ms.String()] = bindings
+}
+
+// Release removes all the bearer tunnels of the given UE IP address.
+func (f *Forwarder) Release(ms net.IP) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, current := range f.bindings[ms.String()] {
+		delete(f.teids, current.IncomingTEID)
+	}
+
+	delete(f.bindings, ms.String())
+}
+
+// Meter limits the traffic of the given UE IP address in one direction to a rate in bits per
+// second, none when it's zero, and accounts the packets forwarded. The counters are kept when
+// the rate is replaced.
+func (f *Forwarder) Meter(ms net.IP, uplink bool, rate uint64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := meterKey{ms: ms.String(), uplink: uplink}
+	if current, ok := f.meters[key]; ok {
+		current.limit(rate)
+
+		return
+	}
+
+	f.meters[key] = newMeter(rate)
+}
+
+// Unmeter removes the meters of the given UE IP address.
+func (f *Forwarder) Unmeter(ms net.IP) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.meters, meterKey{ms: ms.String(), uplink: true})
+	delete(f.meters, meterKey{ms: ms.String(), uplink: false})
+}
+
+// Statistics retrieves the packets and bytes forwarded for the given UE IP address in one
+// direction, none when it isn't metered.
+func (f *Forwarder) Statistics(ms net.IP, uplink bool) (packets, bytes uint64) {
+	f.mutex.RLock()
+	current, ok := f.meters[meterKey{ms: ms.String(), uplink: uplink}]
+	f.mutex.RUnlock()
+
+	if !ok {
+		return 0, 0
+	}
+
+	return current.statistics()
+}
+
+// admit indicates if a packet of the given UE IP address conforms to its meter.
+func (f *Forwarder) admit(ms string, uplink bool, size int) bool {
+	f.mutex.RLock()
+	current, ok := f.meters[meterKey{ms: ms, uplink: uplink}]
+	f.mutex.RUnlock()
+
+	return !ok || current.admit(size)
+}
+
+// ListenAndServe forwards uplink and downlink traffic until the context is done.
+func (f *Forwarder) ListenAndServe(ctx context.Context) error {
+	if f.device == nil {
+		return ErrNoDevice
+	}
+
+	errCh := make(chan error, 2)
+
+	go func() {
+		errCh <- f.serveUplink(ctx)
+	}()
+
+	go func() {
+		errCh <- f.serveDownlink(f.device)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (f *Forwarder) serveUplink(ctx context.Context) error {
+	buffer := make([]byte, bufferSize)
+
+	for {
+		n, _, teid, err := f.connection.ReadFromGTP(buffer)
+		if err != nil {
+			return errors.Wrap(err, "failed to read from GTP-U connection")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		f.mutex.RLock()
+		ms, ok := f.teids[teid]
+		f.mutex.RUnlock()
+
+		if !ok {
+			log.Debugf("Dropping T-PDU received with unknown %d TEID", teid)
+
+			continue
+		}
+
+		if !f.admit(ms, true, n) {
+			continue
+		}
+
+		if _, err := f.device.Write(buffer[:n]); err != nil {
+			log.WithError(err).Warn("Failed to write uplink packet")
+		}
+	}
+}
+
+func (f *Forwarder) serveDownlink(device io.Reader) error {
+	buffer := make([]byte, bufferSize)
+
+	for {
+		n, err := device.Read(buffer)
+		if err != nil {
+			return errors.Wrap(err, "failed to read from TUN device")
+		}
+
+		packet := buffer[:n]
+		if n < ipv4HdrLen || packet[0]>>4 != ipv4Version {
+			continue
+		}
+
+		ms := net.IP(packet[16:20]).String()
+
+		f.mutex.RLock()
+		binding := domain.ClassifyDownlink(f.bindings[ms], packet)
+		f.mutex.RUnlock()
+
+		if binding == nil || !f.admit(ms, false, n) {
+			continue
+		}
+
+		if _, err := f.connection.WriteToGTP(binding.OutgoingTEID, packet, binding.Peer); err != nil {
+			log.WithError(err).Warnf("Failed to send downlink packet to %s", binding.Peer)
+		}
+	}
+}
+
+// Close detaches from the TUN device, which is removed when it was created by the forwarder.
+func (f *Forwarder) Close() error {
+	if err := f.device.Close(); err != nil {
+		log.WithError(err).Warnf("Failed to close %s TUN device", f.link.Name)
+	}
+
+	return nil
+}
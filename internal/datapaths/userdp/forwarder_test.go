@@ -0,0 +1,256 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdp_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/datapaths/userdp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv1/message"
+)
+
+const (
+	defaultTEID   uint32 = 0x20
+	dedicatedTEID uint32 = 0x21
+	udp           uint8  = 17
+)
+
+// newPacket creates an IPv4 header followed by the source and destination ports.
+func newPacket(src, dst string, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = udp
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	packet[20], packet[21] = byte(srcPort>>8), byte(srcPort)
+	packet[22], packet[23] = byte(dstPort>>8), byte(dstPort)
+
+	return packet
+}
+
+func getFreeAddress() *net.UDPAddr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	Expect(err).NotTo(HaveOccurred())
+
+	defer conn.Close()
+
+	laddr, _ := conn.LocalAddr().(*net.UDPAddr)
+
+	return laddr
+}
+
+var _ = Describe("Forwarder", func() {
+	var (
+		ms        = net.ParseIP("10.0.1.2")
+		pgw       *net.UDPAddr
+		sgw       *net.UDPConn
+		tun       net.Conn
+		forwarder *userdp.Forwarder
+		cancel    context.CancelFunc
+	)
+
+	// sendUplink sends a T-PDU from the S-GW and returns the packet written to the TUN device.
+	sendUplink := func(teid uint32, payload []byte) ([]byte, error) {
+		tpdu, err := message.NewTPDU(teid, payload).Marshal()
+		Expect(err).NotTo(HaveOccurred())
+
+		if _, err := sgw.WriteTo(tpdu, pgw); err != nil {
+			return nil, err
+		}
+
+		buffer := make([]byte, 1500)
+
+		Expect(tun.SetReadDeadline(time.Now().Add(100 * time.Millisecond))).To(Succeed())
+
+		n, err := tun.Read(buffer)
+
+		return buffer[:n], err
+	}
+
+	// sendDownlink writes a packet to the TUN device and returns the TEID of the T-PDU received by
+	// the S-GW.
+	sendDownlink := func(packet []byte) (uint32, error) {
+		Expect(tun.SetWriteDeadline(time.Now().Add(time.Second))).To(Succeed())
+
+		if _, err := tun.Write(packet); err != nil {
+			return 0, err
+		}
+
+		buffer := make([]byte, 1500)
+
+		Expect(sgw.SetReadDeadline(time.Now().Add(100 * time.Millisecond))).To(Succeed())
+
+		n, err := sgw.Read(buffer)
+		if err != nil {
+			return 0, err
+		}
+
+		tpdu, err := message.Parse(buffer[:n])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tpdu.(*message.TPDU).Payload).To(Equal(packet))
+
+		return tpdu.TEID(), nil
+	}
+
+	BeforeEach(func() {
+		var (
+			ctx    context.Context
+			device net.Conn
+			err    error
+		)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		pgw = getFreeAddress()
+		sgw, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		Expect(err).NotTo(HaveOccurred())
+
+		device, tun = net.Pipe()
+		conn := gtpv1.NewUPlaneConn(pgw)
+		forwarder = userdp.NewForwarder(conn, device, netlinkdp.NewLink("userspace-pgw", 20))
+
+		_, remote, _ := net.ParseCIDR("192.168.0.0/24")
+		protocol := udp
+		forwarder.Bind(ms, &domain.BearerBinding{EBI: 5, IncomingTEID: 0x10, OutgoingTEID: defaultTEID,
+			Peer: sgw.LocalAddr()})
+		forwarder.Bind(ms, &domain.BearerBinding{EBI: 6, IncomingTEID: 0x11, OutgoingTEID: dedicatedTEID,
+			Peer: sgw.LocalAddr(), TFT: &domain.TrafficFlowTemplate{
+				Operation: domain.TFTOperationCreate,
+				Filters: []*domain.PacketFilter{{
+					ID: 1, Direction: domain.DirectionDownlink, RemoteAddress: remote, Protocol: &protocol,
+					RemotePorts: &domain.PortRange{Low: 5060, High: 5060},
+				}},
+			}})
+
+		go func() {
+			_ = conn.ListenAndServe(ctx)
+		}()
+
+		go func() {
+			_ = forwarder.ListenAndServe(ctx)
+		}()
+
+		// the GTP-U connection is listening once the first uplink packet is forwarded
+		uplink := newPacket("10.0.1.2", "192.168.0.10", 40000, 5060)
+		Eventually(func() ([]byte, error) {
+			return sendUplink(0x10, uplink)
+		}, 5*time.Second).Should(Equal(uplink))
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(forwarder.Close()).To(Succeed())
+		Expect(tun.Close()).To(Succeed())
+		Expect(sgw.Close()).To(Succeed())
+	})
+
+	It("should be provisioned by the caller", func() {
+		Expect(forwarder.Provisioned()).To(BeTrue())
+		Expect(forwarder.Link().Name).To(Equal("userspace-pgw"))
+	})
+
+	Describe("forwarding uplink traffic", func() {
+		It("should decapsulate the T-PDUs of the dedicated bearers", func() {
+			packet := newPacket("10.0.1.2", "192.168.0.10", 40000, 5060)
+			Expect(sendUplink(0x11, packet)).To(Equal(packet))
+		})
+		It("should drop the T-PDUs of unknown tunnels", func() {
+			_, err := sendUplink(0x99, newPacket("10.0.1.2", "192.168.0.10", 40000, 5060))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("forwarding downlink traffic", func() {
+		It("should select the bearer whose packet filters match", func() {
+			Expect(sendDownlink(newPacket("192.168.0.10", "10.0.1.2", 5060, 40000))).To(Equal(dedicatedTEID))
+		})
+		It("should use the default bearer for the other packets", func() {
+			Expect(sendDownlink(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000))).To(Equal(defaultTEID))
+		})
+		It("should use the default bearer once the dedicated one is unbound", func() {
+			forwarder.Unbind(ms, 6)
+
+			Expect(sendDownlink(newPacket("192.168.0.10", "10.0.1.2", 5060, 40000))).To(Equal(defaultTEID))
+			_, err := sendUplink(0x11, newPacket("10.0.1.2", "192.168.0.10", 40000, 5060))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should drop the packets of released UEs", func() {
+			forwarder.Release(ms)
+
+			_, err := sendDownlink(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("metering the UE traffic", func() {
+		// large packets exhaust the burst of the slow meters at once
+		padding := make([]byte, 1000)
+
+		It("should account the forwarded packets", func() {
+			forwarder.Meter(ms, true, 0)
+			forwarder.Meter(ms, false, 0)
+
+			uplink := newPacket("10.0.1.2", "192.168.0.10", 40000, 5060)
+			Expect(sendUplink(0x10, uplink)).To(Equal(uplink))
+			Expect(sendDownlink(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000))).To(Equal(defaultTEID))
+			Expect(sendDownlink(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000))).To(Equal(defaultTEID))
+
+			packets, bytes := forwarder.Statistics(ms, true)
+			Expect(packets).To(Equal(uint64(1)))
+			Expect(bytes).To(Equal(uint64(len(uplink))))
+			packets, bytes = forwarder.Statistics(ms, false)
+			Expect(packets).To(Equal(uint64(2)))
+			Expect(bytes).To(Equal(uint64(2 * len(uplink))))
+		})
+		It("should drop the uplink packets beyond the rate", func() {
+			forwarder.Meter(ms, true, 8000)
+
+			packet := append(newPacket("10.0.1.2", "192.168.0.10", 40000, 5060), padding...)
+			Expect(sendUplink(0x10, packet)).To(Equal(packet))
+			_, err := sendUplink(0x10, packet)
+			Expect(err).To(HaveOccurred())
+
+			packets, bytes := forwarder.Statistics(ms, true)
+			Expect(packets).To(Equal(uint64(1)))
+			Expect(bytes).To(Equal(uint64(len(packet))))
+		})
+		It("should drop the downlink packets beyond the rate", func() {
+			forwarder.Meter(ms, false, 8000)
+
+			packet := append(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000), padding...)
+			Expect(sendDownlink(packet)).To(Equal(defaultTEID))
+			_, err := sendDownlink(packet)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should stop accounting once the meters are removed", func() {
+			forwarder.Meter(ms, false, 8000)
+			Expect(sendDownlink(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000))).To(Equal(defaultTEID))
+
+			forwarder.Unmeter(ms)
+
+			packets, bytes := forwarder.Statistics(ms, false)
+			Expect(packets).To(BeZero())
+			Expect(bytes).To(BeZero())
+			packet := append(newPacket("192.168.1.10", "10.0.1.2", 5060, 40000), padding...)
+			Expect(sendDownlink(packet)).To(Equal(defaultTEID))
+			Expect(sendDownlink(packet)).To(Equal(defaultTEID))
+		})
+	})
+})
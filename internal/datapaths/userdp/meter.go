@@ -0,0 +1,93 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdp
+
+import (
+	"sync"
+	"time"
+)
+
+// burstDuration is the traffic a meter lets through at once, as a share of its rate.
+const burstDuration = 100 * time.Millisecond
+
+// meter polices the traffic of an UE address in one direction with a token bucket, and
+// accounts the packets it lets through.
+type meter struct {
+	mutex   sync.Mutex
+	rate    uint64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	packets uint64
+	bytes   uint64
+}
+
+// newMeter creates a meter limited to the given rate in bits per second, none when it's zero.
+func newMeter(rate uint64) *meter {
+	m := &meter{}
+	m.limit(rate)
+
+	return m
+}
+
+// limit replaces the rate of the meter, keeping its counters.
+func (m *meter) limit(rate uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rate = rate
+	m.burst = float64(rate) / 8 * burstDuration.Seconds()
+
+	if m.burst < bufferSize {
+		m.burst = bufferSize
+	}
+
+	m.tokens = m.burst
+	m.last = time.Now()
+}
+
+// admit indicates if a packet of the given size conforms to the rate, and accounts it then.
+func (m *meter) admit(size int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.rate != 0 {
+		now := time.Now()
+		m.tokens += now.Sub(m.last).Seconds() * float64(m.rate) / 8
+		m.last = now
+
+		if m.tokens > m.burst {
+			m.tokens = m.burst
+		}
+
+		if m.tokens < float64(size) {
+			return false
+		}
+
+		m.tokens -= float64(size)
+	}
+
+	m.packets++
+	m.bytes += uint64(size)
+
+	return true
+}
+
+// statistics retrieves the packets and bytes let through by the meter.
+func (m *meter) statistics() (packets, bytes uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.packets, m.bytes
+}
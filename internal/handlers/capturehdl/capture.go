@@ -0,0 +1,362 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capturehdl
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/protocols/pcap"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+const filePrefix = "pgw"
+
+var (
+	// ErrCaptureActive indicates that a capture was requested while another one is running.
+	ErrCaptureActive = errors.New("capture already active")
+	// ErrNoCapture indicates that there is no capture to stop.
+	ErrNoCapture = errors.New("no active capture")
+	// ErrUnsupportedPlane indicates that the P-GW doesn't serve the plane requested to be captured.
+	ErrUnsupportedPlane = errors.New("plane not served")
+)
+
+// Filter selects the captured packets, all the S5-C messages are captured when it's empty.
+type Filter struct {
+	// IMSI captures the messages of a subscriber session and, on the S5-U, its T-PDUs.
+	IMSI string
+	// Peer captures the packets exchanged with a S-GW address.
+	Peer net.IP
+	// UserPlane captures the S5-U packets too.
+	UserPlane bool
+}
+
+// Status describes the current or the last capture.
+type Status struct {
+	Active  bool
+	Filter  Filter
+	Started time.Time
+	Packets int
+	Files   []string
+}
+
+// Capturer writes the S5-C messages and, optionally, the S5-U packets into rotating pcap files.
+type Capturer struct {
+	mutex      sync.Mutex
+	settings   *domain.Capture
+	connection *gtpv2.Conn
+	local      *net.UDPAddr
+	userPlane  net.IP
+
+	status  Status
+	writer  *pcap.RotatingWriter
+	sniffer *sniffer
+	// pending keeps the transactions with a captured message to capture their counterparts.
+	pending map[string]struct{}
+}
+
+// New creates a capturer of the messages handled through the control plane connection, which
+// is nil when the P-GW doesn't serve S5-C, and of the S5-U packets of the user plane address.
+func New(settings *domain.Capture, connection *gtpv2.Conn, local *net.UDPAddr, userPlane net.IP) *Capturer {
+	return &Capturer{
+		settings:   settings,
+		connection: connection,
+		local:      local,
+		userPlane:  userPlane,
+	}
+}
+
+// Start begins a capture of the packets which match the filter.
+func (c *Capturer) Start(filter Filter) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.status.Active {
+		return ErrCaptureActive
+	}
+
+	if filter.UserPlane && c.userPlane == nil {
+		return errors.Wrap(ErrUnsupportedPlane, "failed to capture S5-U packets")
+	}
+
+	writer, err := pcap.NewRotatingWriter(c.settings.Directory, filePrefix, c.settings.MaxFileSize,
+		c.settings.MaxFiles)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the capture files")
+	}
+
+	c.writer = writer
+	c.pending = map[string]struct{}{}
+	c.status = Status{Active: true, Filter: filter, Started: time.Now()}
+
+	if filter.UserPlane {
+		if c.sniffer, err = newSniffer(c.captureUserPlane); err != nil {
+			c.stop()
+
+			return errors.Wrap(err, "failed to capture S5-U packets")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"IMSI":      filter.IMSI,
+		"peer":      filter.Peer,
+		"userPlane": filter.UserPlane,
+	}).Info("Capture started")
+
+	return nil
+}
+
+// Stop ends the active capture.
+func (c *Capturer) Stop() (*Status, error) {
+	c.mutex.Lock()
+	active := c.status.Active
+	c.mutex.Unlock()
+
+	if !active {
+		return nil, ErrNoCapture
+	}
+
+	// the sniffer is closed without holding the lock because it waits for its last packet.
+	c.closeSniffer()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stop()
+	status := c.getStatus()
+
+	log.WithFields(log.Fields{
+		"packets": status.Packets,
+		"files":   status.Files,
+	}).Info("Capture stopped")
+
+	return status, nil
+}
+
+// Status describes the active capture or the last one.
+func (c *Capturer) Status() *Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.getStatus()
+}
+
+// Close stops the active capture.
+func (c *Capturer) Close() error {
+	if _, err := c.Stop(); err != nil && !errors.Is(err, ErrNoCapture) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Capturer) getStatus() *Status {
+	status := c.status
+	if c.writer != nil {
+		status.Files = c.writer.Files()
+	}
+
+	return &status
+}
+
+func (c *Capturer) closeSniffer() {
+	c.mutex.Lock()
+	sniffer := c.sniffer
+	c.sniffer = nil
+	c.mutex.Unlock()
+
+	if sniffer != nil {
+		if err := sniffer.Close(); err != nil {
+			log.WithError(err).Warn("Failed to stop the S5-U capture")
+		}
+	}
+}
+
+func (c *Capturer) stop() {
+	if err := c.writer.Close(); err != nil {
+		log.WithError(err).Warn("Failed to close the capture file")
+	}
+
+	c.status.Active = false
+	c.pending = nil
+}
+
+// Wrap ensures that the messages received by the handler are captured.
+func (c *Capturer) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		c.captureControlPlane(sender, msg, true)
+
+		return apiHandler(connection, sender, msg)
+	}
+}
+
+// MessageSent captures the messages sent by the handlers.
+func (c *Capturer) MessageSent(peer net.Addr, msg message.Message) {
+	c.captureControlPlane(peer, msg, false)
+}
+
+func (c *Capturer) captureControlPlane(peer net.Addr, msg message.Message, received bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.status.Active || !c.matchPeer(peer) {
+		return
+	}
+
+	if c.status.Filter.IMSI != "" && !c.matchTransaction(peer, msg, received) {
+		return
+	}
+
+	payload, err := message.Marshal(msg)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to capture %s message", msg.MessageTypeName())
+
+		return
+	}
+
+	remote, ok := peer.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	src, dst := c.local, remote
+	if received {
+		src, dst = remote, c.local
+	}
+
+	packet, err := pcap.NewUDPPacket(src, dst, payload)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to capture %s message", msg.MessageTypeName())
+
+		return
+	}
+
+	c.write(packet)
+}
+
+func (c *Capturer) captureUserPlane(packet []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.status.Active || !c.matchUserPlane(packet) {
+		return
+	}
+
+	c.write(packet)
+}
+
+func (c *Capturer) write(packet []byte) {
+	if err := c.writer.WritePacket(time.Now(), packet); err != nil {
+		log.WithError(err).Warn("Failed to write the captured packet")
+
+		return
+	}
+
+	c.status.Packets++
+}
+
+func (c *Capturer) matchPeer(peer net.Addr) bool {
+	if c.status.Filter.Peer == nil {
+		return true
+	}
+
+	udpAddr, ok := peer.(*net.UDPAddr)
+
+	return ok && udpAddr.IP.Equal(c.status.Filter.Peer)
+}
+
+// matchTransaction checks the subscriber of the transaction, given that the responses can't
+// always be looked up, it remembers the transactions of the matched messages.
+func (c *Capturer) matchTransaction(peer net.Addr, msg message.Message, received bool) bool {
+	key := fmt.Sprintf("%s/%d", peer, msg.Sequence())
+	if _, ok := c.pending[key]; ok {
+		delete(c.pending, key)
+
+		return true
+	}
+
+	if c.status.Filter.IMSI != c.getIMSI(peer, msg, received) {
+		return false
+	}
+
+	c.pending[key] = struct{}{}
+
+	return true
+}
+
+// getIMSI retrieves the subscriber of a message, which is only carried by the requests which
+// create sessions, so the rest are looked up by their TEID.
+func (c *Capturer) getIMSI(peer net.Addr, msg message.Message, received bool) string {
+	if request, ok := msg.(*message.CreateSessionRequest); ok && request.IMSI != nil {
+		imsi, err := request.IMSI.IMSI()
+		if err != nil {
+			return ""
+		}
+
+		return imsi
+	}
+
+	if c.connection == nil {
+		return ""
+	}
+
+	if received {
+		imsi, err := c.connection.GetIMSIByTEID(msg.TEID(), peer)
+		if err != nil {
+			return ""
+		}
+
+		return imsi
+	}
+
+	for _, session := range c.connection.Sessions() {
+		if session.PeerAddr().String() != peer.String() {
+			continue
+		}
+
+		if teid, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC); err == nil && teid == msg.TEID() {
+			return session.IMSI
+		}
+	}
+
+	return ""
+}
+
+// getSubscriberIP retrieves the UE address of the filtered subscriber to match its T-PDUs.
+func (c *Capturer) getSubscriberIP() net.IP {
+	if c.connection == nil {
+		return nil
+	}
+
+	session, err := c.connection.GetSessionByIMSI(c.status.Filter.IMSI)
+	if err != nil {
+		return nil
+	}
+
+	bearer := session.GetDefaultBearer()
+	if bearer == nil {
+		return nil
+	}
+
+	return net.ParseIP(bearer.SubscriberIP)
+}
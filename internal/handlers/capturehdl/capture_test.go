@@ -0,0 +1,178 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capturehdl_test
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/capturehdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+const (
+	imsi      = "123451234567891"
+	otherIMSI = "123451234567892"
+)
+
+// readPackets returns the IP packets of a capture file.
+func readPackets(path string) [][]byte {
+	content, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(len(content)).To(BeNumerically(">=", 24))
+
+	packets := [][]byte{}
+
+	for offset := 24; offset < len(content); {
+		length := int(binary.LittleEndian.Uint32(content[offset+8 : offset+12]))
+		packets = append(packets, content[offset+16:offset+16+length])
+		offset += 16 + length
+	}
+
+	return packets
+}
+
+// getMessage parses the GTPv2 message carried by a captured IPv4 packet.
+func getMessage(packet []byte) message.Message {
+	msg, err := message.Parse(packet[28:])
+	Expect(err).NotTo(HaveOccurred())
+
+	return msg
+}
+
+var _ = Describe("Capturer", func() {
+	var (
+		capturer   *capturehdl.Capturer
+		connection *gtpv2.Conn
+		directory  string
+		pgw        = &net.UDPAddr{IP: net.ParseIP("172.25.1.2"), Port: 2123}
+		sgw        = &net.UDPAddr{IP: net.ParseIP("172.25.1.3"), Port: 2123}
+		otherSGW   = &net.UDPAddr{IP: net.ParseIP("172.25.1.4"), Port: 2123}
+		handler    func(*gtpv2.Conn, net.Addr, message.Message) error
+	)
+
+	newCreateRequest := func(imsi string, sequence uint32) message.Message {
+		return message.NewCreateSessionRequest(0, sequence, ie.NewIMSI(imsi))
+	}
+
+	newCreateResponse := func(sequence uint32) message.Message {
+		return message.NewCreateSessionResponse(0x10, sequence,
+			ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil))
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		directory, err = os.MkdirTemp("", "capturehdl")
+		Expect(err).NotTo(HaveOccurred())
+
+		connection = gtpv2.NewConn(pgw, gtpv2.IFTypeS5S8PGWGTPC, 0)
+		capturer = capturehdl.New(&domain.Capture{
+			Directory:   directory,
+			MaxFileSize: domain.DefaultCaptureMaxFileSize,
+			MaxFiles:    domain.DefaultCaptureMaxFiles,
+		}, connection, pgw, nil)
+		handler = capturer.Wrap(func(*gtpv2.Conn, net.Addr, message.Message) error {
+			return nil
+		})
+	})
+
+	AfterEach(func() {
+		Expect(capturer.Close()).To(Succeed())
+		Expect(os.RemoveAll(directory)).To(Succeed())
+	})
+
+	It("should not capture messages when it's stopped", func() {
+		Expect(handler(connection, sgw, newCreateRequest(imsi, 1))).To(Succeed())
+
+		status := capturer.Status()
+		Expect(status.Active).To(BeFalse())
+		Expect(status.Packets).To(BeZero())
+	})
+
+	It("should capture the received and sent messages", func() {
+		Expect(capturer.Start(capturehdl.Filter{})).To(Succeed())
+		Expect(handler(connection, sgw, newCreateRequest(imsi, 1))).To(Succeed())
+		capturer.MessageSent(sgw, newCreateResponse(1))
+
+		status, err := capturer.Stop()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Active).To(BeFalse())
+		Expect(status.Packets).To(Equal(2))
+		Expect(status.Files).To(HaveLen(1))
+
+		packets := readPackets(status.Files[0])
+		Expect(packets).To(HaveLen(2))
+		Expect(net.IP(packets[0][12:16]).Equal(sgw.IP)).To(BeTrue())
+		Expect(net.IP(packets[0][16:20]).Equal(pgw.IP)).To(BeTrue())
+		Expect(getMessage(packets[0]).MessageType()).To(Equal(message.MsgTypeCreateSessionRequest))
+		Expect(net.IP(packets[1][12:16]).Equal(pgw.IP)).To(BeTrue())
+		Expect(getMessage(packets[1]).MessageType()).To(Equal(message.MsgTypeCreateSessionResponse))
+	})
+
+	It("should capture the messages of the filtered peer", func() {
+		Expect(capturer.Start(capturehdl.Filter{Peer: sgw.IP})).To(Succeed())
+		Expect(handler(connection, sgw, newCreateRequest(imsi, 1))).To(Succeed())
+		Expect(handler(connection, otherSGW, newCreateRequest(otherIMSI, 2))).To(Succeed())
+
+		status, err := capturer.Stop()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Packets).To(Equal(1))
+	})
+
+	It("should capture the transactions of the filtered subscriber", func() {
+		session := gtpv2.NewSession(sgw, &gtpv2.Subscriber{IMSI: imsi})
+		session.AddTEID(gtpv2.IFTypeS5S8SGWGTPC, 0x20)
+		connection.RegisterSession(0x30, session)
+
+		Expect(capturer.Start(capturehdl.Filter{IMSI: imsi})).To(Succeed())
+		Expect(handler(connection, sgw, newCreateRequest(imsi, 1))).To(Succeed())
+		capturer.MessageSent(sgw, newCreateResponse(1))
+		Expect(handler(connection, sgw, newCreateRequest(otherIMSI, 2))).To(Succeed())
+		capturer.MessageSent(sgw, newCreateResponse(2))
+		Expect(handler(connection, sgw, message.NewDeleteSessionRequest(0x30, 3))).To(Succeed())
+		capturer.MessageSent(sgw, message.NewDeleteSessionResponse(0x20, 3))
+		capturer.MessageSent(sgw, message.NewCreateBearerRequest(0x20, 4))
+
+		status, err := capturer.Stop()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Packets).To(Equal(5))
+
+		packets := readPackets(status.Files[0])
+		Expect(packets).To(HaveLen(5))
+		Expect(getMessage(packets[2]).MessageType()).To(Equal(message.MsgTypeDeleteSessionRequest))
+		Expect(getMessage(packets[4]).MessageType()).To(Equal(message.MsgTypeCreateBearerRequest))
+	})
+
+	It("should run a single capture", func() {
+		Expect(capturer.Start(capturehdl.Filter{})).To(Succeed())
+		Expect(capturer.Start(capturehdl.Filter{})).To(MatchError(capturehdl.ErrCaptureActive))
+	})
+
+	It("should fail to stop without an active capture", func() {
+		_, err := capturer.Stop()
+		Expect(err).To(MatchError(capturehdl.ErrNoCapture))
+	})
+
+	It("should fail to capture the user plane when it isn't served", func() {
+		Expect(capturer.Start(capturehdl.Filter{UserPlane: true})).To(MatchError(capturehdl.ErrUnsupportedPlane))
+		Expect(capturer.Status().Active).To(BeFalse())
+	})
+})
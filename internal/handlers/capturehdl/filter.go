@@ -0,0 +1,140 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capturehdl
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	gtpUPort        = 2152
+	gtpUMessageTPDU = 255
+	udpProtocol     = 17
+)
+
+// matchUserPlane checks if a S5-U packet is exchanged with the filtered peer and, when a
+// subscriber is filtered, if it tunnels the traffic of its UE address.
+func (c *Capturer) matchUserPlane(packet []byte) bool {
+	src, dst, protocol, payload, ok := parseIP(packet)
+	if !ok || protocol != udpProtocol || len(payload) < 8 {
+		return false
+	}
+
+	if binary.BigEndian.Uint16(payload[0:2]) != gtpUPort && binary.BigEndian.Uint16(payload[2:4]) != gtpUPort {
+		return false
+	}
+
+	var remote net.IP
+
+	switch {
+	case src.Equal(c.userPlane):
+		remote = dst
+	case dst.Equal(c.userPlane):
+		remote = src
+	default:
+		return false
+	}
+
+	if c.status.Filter.Peer != nil && !remote.Equal(c.status.Filter.Peer) {
+		return false
+	}
+
+	if c.status.Filter.IMSI == "" {
+		return true
+	}
+
+	ue := c.getSubscriberIP()
+	if ue == nil {
+		return false
+	}
+
+	tpdu, ok := getTPDU(payload[8:])
+	if !ok {
+		return false
+	}
+
+	innerSrc, innerDst, _, _, ok := parseIP(tpdu)
+
+	return ok && (innerSrc.Equal(ue) || innerDst.Equal(ue))
+}
+
+// parseIP retrieves the addresses, the protocol and the payload of an IPv4 or IPv6 packet.
+func parseIP(packet []byte) (src, dst net.IP, protocol uint8, payload []byte, ok bool) {
+	if len(packet) == 0 {
+		return nil, nil, 0, nil, false
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		headerLength := int(packet[0]&0x0f) * 4
+		if headerLength < 20 || len(packet) < headerLength {
+			return nil, nil, 0, nil, false
+		}
+
+		return net.IP(packet[12:16]), net.IP(packet[16:20]), packet[9], packet[headerLength:], true
+	case 6:
+		if len(packet) < 40 {
+			return nil, nil, 0, nil, false
+		}
+
+		return net.IP(packet[8:24]), net.IP(packet[24:40]), packet[6], packet[40:], true
+	}
+
+	return nil, nil, 0, nil, false
+}
+
+// getTPDU retrieves the user packet tunneled by a GTP-U message, skipping its optional fields
+// and extension headers.
+func getTPDU(message []byte) ([]byte, bool) {
+	const (
+		headerLength     = 8
+		optionalLength   = 4
+		extensionFlag    = 0x04
+		optionalFlags    = 0x07
+		gtpVersionOffset = 5
+	)
+
+	if len(message) < headerLength || message[0]>>gtpVersionOffset != 1 || message[1] != gtpUMessageTPDU {
+		return nil, false
+	}
+
+	offset := headerLength
+	if message[0]&optionalFlags == 0 {
+		return message[offset:], true
+	}
+
+	offset += optionalLength
+	if len(message) < offset {
+		return nil, false
+	}
+
+	if message[0]&extensionFlag != 0 {
+		for next := message[offset-1]; next != 0; {
+			if len(message) <= offset {
+				return nil, false
+			}
+
+			length := int(message[offset]) * 4
+			if length == 0 || len(message) < offset+length {
+				return nil, false
+			}
+
+			next = message[offset+length-1]
+			offset += length
+		}
+	}
+
+	return message[offset:], true
+}
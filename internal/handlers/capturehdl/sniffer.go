@@ -0,0 +1,109 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capturehdl
+
+import (
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const snifferBufferSize = 65535
+
+// sniffer receives copies of the IP packets sent and received through all the interfaces.
+type sniffer struct {
+	file     *os.File
+	loopback int
+	done     sync.WaitGroup
+}
+
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
+
+// newSniffer opens a packet socket which passes the IP packets to the capture function until
+// it's closed.
+func newSniffer(capture func(packet []byte)) (*sniffer, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC,
+		int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the packet socket")
+	}
+
+	s := &sniffer{file: os.NewFile(uintptr(fd), "packet"), loopback: -1}
+
+	if interfaces, err := net.Interfaces(); err == nil {
+		for i := range interfaces {
+			if interfaces[i].Flags&net.FlagLoopback != 0 {
+				s.loopback = interfaces[i].Index
+			}
+		}
+	}
+
+	s.done.Add(1)
+
+	go s.receive(capture)
+
+	return s, nil
+}
+
+func (s *sniffer) receive(capture func(packet []byte)) {
+	defer s.done.Done()
+
+	conn, err := s.file.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	buffer := make([]byte, snifferBufferSize)
+
+	for {
+		var (
+			length  int
+			from    unix.Sockaddr
+			recvErr error
+		)
+
+		if err := conn.Read(func(fd uintptr) bool {
+			length, from, recvErr = unix.Recvfrom(int(fd), buffer, 0)
+
+			return !errors.Is(recvErr, unix.EAGAIN)
+		}); err != nil {
+			return
+		}
+
+		if recvErr != nil {
+			continue
+		}
+
+		// the loopback interface passes every packet twice, once when it's sent.
+		if link, ok := from.(*unix.SockaddrLinklayer); ok && link.Ifindex == s.loopback &&
+			link.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+
+		capture(append([]byte{}, buffer[:length]...))
+	}
+}
+
+// Close stops receiving packets.
+func (s *sniffer) Close() error {
+	err := s.file.Close()
+	s.done.Wait()
+
+	return errors.Wrap(err, "failed to close the packet socket")
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counterhdl
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+const (
+	directionUplink   = "uplink"
+	directionDownlink = "downlink"
+)
+
+// TrafficSource retrieves the user plane traffic forwarded for an UE.
+type TrafficSource interface {
+	Counters(ms net.IP) (*domain.TrafficCounters, error)
+}
+
+// sessionCollector exports the sessions registered in a GTPv2 connection when they are scraped.
+type sessionCollector struct {
+	mutex      sync.RWMutex
+	connection *gtpv2.Conn
+	pool       *net.IPNet
+	poolSize   float64
+	traffic    TrafficSource
+
+	sessions    *prometheus.Desc
+	bearers     *prometheus.Desc
+	poolUsed    *prometheus.Desc
+	utilization *prometheus.Desc
+	packets     *prometheus.Desc
+	bytes       *prometheus.Desc
+}
+
+func newSessionCollector(connection *gtpv2.Conn, pool *net.IPNet, traffic TrafficSource) *sessionCollector {
+	collector := &sessionCollector{
+		connection: connection,
+		traffic:    traffic,
+		sessions: prometheus.NewDesc("active_sessions", "Active PDN connections per APN",
+			[]string{"apn"}, nil),
+		bearers: prometheus.NewDesc("active_bearers", "Active bearers per APN",
+			[]string{"apn"}, nil),
+		poolUsed: prometheus.NewDesc("ip_pool_used_addresses", "UE addresses of the pool in use",
+			nil, nil),
+		utilization: prometheus.NewDesc("ip_pool_utilization_ratio", "Fraction of the UE address pool in use",
+			nil, nil),
+		// Released sessions aren't accounted anymore, so the totals are reset when they decrease
+		packets: prometheus.NewDesc("user_plane_packets_total", "User plane packets of the active sessions per APN",
+			[]string{"apn", "direction"}, nil),
+		bytes: prometheus.NewDesc("user_plane_bytes_total", "User plane bytes of the active sessions per APN",
+			[]string{"apn", "direction"}, nil),
+	}
+
+	collector.resize(pool)
+
+	return collector
+}
+
+// resize changes the UE address pool whose usage is exported.
+func (c *sessionCollector) resize(pool *net.IPNet) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pool, c.poolSize = pool, 0
+
+	if pool != nil {
+		ones, bits := pool.Mask.Size()
+		// The network and broadcast addresses can't be assigned
+		c.poolSize = float64(uint64(1)<<(bits-ones)) - 2
+	}
+}
+
+// Describe sends the descriptors of the session metrics.
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sessions
+	ch <- c.bearers
+	ch <- c.poolUsed
+	ch <- c.utilization
+	ch <- c.packets
+	ch <- c.bytes
+}
+
+// Collect sends the current values of the session metrics.
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	sessions := map[string]int{}
+	bearers := map[string]int{}
+	traffic := map[string]*domain.TrafficCounters{}
+	used := 0
+
+	c.mutex.RLock()
+	pool, poolSize := c.pool, c.poolSize
+	c.mutex.RUnlock()
+
+	for _, session := range c.connection.Sessions() {
+		bearer := session.GetDefaultBearer()
+		if !session.IsActive() || bearer == nil {
+			continue
+		}
+
+		sessions[bearer.APN]++
+		bearers[bearer.APN] += session.BearerCount()
+
+		ip := net.ParseIP(bearer.SubscriberIP)
+		if ip != nil && pool != nil && pool.Contains(ip) {
+			used++
+		}
+
+		if counters := c.getCounters(ip); counters != nil {
+			if _, ok := traffic[bearer.APN]; !ok {
+				traffic[bearer.APN] = &domain.TrafficCounters{}
+			}
+
+			traffic[bearer.APN].Add(counters)
+		}
+	}
+
+	for apn, count := range sessions {
+		ch <- prometheus.MustNewConstMetric(c.sessions, prometheus.GaugeValue, float64(count), apn)
+		ch <- prometheus.MustNewConstMetric(c.bearers, prometheus.GaugeValue, float64(bearers[apn]), apn)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.poolUsed, prometheus.GaugeValue, float64(used))
+
+	if poolSize > 0 {
+		ch <- prometheus.MustNewConstMetric(c.utilization, prometheus.GaugeValue, float64(used)/poolSize)
+	}
+
+	for apn, counters := range traffic {
+		for _, value := range []struct {
+			desc      *prometheus.Desc
+			direction string
+			value     uint64
+		}{
+			{c.packets, directionUplink, counters.UplinkPackets},
+			{c.packets, directionDownlink, counters.DownlinkPackets},
+			{c.bytes, directionUplink, counters.UplinkBytes},
+			{c.bytes, directionDownlink, counters.DownlinkBytes},
+		} {
+			ch <- prometheus.MustNewConstMetric(value.desc, prometheus.CounterValue, float64(value.value),
+				apn, value.direction)
+		}
+	}
+}
+
+// getCounters retrieves the traffic of an UE, sessions which aren't accounted are skipped.
+func (c *sessionCollector) getCounters(ms net.IP) *domain.TrafficCounters {
+	if c.traffic == nil || ms == nil {
+		return nil
+	}
+
+	counters, err := c.traffic.Counters(ms)
+	if err != nil {
+		return nil
+	}
+
+	return counters
+}
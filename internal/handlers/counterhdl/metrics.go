@@ -0,0 +1,185 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counterhdl
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+const (
+	directionReceived = "received"
+	directionSent     = "sent"
+)
+
+// Metrics groups the Prometheus collectors which describe the P-GW activity.
+type Metrics struct {
+	registerer prometheus.Registerer
+	messages   *prometheus.CounterVec
+	blocked    *prometheus.CounterVec
+	retransmit *prometheus.CounterVec
+	rejected   *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	sessions   *sessionCollector
+
+	// DatastoreErrors counts the failed datastore operations.
+	DatastoreErrors *prometheus.CounterVec
+	// SessionsCreated counts the Create Session Requests handled successfully.
+	SessionsCreated prometheus.Counter
+}
+
+// NewMetrics creates the P-GW collectors and registers them in the given registerer.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	factory := promauto.With(registerer)
+
+	return &Metrics{
+		registerer: registerer,
+		messages: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "gtp_messages_total",
+			Help: "GTPv2 messages received and sent by the P-GW",
+		}, []string{"direction", "type", "peer", "cause"}),
+		blocked: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "gtp_messages_blocked_total",
+			Help: "GTPv2 messages blocked by the S5/S8 firewall",
+		}, []string{"type", "peer", "reason"}),
+		retransmit: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "gtp_retransmissions_total",
+			Help: "Retransmitted GTPv2 requests received",
+		}, []string{"type", "peer", "action"}),
+		rejected: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "sessions_rejected_total",
+			Help: "Create Session Requests rejected by the admission limits",
+		}, []string{"peer", "reason"}),
+		latency: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gtp_handler_duration_seconds",
+			Help:    "Time spent processing the received GTPv2 messages",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"type", "result"}),
+		DatastoreErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "datastore_errors_total",
+			Help: "Datastore operations which failed",
+		}, []string{"operation"}),
+		SessionsCreated: factory.NewCounter(prometheus.CounterOpts{
+			Name: "sessions_created_total",
+			Help: "Create Session Request",
+		}),
+	}
+}
+
+// Wrap ensures that the received messages and the handler latency are recorded.
+func (m *Metrics) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		m.count(directionReceived, sender, msg)
+
+		start := time.Now()
+		err := apiHandler(connection, sender, msg)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+
+		m.latency.WithLabelValues(msg.MessageTypeName(), result).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// MessageSent records a message sent to a peer.
+func (m *Metrics) MessageSent(peer net.Addr, msg message.Message) {
+	m.count(directionSent, peer, msg)
+}
+
+// MessageBlocked records a message blocked by the firewall.
+func (m *Metrics) MessageBlocked(peer net.Addr, msg message.Message, reason string) {
+	m.blocked.WithLabelValues(msg.MessageTypeName(), getPeer(peer), reason).Inc()
+}
+
+// SessionRejected records a Create Session Request rejected by the admission limits.
+func (m *Metrics) SessionRejected(peer net.Addr, _ message.Message, reason string) {
+	m.rejected.WithLabelValues(getPeer(peer), reason).Inc()
+}
+
+// RetransmissionDetected records a retransmitted request.
+func (m *Metrics) RetransmissionDetected(peer net.Addr, msg message.Message, action string) {
+	m.retransmit.WithLabelValues(msg.MessageTypeName(), getPeer(peer), action).Inc()
+}
+
+// ObserveSessions exports the active sessions of the connection, the usage of the UE address pool and,
+// when a traffic source is given, the user plane traffic.
+func (m *Metrics) ObserveSessions(connection *gtpv2.Conn, pool *net.IPNet, traffic TrafficSource) error {
+	m.sessions = newSessionCollector(connection, pool, traffic)
+
+	return m.registerer.Register(m.sessions)
+}
+
+// ResizePool changes the UE address pool whose usage is exported once it grows.
+func (m *Metrics) ResizePool(pool *net.IPNet) {
+	if m.sessions != nil {
+		m.sessions.resize(pool)
+	}
+}
+
+func (m *Metrics) count(direction string, peer net.Addr, msg message.Message) {
+	m.messages.WithLabelValues(direction, msg.MessageTypeName(), getPeer(peer), getCause(msg)).Inc()
+}
+
+// getPeer retrieves the address of the peer without its port to keep the label cardinality low.
+func getPeer(peer net.Addr) string {
+	if peer == nil {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(peer.String()); err == nil {
+		return host
+	}
+
+	return peer.String()
+}
+
+func getCause(msg message.Message) string {
+	var causeIE *ie.IE
+
+	switch response := msg.(type) {
+	case *message.CreateSessionResponse:
+		causeIE = response.Cause
+	case *message.DeleteSessionResponse:
+		causeIE = response.Cause
+	case *message.ModifyBearerResponse:
+		causeIE = response.Cause
+	case *message.CreateBearerResponse:
+		causeIE = response.Cause
+	}
+
+	if causeIE == nil {
+		return ""
+	}
+
+	cause, err := causeIE.Cause()
+	if err != nil {
+		return "invalid"
+	}
+
+	return strconv.Itoa(int(cause))
+}
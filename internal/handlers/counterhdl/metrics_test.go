@@ -0,0 +1,184 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counterhdl_test
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+var errHandler = errors.New("handler error")
+
+// traffic reports the counters of the UE addresses, the rest of them aren't accounted.
+type traffic map[string]*domain.TrafficCounters
+
+func (t traffic) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	counters, ok := t[ms.String()]
+	if !ok {
+		return nil, errHandler
+	}
+
+	return counters, nil
+}
+
+var _ = Describe("Metrics", func() {
+	var (
+		registry *prometheus.Registry
+		metrics  *counterhdl.Metrics
+		sgw      = &net.UDPAddr{IP: net.ParseIP("172.25.1.3"), Port: 2123}
+	)
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		metrics = counterhdl.NewMetrics(registry)
+	})
+
+	Describe("wrapping a handler", func() {
+		var handler func(*gtpv2.Conn, net.Addr, message.Message) error
+
+		BeforeEach(func() {
+			handler = metrics.Wrap(func(*gtpv2.Conn, net.Addr, message.Message) error {
+				return errHandler
+			})
+		})
+		It("should count the received messages per type and peer", func() {
+			Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0, 1))).To(MatchError(errHandler))
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gtp_messages_total GTPv2 messages received and sent by the P-GW
+# TYPE gtp_messages_total counter
+gtp_messages_total{cause="",direction="received",peer="172.25.1.3",type="Delete Session Request"} 1
+`), "gtp_messages_total")).To(Succeed())
+		})
+		It("should observe the handler latency", func() {
+			Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0, 1))).To(MatchError(errHandler))
+
+			families, err := registry.Gather()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(families).To(ContainElement(WithTransform(func(family *dto.MetricFamily) uint64 {
+				if family.GetName() != "gtp_handler_duration_seconds" {
+					return 0
+				}
+
+				return family.GetMetric()[0].GetHistogram().GetSampleCount()
+			}, BeEquivalentTo(1))))
+		})
+	})
+
+	Describe("sending a response", func() {
+		It("should count the sent messages per cause", func() {
+			metrics.MessageSent(sgw, message.NewCreateSessionResponse(1, 1,
+				ie.NewCause(gtpv2.CauseMandatoryIEMissing, 0, 0, 0, nil)))
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gtp_messages_total GTPv2 messages received and sent by the P-GW
+# TYPE gtp_messages_total counter
+gtp_messages_total{cause="70",direction="sent",peer="172.25.1.3",type="Create Session Response"} 1
+`), "gtp_messages_total")).To(Succeed())
+		})
+	})
+
+	Describe("blocking a message", func() {
+		It("should count the blocked messages per reason", func() {
+			metrics.MessageBlocked(sgw, message.NewCreateSessionRequest(0, 1), "unknown_peer")
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gtp_messages_blocked_total GTPv2 messages blocked by the S5/S8 firewall
+# TYPE gtp_messages_blocked_total counter
+gtp_messages_blocked_total{peer="172.25.1.3",reason="unknown_peer",type="Create Session Request"} 1
+`), "gtp_messages_blocked_total")).To(Succeed())
+		})
+
+		It("should count the rejected sessions per reason", func() {
+			metrics.SessionRejected(sgw, message.NewCreateSessionRequest(0, 1), "max_sessions")
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP sessions_rejected_total Create Session Requests rejected by the admission limits
+# TYPE sessions_rejected_total counter
+sessions_rejected_total{peer="172.25.1.3",reason="max_sessions"} 1
+`), "sessions_rejected_total")).To(Succeed())
+		})
+
+		It("should count the retransmitted requests per action", func() {
+			metrics.RetransmissionDetected(sgw, message.NewCreateSessionRequest(0, 1), "replayed")
+			metrics.RetransmissionDetected(sgw, message.NewCreateSessionRequest(0, 2), "replayed")
+
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gtp_retransmissions_total Retransmitted GTPv2 requests received
+# TYPE gtp_retransmissions_total counter
+gtp_retransmissions_total{action="replayed",peer="172.25.1.3",type="Create Session Request"} 2
+`), "gtp_retransmissions_total")).To(Succeed())
+		})
+	})
+
+	Describe("observing the sessions", func() {
+		BeforeEach(func() {
+			_, pool, _ := net.ParseCIDR("10.0.1.0/30")
+			connection := gtpv2.NewConn(sgw, gtpv2.IFTypeS5S8PGWGTPC, 0)
+
+			for i, ip := range []string{"10.0.1.1", "10.0.1.2", "192.168.0.1"} {
+				session := gtpv2.NewSession(sgw, &gtpv2.Subscriber{IMSI: "12345123456789" + string(rune('0'+i))})
+				session.GetDefaultBearer().APN = "internet"
+				session.GetDefaultBearer().SubscriberIP = ip
+				Expect(session.Activate()).To(Succeed())
+				connection.RegisterSession(uint32(i+1), session)
+			}
+
+			Expect(metrics.ObserveSessions(connection, pool, traffic{
+				"10.0.1.1": {UplinkPackets: 1, UplinkBytes: 100, DownlinkPackets: 2, DownlinkBytes: 200},
+				"10.0.1.2": {UplinkPackets: 3, UplinkBytes: 300, DownlinkPackets: 4, DownlinkBytes: 400},
+			})).To(Succeed())
+		})
+		It("should export the active sessions and the pool utilization", func() {
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP active_bearers Active bearers per APN
+# TYPE active_bearers gauge
+active_bearers{apn="internet"} 3
+# HELP active_sessions Active PDN connections per APN
+# TYPE active_sessions gauge
+active_sessions{apn="internet"} 3
+# HELP ip_pool_used_addresses UE addresses of the pool in use
+# TYPE ip_pool_used_addresses gauge
+ip_pool_used_addresses 2
+# HELP ip_pool_utilization_ratio Fraction of the UE address pool in use
+# TYPE ip_pool_utilization_ratio gauge
+ip_pool_utilization_ratio 1
+`), "active_bearers", "active_sessions", "ip_pool_used_addresses", "ip_pool_utilization_ratio")).To(Succeed())
+		})
+		It("should aggregate the user plane traffic per APN", func() {
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP user_plane_bytes_total User plane bytes of the active sessions per APN
+# TYPE user_plane_bytes_total counter
+user_plane_bytes_total{apn="internet",direction="downlink"} 600
+user_plane_bytes_total{apn="internet",direction="uplink"} 400
+# HELP user_plane_packets_total User plane packets of the active sessions per APN
+# TYPE user_plane_packets_total counter
+user_plane_packets_total{apn="internet",direction="downlink"} 6
+user_plane_packets_total{apn="internet",direction="uplink"} 4
+`), "user_plane_bytes_total", "user_plane_packets_total")).To(Succeed())
+		})
+	})
+})
@@ -15,23 +15,59 @@ package loggerhdl
 
 import (
 	"net"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/wmnsk/go-gtp/gtpv2"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
-// Wrap ensures that entry logs are registered.
+// entries stores the log entries of the messages which are being handled.
+var entries sync.Map
+
+// Wrap ensures that entry logs are registered and the logs written while handling the message
+// carry its context.
 func Wrap(apiHandler func(connection *gtpv2.Conn,
 	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
 	sender net.Addr, msg message.Message) error,
 ) {
 	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
-		log.WithFields(log.Fields{
-			"messageType":   msg.MessageTypeName(),
-			"addressSource": sender,
-		}).Info("Session received")
+		entry := log.WithFields(log.Fields{
+			"messageType": msg.MessageTypeName(),
+			"peer":        sender.String(),
+			"sequence":    msg.Sequence(),
+			"TEID":        msg.TEID(),
+		})
+		entries.Store(msg, entry)
+
+		defer entries.Delete(msg)
+
+		entry.Info("Session received")
+
+		err := apiHandler(connection, sender, msg)
+		if err != nil {
+			FromMessage(msg).WithError(err).Warn("Failed to handle the message")
+		}
+
+		return err
+	}
+}
+
+// FromMessage returns the log entry with the context of the message which is being handled.
+func FromMessage(msg message.Message) *log.Entry {
+	if entry, ok := entries.Load(msg); ok {
+		if entry, ok := entry.(*log.Entry); ok {
+			return entry
+		}
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
 
-		return apiHandler(connection, sender, msg)
+// AddFields adds the fields, like the IMSI or the APN once they are decoded, to the context of
+// the message which is being handled.
+func AddFields(msg message.Message, fields log.Fields) {
+	if _, ok := entries.Load(msg); ok {
+		entries.Store(msg, FromMessage(msg).WithFields(fields))
 	}
 }
@@ -0,0 +1,90 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerhdl_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+var errHandler = errors.New("handler error")
+
+var _ = Describe("Logger", func() {
+	var (
+		hook *test.Hook
+		sgw  = &net.UDPAddr{IP: net.ParseIP("172.25.1.3"), Port: 2123}
+	)
+
+	BeforeEach(func() {
+		hook = test.NewGlobal()
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	})
+
+	It("should add the message context to the handler logs", func() {
+		handler := loggerhdl.Wrap(func(_ *gtpv2.Conn, _ net.Addr, msg message.Message) error {
+			loggerhdl.AddFields(msg, log.Fields{"IMSI": "123451234567891", "APN": "internet"})
+			loggerhdl.FromMessage(msg).Info("Session deleted")
+
+			return errHandler
+		})
+
+		Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0x10, 7))).To(MatchError(errHandler))
+		Expect(hook.AllEntries()).To(HaveLen(3))
+
+		received, deleted, failed := hook.AllEntries()[0], hook.AllEntries()[1], hook.AllEntries()[2]
+		Expect(received.Message).To(Equal("Session received"))
+		Expect(received.Data).To(Equal(log.Fields{
+			"messageType": "Delete Session Request",
+			"peer":        sgw.String(),
+			"sequence":    uint32(7),
+			"TEID":        uint32(0x10),
+		}))
+		Expect(deleted.Message).To(Equal("Session deleted"))
+		Expect(deleted.Data).To(Equal(log.Fields{
+			"messageType": "Delete Session Request",
+			"peer":        sgw.String(),
+			"sequence":    uint32(7),
+			"TEID":        uint32(0x10),
+			"IMSI":        "123451234567891",
+			"APN":         "internet",
+		}))
+		Expect(failed.Level).To(Equal(log.WarnLevel))
+		Expect(failed.Data).To(HaveKeyWithValue("IMSI", "123451234567891"))
+		Expect(failed.Data).To(HaveKeyWithValue(log.ErrorKey, errHandler))
+	})
+	It("should log messages without context once they are handled", func() {
+		msg := message.NewDeleteSessionRequest(0x10, 7)
+
+		handler := loggerhdl.Wrap(func(*gtpv2.Conn, net.Addr, message.Message) error {
+			return nil
+		})
+		Expect(handler(nil, sgw, msg)).To(Succeed())
+
+		loggerhdl.AddFields(msg, log.Fields{"IMSI": "123451234567891"})
+		loggerhdl.FromMessage(msg).Info("Late log")
+
+		Expect(hook.LastEntry().Data).To(BeEmpty())
+	})
+})
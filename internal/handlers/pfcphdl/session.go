@@ -0,0 +1,550 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcphdl
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/protocols/pfcp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+const kilo = 1000
+
+var (
+	// ErrIncompleteRules indicates that the rules of a session don't describe its default bearer.
+	ErrIncompleteRules = errors.New("incomplete session rules")
+	// ErrUnknownRule indicates that a PDR refers to a FAR or QER which wasn't created.
+	ErrUnknownRule = errors.New("unknown rule")
+	// ErrNotAssociated indicates that the PGW-C requested a session without a PFCP association.
+	ErrNotAssociated = errors.New("no PFCP association with the PGW-C")
+	// ErrSessionReleased indicates that a session was deleted while its request was processed.
+	ErrSessionReleased = errors.New("session released")
+)
+
+// Sessions applies the rules received from the PGW-C to the local user plane.
+type Sessions struct {
+	mutex       sync.Mutex
+	userPlane   pgwhdl.UserPlaneFunction
+	nodeIP      net.IP
+	userPlaneIP net.IP
+	sessions    map[uint64]*session
+	// established indexes the local SEIDs by the PGW-C F-SEID, so a retransmitted request
+	// doesn't establish the same session twice.
+	established  map[string]uint64
+	associations map[string]bool
+	lastSEID     uint64
+}
+
+// session rules are only accessed while holding its mutex, which serializes the requests of
+// the PGW-C, so a session isn't modified while it's established or deleted.
+type session struct {
+	mutex      sync.Mutex
+	released   bool
+	key        string
+	localSEID  uint64
+	remoteSEID uint64
+	ms         net.IP
+	pdrs       map[uint16]*pfcp.PDR
+	fars       map[uint32]*pfcp.FAR
+	qers       map[uint32]*pfcp.QER
+	urrs       map[uint32]*pfcp.URR
+}
+
+// bearerRules groups the rules which share a QER, which identifies a bearer.
+type bearerRules struct {
+	binding    *domain.BearerBinding
+	guaranteed pgwhdl.BitRates
+	maximum    pgwhdl.BitRates
+	catchAll   bool
+}
+
+// New creates the handlers of the PGW-U side of the Sxb interface.
+func New(userPlane pgwhdl.UserPlaneFunction, nodeIP, userPlaneIP net.IP) *Sessions {
+	return &Sessions{
+		userPlane:    userPlane,
+		nodeIP:       nodeIP,
+		userPlaneIP:  userPlaneIP,
+		sessions:     map[uint64]*session{},
+		established:  map[string]uint64{},
+		associations: map[string]bool{},
+	}
+}
+
+// HandleAssociationSetup accepts the association requested by a PGW-C.
+func (s *Sessions) HandleAssociationSetup(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message) error {
+	log.WithField("PGW-C", sender).Info("PFCP association requested")
+
+	s.mutex.Lock()
+	s.associations[getHost(sender)] = true
+	s.mutex.Unlock()
+
+	return conn.RespondTo(sender, msg, pfcp.NewNodeMessage(pfcp.MsgTypeAssociationSetupResponse,
+		pfcp.NewNodeID(s.nodeIP),
+		pfcp.NewCause(pfcp.CauseRequestAccepted),
+		pfcp.NewRecoveryTimeStamp(conn.RecoveryTimeStamp()),
+		pfcp.NewUserPlaneIPResourceInformation(s.userPlaneIP),
+	))
+}
+
+// HandleSessionEstablishment creates a session and forwards the traffic of its default bearer.
+func (s *Sessions) HandleSessionEstablishment(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message) error {
+	if !s.isAssociated(sender) {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, 0,
+			pfcp.CauseNoEstablishedPFCPAssociation, errors.Wrapf(ErrNotAssociated, "%s PGW-C", sender))
+	}
+
+	fseid := msg.Find(pfcp.FSEID)
+	if fseid == nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, 0,
+			pfcp.CauseMandatoryIEMissing, errors.Wrap(pfcp.ErrMissingIE, "no PGW-C F-SEID"))
+	}
+
+	remoteSEID, _, err := fseid.FSEID()
+	if err != nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, 0,
+			pfcp.CauseMandatoryIEIncorrect, err)
+	}
+
+	current := &session{
+		key:        fmt.Sprintf("%s/%d", getHost(sender), remoteSEID),
+		remoteSEID: remoteSEID,
+		pdrs:       map[uint16]*pfcp.PDR{},
+		fars:       map[uint32]*pfcp.FAR{},
+		qers:       map[uint32]*pfcp.QER{},
+		urrs:       map[uint32]*pfcp.URR{},
+	}
+
+	if _, err := current.addRules(msg); err != nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, remoteSEID,
+			pfcp.CauseMandatoryIEIncorrect, err)
+	}
+
+	if previous, ok := s.register(current); !ok {
+		return s.respondDuplicate(conn, sender, msg, previous)
+	}
+	defer current.mutex.Unlock()
+
+	if err := s.establish(current); err != nil {
+		s.forget(current)
+
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, remoteSEID,
+			pfcp.CauseRuleCreationFailure, err)
+	}
+
+	return respondEstablished(conn, sender, msg, current, s.nodeIP)
+}
+
+// respondDuplicate answers a retransmitted establishment request with the session created by
+// the original one.
+func (s *Sessions) respondDuplicate(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message,
+	previous *session,
+) error {
+	previous.mutex.Lock()
+	released := previous.released
+	previous.mutex.Unlock()
+
+	if released {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionEstablishmentResponse, previous.remoteSEID,
+			pfcp.CauseRequestRejected, errors.Wrapf(ErrSessionReleased, "%d SEID", previous.localSEID))
+	}
+
+	log.WithField("PGW-C", sender).Debugf("Duplicated %d PFCP session establishment", previous.remoteSEID)
+
+	return respondEstablished(conn, sender, msg, previous, s.nodeIP)
+}
+
+func respondEstablished(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message, current *session,
+	nodeIP net.IP,
+) error {
+	return conn.RespondTo(sender, msg, pfcp.NewSessionMessage(pfcp.MsgTypeSessionEstablishmentResponse,
+		current.remoteSEID,
+		pfcp.NewNodeID(nodeIP),
+		pfcp.NewCause(pfcp.CauseRequestAccepted),
+		pfcp.NewFSEID(current.localSEID, nodeIP),
+	))
+}
+
+// HandleSessionModification adds the rules of dedicated bearers to an existing session and
+// updates the S-GW endpoints of its bearers.
+func (s *Sessions) HandleSessionModification(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message) error {
+	current, ok := s.getSession(msg.SEID)
+	if !ok {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, 0,
+			pfcp.CauseSessionContextNotFound, errors.Errorf("unknown %d SEID", msg.SEID))
+	}
+
+	current.mutex.Lock()
+	defer current.mutex.Unlock()
+
+	if current.released {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, 0,
+			pfcp.CauseSessionContextNotFound, errors.Wrapf(ErrSessionReleased, "%d SEID", msg.SEID))
+	}
+
+	created, err := current.addRules(msg)
+	if err != nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, current.remoteSEID,
+			pfcp.CauseMandatoryIEIncorrect, err)
+	}
+
+	updated, err := current.updateRules(msg)
+	if err != nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, current.remoteSEID,
+			pfcp.CauseMandatoryIEIncorrect, err)
+	}
+
+	if err := s.modify(current, created, updated); err != nil {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, current.remoteSEID,
+			pfcp.CauseRuleCreationFailure, err)
+	}
+
+	return respond(conn, sender, msg, pfcp.MsgTypeSessionModificationResponse, current.remoteSEID,
+		pfcp.CauseRequestAccepted, nil)
+}
+
+// HandleSessionDeletion stops forwarding the traffic of a session.
+func (s *Sessions) HandleSessionDeletion(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message) error {
+	current, ok := s.getSession(msg.SEID)
+	if !ok {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionDeletionResponse, 0,
+			pfcp.CauseSessionContextNotFound, errors.Errorf("unknown %d SEID", msg.SEID))
+	}
+
+	current.mutex.Lock()
+	defer current.mutex.Unlock()
+
+	if current.released {
+		return respond(conn, sender, msg, pfcp.MsgTypeSessionDeletionResponse, 0,
+			pfcp.CauseSessionContextNotFound, errors.Wrapf(ErrSessionReleased, "%d SEID", msg.SEID))
+	}
+
+	s.forget(current)
+
+	if err := s.userPlane.Release(current.ms); err != nil {
+		log.WithError(err).Warnf("Failed to release %s user plane", current.ms)
+	}
+
+	return respond(conn, sender, msg, pfcp.MsgTypeSessionDeletionResponse, current.remoteSEID,
+		pfcp.CauseRequestAccepted, nil)
+}
+
+// respond answers a session request with the given cause and returns the error which caused it.
+func respond(conn *pfcp.Conn, sender net.Addr, msg *pfcp.Message, msgType uint8, seid uint64,
+	cause uint8, err error,
+) error {
+	if respondErr := conn.RespondTo(sender, msg, pfcp.NewSessionMessage(msgType, seid,
+		pfcp.NewCause(cause))); respondErr != nil {
+		return respondErr
+	}
+
+	return err
+}
+
+func getHost(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+
+	return addr.String()
+}
+
+func (s *Sessions) isAssociated(peer net.Addr) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.associations[getHost(peer)]
+}
+
+// register allocates the local SEID of a new session and returns it locked, or returns the
+// session previously established with the same PGW-C F-SEID.
+func (s *Sessions) register(current *session) (*session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if seid, ok := s.established[current.key]; ok {
+		return s.sessions[seid], false
+	}
+
+	s.lastSEID++
+	current.localSEID = s.lastSEID
+	current.mutex.Lock()
+	s.sessions[current.localSEID] = current
+	s.established[current.key] = current.localSEID
+
+	return current, true
+}
+
+// forget removes a session, which has to be locked by the caller.
+func (s *Sessions) forget(current *session) {
+	current.released = true
+
+	s.mutex.Lock()
+	delete(s.sessions, current.localSEID)
+	delete(s.established, current.key)
+	s.mutex.Unlock()
+}
+
+func (s *Sessions) getSession(seid uint64) (*session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.sessions[seid]
+
+	return current, ok
+}
+
+// addRules stores the rules created by the message and returns the new PDRs.
+func (c *session) addRules(msg *pfcp.Message) ([]*pfcp.PDR, error) {
+	for _, i := range msg.FindAll(pfcp.CreateFAR) {
+		far, err := pfcp.ParseFAR(i)
+		if err != nil {
+			return nil, err
+		}
+
+		c.fars[far.ID] = far
+	}
+
+	for _, i := range msg.FindAll(pfcp.CreateQER) {
+		qer, err := pfcp.ParseQER(i)
+		if err != nil {
+			return nil, err
+		}
+
+		c.qers[qer.ID] = qer
+	}
+
+	for _, i := range msg.FindAll(pfcp.CreateURR) {
+		urr, err := pfcp.ParseURR(i)
+		if err != nil {
+			return nil, err
+		}
+
+		c.urrs[urr.ID] = urr
+	}
+
+	created := []*pfcp.PDR{}
+
+	for _, i := range msg.FindAll(pfcp.CreatePDR) {
+		pdr, err := pfcp.ParsePDR(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := c.fars[pdr.FARID]; !ok {
+			return nil, errors.Wrapf(ErrUnknownRule, "%d FAR", pdr.FARID)
+		}
+
+		if len(pdr.QERIDs) == 0 {
+			return nil, errors.Wrapf(ErrIncompleteRules, "no QER in %d PDR", pdr.ID)
+		}
+
+		if _, ok := c.qers[pdr.QERIDs[0]]; !ok {
+			return nil, errors.Wrapf(ErrUnknownRule, "%d QER", pdr.QERIDs[0])
+		}
+
+		c.pdrs[pdr.ID] = pdr
+		created = append(created, pdr)
+	}
+
+	return created, nil
+}
+
+// updateRules applies the forwarding parameters of the updated FARs and returns their IDs.
+func (c *session) updateRules(msg *pfcp.Message) (map[uint32]bool, error) {
+	updated := map[uint32]bool{}
+
+	for _, i := range msg.FindAll(pfcp.UpdateFAR) {
+		far, err := pfcp.ParseFAR(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := c.fars[far.ID]; !ok {
+			return nil, errors.Wrapf(ErrUnknownRule, "%d FAR", far.ID)
+		}
+
+		c.fars[far.ID] = far
+		updated[far.ID] = true
+	}
+
+	return updated, nil
+}
+
+// getBearers groups all the session rules by bearer.
+func (c *session) getBearers() (map[uint32]*bearerRules, error) {
+	bearers := map[uint32]*bearerRules{}
+
+	for _, pdr := range c.pdrs {
+		qer := c.qers[pdr.QERIDs[0]]
+
+		bearer, ok := bearers[qer.ID]
+		if !ok {
+			bearer = &bearerRules{
+				binding:    &domain.BearerBinding{EBI: uint8(qer.ID)},
+				guaranteed: pgwhdl.BitRates{Uplink: qer.GBRUplink * kilo, Downlink: qer.GBRDownlink * kilo},
+				maximum:    pgwhdl.BitRates{Uplink: qer.MBRUplink * kilo, Downlink: qer.MBRDownlink * kilo},
+			}
+			bearers[qer.ID] = bearer
+		}
+
+		if err := c.bindPDR(bearer, pdr); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, bearer := range bearers {
+		if bearer.catchAll {
+			bearer.binding.TFT = nil
+		}
+	}
+
+	return bearers, nil
+}
+
+func (c *session) bindPDR(bearer *bearerRules, pdr *pfcp.PDR) error {
+	if pdr.SourceInterface == pfcp.InterfaceAccess {
+		bearer.binding.IncomingTEID = pdr.TEID
+
+		return nil
+	}
+
+	if pdr.UEAddress == nil {
+		return errors.Wrapf(ErrIncompleteRules, "no UE address in %d PDR", pdr.ID)
+	}
+
+	c.ms = pdr.UEAddress
+
+	far := c.fars[pdr.FARID]
+	if far.OuterHeaderAddress != nil {
+		peer, err := net.ResolveUDPAddr("udp", far.OuterHeaderAddress.String()+gtpv2.GTPUPort)
+		if err != nil {
+			return errors.Wrap(err, "failed to resolve S-GW user plane address")
+		}
+
+		bearer.binding.OutgoingTEID = far.OuterHeaderTEID
+		bearer.binding.Peer = peer
+	}
+
+	if len(pdr.Filters) == 0 {
+		bearer.catchAll = true
+
+		return nil
+	}
+
+	if bearer.binding.TFT == nil {
+		bearer.binding.TFT = &domain.TrafficFlowTemplate{Operation: domain.TFTOperationCreate}
+	}
+
+	for _, sdf := range pdr.Filters {
+		filter, err := domain.ParseFlowDescription(sdf.FlowDescription)
+		if err != nil {
+			return err
+		}
+
+		filter.ID = uint8(len(bearer.binding.TFT.Filters) + 1)
+		filter.Precedence = uint8(pdr.Precedence)
+		filter.TypeOfService, filter.ToSMask, filter.SPI = sdf.TypeOfService, sdf.ToSMask, sdf.SPI
+		bearer.binding.TFT.Filters = append(bearer.binding.TFT.Filters, filter)
+	}
+
+	return nil
+}
+
+// establish forwards the traffic of the bearers described by the new session rules.
+func (s *Sessions) establish(current *session) error {
+	bearers, err := current.getBearers()
+	if err != nil {
+		return err
+	}
+
+	var defaultBearer *bearerRules
+
+	for _, bearer := range bearers {
+		if bearer.catchAll {
+			defaultBearer = bearer
+		}
+	}
+
+	if defaultBearer == nil || current.ms == nil {
+		return errors.Wrap(ErrIncompleteRules, "no default bearer")
+	}
+
+	if err := s.userPlane.Establish(current.ms, defaultBearer.binding, defaultBearer.guaranteed,
+		defaultBearer.maximum); err != nil {
+		return errors.Wrap(err, "failed to setup the User Plane")
+	}
+
+	for _, bearer := range bearers {
+		if bearer == defaultBearer {
+			continue
+		}
+
+		if err := s.userPlane.AddBearer(current.ms, bearer.binding, bearer.guaranteed, bearer.maximum); err != nil {
+			if releaseErr := s.userPlane.Release(current.ms); releaseErr != nil {
+				log.WithError(releaseErr).Warnf("Failed to release %s user plane", current.ms)
+			}
+
+			return errors.Wrap(err, "failed to forward the dedicated bearer traffic")
+		}
+	}
+
+	return nil
+}
+
+// modify forwards the traffic of the bearers affected by the new and updated session rules.
+func (s *Sessions) modify(current *session, created []*pfcp.PDR, updatedFARs map[uint32]bool) error {
+	bearers, err := current.getBearers()
+	if err != nil {
+		return err
+	}
+
+	modified := map[uint32]bool{}
+	for _, pdr := range created {
+		modified[pdr.QERIDs[0]] = true
+	}
+
+	updated := map[uint32]bool{}
+
+	for _, pdr := range current.pdrs {
+		if updatedFARs[pdr.FARID] && !modified[pdr.QERIDs[0]] {
+			updated[pdr.QERIDs[0]] = true
+		}
+	}
+
+	for id := range modified {
+		bearer := bearers[id]
+		if bearer.catchAll {
+			continue
+		}
+
+		if err := s.userPlane.AddBearer(current.ms, bearer.binding, bearer.guaranteed, bearer.maximum); err != nil {
+			return errors.Wrap(err, "failed to forward the dedicated bearer traffic")
+		}
+	}
+
+	for id := range updated {
+		if err := s.userPlane.Update(current.ms, bearers[id].binding); err != nil {
+			return errors.Wrap(err, "failed to update the bearer S-GW endpoint")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcphdl_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/pfcphdl"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/protocols/pfcp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+var errForwarding = errors.New("forwarding failure")
+
+// userPlane records the bearers forwarded for each UE address.
+type userPlane struct {
+	mutex        sync.Mutex
+	bearers      map[string][]*domain.BearerBinding
+	established  int
+	orphans      int
+	addBearerErr error
+}
+
+func newUserPlane() *userPlane {
+	return &userPlane{bearers: map[string][]*domain.BearerBinding{}}
+}
+
+func (u *userPlane) NewFTEID(ifType uint8) *ie.IE {
+	return nil
+}
+
+func (u *userPlane) Establish(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum pgwhdl.BitRates) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.established++
+	u.bearers[ms.String()] = []*domain.BearerBinding{bearer}
+
+	return nil
+}
+
+func (u *userPlane) AddBearer(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum pgwhdl.BitRates) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.addBearerErr != nil {
+		return u.addBearerErr
+	}
+
+	if _, ok := u.bearers[ms.String()]; !ok {
+		u.orphans++
+
+		return pgwhdl.ErrUnknownBearer
+	}
+
+	u.bearers[ms.String()] = append(u.bearers[ms.String()], bearer)
+
+	return nil
+}
+
+func (u *userPlane) Update(ms net.IP, bearer *domain.BearerBinding) error {
+	return nil
+}
+
+func (u *userPlane) Release(ms net.IP) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	delete(u.bearers, ms.String())
+
+	return nil
+}
+
+func (u *userPlane) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	return &domain.TrafficCounters{}, nil
+}
+
+func (u *userPlane) Close() error {
+	return nil
+}
+
+func (u *userPlane) Fail(err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.addBearerErr = err
+}
+
+func (u *userPlane) Bearers(ms net.IP) []*domain.BearerBinding {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return append([]*domain.BearerBinding{}, u.bearers[ms.String()]...)
+}
+
+func (u *userPlane) Established() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.established
+}
+
+func (u *userPlane) Orphans() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.orphans
+}
+
+// newBearerRules creates the rules of a bearer, whose EBI is used for the rule IDs.
+func newBearerRules(ebi uint8, ms, upf, sgw net.IP, catchAll bool) []*pfcp.IE {
+	uplinkFAR := &pfcp.FAR{
+		ID:                   uint32(ebi) * 2,
+		ApplyAction:          pfcp.ApplyActionForward,
+		DestinationInterface: pfcp.InterfaceCore,
+	}
+	downlinkFAR := &pfcp.FAR{
+		ID:                   uint32(ebi)*2 + 1,
+		ApplyAction:          pfcp.ApplyActionForward,
+		DestinationInterface: pfcp.InterfaceAccess,
+		OuterHeaderTEID:      0x200 + uint32(ebi),
+		OuterHeaderAddress:   sgw,
+	}
+	downlinkPDR := &pfcp.PDR{
+		ID:              uint16(ebi)*2 + 1,
+		Precedence:      256,
+		SourceInterface: pfcp.InterfaceCore,
+		UEAddress:       ms,
+		FARID:           downlinkFAR.ID,
+		QERIDs:          []uint32{uint32(ebi)},
+	}
+
+	if !catchAll {
+		downlinkPDR.Precedence = uint32(ebi)
+		downlinkPDR.Filters = []*pfcp.Filter{{FlowDescription: "permit out 17 from any 5060 to assigned"}}
+	}
+
+	return []*pfcp.IE{
+		(&pfcp.QER{ID: uint32(ebi)}).IE(),
+		uplinkFAR.IE(),
+		(&pfcp.PDR{
+			ID:                 uint16(ebi) * 2,
+			Precedence:         256,
+			SourceInterface:    pfcp.InterfaceAccess,
+			TEID:               0x100 + uint32(ebi),
+			TEIDAddress:        upf,
+			OuterHeaderRemoval: true,
+			FARID:              uplinkFAR.ID,
+			QERIDs:             []uint32{uint32(ebi)},
+		}).IE(),
+		downlinkFAR.IE(),
+		downlinkPDR.IE(),
+	}
+}
+
+func getCause(response *pfcp.Message) uint8 {
+	cause, err := response.Cause()
+	Expect(err).NotTo(HaveOccurred())
+
+	return cause
+}
+
+var _ = Describe("Sessions", func() {
+	var (
+		pgwc      = net.ParseIP("127.0.0.1").To4()
+		upf       = net.ParseIP("172.25.0.2").To4()
+		sgw       = net.ParseIP("172.25.0.3").To4()
+		ms        = net.ParseIP("10.0.1.2").To4()
+		cancel    context.CancelFunc
+		client    *pfcp.Conn
+		peer      net.Addr
+		forwarder *userPlane
+		serving   sync.WaitGroup
+	)
+
+	associate := func() {
+		response, err := client.Request(peer, pfcp.NewNodeMessage(pfcp.MsgTypeAssociationSetupRequest,
+			pfcp.NewNodeID(pgwc), pfcp.NewRecoveryTimeStamp(client.RecoveryTimeStamp())))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getCause(response)).To(Equal(pfcp.CauseRequestAccepted))
+	}
+
+	newEstablishment := func(remoteSEID uint64, ies ...*pfcp.IE) *pfcp.Message {
+		return pfcp.NewSessionMessage(pfcp.MsgTypeSessionEstablishmentRequest, 0,
+			append([]*pfcp.IE{pfcp.NewNodeID(pgwc), pfcp.NewFSEID(remoteSEID, pgwc)}, ies...)...)
+	}
+
+	establish := func(remoteSEID uint64) uint64 {
+		response, err := client.Request(peer, newEstablishment(remoteSEID,
+			newBearerRules(5, ms, upf, sgw, true)...))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getCause(response)).To(Equal(pfcp.CauseRequestAccepted))
+		Expect(response.SEID).To(Equal(remoteSEID))
+
+		seid, _, err := response.Find(pfcp.FSEID).FSEID()
+		Expect(err).NotTo(HaveOccurred())
+
+		return seid
+	}
+
+	BeforeEach(func() {
+		var ctx context.Context
+
+		ctx, cancel = context.WithCancel(context.Background())
+		forwarder = newUserPlane()
+
+		server, err := pfcp.Listen("127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		sessions := pfcphdl.New(forwarder, upf, upf)
+		server.AddHandler(pfcp.MsgTypeAssociationSetupRequest, sessions.HandleAssociationSetup)
+		server.AddHandler(pfcp.MsgTypeSessionEstablishmentRequest, sessions.HandleSessionEstablishment)
+		server.AddHandler(pfcp.MsgTypeSessionModificationRequest, sessions.HandleSessionModification)
+		server.AddHandler(pfcp.MsgTypeSessionDeletionRequest, sessions.HandleSessionDeletion)
+		peer = server.LocalAddr()
+
+		client, err = pfcp.Listen("127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		client.Timeout = time.Duration(500) * time.Millisecond
+
+		for _, conn := range []*pfcp.Conn{server, client} {
+			serving.Add(1)
+
+			go func(conn *pfcp.Conn) {
+				defer GinkgoRecover()
+				defer serving.Done()
+				Expect(conn.ListenAndServe(ctx)).To(Succeed())
+			}(conn)
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+		serving.Wait()
+	})
+
+	Describe("session establishment", func() {
+		Context("when there is no PFCP association", func() {
+			It("should reject the session", func() {
+				response, err := client.Request(peer, newEstablishment(1, newBearerRules(5, ms, upf, sgw, true)...))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(getCause(response)).To(Equal(pfcp.CauseNoEstablishedPFCPAssociation))
+				Expect(forwarder.Established()).To(BeZero())
+			})
+		})
+		Context("when the PFCP association is established", func() {
+			BeforeEach(associate)
+
+			It("should forward the default bearer traffic", func() {
+				establish(1)
+
+				bearers := forwarder.Bearers(ms)
+				Expect(bearers).To(HaveLen(1))
+				Expect(bearers[0].EBI).To(Equal(uint8(5)))
+				Expect(bearers[0].IncomingTEID).To(Equal(uint32(0x105)))
+				Expect(bearers[0].OutgoingTEID).To(Equal(uint32(0x205)))
+				Expect(bearers[0].TFT).To(BeNil())
+			})
+			It("should answer the duplicated requests with the same session", func() {
+				seid := establish(1)
+
+				Expect(establish(1)).To(Equal(seid))
+				Expect(establish(2)).NotTo(Equal(seid))
+				Expect(forwarder.Established()).To(Equal(2))
+			})
+			Context("when the dedicated bearer can't be forwarded", func() {
+				BeforeEach(func() {
+					forwarder.Fail(errForwarding)
+				})
+
+				It("should release the default bearer", func() {
+					rules := append(newBearerRules(5, ms, upf, sgw, true), newBearerRules(6, ms, upf, sgw, false)...)
+					response, err := client.Request(peer, newEstablishment(1, rules...))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(getCause(response)).To(Equal(pfcp.CauseRuleCreationFailure))
+					Expect(forwarder.Established()).To(Equal(1))
+					Expect(forwarder.Bearers(ms)).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Describe("session modification and deletion", func() {
+		BeforeEach(associate)
+
+		It("should forward the dedicated bearers traffic until the session is deleted", func() {
+			seid := establish(1)
+
+			response, err := client.Request(peer, pfcp.NewSessionMessage(pfcp.MsgTypeSessionModificationRequest,
+				seid, newBearerRules(6, ms, upf, sgw, false)...))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getCause(response)).To(Equal(pfcp.CauseRequestAccepted))
+			Expect(forwarder.Bearers(ms)).To(HaveLen(2))
+
+			response, err = client.Request(peer, pfcp.NewSessionMessage(pfcp.MsgTypeSessionDeletionRequest, seid))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getCause(response)).To(Equal(pfcp.CauseRequestAccepted))
+			Expect(forwarder.Bearers(ms)).To(BeEmpty())
+
+			response, err = client.Request(peer, pfcp.NewSessionMessage(pfcp.MsgTypeSessionModificationRequest,
+				seid, newBearerRules(7, ms, upf, sgw, false)...))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getCause(response)).To(Equal(pfcp.CauseSessionContextNotFound))
+		})
+		It("should not forward the traffic of the bearers modified concurrently with the deletion", func() {
+			seid := establish(1)
+
+			var wg sync.WaitGroup
+
+			for ebi := uint8(6); ebi <= 15; ebi++ {
+				wg.Add(1)
+
+				go func(ebi uint8) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					_, err := client.Request(peer, pfcp.NewSessionMessage(pfcp.MsgTypeSessionModificationRequest,
+						seid, newBearerRules(ebi, ms, upf, sgw, false)...))
+					Expect(err).NotTo(HaveOccurred())
+				}(ebi)
+			}
+
+			response, err := client.Request(peer, pfcp.NewSessionMessage(pfcp.MsgTypeSessionDeletionRequest, seid))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getCause(response)).To(Equal(pfcp.CauseRequestAccepted))
+			wg.Wait()
+
+			Expect(forwarder.Bearers(ms)).To(BeEmpty())
+			Expect(forwarder.Orphans()).To(BeZero())
+		})
+	})
+
+	Describe("PGW-C Sxb user plane function", func() {
+		var sxb *pgwhdl.Sxb
+
+		BeforeEach(func() {
+			sxb = pgwhdl.NewSxb(client, pgwc, peer)
+			Expect(sxb.Associate()).To(Succeed())
+			Expect(sxb.Establish(ms, &domain.BearerBinding{
+				EBI: 5, IncomingTEID: 0x105, OutgoingTEID: 0x205, Peer: &net.UDPAddr{IP: sgw, Port: 2152},
+			}, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+		})
+
+		It("should allocate different rules for the concurrent dedicated bearers", func() {
+			var wg sync.WaitGroup
+
+			for ebi := uint8(6); ebi <= 15; ebi++ {
+				wg.Add(1)
+
+				go func(ebi uint8) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					Expect(sxb.AddBearer(ms, &domain.BearerBinding{
+						EBI:          ebi,
+						IncomingTEID: 0x100 + uint32(ebi),
+						OutgoingTEID: 0x200 + uint32(ebi),
+						Peer:         &net.UDPAddr{IP: sgw, Port: 2152},
+						TFT: &domain.TrafficFlowTemplate{
+							Operation: domain.TFTOperationCreate,
+							Filters: []*domain.PacketFilter{{
+								ID: 1, Direction: domain.DirectionDownlink, Precedence: ebi,
+							}},
+						},
+					}, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+				}(ebi)
+			}
+
+			wg.Wait()
+
+			Expect(forwarder.Bearers(ms)).To(HaveLen(11))
+		})
+		It("should not account the traffic, which isn't reported by the PGW-U", func() {
+			_, err := sxb.Counters(ms)
+			Expect(err).To(MatchError(pgwhdl.ErrNotAccounted))
+		})
+		It("should release the user plane", func() {
+			Expect(sxb.Release(ms)).To(Succeed())
+			Expect(forwarder.Bearers(ms)).To(BeEmpty())
+		})
+	})
+})
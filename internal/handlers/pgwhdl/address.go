@@ -0,0 +1,75 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// assignStaticAddress replaces the UE address requested by the S-GW with the static one of the
+// subscriber.
+func (h *create) assignStaticAddress(request *message.CreateSessionRequest, session *gtpv2.Session,
+	bearer *gtpv2.Bearer,
+) error {
+	if h.addresses == nil {
+		return nil
+	}
+
+	address, err := h.addresses.Get(session.IMSI, bearer.APN)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the static address of the subscriber")
+	}
+
+	if address == nil {
+		return nil
+	}
+
+	loggerhdl.FromMessage(request).WithField("ip", address.IP).Debug("Using the static address of the subscriber")
+
+	bearer.SubscriberIP = address.IP
+
+	return nil
+}
+
+// checkAddress verifies the final UE address, requested by the S-GW or assigned by the static
+// addresses or the AAA server, which can't be reserved for or used by the active session of
+// another subscriber.
+func (h *create) checkAddress(connection *gtpv2.Conn, session *gtpv2.Session, bearer *gtpv2.Bearer) error {
+	if h.addresses != nil {
+		owner, err := h.addresses.Owner(bearer.SubscriberIP)
+		if err != nil {
+			return errors.Wrap(err, "failed to get the reservation of the assigned address")
+		}
+
+		if owner != nil && owner.IMSI != session.IMSI {
+			return errors.Wrapf(domain.ErrAddressReserved, "%s address for %s IMSI", owner.IP, owner.IMSI)
+		}
+	}
+
+	for _, active := range connection.Sessions() {
+		if active.IMSI == session.IMSI {
+			continue
+		}
+
+		if current := active.GetDefaultBearer(); current != nil && current.SubscriberIP == bearer.SubscriberIP {
+			return errors.Wrapf(domain.ErrAddressInUse, "%s address by %s IMSI", bearer.SubscriberIP, active.IMSI)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// PPP codes used by PAP and CHAP containers.
+const (
+	pppCodeRequest  uint8 = 1
+	pppCodeResponse uint8 = 2
+)
+
+// getCredentials extracts PAP or CHAP credentials from the Protocol Configuration Options.
+func getCredentials(request *message.CreateSessionRequest) *domain.Credentials {
+	credentials := &domain.Credentials{Protocol: domain.AuthenticationNone}
+	if request.PCO == nil {
+		return credentials
+	}
+
+	pco, err := request.PCO.ProtocolConfigurationOptions()
+	if err != nil {
+		loggerhdl.FromMessage(request).WithError(err).Warn("Failed to get Protocol Configuration Options")
+
+		return credentials
+	}
+
+	for _, container := range pco.ProtocolOrContainers {
+		ppp, err := ie.ParsePCOPPP(container.Contents)
+		if err != nil {
+			loggerhdl.FromMessage(request).WithError(err).Debugf("Ignoring %x PCO container", container.ID)
+
+			continue
+		}
+
+		switch container.ID {
+		case ie.PCOProtocolIdentifierPAP:
+			pap, err := ie.ParsePAPFields(ppp.Payload)
+			if err != nil || ppp.Code != pppCodeRequest {
+				continue
+			}
+
+			credentials.Protocol = domain.AuthenticationPAP
+			credentials.Username = pap.PeerID
+			credentials.Password = pap.Password
+		case ie.PCOProtocolIdentifierCHAP:
+			chap, err := ie.ParseCHAPFields(ppp.Payload)
+			if err != nil {
+				continue
+			}
+
+			switch ppp.Code {
+			case pppCodeRequest:
+				credentials.Challenge = chap.Value
+			case pppCodeResponse:
+				credentials.Protocol = domain.AuthenticationCHAP
+				credentials.ChapID = ppp.Identifier
+				credentials.Response = chap.Value
+				credentials.Username = chap.Name
+			}
+		}
+	}
+
+	return credentials
+}
+
+// authenticate verifies the subscriber against the AAA server when the APN requires it.
+func (h *create) authenticate(request *message.CreateSessionRequest, session *gtpv2.Session,
+	bearer *gtpv2.Bearer,
+) error {
+	if h.authenticator == nil || !h.config.RequiresAuthentication(bearer.APN) {
+		return nil
+	}
+
+	credentials := getCredentials(request)
+	credentials.IMSI = session.IMSI
+	credentials.MSISDN = session.MSISDN
+	credentials.Apn = bearer.APN
+	credentials.NasAddress = h.config.ControlPlane.IP
+
+	authorization, err := h.authenticator.Authenticate(credentials)
+	if err != nil {
+		return errors.Wrap(err, "failed to authenticate the subscriber")
+	}
+
+	if authorization.FramedIP != nil {
+		loggerhdl.FromMessage(request).WithFields(log.Fields{
+			"IMSI": session.IMSI,
+			"ip":   authorization.FramedIP,
+		}).Debug("Using Framed IP address assigned by AAA server")
+
+		bearer.SubscriberIP = authorization.FramedIP.String()
+	}
+
+	return nil
+}
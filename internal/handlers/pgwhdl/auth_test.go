@@ -0,0 +1,220 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/repositories/aaarepo"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+var errUnreachable = errors.New("AAA server unreachable")
+
+// authenticator keeps the credentials received by the AAA server.
+type authenticator struct {
+	ports.Authenticator
+
+	mutex       sync.Mutex
+	err         error
+	credentials []*domain.Credentials
+}
+
+func (a *authenticator) Authenticate(credentials *domain.Credentials) (*domain.Authorization, error) {
+	a.mutex.Lock()
+	a.credentials = append(a.credentials, credentials)
+	err := a.err
+	a.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Authenticator.Authenticate(credentials)
+}
+
+// Last returns the credentials of the last authentication.
+func (a *authenticator) Last() *domain.Credentials {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.credentials) == 0 {
+		return nil
+	}
+
+	return a.credentials[len(a.credentials)-1]
+}
+
+func newSecureSubscriber(imsi, ip string, containers ...*ie.PCOContainer) *sgwsim.Subscriber {
+	subscriber := sgwsim.NewSubscriber(imsi, ip)
+	subscriber.APN = "secure"
+
+	if len(containers) > 0 {
+		subscriber.ExtraIEs = []*ie.IE{
+			ie.NewProtocolConfigurationOptions(gtpv2.ConfigProtocolPPPWithIP, containers...),
+		}
+	}
+
+	return subscriber
+}
+
+func newPAPContainer(username, password string) *ie.PCOContainer {
+	ppp, err := ie.NewPCOPPPWithPAP(1, username, password).Marshal()
+	Expect(err).NotTo(HaveOccurred())
+
+	return ie.NewPCOContainer(ie.PCOProtocolIdentifierPAP, ppp)
+}
+
+func newCHAPContainer(code, id uint8, value []byte, name string) *ie.PCOContainer {
+	fields, err := ie.NewCHAPFields(value, name).Marshal()
+	Expect(err).NotTo(HaveOccurred())
+
+	ppp, err := ie.NewPCOPPP(code, id, fields).Marshal()
+	Expect(err).NotTo(HaveOccurred())
+
+	return ie.NewPCOContainer(ie.PCOProtocolIdentifierCHAP, ppp)
+}
+
+var _ = Describe("Authentication", func() {
+	var (
+		pgw  *gateway
+		aaa  *authenticator
+		imsi = "123451234567871"
+	)
+
+	BeforeEach(func() {
+		aaa = &authenticator{Authenticator: aaarepo.NewStub(
+			aaarepo.Account{Username: "alice", Password: "secret"},
+			aaarepo.Account{Username: "bob", Password: "secret", FramedIP: net.ParseIP("10.0.1.200")},
+		)}
+		pgw = startGateway(aaa)
+		pgw.config.AddApn(&domain.Apn{Name: "secure", Authenticate: true})
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Describe("attaching with PAP credentials", func() {
+		It("should pass them to the AAA server", func() {
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("alice", "secret")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestAccepted))
+
+			credentials := aaa.Last()
+			Expect(credentials).NotTo(BeNil())
+			Expect(credentials.Protocol).To(Equal(domain.AuthenticationPAP))
+			Expect(credentials.Username).To(Equal("alice"))
+			Expect(credentials.Password).To(Equal("secret"))
+			Expect(credentials.IMSI).To(Equal(imsi))
+			Expect(credentials.Apn).To(Equal("secure"))
+		})
+		It("should reject an invalid password", func() {
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("alice", "wrong")))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseUserAuthenticationFailed))
+		})
+		It("should assign the Framed IP address of the AAA server", func() {
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("bob", "secret")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.PAA.MustIPAddress()).To(Equal("10.0.1.200"))
+		})
+		It("should refuse a Framed IP address reserved for another subscriber", func() {
+			Expect(pgw.addresses.Reserve(&domain.StaticAddress{IMSI: "123451234567872", IP: "10.0.1.200"})).To(Succeed())
+
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("bob", "secret")))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+		It("should refuse a Framed IP address used by another subscriber", func() {
+			_, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567872", "10.0.1.200"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("bob", "secret")))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+	})
+
+	Describe("attaching with CHAP credentials", func() {
+		It("should pass the challenge and its response to the AAA server", func() {
+			challenge := []byte("0123456789abcdef")
+
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newCHAPContainer(1, 7, challenge, "pgw"),
+				newCHAPContainer(2, 7, aaarepo.CHAPResponse(7, "secret", challenge), "alice")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestAccepted))
+
+			credentials := aaa.Last()
+			Expect(credentials).NotTo(BeNil())
+			Expect(credentials.Protocol).To(Equal(domain.AuthenticationCHAP))
+			Expect(credentials.Username).To(Equal("alice"))
+			Expect(credentials.ChapID).To(Equal(uint8(7)))
+			Expect(credentials.Challenge).To(Equal(challenge))
+		})
+	})
+
+	Describe("attaching without credentials", func() {
+		It("should reject the subscriber", func() {
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseUserAuthenticationFailed))
+			Expect(aaa.Last().Protocol).To(Equal(domain.AuthenticationNone))
+		})
+	})
+
+	Describe("failing to reach the AAA server", func() {
+		It("should reject the request without blaming the subscriber", func() {
+			aaa.mutex.Lock()
+			aaa.err = errUnreachable
+			aaa.mutex.Unlock()
+
+			_, response, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("alice", "secret")))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestRejectedReasonNotSpecified))
+		})
+	})
+
+	Describe("re-attaching with invalid credentials", func() {
+		It("should keep the previous session", func() {
+			session, _, err := pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("alice", "secret")))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(pgw.datapath.Tunnels).Should(HaveKey(session.RemoteTEIDU))
+
+			_, _, err = pgw.sgw.CreateSession(newSecureSubscriber(imsi, "10.0.1.71",
+				newPAPContainer("alice", "wrong")))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+
+			_, err = pgw.conn.GetSessionByTEID(session.RemoteTEIDC, pgw.sgw.LocalAddr())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgw.datapath.Tunnels()).To(HaveKey(session.RemoteTEIDU))
+		})
+	})
+})
@@ -17,25 +17,30 @@ import (
 	"net"
 
 	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"github.com/vishvananda/netlink"
-	"github.com/wmnsk/go-gtp/gtpv1"
 	"github.com/wmnsk/go-gtp/gtpv2"
 	"github.com/wmnsk/go-gtp/gtpv2/ie"
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
-// ErrInvalidRequestType indicates that an invalid session request was received.
-var ErrInvalidRequestType = errors.New("invalid request type")
+var (
+	// ErrInvalidRequestType indicates that an invalid session request was received.
+	ErrInvalidRequestType = errors.New("invalid request type")
+	// ErrIncorrectIE indicates that an information element has an unexpected value.
+	ErrIncorrectIE = errors.New("incorrect information element")
+)
 
 type create struct {
-	userPlaneConnection *gtpv1.UPlaneConn
-
-	addedRoutes []*netlink.Route
-	addedRules  []*netlink.Rule
-
-	config *domain.Pgw
+	userPlane     UserPlaneFunction
+	authenticator ports.Authenticator
+	policies      ports.PolicyRepository
+	addresses     ports.AddressRepository
+	config        *domain.Pgw
+	hooks         *Hooks
 }
 
 // Handler defines PGW contracts.
@@ -44,30 +49,23 @@ type Handler interface {
 	Close() error
 }
 
-// NewCreate creates a PGW handler for creating ISMI Sessions.
-func NewCreate(conn *gtpv1.UPlaneConn, config *domain.Pgw) Handler {
+// NewCreate creates a PGW handler for creating ISMI Sessions. The authenticator, the subscriber
+// policies, the static addresses and the hooks are optional.
+func NewCreate(config *domain.Pgw, authenticator ports.Authenticator, policies ports.PolicyRepository,
+	addresses ports.AddressRepository, userPlane UserPlaneFunction, hooks *Hooks,
+) Handler {
 	return &create{
-		userPlaneConnection: conn,
-		config:              config,
-		addedRoutes:         []*netlink.Route{},
-		addedRules:          []*netlink.Rule{},
+		userPlane:     userPlane,
+		authenticator: authenticator,
+		policies:      policies,
+		addresses:     addresses,
+		config:        config,
+		hooks:         hooks,
 	}
 }
 
-// Close removes rules and routes added by the Router and closes user plane connnection.
+// Close releases the resources used by the handler.
 func (h *create) Close() error {
-	for _, route := range h.addedRoutes {
-		if err := netlink.RouteDel(route); err != nil {
-			log.WithError(err).Warn("Route Deletion error")
-		}
-	}
-
-	for _, rule := range h.addedRules {
-		if err := netlink.RuleDel(rule); err != nil {
-			log.WithError(err).Warn("Rule Deletion error")
-		}
-	}
-
 	return nil
 }
 
@@ -167,7 +165,11 @@ func getContextObjects(sender net.Addr, msg message.Message) (*message.CreateSes
 		return request, nil, nil, errors.Wrap(ErrInvalidRequestType, "failed to get the create session")
 	}
 
-	if err := validate(request); err != nil {
+	span := tracehdl.Start(msg, "validation")
+	err := validate(request)
+	tracehdl.End(span, err)
+
+	if err != nil {
 		return request, nil, nil, errors.Wrap(err, "failed to get a valid create session request")
 	}
 
@@ -189,95 +191,186 @@ func getContextObjects(sender net.Addr, msg message.Message) (*message.CreateSes
 		return request, session, bearer, errors.Wrap(err, "failed to get the suscriber IP for the bearer object")
 	}
 
+	if net.ParseIP(bearer.SubscriberIP) == nil {
+		return request, session, bearer, errors.Wrapf(ErrIncorrectIE, "%q suscriber IP", bearer.SubscriberIP)
+	}
+
 	for _, childIE := range request.BearerContextsToBeCreated.ChildIEs {
-		if childIE.Type == ie.EPSBearerID {
+		switch childIE.Type {
+		case ie.EPSBearerID:
 			bearer.EBI, err = childIE.EPSBearerID()
 			if err != nil {
 				return request, session, bearer, errors.Wrapf(err, "failed to get EPSBearerID from %s childIE", childIE)
 			}
-
-			break
+		case ie.BearerQoS:
+			bearer.QoSProfile = getQoSProfile(childIE)
 		}
 	}
 
+	if bearer.EBI == 0 {
+		return request, session, bearer, &gtpv2.RequiredIEMissingError{Type: ie.EPSBearerID}
+	}
+
 	return request, session, bearer, nil
 }
 
-func removePreviousIMSISession(connection *gtpv2.Conn, imsi string) error {
-	// remove previous session for the same subscriber if exists.
+// removePreviousIMSISession replaces the session of the subscriber if exists, its user plane is
+// released given that the S-GW won't delete it.
+func (h *create) removePreviousIMSISession(msg message.Message, connection *gtpv2.Conn, imsi string) {
 	previousSession, err := connection.GetSessionByIMSI(imsi)
-	if err == nil {
-		connection.RemoveSession(previousSession)
+	if err != nil {
+		return
 	}
 
-	return nil
+	connection.RemoveSession(previousSession)
+
+	if bearer := previousSession.GetDefaultBearer(); bearer != nil {
+		h.releaseUserPlane(msg, bearer)
+	}
 }
 
-func getTunnelData(session *gtpv2.Session, childIEs []*ie.IE) (string, uint32, bool) {
+func getTunnelData(session *gtpv2.Session, childIEs []*ie.IE) (string, uint32, error) {
 	for _, childIE := range childIEs {
 		if childIE.Type == ie.FullyQualifiedTEID {
 			it, err := childIE.InterfaceType()
 			if err != nil {
-				log.WithError(err).Warnf("Failed to get InterfaceType from %s childIE", childIE)
+				return "", 0, errors.Wrapf(err, "failed to get InterfaceType from %s childIE", childIE)
 			}
 
 			oteiU, err := childIE.TEID()
 			if err != nil {
-				log.WithError(err).Warnf("Failed to get TEID from %s childIE", childIE)
+				return "", 0, errors.Wrapf(err, "failed to get TEID from %s childIE", childIE)
 			}
 
-			session.AddTEID(it, oteiU)
-
 			s5sgwuIP, err := childIE.IPAddress()
 			if err != nil {
-				log.WithError(err).Warnf("Failed to get IP Address from %s childIE", childIE)
+				return "", 0, errors.Wrapf(err, "failed to get IP Address from %s childIE", childIE)
 			}
 
-			return s5sgwuIP, oteiU, true
+			session.AddTEID(it, oteiU)
+
+			return s5sgwuIP, oteiU, nil
 		}
 	}
 
-	return "", 0, false
+	return "", 0, &gtpv2.RequiredIEMissingError{Type: ie.FullyQualifiedTEID}
 }
 
 // Handle creates a IMSI Session request.
 func (h *create) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	span := tracehdl.Start(msg, "decode")
 	request, session, bearer, err := getContextObjects(sender, msg)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		if request == nil {
+			return err
+		}
+
+		return h.hooks.rejectInvalid(connection, sender, request, err)
+	}
+
+	tracehdl.SetAttributes(msg, tracehdl.IMSIKey.String(session.IMSI), tracehdl.APNKey.String(bearer.APN))
+	loggerhdl.AddFields(msg, log.Fields{"IMSI": session.IMSI, "APN": bearer.APN})
+
+	span = tracehdl.Start(msg, "policy", tracehdl.IMSIKey.String(session.IMSI), tracehdl.APNKey.String(bearer.APN))
+	qos, err := h.applyPolicy(request, session, bearer)
+	tracehdl.End(span, err)
+
+	if err == nil {
+		span = tracehdl.Start(msg, "datastore", tracehdl.IMSIKey.String(session.IMSI))
+		err = h.assignStaticAddress(request, session, bearer)
+		tracehdl.End(span, err)
+	}
+
 	if err != nil {
+		if rejectErr := h.hooks.reject(connection, sender, request, getSubscriberCause(err), nil); rejectErr != nil {
+			return rejectErr
+		}
+
 		return err
 	}
 
-	if err := removePreviousIMSISession(connection, session.IMSI); err != nil {
+	span = tracehdl.Start(msg, "aaa", tracehdl.IMSIKey.String(session.IMSI), tracehdl.APNKey.String(bearer.APN))
+	err = h.authenticate(request, session, bearer)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		cause := gtpv2.CauseRequestRejectedReasonNotSpecified
+		if errors.Is(err, domain.ErrAuthenticationRejected) {
+			cause = gtpv2.CauseUserAuthenticationFailed
+		}
+
+		if rejectErr := h.hooks.reject(connection, sender, request, cause, nil); rejectErr != nil {
+			return rejectErr
+		}
+
 		return err
 	}
 
-	s5sgwuIP, oteiU, ok := getTunnelData(session, request.BearerContextsToBeCreated.ChildIEs)
-	if !ok {
-		return errors.New("failed to get tunnel data")
+	// the AAA server can replace the address, so the final one is checked.
+	span = tracehdl.Start(msg, "datastore", tracehdl.IMSIKey.String(session.IMSI))
+	err = h.checkAddress(connection, session, bearer)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		if rejectErr := h.hooks.reject(connection, sender, request, getSubscriberCause(err), nil); rejectErr != nil {
+			return rejectErr
+		}
+
+		return err
+	}
+
+	s5sgwuIP, oteiU, err := getTunnelData(session, request.BearerContextsToBeCreated.ChildIEs)
+	if err != nil {
+		return h.hooks.rejectInvalid(connection, sender, request, err)
+	}
+
+	s5uFTEID := h.userPlane.NewFTEID(gtpv2.IFTypeS5S8PGWGTPU).WithInstance(2)
+
+	binding, err := getDefaultBinding(request, bearer, s5sgwuIP, oteiU, s5uFTEID.MustTEID())
+	if err != nil {
+		return h.hooks.rejectInvalid(connection, sender, request, err)
 	}
 
 	s5cFTEID := connection.NewSenderFTEID(h.config.ControlPlane.IP, "").WithInstance(1)
-	s5uFTEID := h.userPlaneConnection.NewFTEID(gtpv2.IFTypeS5S8PGWGTPU, h.config.UserPlane.IP, "").WithInstance(2)
+	tracehdl.SetAttributes(msg, tracehdl.LocalTEIDKey.Int64(int64(s5cFTEID.MustTEID())))
 
 	s5sgwTEID, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC)
 	if err != nil {
 		return errors.Wrap(err, "failed to get TEID from the current session")
 	}
 
+	bearerContext := []*ie.IE{
+		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		ie.NewEPSBearerID(bearer.EBI),
+		s5uFTEID,
+		ie.NewChargingID(bearer.ChargingID),
+	}
+
+	// the S-GW is informed about the QoS values changed by the subscriber policy.
+	if qos != nil && bearer.QoSProfile != nil && (qos.QCI != 0 || qos.PL != 0) {
+		profile := bearer.QoSProfile
+		bearerContext = append(bearerContext, ie.NewBearerQoS(boolToUint8(profile.PCI), profile.PL,
+			boolToUint8(profile.PVI), profile.QCI, profile.MBRUL, profile.MBRDL, profile.GBRUL, profile.GBRDL))
+	}
+
 	response := message.NewCreateSessionResponse(
 		s5sgwTEID, 0,
 		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
 		s5cFTEID,
 		ie.NewPDNAddressAllocation(bearer.SubscriberIP),
 		ie.NewAPNRestriction(gtpv2.APNRestrictionPublic2),
-		ie.NewBearerContext(
-			ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
-			ie.NewEPSBearerID(bearer.EBI),
-			s5uFTEID,
-			ie.NewChargingID(bearer.ChargingID),
-		),
+		ie.NewBearerContext(bearerContext...),
 	)
 
+	ambr := getAMBR(request.AMBR, qos)
+	if ambr != request.AMBR {
+		response.AMBR = ambr
+	}
+
+	response.PCO = getPCO(request, h.config.GetApn(bearer.APN))
+
 	if request.SGWFQCSID != nil {
 		response.PGWFQCSID = ie.NewFullyQualifiedCSID(h.config.ControlPlane.IP, 1)
 	}
@@ -285,110 +378,153 @@ func (h *create) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Mes
 	session.AddTEID(gtpv2.IFTypeS5S8PGWGTPC, s5cFTEID.MustTEID())
 	session.AddTEID(gtpv2.IFTypeS5S8PGWGTPU, s5uFTEID.MustTEID())
 
-	if err := connection.RespondTo(sender, request, response); err != nil {
-		return errors.Wrap(err, "failed to send a respond through the control plane connection")
-	}
+	bearer.SetIncomingTEID(binding.IncomingTEID)
+	bearer.SetOutgoingTEID(binding.OutgoingTEID)
+	bearer.SetRemoteAddress(binding.Peer)
 
-	if err := addSession(session, connection); err != nil {
-		return errors.Wrap(err, "failed to activate and add session created to the session list")
-	}
+	// the previous session is kept when the new one is rejected before its user plane is set up.
+	span = tracehdl.Start(msg, "datastore", tracehdl.IMSIKey.String(session.IMSI))
+	h.removePreviousIMSISession(msg, connection, session.IMSI)
+	tracehdl.End(span, nil)
+
+	// the user plane is ready to forward traffic once the S-GW gets the response.
+	span = tracehdl.Start(msg, "user-plane", tracehdl.IMSIKey.String(session.IMSI))
+	guaranteed, maximum := getBitRates(bearer.QoSProfile, ambr)
+	err = h.userPlane.Establish(net.ParseIP(bearer.SubscriberIP), binding, guaranteed, maximum)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		if rejectErr := h.hooks.reject(connection, sender, request, gtpv2.CauseNoResourcesAvailable, nil); rejectErr != nil {
+			return rejectErr
+		}
 
-	if err := h.setupUserPlane(s5sgwuIP, bearer.SubscriberIP, oteiU, s5uFTEID.MustTEID()); err != nil {
-		return errors.Wrap(err, "failed to setup User Plane routes and rules")
+		return errors.Wrap(err, "failed to setup the User Plane")
 	}
 
-	return nil
-}
+	// the session is registered before it's accepted, so the accepted responses replayed for the
+	// retransmissions always refer to an active session.
+	span = tracehdl.Start(msg, "datastore", tracehdl.IMSIKey.String(session.IMSI))
+	err = addSession(session, connection)
+	tracehdl.End(span, err)
 
-func addSession(session *gtpv2.Session, connection *gtpv2.Conn) error {
-	s5pgwTEID, err := session.GetTEID(gtpv2.IFTypeS5S8PGWGTPC)
 	if err != nil {
-		return errors.Wrap(err, "failed to get TEID form the current session")
+		h.releaseUserPlane(msg, bearer)
+
+		if rejectErr := h.hooks.reject(connection, sender, request, gtpv2.CauseNoResourcesAvailable, nil); rejectErr != nil {
+			return rejectErr
+		}
+
+		return errors.Wrap(err, "failed to activate and add session created to the session list")
 	}
 
-	if err := session.Activate(); err != nil {
-		return errors.Wrap(err, "failed to activate the current session")
+	if err := h.hooks.respondTo(connection, sender, request, response); err != nil {
+		connection.RemoveSession(session)
+		h.releaseUserPlane(msg, bearer)
+
+		return errors.Wrap(err, "failed to send a respond through the control plane connection")
 	}
 
-	connection.RegisterSession(s5pgwTEID, session)
+	h.hooks.publishEvent(loggerhdl.FromMessage(msg), newSessionEvent(domain.SessionCreated, session, bearer,
+		gtpv2.CauseRequestAccepted))
 
 	return nil
 }
 
-func newRoute(dst *net.IPNet, linkIndex int) *netlink.Route {
-	return &netlink.Route{
-		Dst:       dst,
-		LinkIndex: linkIndex,
-		Scope:     netlink.SCOPE_LINK, // scope link
-		Protocol:  4,                  // proto static
-		Priority:  1,                  // metric 1
-	}
-}
+// rejectInvalid answers a Create Session Request which can't be processed with the cause of the failure.
+func (k *Hooks) rejectInvalid(connection *gtpv2.Conn, sender net.Addr, request *message.CreateSessionRequest, err error) error {
+	cause, offendingIE := gtpv2.CauseMandatoryIEIncorrect, (*ie.IE)(nil)
 
-func (h *create) appendRoute(route *netlink.Route) {
-	if err := netlink.RouteReplace(route); err != nil {
-		log.WithError(err).Warnf("Failed to add %s route", route)
+	var missingErr *gtpv2.RequiredIEMissingError
+
+	switch {
+	case errors.As(err, &missingErr):
+		cause, offendingIE = gtpv2.CauseMandatoryIEMissing, ie.New(missingErr.Type, 0, nil)
+	case errors.Is(err, domain.ErrInvalidTFT):
+		cause = gtpv2.CauseSemanticErrorInTheTFTOperation
 	}
 
-	log.WithFields(log.Fields{
-		"route": route,
-	}).Debug("Adding User plane route")
+	if rejectErr := k.reject(connection, sender, request, cause, offendingIE); rejectErr != nil {
+		return rejectErr
+	}
 
-	h.addedRoutes = append(h.addedRoutes, route)
+	return err
 }
 
-func findRule(ms32 *net.IPNet, ifName string) bool {
-	// Priority 0 rules
-	rules, _ := netlink.RuleList(0)
-	for _, rule := range rules {
-		if rule.IifName == ifName && rule.Dst == ms32 {
-			log.Debugf("%s rule found", rule)
+// reject answers a Create Session Request with the given cause.
+func (k *Hooks) reject(connection *gtpv2.Conn, sender net.Addr, request *message.CreateSessionRequest, cause uint8,
+	offendingIE *ie.IE,
+) error {
+	var teid uint32
 
-			return true
+	if request.SenderFTEIDC != nil {
+		var err error
+
+		if teid, err = request.SenderFTEIDC.TEID(); err != nil {
+			loggerhdl.FromMessage(request).WithError(err).Warn("Failed to get S-GW TEID for the rejection response")
 		}
 	}
 
-	return false
-}
+	response := message.NewCreateSessionResponse(teid, 0, ie.NewCause(cause, 0, 0, 0, offendingIE))
 
-func (h *create) appendRule(rule *netlink.Rule) {
-	if err := netlink.RuleAdd(rule); err != nil {
-		log.WithError(err).Warnf("Failed to add %s rule", rule)
+	if err := k.respondTo(connection, sender, request, response); err != nil {
+		return errors.Wrap(err, "failed to send a rejection through the control plane connection")
 	}
 
-	log.WithFields(log.Fields{
-		"rule": rule,
-	}).Debug("Adding User plane rule")
+	k.publishEvent(loggerhdl.FromMessage(request), newRejectedEvent(sender, request, cause))
+
+	return nil
+}
 
-	h.addedRules = append(h.addedRules, rule)
+// releaseUserPlane tears down the user plane of a session which is replaced or couldn't be created.
+func (h *create) releaseUserPlane(msg message.Message, bearer *gtpv2.Bearer) {
+	if err := h.userPlane.Release(net.ParseIP(bearer.SubscriberIP)); err != nil {
+		loggerhdl.FromMessage(msg).WithError(err).Warnf("Failed to release %s user plane", bearer.SubscriberIP)
+	}
 }
 
-// setupUserPlane configures the routes and rules for User plane traffic.
-func (h *create) setupUserPlane(peer, ms string, otei, itei uint32) error {
-	peerIP := net.ParseIP(peer)
-	msIP := net.ParseIP(ms)
+func addSession(session *gtpv2.Session, connection *gtpv2.Conn) error {
+	s5pgwTEID, err := session.GetTEID(gtpv2.IFTypeS5S8PGWGTPC)
+	if err != nil {
+		return errors.Wrap(err, "failed to get TEID form the current session")
+	}
 
-	if err := h.userPlaneConnection.AddTunnelOverride(peerIP, msIP, otei, itei); err != nil {
-		return errors.Wrap(err, "failed to add a GTP-U tunnel")
+	if err := session.Activate(); err != nil {
+		return errors.Wrap(err, "failed to activate the current session")
 	}
 
-	ms32 := &net.IPNet{
-		IP:   msIP,
-		Mask: net.CIDRMask(32, 32),
+	connection.RegisterSession(s5pgwTEID, session)
+
+	return nil
+}
+
+// getAPN returns the access point name of the session, which is empty until its default bearer is created.
+func getAPN(session *gtpv2.Session) string {
+	if bearer := session.GetDefaultBearer(); bearer != nil {
+		return bearer.APN
 	}
-	dlroute := newRoute(ms32, h.userPlaneConnection.KernelGTP.Link.Attrs().Index)
-	dlroute.Table = 3001
-	h.appendRoute(dlroute)
-	h.appendRoute(newRoute(h.config.Sgi.Subnet, h.config.Sgi.Link.Attrs().Index))
 
-	if !findRule(ms32, h.config.Sgi.Link.Attrs().Name) {
-		rule := netlink.NewRule()
-		rule.IifName = h.config.Sgi.Link.Attrs().Name
-		rule.Dst = ms32
-		rule.Table = 3001
+	return ""
+}
 
-		h.appendRule(rule)
+// getDefaultBinding retrieves the GTP-U tunnel and the optional TFT of the default bearer.
+func getDefaultBinding(request *message.CreateSessionRequest, bearer *gtpv2.Bearer,
+	peer string, otei, itei uint32,
+) (*domain.BearerBinding, error) {
+	peerAddr, err := net.ResolveUDPAddr("udp", peer+gtpv2.GTPUPort)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve S-GW user plane address")
 	}
 
-	return nil
+	tft, err := getTFT(request.BearerContextsToBeCreated.ChildIEs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.BearerBinding{
+		EBI:          bearer.EBI,
+		IncomingTEID: itei,
+		OutgoingTEID: otei,
+		Peer:         peerAddr,
+		TFT:          tft,
+	}, nil
 }
@@ -0,0 +1,215 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+var _ = Describe("Create", func() {
+	var pgw *gateway
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Describe("attaching a subscriber", func() {
+		It("should accept the session and add its GTP-U tunnel", func() {
+			session, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestAccepted))
+			Expect(response.PAA.MustIPAddress()).To(Equal("10.0.1.2"))
+			Expect(session.RemoteTEIDC).NotTo(BeZero())
+			Expect(session.RemoteAddress).To(Equal("127.0.0.1"))
+			Eventually(pgw.datapath.Tunnels).Should(HaveKey(session.RemoteTEIDU))
+		})
+		It("should register the session before accepting it", func() {
+			session, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = pgw.conn.GetSessionByTEID(session.RemoteTEIDC, pgw.sgw.LocalAddr())
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should keep the session when its reattach is rejected", func() {
+			session, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+
+			subscriber := sgwsim.NewSubscriber("123451234567891", "10.0.1.2")
+			subscriber.BearerIEs = []*ie.IE{ie.New(ie.BearerTFT, 0, []byte{0xff})}
+			_, response, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseSemanticErrorInTheTFTOperation))
+
+			_, err = pgw.conn.GetSessionByTEID(session.RemoteTEIDC, pgw.sgw.LocalAddr())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgw.datapath.Tunnels()).To(HaveKey(session.RemoteTEIDU))
+		})
+	})
+
+	Describe("attaching a subscriber concurrently", func() {
+		It("should keep only the last session of the subscriber", func() {
+			var (
+				wg       sync.WaitGroup
+				mutex    sync.Mutex
+				sessions []*sgwsim.Session
+			)
+
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					session, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567821", "10.0.1.41"))
+					Expect(err).NotTo(HaveOccurred())
+
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					sessions = append(sessions, session)
+				}()
+			}
+
+			wg.Wait()
+
+			// The TEIDs of the replaced sessions are released, only the last session remains
+			Eventually(func() int {
+				active := 0
+
+				for _, session := range sessions {
+					if _, err := pgw.conn.GetSessionByTEID(session.RemoteTEIDC, pgw.sgw.LocalAddr()); err == nil {
+						active++
+					}
+				}
+
+				return active
+			}).Should(Equal(1))
+			Expect(pgw.conn.SessionCount()).To(Equal(1))
+			// The user planes of the replaced sessions are released as well
+			Eventually(pgw.datapath.Tunnels).Should(HaveLen(1))
+		})
+	})
+
+	Describe("requesting DNS servers", func() {
+		It("should answer the servers of the APN", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567897", "10.0.1.7")
+			subscriber.APN = "ims"
+			subscriber.ExtraIEs = []*ie.IE{ie.NewProtocolConfigurationOptions(gtpv2.ConfigProtocolPPPWithIP,
+				ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv4AddressRequest, nil),
+				ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv6AddressRequest, nil),
+			)}
+
+			_, response, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.PCO).NotTo(BeNil())
+			Expect(response.PCO.MustProtocolConfigurationOptions().ProtocolOrContainers).To(Equal([]*ie.PCOContainer{
+				ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv4AddressRequest, net.ParseIP("10.0.0.53").To4()),
+			}))
+		})
+		It("should omit them when the APN has none", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567898", "10.0.1.8")
+			subscriber.ExtraIEs = []*ie.IE{ie.NewProtocolConfigurationOptions(gtpv2.ConfigProtocolPPPWithIP,
+				ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv4AddressRequest, nil),
+			)}
+
+			_, response, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.PCO).To(BeNil())
+		})
+	})
+
+	Describe("applying subscriber policies", func() {
+		It("should reject the denied subscribers", func() {
+			Expect(pgw.policies.Replace([]*domain.SubscriberPolicy{
+				{Name: "barred", IMSIPrefix: "12345123456789", Action: domain.PolicyDeny},
+			})).To(Succeed())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567898", "10.0.1.9"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseUserAuthenticationFailed))
+		})
+		It("should reject the APNs outside the subscription", func() {
+			Expect(pgw.policies.Replace([]*domain.SubscriberPolicy{
+				{Name: "ims-only", IMSIFirst: "123451234567800", IMSILast: "123451234567899",
+					Action: domain.PolicyAllow, Apns: []string{"ims"}},
+			})).To(Succeed())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567898", "10.0.1.9"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseAPNAccessDeniedNoSubscription))
+		})
+		It("should override the QoS of the allowed subscribers", func() {
+			Expect(pgw.policies.Replace([]*domain.SubscriberPolicy{
+				{Name: "gold", MSISDNPrefix: "8140", Action: domain.PolicyAllow,
+					QoS: &domain.QoSOverride{QCI: 6, AMBRUplink: 1000, AMBRDownlink: 2000}},
+				{Name: "default", Action: domain.PolicyDeny},
+			})).To(Succeed())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567898", "10.0.1.9"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.AMBR.AggregateMaximumBitRateUp()).To(Equal(uint32(1000)))
+			Expect(response.AMBR.AggregateMaximumBitRateDown()).To(Equal(uint32(2000)))
+
+			qos, err := response.BearerContextsCreated.FindByType(ie.BearerQoS, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(qos.QCILabel()).To(Equal(uint8(6)))
+		})
+	})
+
+	Describe("assigning static addresses", func() {
+		BeforeEach(func() {
+			Expect(pgw.addresses.Reserve(&domain.StaticAddress{IMSI: "123451234567801", IP: "10.0.1.20"})).To(Succeed())
+		})
+
+		It("should replace the requested address", func() {
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567801", "10.0.1.9"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.PAA.MustIPAddress()).To(Equal("10.0.1.20"))
+		})
+		It("should refuse the address to other subscribers", func() {
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567802", "10.0.1.20"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+		It("should refuse the address used by an active session", func() {
+			Expect(pgw.addresses.Reserve(&domain.StaticAddress{IMSI: "123451234567801", IP: "10.0.1.21"})).To(Succeed())
+
+			active, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567802", "10.0.1.21"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(active).To(BeNil())
+
+			Expect(pgw.addresses.Release("123451234567801", "")).To(Succeed())
+			_, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567802", "10.0.1.21"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pgw.addresses.Reserve(&domain.StaticAddress{IMSI: "123451234567801", IP: "10.0.1.21"})).To(Succeed())
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567801", "10.0.1.9"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+	})
+})
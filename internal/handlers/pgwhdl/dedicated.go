@@ -0,0 +1,243 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+var (
+	// ErrUnsupportedDatapath indicates that the datapath can't steer traffic to dedicated bearers.
+	ErrUnsupportedDatapath = errors.New("datapath doesn't support dedicated bearers")
+	// ErrBearerRejected indicates that the S-GW didn't accept the bearer creation.
+	ErrBearerRejected = errors.New("bearer rejected")
+)
+
+// BearerBinder binds bearer tunnels to the UE traffic forwarded in userspace.
+type BearerBinder interface {
+	Link() *domain.Link
+	Bind(ms net.IP, binding *domain.BearerBinding)
+	Release(ms net.IP)
+}
+
+// Dedicated activates dedicated bearers initiated by the PDN Gateway.
+type Dedicated struct {
+	userPlane UserPlaneFunction
+	hooks     *Hooks
+	timeout   time.Duration
+}
+
+// NewDedicated creates a PGW handler for activating dedicated bearers. The user plane function
+// is nil when the datapath can't steer traffic to dedicated bearers, the hooks are optional.
+func NewDedicated(userPlane UserPlaneFunction, hooks *Hooks) *Dedicated {
+	return &Dedicated{
+		userPlane: userPlane,
+		hooks:     hooks,
+		timeout:   time.Duration(5) * time.Second,
+	}
+}
+
+// Close releases the resources used by the handler.
+func (h *Dedicated) Close() error {
+	return nil
+}
+
+// Handle passes the Create Bearer Response to the session which is waiting for it.
+func (h *Dedicated) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	session, err := connection.GetSessionByTEID(msg.TEID(), sender)
+	if err != nil {
+		return errors.Wrap(err, "failed to get a session from TEID")
+	}
+
+	if err := gtpv2.PassMessageTo(session, msg, h.timeout); err != nil {
+		return errors.Wrap(err, "failed to pass the create bearer response")
+	}
+
+	return nil
+}
+
+// Activate sends a Create Bearer Request to the S-GW and binds the new bearer to the given TFT.
+func (h *Dedicated) Activate(connection *gtpv2.Conn, imsi string, qos *gtpv2.QoSProfile,
+	tft *domain.TrafficFlowTemplate,
+) (uint8, error) {
+	if h.userPlane == nil {
+		return 0, ErrUnsupportedDatapath
+	}
+
+	session, err := connection.GetSessionByIMSI(imsi)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get %s session", imsi)
+	}
+
+	defaultBearer := session.GetDefaultBearer()
+
+	sgwTEID, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get TEID from the current session")
+	}
+
+	value, err := tft.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	s5uFTEID := h.userPlane.NewFTEID(gtpv2.IFTypeS5S8PGWGTPU).WithInstance(1)
+	request := message.NewCreateBearerRequest(
+		sgwTEID, 0,
+		ie.NewEPSBearerID(defaultBearer.EBI),
+		ie.NewBearerContext(
+			ie.NewEPSBearerID(0),
+			ie.New(ie.BearerTFT, 0, value),
+			s5uFTEID,
+			ie.NewBearerQoS(boolToUint8(qos.PCI), qos.PL, boolToUint8(qos.PVI), qos.QCI,
+				qos.MBRUL, qos.MBRDL, qos.GBRUL, qos.GBRDL),
+		),
+	)
+
+	seq, err := connection.SendMessageTo(request, session.PeerAddr())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to send a create bearer request")
+	}
+
+	h.hooks.notifySent(session.PeerAddr(), request)
+
+	msg, err := session.WaitMessage(seq, h.timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get a create bearer response")
+	}
+
+	response, ok := msg.(*message.CreateBearerResponse)
+	if !ok {
+		return 0, errors.Wrap(ErrInvalidRequestType, "failed to get the create bearer response")
+	}
+
+	bearer, err := getDedicatedBearer(response)
+	if err != nil {
+		return 0, err
+	}
+
+	bearer.QoSProfile = qos
+	bearer.SubscriberIP = defaultBearer.SubscriberIP
+	bearer.APN = defaultBearer.APN
+	bearer.SetIncomingTEID(s5uFTEID.MustTEID())
+	session.AddBearer(dedicatedBearerName(bearer.EBI), bearer)
+
+	guaranteed, maximum := getBitRates(qos, nil)
+	if err := h.userPlane.AddBearer(net.ParseIP(bearer.SubscriberIP), &domain.BearerBinding{
+		EBI:          bearer.EBI,
+		IncomingTEID: bearer.IncomingTEID(),
+		OutgoingTEID: bearer.OutgoingTEID(),
+		Peer:         bearer.RemoteAddress(),
+		TFT:          tft,
+	}, guaranteed, maximum); err != nil {
+		return 0, errors.Wrap(err, "failed to forward the dedicated bearer traffic")
+	}
+
+	log.WithFields(log.Fields{
+		"IMSI": imsi,
+		"EBI":  bearer.EBI,
+	}).Info("Dedicated bearer created")
+	h.hooks.publishEvent(log.WithField("IMSI", imsi), newSessionEvent(domain.SessionModified, session, bearer,
+		gtpv2.CauseRequestAccepted))
+
+	return bearer.EBI, nil
+}
+
+func getDedicatedBearer(response *message.CreateBearerResponse) (*gtpv2.Bearer, error) {
+	if response.Cause == nil || response.BearerContexts == nil {
+		return nil, errors.Wrap(ErrBearerRejected, "missing cause or bearer context")
+	}
+
+	if cause, err := response.Cause.Cause(); err != nil || cause != gtpv2.CauseRequestAccepted {
+		return nil, errors.Wrapf(ErrBearerRejected, "%d cause", cause)
+	}
+
+	bearer := &gtpv2.Bearer{}
+
+	for _, childIE := range response.BearerContexts.ChildIEs {
+		switch childIE.Type {
+		case ie.Cause:
+			if cause, err := childIE.Cause(); err != nil || cause != gtpv2.CauseRequestAccepted {
+				return nil, errors.Wrapf(ErrBearerRejected, "%d bearer cause", cause)
+			}
+		case ie.EPSBearerID:
+			ebi, err := childIE.EPSBearerID()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get EPSBearerID from %s childIE", childIE)
+			}
+
+			bearer.EBI = ebi
+		case ie.FullyQualifiedTEID:
+			if it, err := childIE.InterfaceType(); err != nil || it != gtpv2.IFTypeS5S8SGWGTPU {
+				continue
+			}
+
+			ip, err := childIE.IPAddress()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get IP Address from %s childIE", childIE)
+			}
+
+			peer, err := net.ResolveUDPAddr("udp", ip+gtpv2.GTPUPort)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to resolve S-GW user plane address")
+			}
+
+			bearer.SetRemoteAddress(peer)
+			bearer.SetOutgoingTEID(childIE.MustTEID())
+		}
+	}
+
+	if bearer.EBI == 0 || bearer.RemoteAddress() == nil {
+		return nil, errors.Wrap(ErrBearerRejected, "incomplete bearer context")
+	}
+
+	return bearer, nil
+}
+
+// getTFT retrieves the Traffic Flow Template of a bearer context if it was provided.
+func getTFT(childIEs []*ie.IE) (*domain.TrafficFlowTemplate, error) {
+	for _, childIE := range childIEs {
+		if childIE.Type == ie.BearerTFT {
+			tft, err := domain.ParseTFT(childIE.Payload)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get Bearer TFT")
+			}
+
+			return tft, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func dedicatedBearerName(ebi uint8) string {
+	return fmt.Sprintf("dedicated-%d", ebi)
+}
+
+func boolToUint8(value bool) uint8 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
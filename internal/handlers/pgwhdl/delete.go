@@ -16,6 +16,9 @@ package pgwhdl
 import (
 	"net"
 
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/wmnsk/go-gtp/gtpv2"
@@ -23,27 +26,51 @@ import (
 	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
+type remove struct {
+	userPlane UserPlaneFunction
+	hooks     *Hooks
+}
+
+// NewDelete creates a PGW handler for deleting IMSI Sessions, the hooks are optional.
+func NewDelete(userPlane UserPlaneFunction, hooks *Hooks) Handler {
+	return &remove{
+		userPlane: userPlane,
+		hooks:     hooks,
+	}
+}
+
+// Close releases the resources used by the handler.
+func (h *remove) Close() error {
+	return nil
+}
+
 // Handle drops a IMSI Session response.
-func Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+func (h *remove) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
 	// assert type to refer to the struct field specific to the message.
 	// in general, no need to check if it can be type-asserted, as long as the MessageType is
 	// specified correctly in AddHandler().
+	span := tracehdl.Start(msg, "datastore")
 	session, err := connection.GetSessionByTEID(msg.TEID(), sender)
+	tracehdl.End(span, err)
+
 	if err != nil {
 		response := message.NewDeleteSessionResponse(
 			0, 0,
 			ie.NewCause(gtpv2.CauseIMSIIMEINotKnown, 0, 0, 0, nil),
 		)
-		if err := connection.RespondTo(sender, msg, response); err != nil {
+		if err := h.hooks.respondTo(connection, sender, msg, response); err != nil {
 			return errors.Wrap(err, "failed to send an error caused by getting session method")
 		}
 
 		return errors.Wrap(err, "failed to get a session from TEID")
 	}
 
+	tracehdl.SetAttributes(msg, tracehdl.IMSIKey.String(session.IMSI), tracehdl.APNKey.String(getAPN(session)))
+	loggerhdl.AddFields(msg, log.Fields{"IMSI": session.IMSI, "APN": getAPN(session)})
+
 	teid, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC)
 	if err != nil {
-		log.WithError(err).Error("Failed to respond to S-GW with Delete Session Response")
+		loggerhdl.FromMessage(msg).WithError(err).Error("Failed to respond to S-GW with Delete Session Response")
 
 		return nil
 	}
@@ -53,14 +80,30 @@ func Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error
 		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
 	)
 
-	if err := connection.RespondTo(sender, msg, response); err != nil {
+	if err := h.hooks.respondTo(connection, sender, msg, response); err != nil {
 		return errors.Wrap(err, "failed to send a delete response message")
 	}
 
-	log.WithFields(log.Fields{
+	loggerhdl.FromMessage(msg).WithFields(log.Fields{
 		"IMSI": session.IMSI,
 	}).Info("Session deleted")
 	connection.RemoveSession(session)
+	h.teardownUserPlane(msg, session)
+	h.hooks.publishEvent(loggerhdl.FromMessage(msg), newSessionEvent(domain.SessionDeleted, session,
+		session.GetDefaultBearer(), gtpv2.CauseRequestAccepted))
 
 	return nil
 }
+
+// teardownUserPlane stops forwarding the traffic of the session.
+func (h *remove) teardownUserPlane(msg message.Message, session *gtpv2.Session) {
+	if bearer := session.GetDefaultBearer(); bearer != nil {
+		span := tracehdl.Start(msg, "user-plane", tracehdl.APNKey.String(bearer.APN))
+		err := h.userPlane.Release(net.ParseIP(bearer.SubscriberIP))
+		tracehdl.End(span, err)
+
+		if err != nil {
+			loggerhdl.FromMessage(msg).WithError(err).Warnf("Failed to release %s user plane", session.IMSI)
+		}
+	}
+}
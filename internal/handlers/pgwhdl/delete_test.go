@@ -0,0 +1,70 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Delete", func() {
+	var pgw *gateway
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Describe("detaching a subscriber", func() {
+		It("should delete the session and its GTP-U tunnel", func() {
+			session, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(pgw.datapath.Tunnels).Should(HaveKey(session.RemoteTEIDU))
+
+			_, err = pgw.sgw.DeleteSession(session)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(pgw.conn.SessionCount).Should(BeZero())
+			Eventually(pgw.datapath.Tunnels).ShouldNot(HaveKey(session.RemoteTEIDU))
+		})
+	})
+
+	Describe("publishing session events", func() {
+		It("should report the session lifecycle", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567893", "10.0.1.4")
+
+			session, _, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = pgw.sgw.ModifyBearer(session, "127.0.0.2", 1234)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = pgw.sgw.DeleteSession(session)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() []string {
+				return pgw.events.Types(subscriber.IMSI)
+			}).Should(Equal([]string{domain.SessionCreated, domain.SessionModified, domain.SessionDeleted}))
+
+			created := pgw.events.Find(subscriber.IMSI, domain.SessionCreated)
+			Expect(created.APN).To(Equal(subscriber.APN))
+			Expect(created.UEAddress).To(Equal("10.0.1.4"))
+			Expect(created.SGW).To(Equal(pgw.sgw.LocalAddr().String()))
+			Expect(created.EBI).To(Equal(subscriber.EBI))
+			Expect(created.Cause).To(Equal(gtpv2.CauseRequestAccepted))
+		})
+	})
+})
@@ -0,0 +1,194 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// drainPollInterval is the time waited between checks of the remaining sessions.
+const drainPollInterval = time.Duration(500) * time.Millisecond
+
+// Drain stops accepting sessions and deactivates the active ones before the P-GW shuts down.
+type Drain struct {
+	userPlane UserPlaneFunction
+	hooks     *Hooks
+	timeout   time.Duration
+	draining  int32
+}
+
+// NewDrain creates a PGW handler for draining the sessions, the hooks are optional.
+func NewDrain(userPlane UserPlaneFunction, hooks *Hooks) *Drain {
+	return &Drain{
+		userPlane: userPlane,
+		hooks:     hooks,
+		timeout:   time.Duration(5) * time.Second,
+	}
+}
+
+// Close releases the resources used by the handler.
+func (d *Drain) Close() error {
+	return nil
+}
+
+// IsDraining indicates if the new sessions are rejected.
+func (d *Drain) IsDraining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// Wrap rejects the Create Session Requests received once the P-GW is draining.
+func (d *Drain) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		request, ok := msg.(*message.CreateSessionRequest)
+		if !ok || !d.IsDraining() {
+			return apiHandler(connection, sender, msg)
+		}
+
+		loggerhdl.FromMessage(msg).Info("Session rejected while draining")
+
+		return d.hooks.reject(connection, sender, request, gtpv2.CauseNoResourcesAvailable, nil)
+	}
+}
+
+// Handle passes the Delete Bearer Response to the session which is waiting for it.
+func (d *Drain) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	session, err := connection.GetSessionByTEID(msg.TEID(), sender)
+	if err != nil {
+		return errors.Wrap(err, "failed to get a session from TEID")
+	}
+
+	if err := gtpv2.PassMessageTo(session, msg, d.timeout); err != nil {
+		return errors.Wrap(err, "failed to pass the delete bearer response")
+	}
+
+	return nil
+}
+
+// Drain rejects the new sessions and waits until the S-GWs delete the active ones or the context
+// is done. When the sessions are deactivated, the S-GWs are asked to delete their bearers first.
+func (d *Drain) Drain(ctx context.Context, connection *gtpv2.Conn, deactivate bool) {
+	atomic.StoreInt32(&d.draining, 1)
+
+	sessions := connection.Sessions()
+
+	log.WithFields(log.Fields{
+		"sessions":   len(sessions),
+		"deactivate": deactivate,
+	}).Info("Draining sessions")
+
+	if deactivate {
+		var wg sync.WaitGroup
+
+		for _, session := range sessions {
+			if !session.IsActive() {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(session *gtpv2.Session) {
+				defer wg.Done()
+
+				if err := d.deactivate(ctx, connection, session); err != nil {
+					log.WithError(err).Warnf("Failed to deactivate %s session", session.IMSI)
+				}
+			}(session)
+		}
+
+		wg.Wait()
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for connection.SessionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			log.WithField("sessions", connection.SessionCount()).Warn("Drain deadline exceeded")
+
+			return
+		case <-ticker.C:
+		}
+	}
+
+	log.Info("Sessions drained")
+}
+
+// deactivate sends a Delete Bearer Request for the default bearer, which deletes the whole
+// session, and releases its resources whatever the S-GW answers.
+func (d *Drain) deactivate(ctx context.Context, connection *gtpv2.Conn, session *gtpv2.Session) error {
+	bearer := session.GetDefaultBearer()
+
+	defer func() {
+		connection.RemoveSession(session)
+
+		if err := d.userPlane.Release(net.ParseIP(bearer.SubscriberIP)); err != nil {
+			log.WithError(err).Warnf("Failed to release %s user plane", session.IMSI)
+		}
+
+		d.hooks.publishEvent(log.WithField("IMSI", session.IMSI), newSessionEvent(domain.SessionDeleted, session,
+			bearer, gtpv2.CauseReactivationRequested))
+	}()
+
+	sgwTEID, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC)
+	if err != nil {
+		return errors.Wrap(err, "failed to get TEID from the current session")
+	}
+
+	request := message.NewDeleteBearerRequest(sgwTEID, 0,
+		ie.NewEPSBearerID(bearer.EBI),
+		ie.NewCause(gtpv2.CauseReactivationRequested, 0, 0, 0, nil),
+	)
+
+	seq, err := connection.SendMessageTo(request, session.PeerAddr())
+	if err != nil {
+		return errors.Wrap(err, "failed to send a delete bearer request")
+	}
+
+	d.hooks.notifySent(session.PeerAddr(), request)
+
+	if _, err := session.WaitMessage(seq, d.getTimeout(ctx)); err != nil {
+		return errors.Wrap(err, "failed to get a delete bearer response")
+	}
+
+	log.WithFields(log.Fields{
+		"IMSI": session.IMSI,
+	}).Info("Session deactivated")
+
+	return nil
+}
+
+// getTimeout returns the time left until the context deadline or the default response timeout.
+func (d *Drain) getTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+
+	return d.timeout
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Drain", func() {
+	var (
+		pgw     *gateway
+		session *sgwsim.Session
+	)
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+
+		var err error
+		session, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567894", "10.0.1.5"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(pgw.datapath.Tunnels).Should(HaveKey(session.RemoteTEIDU))
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Context("when the sessions are kept", func() {
+		It("should reject new sessions until the active ones are deleted", func() {
+			drained := make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+				defer close(drained)
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				pgw.control.Drain(ctx, false)
+			}()
+			Eventually(pgw.control.IsDraining).Should(BeTrue())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567895", "10.0.1.6"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+			Consistently(drained).ShouldNot(BeClosed())
+
+			_, err = pgw.sgw.DeleteSession(session)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(drained, 2*time.Second).Should(BeClosed())
+		})
+	})
+
+	Context("when the sessions are deactivated", func() {
+		It("should delete the bearers of the active sessions", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			pgw.control.Drain(ctx, true)
+
+			Expect(pgw.sgw.HasSession(session)).To(BeFalse())
+			Expect(pgw.conn.SessionCount()).To(BeZero())
+			Expect(pgw.datapath.Tunnels()).NotTo(HaveKey(session.RemoteTEIDU))
+			Expect(pgw.events.Types(session.Subscriber.IMSI)).To(Equal([]string{
+				domain.SessionCreated, domain.SessionDeleted,
+			}))
+		})
+	})
+})
@@ -0,0 +1,103 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+func (k *Hooks) publishEvent(entry *log.Entry, event *domain.SessionEvent) {
+	if k == nil || k.Publisher == nil {
+		return
+	}
+
+	if err := k.Publisher.Publish(event); err != nil {
+		entry.WithError(err).Warnf("Failed to publish %s session event", event.Type)
+	}
+}
+
+// newSessionEvent describes a change of the session through the given bearer.
+func newSessionEvent(eventType string, session *gtpv2.Session, bearer *gtpv2.Bearer, cause uint8) *domain.SessionEvent {
+	event := &domain.SessionEvent{
+		Type:   eventType,
+		Time:   time.Now(),
+		IMSI:   session.IMSI,
+		MSISDN: session.MSISDN,
+		Cause:  cause,
+	}
+
+	if peer := session.PeerAddr(); peer != nil {
+		event.SGW = peer.String()
+	}
+
+	if bearer != nil {
+		event.APN = bearer.APN
+		event.UEAddress = bearer.SubscriberIP
+		event.EBI = bearer.EBI
+	}
+
+	return event
+}
+
+// newRejectedEvent describes a Create Session Request which wasn't accepted with the
+// information elements which could be decoded.
+func newRejectedEvent(sender net.Addr, request *message.CreateSessionRequest, cause uint8) *domain.SessionEvent {
+	event := &domain.SessionEvent{
+		Type:  domain.SessionRejected,
+		Time:  time.Now(),
+		SGW:   sender.String(),
+		Cause: cause,
+	}
+
+	if request.IMSI != nil {
+		event.IMSI, _ = request.IMSI.IMSI()
+	}
+
+	if request.MSISDN != nil {
+		event.MSISDN, _ = request.MSISDN.MSISDN()
+	}
+
+	if request.APN != nil {
+		event.APN, _ = request.APN.AccessPointName()
+	}
+
+	if request.PAA != nil {
+		event.UEAddress, _ = request.PAA.IPAddress()
+	}
+
+	if request.BearerContextsToBeCreated != nil {
+		event.EBI = getEBI(request.BearerContextsToBeCreated.ChildIEs)
+	}
+
+	return event
+}
+
+func getEBI(childIEs []*ie.IE) uint8 {
+	for _, childIE := range childIEs {
+		if childIE.Type == ie.EPSBearerID {
+			ebi, _ := childIE.EPSBearerID()
+
+			return ebi
+		}
+	}
+
+	return 0
+}
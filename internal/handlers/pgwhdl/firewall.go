@@ -0,0 +1,138 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Reasons of the Create Session Requests blocked by the firewall.
+const (
+	// BlockedUnknownPeer indicates that the peer isn't allowed to serve the PLMN.
+	BlockedUnknownPeer = "unknown_peer"
+	// BlockedSenderMismatch indicates that the Sender F-TEID address isn't the source of the request.
+	BlockedSenderMismatch = "sender_mismatch"
+)
+
+// BlockObserver is notified of the messages blocked by the firewall.
+type BlockObserver interface {
+	MessageBlocked(peer net.Addr, msg message.Message, reason string)
+}
+
+// Firewall filters the S5/S8 peers which can create sessions.
+type Firewall struct {
+	config   *domain.Pgw
+	observer BlockObserver
+	hooks    *Hooks
+}
+
+// NewFirewall creates a PGW handler which applies the firewall rules of the configuration, the
+// observer and the hooks are optional.
+func NewFirewall(config *domain.Pgw, observer BlockObserver, hooks *Hooks) *Firewall {
+	return &Firewall{
+		config:   config,
+		observer: observer,
+		hooks:    hooks,
+	}
+}
+
+// Wrap rejects or drops the Create Session Requests received from the peers which aren't allowed.
+func (f *Firewall) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		request, ok := msg.(*message.CreateSessionRequest)
+		if !ok {
+			return apiHandler(connection, sender, msg)
+		}
+
+		firewall := f.config.GetFirewall()
+
+		reason := check(firewall, sender, request)
+		if reason == "" {
+			return apiHandler(connection, sender, msg)
+		}
+
+		if f.observer != nil {
+			f.observer.MessageBlocked(sender, msg, reason)
+		}
+
+		logger := loggerhdl.FromMessage(msg).WithField("reason", reason)
+		if firewall.Drops() {
+			logger.Warn("Create Session Request dropped by the firewall")
+
+			return nil
+		}
+
+		logger.Warn("Create Session Request rejected by the firewall")
+
+		return f.hooks.reject(connection, sender, request, gtpv2.CauseRequestRejectedReasonNotSpecified, nil)
+	}
+}
+
+// check returns the reason to block the request, it's empty when the request is allowed.
+func check(firewall *domain.Firewall, sender net.Addr, request *message.CreateSessionRequest) string {
+	source := getSourceIP(sender)
+
+	if !firewall.Allows(source, getPLMN(request)) {
+		return BlockedUnknownPeer
+	}
+
+	if firewall.ValidateSender && request.SenderFTEIDC != nil {
+		address, err := request.SenderFTEIDC.IPAddress()
+		if err != nil || !source.Equal(net.ParseIP(address)) {
+			return BlockedSenderMismatch
+		}
+	}
+
+	return ""
+}
+
+// getPLMN retrieves the MCC and MNC of the Serving Network, it's empty when they aren't provided.
+func getPLMN(request *message.CreateSessionRequest) string {
+	if request.ServingNetwork == nil {
+		return ""
+	}
+
+	mcc, err := request.ServingNetwork.MCC()
+	if err != nil {
+		return ""
+	}
+
+	mnc, err := request.ServingNetwork.MNC()
+	if err != nil {
+		return ""
+	}
+
+	return mcc + mnc
+}
+
+func getSourceIP(sender net.Addr) net.IP {
+	if address, ok := sender.(*net.UDPAddr); ok {
+		return address.IP
+	}
+
+	host, _, err := net.SplitHostPort(sender.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
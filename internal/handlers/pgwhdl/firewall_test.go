@@ -0,0 +1,88 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Firewall", func() {
+	var pgw *gateway
+
+	mustParseCIDR := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		Expect(err).NotTo(HaveOccurred())
+
+		return network
+	}
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Context("when the S-GW isn't allowed", func() {
+		BeforeEach(func() {
+			pgw.config.SetFirewall(&domain.Firewall{
+				Action: domain.FirewallActionReject,
+				Peers:  []*domain.PeerRule{{Networks: []*net.IPNet{mustParseCIDR("192.0.2.0/24")}}},
+			})
+		})
+		It("should reject the session", func() {
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567897", "10.0.1.8"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestRejectedReasonNotSpecified))
+		})
+		It("should drop the request when configured", func() {
+			firewall := *pgw.config.GetFirewall()
+			firewall.Action = domain.FirewallActionDrop
+			pgw.config.SetFirewall(&firewall)
+			pgw.sgw.Timeout = 500 * time.Millisecond
+
+			_, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567897", "10.0.1.8"))
+			Expect(err).To(MatchError(sgwsim.ErrTimeout))
+		})
+	})
+
+	Context("when the S-GW serves an allowed PLMN", func() {
+		BeforeEach(func() {
+			pgw.config.SetFirewall(&domain.Firewall{
+				Action:         domain.FirewallActionReject,
+				ValidateSender: true,
+				Peers:          []*domain.PeerRule{{PLMN: "44010", Networks: []*net.IPNet{mustParseCIDR("127.0.0.0/8")}}},
+			})
+		})
+		It("should accept the session", func() {
+			_, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567897", "10.0.1.8"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should reject the sessions of other PLMNs", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567897", "10.0.1.8")
+			subscriber.MNC = "20"
+
+			_, _, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+		})
+	})
+})
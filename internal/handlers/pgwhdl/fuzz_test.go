@@ -0,0 +1,320 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+const responseTimeout = time.Second
+
+// harness serves the P-GW handlers on top of an in-memory datapath to a raw S-GW socket.
+type harness struct {
+	pgw    *gtpv2.Conn
+	sgw    *net.UDPConn
+	create pgwhdl.Handler
+	remove pgwhdl.Handler
+	// listening is closed by the first Echo Request handled, once the connection can be written
+	listening chan struct{}
+}
+
+var (
+	harnessOnce sync.Once
+	fuzzHarness *harness
+	harnessErr  error
+)
+
+func getHarness(tb testing.TB) *harness {
+	tb.Helper()
+
+	harnessOnce.Do(func() {
+		fuzzHarness, harnessErr = newHarness()
+	})
+
+	if harnessErr != nil {
+		tb.Fatalf("failed to start the P-GW: %v", harnessErr)
+	}
+
+	return fuzzHarness
+}
+
+func newHarness() (*harness, error) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	sgi := netlinkdp.NewLink("eth2", 2)
+	config, err := domain.New("127.0.0.1", "127.0.0.1", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	config.Sgi = &domain.Sgi{Link: sgi, Subnet: subnet}
+	datapath := netlinkdp.NewMemory(sgi, netlinkdp.NewLink(pgwhdl.KernelGTPLinkName, 10))
+	userPlane := pgwhdl.NewUserPlane(gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}),
+		datapath, config, newShaper(), nil)
+
+	laddr, err := getFreeAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	pgw := gtpv2.NewConn(laddr, gtpv2.IFTypeS5S8PGWGTPC, 0)
+	listening := make(chan struct{})
+
+	var once sync.Once
+
+	pgw.AddHandler(message.MsgTypeEchoRequest, func(connection *gtpv2.Conn, sender net.Addr,
+		msg message.Message,
+	) error {
+		once.Do(func() { close(listening) })
+
+		return connection.EchoResponse(sender, msg)
+	})
+
+	go func() {
+		_ = pgw.ListenAndServe(context.Background())
+	}()
+
+	sgw, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &harness{
+		pgw:       pgw,
+		sgw:       sgw,
+		create:    pgwhdl.NewCreate(config, nil, nil, nil, userPlane, nil),
+		remove:    pgwhdl.NewDelete(userPlane, nil),
+		listening: listening,
+	}
+
+	return h, h.waitListening(laddr)
+}
+
+// getFreeAddress retrieves a loopback address, the fuzzing workers can't share a fixed port.
+func getFreeAddress() (*net.UDPAddr, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	laddr, _ := conn.LocalAddr().(*net.UDPAddr)
+
+	return laddr, nil
+}
+
+// waitListening sends Echo Requests until the P-GW answers them.
+func (h *harness) waitListening(pgw *net.UDPAddr) error {
+	echo, err := message.NewEchoRequest(0, ie.NewRecovery(0)).Marshal()
+	if err != nil {
+		return err
+	}
+
+	for retries := 0; ; retries++ {
+		if _, err := h.sgw.WriteTo(echo, pgw); err != nil {
+			return err
+		}
+
+		_, err = h.read(100 * time.Millisecond)
+		if err == nil {
+			<-h.listening
+
+			return nil
+		}
+
+		if retries > 50 {
+			return err
+		}
+	}
+}
+
+func (h *harness) read(timeout time.Duration) (message.Message, error) {
+	buf := make([]byte, 1500)
+
+	if err := h.sgw.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	n, _, err := h.sgw.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.Parse(buf[:n])
+}
+
+// handle passes the request to the handler and retrieves the response sent to the S-GW.
+func (h *harness) handle(t *testing.T, handler pgwhdl.Handler, request message.Message) message.Message {
+	t.Helper()
+
+	// Malformed requests are expected to fail, but they still have to be answered
+	_ = handler.Handle(h.pgw, h.sgw.LocalAddr(), request)
+
+	response, err := h.read(responseTimeout)
+	if err != nil {
+		t.Fatalf("failed to get a response for %v: %v", request, err)
+	}
+
+	if response.Sequence() != request.Sequence() {
+		t.Fatalf("expected %d sequence number, got %d", request.Sequence(), response.Sequence())
+	}
+
+	return response
+}
+
+func getCause(t *testing.T, causeIE *ie.IE) uint8 {
+	t.Helper()
+
+	if causeIE == nil {
+		t.Fatal("missing cause")
+	}
+
+	cause, err := causeIE.Cause()
+	if err != nil {
+		t.Fatalf("malformed cause: %v", err)
+	}
+
+	return cause
+}
+
+func newCreateSessionRequest(seq uint32, imsi, ip string, ies ...*ie.IE) *message.CreateSessionRequest {
+	return message.NewCreateSessionRequest(0, seq, append([]*ie.IE{
+		ie.NewIMSI(imsi),
+		ie.NewMSISDN("814000000000"),
+		ie.NewMobileEquipmentIdentity("123450000000000"),
+		ie.NewServingNetwork("440", "10"),
+		ie.NewRATType(gtpv2.RATTypeEUTRAN),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPC, 0x100, "127.0.0.1", "").WithInstance(0),
+		ie.NewAccessPointName("internet"),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4),
+		ie.NewPDNAddressAllocation(ip),
+	}, ies...)...)
+}
+
+func newBearerContext(ies ...*ie.IE) *ie.IE {
+	return ie.NewBearerContext(append([]*ie.IE{
+		ie.NewEPSBearerID(5),
+		ie.NewBearerQoS(1, 2, 1, 0xff, 0, 0, 0, 0),
+	}, ies...)...)
+}
+
+func sgwUserFTEID() *ie.IE {
+	return ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPU, 0x200, "127.0.0.1", "").WithInstance(2)
+}
+
+func addSeeds(f *testing.F, requests ...message.Message) {
+	f.Helper()
+
+	for _, request := range requests {
+		data, err := message.Marshal(request)
+		if err != nil {
+			f.Fatalf("failed to marshal %v: %v", request, err)
+		}
+
+		f.Add(data)
+	}
+}
+
+func FuzzCreateSession(f *testing.F) {
+	tft, err := (&domain.TrafficFlowTemplate{}).Marshal()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	addSeeds(f,
+		newCreateSessionRequest(1, "123451234567891", "10.0.1.2", newBearerContext(sgwUserFTEID())),
+		newCreateSessionRequest(2, "123451234567892", "10.0.1.3"),
+		newCreateSessionRequest(3, "123451234567893", "10.0.1.4", newBearerContext()),
+		newCreateSessionRequest(4, "123451234567894", "10.0.1.5", ie.NewBearerContext(sgwUserFTEID())),
+		newCreateSessionRequest(5, "123451234567895", "10.0.1.6",
+			newBearerContext(ie.New(ie.FullyQualifiedTEID, 2, []byte{0x04})),
+		),
+		newCreateSessionRequest(6, "123451234567896", "10.0.1.7",
+			newBearerContext(sgwUserFTEID(), ie.New(ie.BearerTFT, 0, tft)),
+		),
+		newCreateSessionRequest(7, "123451234567897", "2001:db8::1", newBearerContext(sgwUserFTEID())),
+		message.NewCreateSessionRequest(0, 8, ie.NewIMSI("123451234567898")),
+	)
+
+	h := getHarness(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		request, err := message.ParseCreateSessionRequest(data)
+		if err != nil {
+			return
+		}
+
+		response, ok := h.handle(t, h.create, request).(*message.CreateSessionResponse)
+		if !ok {
+			t.Fatal("expected a Create Session Response")
+		}
+
+		if getCause(t, response.Cause) != gtpv2.CauseRequestAccepted {
+			return
+		}
+
+		if response.PGWS5S8FTEIDC == nil || response.PAA == nil || response.BearerContextsCreated == nil {
+			t.Fatalf("incomplete accepted response %v", response)
+		}
+	})
+}
+
+func FuzzDeleteSession(f *testing.F) {
+	addSeeds(f,
+		message.NewDeleteSessionRequest(0, 1, ie.NewEPSBearerID(5)),
+		message.NewDeleteSessionRequest(0, 2),
+		message.NewDeleteSessionRequest(0x1234, 3, ie.NewEPSBearerID(5)),
+	)
+
+	h := getHarness(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		request, err := message.ParseDeleteSessionRequest(data)
+		if err != nil {
+			return
+		}
+
+		// Requests without TEID are addressed to an existing session
+		known := request.TEID() == 0
+		if known {
+			created, ok := h.handle(t, h.create, newCreateSessionRequest(request.Sequence()^1,
+				"123451234567899", "10.0.2.1", newBearerContext(sgwUserFTEID()))).(*message.CreateSessionResponse)
+			if !ok || getCause(t, created.Cause) != gtpv2.CauseRequestAccepted {
+				t.Fatal("failed to create the session to be deleted")
+			}
+
+			request.SetTEID(created.PGWS5S8FTEIDC.MustTEID())
+		}
+
+		response, ok := h.handle(t, h.remove, request).(*message.DeleteSessionResponse)
+		if !ok {
+			t.Fatal("expected a Delete Session Response")
+		}
+
+		if cause := getCause(t, response.Cause); known && cause != gtpv2.CauseRequestAccepted {
+			t.Fatalf("expected the known session to be deleted, got %d cause", cause)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/repositories/pgwrepo"
+	"github.com/gw-tester/pgw/internal/routers/pgwrouter"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// recorder keeps the session events published by the P-GW handlers.
+type recorder struct {
+	mutex  sync.Mutex
+	events []*domain.SessionEvent
+}
+
+func (r *recorder) Publish(event *domain.SessionEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, event)
+
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+// Types returns the types of the events published for a subscriber.
+func (r *recorder) Types(imsi string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	types := []string{}
+
+	for _, event := range r.events {
+		if event.IMSI == imsi {
+			types = append(types, event.Type)
+		}
+	}
+
+	return types
+}
+
+// Find returns the first event of the given type published for a subscriber.
+func (r *recorder) Find(imsi, eventType string) *domain.SessionEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, event := range r.events {
+		if event.IMSI == imsi && event.Type == eventType {
+			return event
+		}
+	}
+
+	return nil
+}
+
+// gateway serves the S5/S8-C interface through the handlers chain of the P-GW, on top of an
+// in-memory datapath, to a simulated S-GW.
+type gateway struct {
+	conn      *gtpv2.Conn
+	config    *domain.Pgw
+	policies  ports.PolicyRepository
+	addresses ports.AddressRepository
+	datapath  *netlinkdp.Memory
+	shaper    *shaper
+	events    *recorder
+	control   *pgwrouter.ControlFunction
+	sgw       *sgwsim.SGW
+	cancel    context.CancelFunc
+}
+
+// startGateway serves the P-GW on a free port until the gateway is stopped, the authenticator is
+// optional.
+func startGateway(authenticator ports.Authenticator) *gateway {
+	_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+	sgi := netlinkdp.NewLink("eth2", 2)
+	config, err := domain.New("127.0.0.1", "127.0.0.1", "", "")
+	Expect(err).NotTo(HaveOccurred())
+
+	config.Sgi = &domain.Sgi{Link: sgi, Subnet: subnet}
+	config.AddApn(&domain.Apn{Name: "ims", DNS: []net.IP{net.ParseIP("10.0.0.53")}})
+
+	laddr, err := getFreeAddress()
+	Expect(err).NotTo(HaveOccurred())
+
+	store := pgwrepo.NewMemKVS()
+	g := &gateway{
+		conn:      gtpv2.NewConn(laddr, gtpv2.IFTypeS5S8PGWGTPC, 0),
+		config:    config,
+		policies:  pgwrepo.NewPolicies(store),
+		addresses: pgwrepo.NewAddresses(store),
+		datapath:  netlinkdp.NewMemory(sgi, netlinkdp.NewLink(pgwhdl.KernelGTPLinkName, 10)),
+		shaper:    newShaper(),
+		events:    &recorder{},
+	}
+	userPlane := pgwhdl.NewUserPlane(gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}),
+		g.datapath, config, g.shaper, nil)
+	g.control = pgwrouter.NewControlFunction(config, g.conn, userPlane, nil, &pgwrouter.Services{
+		Authenticator: authenticator,
+		Policies:      g.policies,
+		Addresses:     g.addresses,
+		Events:        g.events,
+		Metrics:       counterhdl.NewMetrics(prometheus.NewRegistry()),
+	})
+
+	var ctx context.Context
+
+	ctx, g.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		_ = g.conn.ListenAndServe(ctx)
+	}()
+
+	waitListening(laddr)
+
+	g.sgw, err = sgwsim.New(ctx, "127.0.0.1:0", laddr.String())
+	Expect(err).NotTo(HaveOccurred())
+
+	return g
+}
+
+// waitListening sends Echo Requests until the P-GW answers them, the S-GW simulator waits several
+// seconds for the answer of its first one.
+func waitListening(pgw *net.UDPAddr) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	Expect(err).NotTo(HaveOccurred())
+
+	defer probe.Close()
+
+	echo, err := message.NewEchoRequest(0, ie.NewRecovery(0)).Marshal()
+	Expect(err).NotTo(HaveOccurred())
+
+	buf := make([]byte, 1500)
+
+	Eventually(func() error {
+		if _, err := probe.WriteTo(echo, pgw); err != nil {
+			return err
+		}
+
+		if err := probe.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			return err
+		}
+
+		_, _, err := probe.ReadFrom(buf)
+
+		return err
+	}, 10*time.Second).Should(Succeed())
+}
+
+// stop closes the S-GW and the P-GW connections.
+func (g *gateway) stop() {
+	Expect(g.sgw.Close()).To(Succeed())
+	Expect(g.control.Close()).To(Succeed())
+	g.cancel()
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+type modify struct {
+	userPlane UserPlaneFunction
+	hooks     *Hooks
+}
+
+// NewModify creates a PGW handler for modifying the bearers of IMSI Sessions, the hooks are
+// optional.
+func NewModify(userPlane UserPlaneFunction, hooks *Hooks) Handler {
+	return &modify{
+		userPlane: userPlane,
+		hooks:     hooks,
+	}
+}
+
+// Close releases the resources used by the handler.
+func (h *modify) Close() error {
+	return nil
+}
+
+// Handle updates the S-GW endpoints of the bearers of a session, which change when the S-GW
+// relocates its user plane.
+func (h *modify) Handle(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	request, ok := msg.(*message.ModifyBearerRequest)
+	if !ok {
+		return errors.Wrap(ErrInvalidRequestType, "failed to get the modify bearer request")
+	}
+
+	span := tracehdl.Start(msg, "datastore")
+	session, err := connection.GetSessionByTEID(msg.TEID(), sender)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		response := message.NewModifyBearerResponse(
+			0, 0,
+			ie.NewCause(gtpv2.CauseContextNotFound, 0, 0, 0, nil),
+		)
+		if err := h.hooks.respondTo(connection, sender, msg, response); err != nil {
+			return errors.Wrap(err, "failed to send an error caused by getting session method")
+		}
+
+		return errors.Wrap(err, "failed to get a session from TEID")
+	}
+
+	tracehdl.SetAttributes(msg, tracehdl.IMSIKey.String(session.IMSI), tracehdl.APNKey.String(getAPN(session)))
+	loggerhdl.AddFields(msg, log.Fields{"IMSI": session.IMSI, "APN": getAPN(session)})
+
+	if request.SenderFTEIDC != nil {
+		teid, err := request.SenderFTEIDC.TEID()
+		if err != nil {
+			return errors.Wrap(err, "failed to get TEID from the modify bearer request")
+		}
+
+		session.AddTEID(gtpv2.IFTypeS5S8SGWGTPC, teid)
+	}
+
+	teid, err := session.GetTEID(gtpv2.IFTypeS5S8SGWGTPC)
+	if err != nil {
+		return errors.Wrap(err, "failed to get TEID from the current session")
+	}
+
+	cause := gtpv2.CauseRequestAccepted
+	ies := []*ie.IE{}
+	bearer := session.GetDefaultBearer()
+
+	if request.BearerContextsToBeModified != nil {
+		var context *ie.IE
+
+		context, cause = h.modifyBearer(msg, session, request.BearerContextsToBeModified.ChildIEs)
+		ies = append(ies, context)
+
+		if modified, err := session.LookupBearerByEBI(getEBI(context.ChildIEs)); err == nil {
+			bearer = modified
+		}
+	}
+
+	response := message.NewModifyBearerResponse(teid, 0,
+		append([]*ie.IE{ie.NewCause(cause, 0, 0, 0, nil)}, ies...)...)
+
+	if err := h.hooks.respondTo(connection, sender, request, response); err != nil {
+		return errors.Wrap(err, "failed to send a modify bearer response message")
+	}
+
+	loggerhdl.FromMessage(msg).WithFields(log.Fields{
+		"IMSI":  session.IMSI,
+		"cause": cause,
+	}).Info("Session modified")
+	h.hooks.publishEvent(loggerhdl.FromMessage(msg), newSessionEvent(domain.SessionModified, session, bearer, cause))
+
+	return nil
+}
+
+// modifyBearer points the downlink traffic of a bearer to the S-GW F-TEID of its context and
+// returns the Bearer Context of the response with its cause.
+func (h *modify) modifyBearer(msg message.Message, session *gtpv2.Session, childIEs []*ie.IE) (*ie.IE, uint8) {
+	var (
+		ebi   uint8
+		fteid *ie.IE
+	)
+
+	for _, childIE := range childIEs {
+		switch childIE.Type {
+		case ie.EPSBearerID:
+			ebi, _ = childIE.EPSBearerID()
+		case ie.FullyQualifiedTEID:
+			if it, err := childIE.InterfaceType(); err == nil && it == gtpv2.IFTypeS5S8SGWGTPU {
+				fteid = childIE
+			}
+		}
+	}
+
+	cause := gtpv2.CauseRequestAccepted
+
+	bearer, err := session.LookupBearerByEBI(ebi)
+	if err != nil {
+		cause = gtpv2.CauseContextNotFound
+	} else if fteid != nil {
+		span := tracehdl.Start(msg, "user-plane", tracehdl.APNKey.String(bearer.APN))
+		err := h.updateBearer(session, bearer, fteid)
+		tracehdl.End(span, err)
+
+		if err != nil {
+			loggerhdl.FromMessage(msg).WithError(err).Warnf("Failed to modify %d bearer of %s", ebi, session.IMSI)
+
+			cause = gtpv2.CauseSystemFailure
+		}
+	}
+
+	return ie.NewBearerContext(ie.NewCause(cause, 0, 0, 0, nil), ie.NewEPSBearerID(ebi)), cause
+}
+
+func (h *modify) updateBearer(session *gtpv2.Session, bearer *gtpv2.Bearer, fteid *ie.IE) error {
+	ip, err := fteid.IPAddress()
+	if err != nil {
+		return errors.Wrap(err, "failed to get S-GW user plane address")
+	}
+
+	peer, err := net.ResolveUDPAddr("udp", ip+gtpv2.GTPUPort)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve S-GW user plane address")
+	}
+
+	bearer.SetOutgoingTEID(fteid.MustTEID())
+	bearer.SetRemoteAddress(peer)
+
+	if bearer == session.GetDefaultBearer() {
+		session.AddTEID(gtpv2.IFTypeS5S8SGWGTPU, bearer.OutgoingTEID())
+	}
+
+	return h.userPlane.Update(net.ParseIP(bearer.SubscriberIP), &domain.BearerBinding{
+		EBI:          bearer.EBI,
+		IncomingTEID: bearer.IncomingTEID(),
+		OutgoingTEID: bearer.OutgoingTEID(),
+		Peer:         peer,
+	})
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Modify", func() {
+	var pgw *gateway
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	Describe("relocating the S-GW user plane endpoint", func() {
+		It("should modify the bearer", func() {
+			session, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(pgw.datapath.Tunnels).Should(HaveKey(session.RemoteTEIDU))
+
+			response, err := pgw.sgw.ModifyBearer(session, "127.0.0.2", 1234)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.BearerContextsModified.ChildIEs[0])).To(Equal(gtpv2.CauseRequestAccepted))
+			Expect(pgw.datapath.Tunnels()[session.RemoteTEIDU].OutgoingTEID).To(Equal(uint32(1234)))
+			Expect(pgw.datapath.Tunnels()[session.RemoteTEIDU].Peer.String()).To(Equal("127.0.0.2"))
+		})
+	})
+
+	Describe("modifying an unknown session", func() {
+		It("should be rejected", func() {
+			session := &sgwsim.Session{Subscriber: sgwsim.NewSubscriber("123451234567892", "10.0.1.3")}
+			_, err := pgw.sgw.ModifyBearer(session, "127.0.0.2", 1234)
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+		})
+	})
+})
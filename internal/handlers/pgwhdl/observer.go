@@ -0,0 +1,87 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// SentObserver is notified of the messages sent by the PGW handlers.
+type SentObserver interface {
+	MessageSent(peer net.Addr, msg message.Message)
+}
+
+// Hooks are shared by the handlers which answer through the same connection and serve the same
+// sessions. All of them are optional and have to be set before serving.
+type Hooks struct {
+	// Observers are notified of the messages sent by the handlers.
+	Observers []SentObserver
+	// Publisher receives the session lifecycle events.
+	Publisher ports.EventPublisher
+	// Reporter provides the load included in the responses.
+	Reporter LoadReporter
+}
+
+func (k *Hooks) notifySent(peer net.Addr, msg message.Message) {
+	if k == nil {
+		return
+	}
+
+	for _, observer := range k.Observers {
+		observer.MessageSent(peer, msg)
+	}
+}
+
+// respondTo answers a request and notifies the observer.
+func (k *Hooks) respondTo(connection *gtpv2.Conn, sender net.Addr, request, response message.Message) error {
+	span := tracehdl.Start(request, "response", tracehdl.TEIDKey.Int64(int64(response.TEID())))
+	if cause := responseCause(response); cause != nil {
+		if value, err := cause.Cause(); err == nil {
+			span.SetAttributes(tracehdl.CauseKey.Int(int(value)))
+		}
+	}
+
+	k.addLoadInformation(connection, response)
+
+	err := connection.RespondTo(sender, request, response)
+	tracehdl.End(span, err)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to write the response")
+	}
+
+	k.notifySent(sender, response)
+
+	return nil
+}
+
+func responseCause(response message.Message) *ie.IE {
+	switch response := response.(type) {
+	case *message.CreateSessionResponse:
+		return response.Cause
+	case *message.DeleteSessionResponse:
+		return response.Cause
+	case *message.ModifyBearerResponse:
+		return response.Cause
+	}
+
+	return nil
+}
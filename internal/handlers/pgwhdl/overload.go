@@ -0,0 +1,322 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Reasons of the Create Session Requests rejected by the admission limits.
+const (
+	// RejectedMaxSessions indicates that the P-GW serves the maximum number of sessions.
+	RejectedMaxSessions = "max_sessions"
+	// RejectedMaxApnSessions indicates that the APN serves the maximum number of sessions.
+	RejectedMaxApnSessions = "max_apn_sessions"
+	// RejectedMaxCreateRate indicates that the Create Session Requests exceed the accepted rate.
+	RejectedMaxCreateRate = "max_create_rate"
+	// RejectedMaxPending indicates that the P-GW handles the maximum number of requests.
+	RejectedMaxPending = "max_pending_requests"
+)
+
+// AdmissionObserver is notified of the Create Session Requests rejected by the admission limits.
+type AdmissionObserver interface {
+	SessionRejected(peer net.Addr, msg message.Message, reason string)
+}
+
+// LoadReporter provides the Load and Overload Control Information sent to the S-GWs, they are nil
+// when they aren't reported.
+type LoadReporter interface {
+	LoadInformation(connection *gtpv2.Conn) (load, overload *ie.IE)
+}
+
+// reservation is the slot of the sessions being created for a subscriber.
+type reservation struct {
+	apn      string
+	requests int
+}
+
+// Overload applies the admission limits to the new sessions and reports the load of the P-GW,
+// from its sessions and the requests being handled, to the S-GWs.
+type Overload struct {
+	config   *domain.Pgw
+	observer AdmissionObserver
+	hooks    *Hooks
+	pending  int32
+
+	mutex      sync.Mutex
+	reserved   map[string]*reservation
+	tokens     float64
+	refilled   time.Time
+	load       int
+	reduction  int
+	sequence   uint32
+	overloaded time.Time
+}
+
+// NewOverload creates a PGW handler which applies the admission limits of the configuration, the
+// observer and the hooks are optional.
+func NewOverload(config *domain.Pgw, observer AdmissionObserver, hooks *Hooks) *Overload {
+	return &Overload{
+		config:   config,
+		observer: observer,
+		hooks:    hooks,
+		reserved: map[string]*reservation{},
+	}
+}
+
+// Wrap accounts the requests being handled and rejects the Create Session Requests which exceed
+// the admission limits. The slot of an admitted session is reserved until its request is handled,
+// so the concurrent requests can't exceed the limits before the session is added.
+func (o *Overload) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		pending := int(atomic.AddInt32(&o.pending, 1))
+		defer atomic.AddInt32(&o.pending, -1)
+
+		request, ok := msg.(*message.CreateSessionRequest)
+		if !ok {
+			return apiHandler(connection, sender, msg)
+		}
+
+		imsi, reason := o.admit(connection, request, pending)
+		if reason == "" {
+			defer o.release(imsi)
+
+			return apiHandler(connection, sender, msg)
+		}
+
+		if o.observer != nil {
+			o.observer.SessionRejected(sender, msg, reason)
+		}
+
+		loggerhdl.FromMessage(msg).WithField("reason", reason).Warn("Create Session Request rejected by the admission limits")
+
+		return o.hooks.reject(connection, sender, request, gtpv2.CauseNoResourcesAvailable, nil)
+	}
+}
+
+// LoadInformation returns the Load Control Information when a session or pending requests limit
+// is configured, and the Overload Control Information while the load exceeds the threshold and
+// during its validity period afterwards.
+func (o *Overload) LoadInformation(connection *gtpv2.Conn) (load, overload *ie.IE) {
+	limits := o.config.GetOverload()
+	if !limits.Reports() {
+		return nil, nil
+	}
+
+	current := limits.Load(connection.SessionCount(), int(atomic.LoadInt32(&o.pending)))
+	reduction := limits.Reduction(current)
+	now := time.Now()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	// The S-GWs only apply the information carrying a higher sequence number
+	if o.sequence == 0 || current != o.load || reduction != o.reduction {
+		o.sequence++
+		o.load, o.reduction = current, reduction
+	}
+
+	if reduction > 0 {
+		o.overloaded = now.Add(limits.Validity)
+	}
+
+	load = newGroupedIE(ie.LoadControlInformation, newSequenceNumber(o.sequence), newMetric(current))
+	if now.After(o.overloaded) {
+		return load, nil
+	}
+
+	return load, newGroupedIE(ie.OverloadControlInformation, newSequenceNumber(o.sequence), newMetric(reduction),
+		ie.NewEPCTimer(limits.Validity))
+}
+
+// admit returns the limit exceeded by the request, it's empty when the session can be created and
+// a slot is reserved for the returned IMSI until it's released.
+func (o *Overload) admit(connection *gtpv2.Conn, request *message.CreateSessionRequest,
+	pending int) (imsi, reason string) {
+	limits := o.config.GetOverload()
+
+	var apn string
+	if request.IMSI != nil {
+		imsi, _ = request.IMSI.IMSI()
+	}
+
+	if request.APN != nil {
+		apn, _ = request.APN.AccessPointName()
+	}
+
+	if limits.MaxPending > 0 && pending > limits.MaxPending {
+		return imsi, RejectedMaxPending
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if limits.MaxSessions > 0 || limits.MaxApnSessions > 0 {
+		sessions, apnSessions := o.countSessions(connection, imsi, apn)
+
+		if limits.MaxSessions > 0 && sessions >= limits.MaxSessions {
+			return imsi, RejectedMaxSessions
+		}
+
+		if limits.MaxApnSessions > 0 && apnSessions >= limits.MaxApnSessions {
+			return imsi, RejectedMaxApnSessions
+		}
+	}
+
+	if limits.MaxCreateRate > 0 && !o.takeToken(limits.MaxCreateRate, time.Now()) {
+		return imsi, RejectedMaxCreateRate
+	}
+
+	slot, ok := o.reserved[imsi]
+	if !ok {
+		slot = &reservation{}
+		o.reserved[imsi] = slot
+	}
+
+	slot.apn = apn
+	slot.requests++
+
+	return imsi, ""
+}
+
+// release frees the slot reserved for the subscriber once its requests are handled, the session
+// is counted by the connection when it's created.
+func (o *Overload) release(imsi string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if slot, ok := o.reserved[imsi]; ok {
+		if slot.requests--; slot.requests == 0 {
+			delete(o.reserved, imsi)
+		}
+	}
+}
+
+// takeToken consumes a token of the bucket which is refilled at the accepted rate, up to the
+// requests of a second. The mutex has to be held.
+func (o *Overload) takeToken(limit int, now time.Time) bool {
+	rate := float64(limit)
+	if o.refilled.IsZero() {
+		o.tokens = rate
+	} else {
+		o.tokens = math.Min(rate, o.tokens+now.Sub(o.refilled).Seconds()*rate)
+	}
+
+	o.refilled = now
+
+	if o.tokens < 1 {
+		return false
+	}
+
+	o.tokens--
+
+	return true
+}
+
+// countSessions returns the sessions served and reserved by the P-GW and by the APN, the mutex
+// has to be held. The session of the IMSI is excluded given that it's replaced by the new one, and
+// the sessions of the subscribers with a reserved slot are only counted once.
+func (o *Overload) countSessions(connection *gtpv2.Conn, imsi, apn string) (sessions, apnSessions int) {
+	for _, session := range connection.Sessions() {
+		if _, ok := o.reserved[session.IMSI]; ok || (imsi != "" && session.IMSI == imsi) {
+			continue
+		}
+
+		sessions++
+
+		if getAPN(session) == apn {
+			apnSessions++
+		}
+	}
+
+	for subscriber, slot := range o.reserved {
+		if subscriber == imsi {
+			continue
+		}
+
+		sessions++
+
+		if slot.apn == apn {
+			apnSessions++
+		}
+	}
+
+	return sessions, apnSessions
+}
+
+// addLoadInformation includes the load of the P-GW in the responses which can carry it, the P-GW
+// node level information uses the instance 0 in all of them.
+func (k *Hooks) addLoadInformation(connection *gtpv2.Conn, response message.Message) {
+	if k == nil || k.Reporter == nil {
+		return
+	}
+
+	load, overload := k.Reporter.LoadInformation(connection)
+	if load == nil {
+		return
+	}
+
+	switch response := response.(type) {
+	case *message.CreateSessionResponse:
+		response.PGWNodeLoadControlInformation, response.PGWOverloadControlInformation = load, overload
+	case *message.DeleteSessionResponse:
+		response.PGWNodeLoadControlInformation, response.PGWOverloadControlInformation = load, overload
+	case *message.ModifyBearerResponse:
+		response.PGWNodeLoadControlInformation, response.PGWOverloadControlInformation = load, overload
+	}
+}
+
+// newGroupedIE creates a grouped IE which isn't provided by go-gtp.
+func newGroupedIE(itype uint8, children ...*ie.IE) *ie.IE {
+	grouped := ie.New(itype, 0, nil)
+	grouped.ChildIEs = children
+
+	for _, child := range children {
+		serialized, err := child.Marshal()
+		if err != nil {
+			continue
+		}
+
+		grouped.Payload = append(grouped.Payload, serialized...)
+	}
+
+	grouped.SetLength()
+
+	return grouped
+}
+
+func newSequenceNumber(sequence uint32) *ie.IE {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, sequence)
+
+	return ie.New(ie.SequenceNumber, 0, value)
+}
+
+func newMetric(value int) *ie.IE {
+	return ie.New(ie.Metric, 0, []byte{uint8(value)})
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/repositories/aaarepo"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// getMetric retrieves the load or the reduction of a Load or Overload Control Information.
+func getMetric(information *ie.IE) int {
+	Expect(information).NotTo(BeNil())
+
+	for _, child := range information.ChildIEs {
+		if child.Type == ie.Metric {
+			return int(child.Payload[0])
+		}
+	}
+
+	Fail("missing metric")
+
+	return 0
+}
+
+// gate holds the authentications until they are opened, keeping their requests pending.
+type gate struct {
+	ports.Authenticator
+
+	entered chan struct{}
+	opened  chan struct{}
+	once    sync.Once
+}
+
+func (g *gate) Authenticate(credentials *domain.Credentials) (*domain.Authorization, error) {
+	g.entered <- struct{}{}
+	<-g.opened
+
+	return g.Authenticator.Authenticate(credentials)
+}
+
+// Open lets the held and the next authentications through.
+func (g *gate) Open() {
+	g.once.Do(func() { close(g.opened) })
+}
+
+var _ = Describe("Overload", func() {
+	var (
+		pgw    *gateway
+		aaa    ports.Authenticator
+		limits *domain.Overload
+	)
+
+	BeforeEach(func() {
+		aaa = nil
+		limits = &domain.Overload{Threshold: domain.DefaultOverloadThreshold, Validity: domain.DefaultOverloadValidity}
+	})
+
+	JustBeforeEach(func() {
+		pgw = startGateway(aaa)
+		pgw.config.SetOverload(limits)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	It("should not report the load without limits", func() {
+		_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567811", "10.0.1.31"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.PGWNodeLoadControlInformation).To(BeNil())
+	})
+
+	Context("when the P-GW serves the maximum number of sessions", func() {
+		BeforeEach(func() {
+			limits.MaxSessions = 2
+		})
+		It("should reject the new sessions and report the load", func() {
+			session, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567811", "10.0.1.31"))
+			Expect(err).NotTo(HaveOccurred())
+			// the load includes the accepted session
+			Expect(getMetric(response.PGWNodeLoadControlInformation)).To(Equal(50))
+
+			_, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567812", "10.0.1.32"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, response, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567813", "10.0.1.33"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+			Expect(getMetric(response.PGWNodeLoadControlInformation)).To(Equal(100))
+			Expect(getMetric(response.PGWOverloadControlInformation)).To(Equal(100))
+			Expect(response.PGWOverloadControlInformation.ChildIEs[2].MustEPCTimer()).To(Equal(time.Minute))
+
+			By("accepting the reattach of the subscriber")
+			session, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567811", "10.0.1.31"))
+			Expect(err).NotTo(HaveOccurred())
+
+			By("reporting the overload during its validity period")
+			deleted, err := pgw.sgw.DeleteSession(session)
+			Expect(err).NotTo(HaveOccurred())
+			// go-gtp decodes the instance 0 of the Delete Session Response as an additional IE
+			Expect(deleted.AdditionalIEs).To(ContainElement(
+				WithTransform(func(information *ie.IE) uint8 { return information.Type },
+					Equal(ie.OverloadControlInformation))))
+		})
+	})
+
+	Context("when the APN serves the maximum number of sessions", func() {
+		BeforeEach(func() {
+			limits.MaxApnSessions = 1
+		})
+		It("should reject the new sessions of the APN", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567811", "10.0.1.31")
+			subscriber.APN = "lab"
+			_, _, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+
+			subscriber = sgwsim.NewSubscriber("123451234567812", "10.0.1.32")
+			subscriber.APN = "lab"
+			_, response, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+
+			By("accepting the sessions of other APNs")
+			_, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567812", "10.0.1.32"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when the Create Session Requests exceed the rate", func() {
+		BeforeEach(func() {
+			limits.MaxCreateRate = 1
+		})
+		It("should reject the requests received before the next token", func() {
+			_, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567811", "10.0.1.31"))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567812", "10.0.1.32"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+	})
+
+	Context("when the Create Session Requests are concurrent", func() {
+		BeforeEach(func() {
+			limits.MaxSessions = 3
+		})
+		It("should admit them up to the maximum number of sessions", func() {
+			var (
+				wg       sync.WaitGroup
+				mutex    sync.Mutex
+				accepted int
+				causes   []uint8
+			)
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber(
+						fmt.Sprintf("1234512345678%02d", i), fmt.Sprintf("10.0.1.%d", 40+i)))
+					Expect(err).To(Or(BeNil(), MatchError(sgwsim.ErrRejected)))
+					Expect(response).NotTo(BeNil())
+
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					if err == nil {
+						accepted++
+					} else {
+						causes = append(causes, sgwsim.Cause(response.Cause))
+					}
+				}(i)
+			}
+
+			wg.Wait()
+			Expect(accepted).To(Equal(3))
+			Expect(causes).To(HaveLen(7))
+
+			for _, cause := range causes {
+				Expect(cause).To(Equal(gtpv2.CauseNoResourcesAvailable))
+			}
+		})
+		It("should release the slots of the failed requests", func() {
+			Expect(pgw.policies.Replace([]*domain.SubscriberPolicy{
+				{Name: "barred", IMSIPrefix: "12345123456789", Action: domain.PolicyDeny},
+			})).To(Succeed())
+
+			for i := 0; i < 3; i++ {
+				_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber(
+					fmt.Sprintf("12345123456789%d", i), fmt.Sprintf("10.0.1.%d", 50+i)))
+				Expect(err).To(MatchError(sgwsim.ErrRejected))
+				Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseUserAuthenticationFailed))
+			}
+
+			for i := 0; i < 3; i++ {
+				_, _, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber(
+					fmt.Sprintf("12345123456781%d", i), fmt.Sprintf("10.0.1.%d", 60+i)))
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when the P-GW handles the maximum number of requests", func() {
+		var held *gate
+
+		BeforeEach(func() {
+			limits.MaxPending = 1
+			held = &gate{
+				Authenticator: aaarepo.NewStub(aaarepo.Account{Username: "alice", Password: "secret"}),
+				entered:       make(chan struct{}, 1),
+				opened:        make(chan struct{}),
+			}
+			aaa = held
+		})
+
+		AfterEach(func() {
+			held.Open()
+		})
+		It("should reject the new sessions until a request is handled", func() {
+			pgw.config.AddApn(&domain.Apn{Name: "secure", Authenticate: true})
+
+			created := make(chan error, 1)
+
+			go func() {
+				_, _, err := pgw.sgw.CreateSession(newSecureSubscriber("123451234567871", "10.0.1.71",
+					newPAPContainer("alice", "secret")))
+				created <- err
+			}()
+
+			Eventually(held.entered).Should(Receive())
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567812", "10.0.1.32"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+			Expect(getMetric(response.PGWNodeLoadControlInformation)).To(Equal(100))
+
+			held.Open()
+			Eventually(created).Should(Receive(BeNil()))
+
+			_, _, err = pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567812", "10.0.1.32"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,64 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// getPCO answers the DNS server addresses requested through the Protocol Configuration Options
+// with the servers of the APN, it returns nil when there is nothing to answer.
+func getPCO(request *message.CreateSessionRequest, apn *domain.Apn) *ie.IE {
+	if request.PCO == nil || apn == nil || len(apn.DNS) == 0 {
+		return nil
+	}
+
+	pco, err := request.PCO.ProtocolConfigurationOptions()
+	if err != nil {
+		loggerhdl.FromMessage(request).WithError(err).Warn("Failed to get Protocol Configuration Options")
+
+		return nil
+	}
+
+	requested := map[uint16]bool{}
+	for _, container := range pco.ProtocolOrContainers {
+		requested[container.ID] = true
+	}
+
+	containers := []*ie.PCOContainer{}
+
+	for _, server := range apn.DNS {
+		if ip := server.To4(); ip != nil {
+			if requested[gtpv2.ContIDDNSServerIPv4AddressRequest] {
+				containers = append(containers, ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv4AddressRequest, ip))
+			}
+
+			continue
+		}
+
+		if requested[gtpv2.ContIDDNSServerIPv6AddressRequest] {
+			containers = append(containers, ie.NewPCOContainer(gtpv2.ContIDDNSServerIPv6AddressRequest, server.To16()))
+		}
+	}
+
+	if len(containers) == 0 {
+		return nil
+	}
+
+	return ie.NewProtocolConfigurationOptions(gtpv2.ConfigProtocolPPPWithIP, containers...)
+}
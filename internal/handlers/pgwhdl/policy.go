@@ -0,0 +1,109 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// applyPolicy authorizes the subscriber with the first matching policy of the datastore and
+// overrides the QoS of its default bearer. The subscribers without policy are allowed.
+func (h *create) applyPolicy(request *message.CreateSessionRequest, session *gtpv2.Session,
+	bearer *gtpv2.Bearer,
+) (*domain.QoSOverride, error) {
+	if h.policies == nil {
+		return nil, nil
+	}
+
+	policies, err := h.policies.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the subscriber policies")
+	}
+
+	policy := domain.MatchPolicy(policies, session.IMSI, session.MSISDN)
+	if policy == nil {
+		return nil, nil
+	}
+
+	loggerhdl.FromMessage(request).WithField("policy", policy.Name).Debug("Subscriber policy matched")
+
+	if err := policy.Authorize(bearer.APN); err != nil {
+		return nil, err
+	}
+
+	if policy.QoS != nil && (policy.QoS.QCI != 0 || policy.QoS.PL != 0) {
+		if bearer.QoSProfile == nil {
+			bearer.QoSProfile = &gtpv2.QoSProfile{}
+		}
+
+		if policy.QoS.QCI != 0 {
+			bearer.QoSProfile.QCI = policy.QoS.QCI
+		}
+
+		if policy.QoS.PL != 0 {
+			bearer.QoSProfile.PL = policy.QoS.PL
+		}
+
+		loggerhdl.FromMessage(request).WithFields(log.Fields{
+			"QCI": bearer.QoSProfile.QCI,
+			"PL":  bearer.QoSProfile.PL,
+		}).Debug("Bearer QoS overridden by the subscriber policy")
+	}
+
+	return policy.QoS, nil
+}
+
+// getSubscriberCause returns the cause used to reject the subscribers refused by their policy
+// or their static address.
+func getSubscriberCause(err error) uint8 {
+	switch {
+	case errors.Is(err, domain.ErrSubscriberDenied):
+		return gtpv2.CauseUserAuthenticationFailed
+	case errors.Is(err, domain.ErrApnNotAllowed):
+		return gtpv2.CauseAPNAccessDeniedNoSubscription
+	case errors.Is(err, domain.ErrAddressReserved), errors.Is(err, domain.ErrAddressInUse):
+		return gtpv2.CauseNoResourcesAvailable
+	}
+
+	return gtpv2.CauseRequestRejectedReasonNotSpecified
+}
+
+// getAMBR caps the APN-AMBR requested by the S-GW with the values of the policy.
+func getAMBR(requested *ie.IE, qos *domain.QoSOverride) *ie.IE {
+	if qos == nil || (qos.AMBRUplink == 0 && qos.AMBRDownlink == 0) {
+		return requested
+	}
+
+	var up, down uint64
+
+	if requested != nil {
+		if value, err := requested.AggregateMaximumBitRateUp(); err == nil {
+			up = uint64(value)
+		}
+
+		if value, err := requested.AggregateMaximumBitRateDown(); err == nil {
+			down = uint64(value)
+		}
+	}
+
+	up, down = lowest(up, uint64(qos.AMBRUplink)), lowest(down, uint64(qos.AMBRDownlink))
+
+	return ie.NewAggregateMaximumBitRate(uint32(up), uint32(down))
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+const (
+	// maxClassID is the highest identifier of the u32 filter nodes, which have 12 bits.
+	maxClassID uint16 = 0xfff
+	kilo              = 1000
+	// unlimitedRate is the ceil of the classes used only for accounting the UE traffic.
+	unlimitedRate uint64 = 10 * kilo * kilo * kilo
+)
+
+var (
+	// ErrNoClassAvailable indicates that all the traffic control class identifiers are in use.
+	ErrNoClassAvailable = errors.New("no traffic control class available")
+	// ErrInvalidAddress indicates that an IP address can't be used by the user plane.
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrNotAccounted indicates that the traffic of an UE address isn't classified.
+	ErrNotAccounted = errors.New("traffic not accounted")
+)
+
+// BitRates stores uplink and downlink rates in bits per second.
+type BitRates struct {
+	Uplink   uint64
+	Downlink uint64
+}
+
+// getBitRates calculates guaranteed and maximum bit rates from Bearer QoS and APN-AMBR values.
+func getBitRates(qos *gtpv2.QoSProfile, ambr *ie.IE) (guaranteed, maximum BitRates) {
+	if qos != nil {
+		guaranteed = BitRates{Uplink: qos.GBRUL * kilo, Downlink: qos.GBRDL * kilo}
+		maximum = BitRates{Uplink: qos.MBRUL * kilo, Downlink: qos.MBRDL * kilo}
+	}
+
+	if ambr != nil {
+		if up, err := ambr.AggregateMaximumBitRateUp(); err == nil {
+			maximum.Uplink = lowest(maximum.Uplink, uint64(up)*kilo)
+		}
+
+		if down, err := ambr.AggregateMaximumBitRateDown(); err == nil {
+			maximum.Downlink = lowest(maximum.Downlink, uint64(down)*kilo)
+		}
+	}
+
+	return guaranteed, maximum
+}
+
+// lowest returns the smallest non-zero value.
+func lowest(current, limit uint64) uint64 {
+	if current == 0 || (limit != 0 && limit < current) {
+		return limit
+	}
+
+	return current
+}
+
+func getQoSProfile(childIE *ie.IE) *gtpv2.QoSProfile {
+	qos, err := childIE.BearerQoS()
+	if err != nil {
+		log.WithError(err).Warnf("Failed to get Bearer QoS from %s childIE", childIE)
+
+		return nil
+	}
+
+	return &gtpv2.QoSProfile{
+		PCI:   qos.ARP&0x40 != 0,
+		PL:    (qos.ARP >> 2) & 0x0f,
+		PVI:   qos.ARP&0x01 != 0,
+		QCI:   qos.QCI,
+		MBRUL: qos.MaximumBitRateForUplink,
+		MBRDL: qos.MaximumBitRateForDownlink,
+		GBRUL: qos.GuaranteedBitRateForUplink,
+		GBRDL: qos.GuaranteedBitRateForDownlink,
+	}
+}
+
+// shapedUE stores the class of an UE address and the links where its traffic is classified.
+type shapedUE struct {
+	minor    uint16
+	downlink *domain.Link
+	uplink   *domain.Link
+}
+
+// TrafficShaper programs the traffic control of the links to enforce bearer bit rates per UE
+// IP address. The classes also account the traffic of every UE address.
+type TrafficShaper struct {
+	mutex   sync.Mutex
+	control ports.TrafficControl
+	links   map[int]*domain.Link
+	classes map[string]*shapedUE
+	nextID  uint16
+}
+
+// NewTrafficShaper creates a traffic shaper without any programmed class.
+func NewTrafficShaper(control ports.TrafficControl) *TrafficShaper {
+	return &TrafficShaper{
+		control: control,
+		links:   map[int]*domain.Link{},
+		classes: map[string]*shapedUE{},
+		nextID:  1,
+	}
+}
+
+// Apply limits the downlink traffic sent to the UE through the tunnel link and the uplink
+// traffic sent from the UE through the SGi link. Unlimited directions are only accounted.
+func (s *TrafficShaper) Apply(ms net.IP, tunnel, sgi *domain.Link, guaranteed, maximum BitRates) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	limited := maximum.Downlink != 0 || maximum.Uplink != 0
+
+	if ms.To4() == nil {
+		if !limited {
+			return nil
+		}
+
+		return errors.Wrapf(ErrInvalidAddress, "%s UE address", ms)
+	}
+
+	ue, ok := s.classes[ms.String()]
+	if !ok {
+		minor, err := s.allocate()
+		if err != nil {
+			if !limited {
+				log.WithError(err).Warnf("%s UE traffic won't be accounted", ms)
+
+				return nil
+			}
+
+			return err
+		}
+
+		ue = &shapedUE{minor: minor}
+		s.classes[ms.String()] = ue
+	}
+
+	ue.downlink, ue.uplink = tunnel, sgi
+	minor := ue.minor
+
+	if err := s.shape(&domain.TrafficClass{Link: tunnel, ID: minor, Address: ms},
+		guaranteed.Downlink, maximum.Downlink); err != nil {
+		return errors.Wrap(err, "failed to shape downlink traffic")
+	}
+
+	if err := s.shape(&domain.TrafficClass{Link: sgi, ID: minor, Address: ms, Source: true},
+		guaranteed.Uplink, maximum.Uplink); err != nil {
+		return errors.Wrap(err, "failed to shape uplink traffic")
+	}
+
+	log.WithFields(log.Fields{
+		"ms":       ms,
+		"class":    minor,
+		"uplink":   maximum.Uplink,
+		"downlink": maximum.Downlink,
+	}).Debug("Bearer QoS enforced")
+
+	return nil
+}
+
+// Remove deletes the classes programmed for the given UE IP address.
+func (s *TrafficShaper) Remove(ms net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ue, ok := s.classes[ms.String()]
+	if !ok {
+		return
+	}
+
+	for _, link := range s.links {
+		if err := s.control.DeleteClass(&domain.TrafficClass{Link: link, ID: ue.minor, Address: ms}); err != nil {
+			log.WithError(err).Warnf("Failed to delete %d class", ue.minor)
+		}
+	}
+
+	delete(s.classes, ms.String())
+}
+
+// Close removes the root queueing disciplines added by the shaper.
+func (s *TrafficShaper) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for index, link := range s.links {
+		if err := s.control.DeleteQdisc(link); err != nil {
+			log.WithError(err).Warnf("Failed to delete %s qdisc", link.Name)
+		}
+
+		delete(s.links, index)
+	}
+
+	s.classes = map[string]*shapedUE{}
+
+	return nil
+}
+
+// Counters retrieves the traffic accounted by the classes of the given UE IP address.
+func (s *TrafficShaper) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ue, ok := s.classes[ms.String()]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotAccounted, "%s UE address", ms)
+	}
+
+	counters := &domain.TrafficCounters{}
+
+	var err error
+
+	counters.DownlinkPackets, counters.DownlinkBytes, err = s.control.ClassStatistics(
+		&domain.TrafficClass{Link: ue.downlink, ID: ue.minor, Address: ms})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get downlink statistics")
+	}
+
+	counters.UplinkPackets, counters.UplinkBytes, err = s.control.ClassStatistics(
+		&domain.TrafficClass{Link: ue.uplink, ID: ue.minor, Address: ms, Source: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get uplink statistics")
+	}
+
+	return counters, nil
+}
+
+func (s *TrafficShaper) allocate() (uint16, error) {
+	used := make(map[uint16]bool, len(s.classes))
+	for _, ue := range s.classes {
+		used[ue.minor] = true
+	}
+
+	for i := uint16(0); i < maxClassID; i++ {
+		minor := s.nextID
+
+		s.nextID++
+		if s.nextID > maxClassID {
+			s.nextID = 1
+		}
+
+		if !used[minor] {
+			return minor, nil
+		}
+	}
+
+	return 0, ErrNoClassAvailable
+}
+
+// addQdisc adds the root queueing discipline of a link the first time it's shaped.
+func (s *TrafficShaper) addQdisc(link *domain.Link) error {
+	if _, ok := s.links[link.Index]; ok {
+		return nil
+	}
+
+	if err := s.control.ReplaceQdisc(link); err != nil {
+		return errors.Wrapf(err, "failed to add %s qdisc", link.Name)
+	}
+
+	s.links[link.Index] = link
+
+	return nil
+}
+
+func (s *TrafficShaper) shape(class *domain.TrafficClass, rate, ceil uint64) error {
+	if ceil == 0 {
+		ceil = unlimitedRate
+	}
+
+	if rate == 0 || rate > ceil {
+		rate = ceil
+	}
+
+	if err := s.addQdisc(class.Link); err != nil {
+		return err
+	}
+
+	class.Rate, class.Ceil = rate, ceil
+
+	return errors.Wrapf(s.control.ReplaceClass(class), "failed to add %d class", class.ID)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+// unlimitedRate is the ceil in bits per second of the classes which only account the traffic.
+const unlimitedRate uint64 = 10 * 1000 * 1000 * 1000
+
+var _ = Describe("TrafficShaper", func() {
+	var (
+		ms       = net.ParseIP("10.0.1.2")
+		tunnel   = netlinkdp.NewLink(pgwhdl.KernelGTPLinkName, 10)
+		sgi      = netlinkdp.NewLink("eth2", 2)
+		datapath *netlinkdp.Memory
+		shaper   *pgwhdl.TrafficShaper
+	)
+
+	BeforeEach(func() {
+		datapath = netlinkdp.NewMemory(tunnel, sgi)
+		shaper = pgwhdl.NewTrafficShaper(datapath)
+	})
+
+	Describe("limiting the bit rates of an UE", func() {
+		It("should add a class per direction", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{Downlink: 8000},
+				pgwhdl.BitRates{Uplink: 16000, Downlink: 32000})).To(Succeed())
+
+			Expect(datapath.Qdiscs()).To(ConsistOf(tunnel, sgi))
+			Expect(datapath.Class(tunnel, 1)).To(Equal(&domain.TrafficClass{
+				Link: tunnel, ID: 1, Address: ms, Rate: 8000, Ceil: 32000,
+			}))
+			Expect(datapath.Class(sgi, 1)).To(Equal(&domain.TrafficClass{
+				Link: sgi, ID: 1, Address: ms, Source: true, Rate: 16000, Ceil: 16000,
+			}))
+		})
+		It("should cap the guaranteed rate with the maximum one", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{Downlink: 64000},
+				pgwhdl.BitRates{Downlink: 32000})).To(Succeed())
+
+			Expect(datapath.Class(tunnel, 1).Rate).To(Equal(uint64(32000)))
+		})
+		It("should only account the traffic of the unlimited directions", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+
+			Expect(datapath.Class(tunnel, 1).Ceil).To(Equal(unlimitedRate))
+			Expect(datapath.Class(sgi, 1).Ceil).To(Equal(unlimitedRate))
+		})
+		It("should reuse the class of the UE", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{Downlink: 8000})).To(Succeed())
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{Downlink: 16000})).To(Succeed())
+			Expect(shaper.Apply(net.ParseIP("10.0.1.3"), tunnel, sgi, pgwhdl.BitRates{},
+				pgwhdl.BitRates{})).To(Succeed())
+
+			Expect(datapath.Class(tunnel, 1).Ceil).To(Equal(uint64(16000)))
+			Expect(datapath.Class(tunnel, 2).Address).To(Equal(net.ParseIP("10.0.1.3")))
+		})
+		It("should reject the limits of IPv6 addresses", func() {
+			ipv6 := net.ParseIP("2001:db8::2")
+
+			Expect(shaper.Apply(ipv6, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+			Expect(shaper.Apply(ipv6, tunnel, sgi, pgwhdl.BitRates{},
+				pgwhdl.BitRates{Downlink: 8000})).To(MatchError(pgwhdl.ErrInvalidAddress))
+			Expect(datapath.Qdiscs()).To(BeEmpty())
+		})
+	})
+
+	Describe("removing the limits of an UE", func() {
+		It("should delete its classes", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{Downlink: 8000})).To(Succeed())
+
+			shaper.Remove(ms)
+
+			Expect(datapath.Class(tunnel, 1)).To(BeNil())
+			Expect(datapath.Class(sgi, 1)).To(BeNil())
+			_, err := shaper.Counters(ms)
+			Expect(err).To(MatchError(pgwhdl.ErrNotAccounted))
+		})
+	})
+
+	Describe("accounting the traffic of an UE", func() {
+		It("should read the statistics of its classes", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+
+			datapath.Account(tunnel, 1, 3, 300)
+			datapath.Account(sgi, 1, 2, 100)
+
+			Expect(shaper.Counters(ms)).To(Equal(&domain.TrafficCounters{
+				UplinkPackets: 2, UplinkBytes: 100, DownlinkPackets: 3, DownlinkBytes: 300,
+			}))
+		})
+	})
+
+	Describe("closing", func() {
+		It("should delete the queueing disciplines", func() {
+			Expect(shaper.Apply(ms, tunnel, sgi, pgwhdl.BitRates{}, pgwhdl.BitRates{})).To(Succeed())
+
+			Expect(shaper.Close()).To(Succeed())
+			Expect(datapath.Qdiscs()).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Bearer QoS", func() {
+	var pgw *gateway
+
+	BeforeEach(func() {
+		pgw = startGateway(nil)
+	})
+
+	AfterEach(func() {
+		pgw.stop()
+	})
+
+	// the rates of the requests are expressed in kbps and enforced in bps.
+	table.DescribeTable("enforcing the bit rates of the default bearer",
+		func(mbr, gbr, ambr, guaranteed, maximum pgwhdl.BitRates) {
+			subscriber := sgwsim.NewSubscriber("123451234567861", "10.0.1.61")
+			subscriber.MBRUplink, subscriber.MBRDownlink = mbr.Uplink, mbr.Downlink
+			subscriber.GBRUplink, subscriber.GBRDownlink = gbr.Uplink, gbr.Downlink
+			subscriber.AMBRUplink, subscriber.AMBRDownlink = uint32(ambr.Uplink), uint32(ambr.Downlink)
+
+			_, response, err := pgw.sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestAccepted))
+
+			enforcedGuaranteed, enforcedMaximum := pgw.shaper.Rates("10.0.1.61")
+			Expect(enforcedGuaranteed).To(Equal(guaranteed))
+			Expect(enforcedMaximum).To(Equal(maximum))
+		},
+		table.Entry("without limits", pgwhdl.BitRates{}, pgwhdl.BitRates{}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{}, pgwhdl.BitRates{}),
+		table.Entry("with the MBR only", pgwhdl.BitRates{Uplink: 100, Downlink: 200}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{}, pgwhdl.BitRates{}, pgwhdl.BitRates{Uplink: 100000, Downlink: 200000}),
+		table.Entry("with the APN-AMBR only", pgwhdl.BitRates{}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{Uplink: 300, Downlink: 400}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{Uplink: 300000, Downlink: 400000}),
+		table.Entry("with a MBR lower than the APN-AMBR", pgwhdl.BitRates{Uplink: 100, Downlink: 200},
+			pgwhdl.BitRates{}, pgwhdl.BitRates{Uplink: 300, Downlink: 400}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{Uplink: 100000, Downlink: 200000}),
+		table.Entry("with an APN-AMBR lower than the MBR", pgwhdl.BitRates{Uplink: 500, Downlink: 200},
+			pgwhdl.BitRates{}, pgwhdl.BitRates{Uplink: 300, Downlink: 400}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{Uplink: 300000, Downlink: 200000}),
+		table.Entry("with a GBR", pgwhdl.BitRates{Uplink: 100, Downlink: 200},
+			pgwhdl.BitRates{Uplink: 50, Downlink: 60}, pgwhdl.BitRates{},
+			pgwhdl.BitRates{Uplink: 50000, Downlink: 60000}, pgwhdl.BitRates{Uplink: 100000, Downlink: 200000}),
+	)
+
+	Describe("failing to enforce the bit rates", func() {
+		It("should reject the session and release its user plane", func() {
+			pgw.shaper.Fail(pgwhdl.ErrNoClassAvailable)
+
+			_, response, err := pgw.sgw.CreateSession(sgwsim.NewSubscriber("123451234567862", "10.0.1.62"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseNoResourcesAvailable))
+			Expect(pgw.conn.SessionCount()).To(BeZero())
+			Expect(pgw.datapath.Tunnels()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,388 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/protocols/pfcp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+const (
+	// catchAllPrecedence is used by the downlink rule of the default bearer, which has to be
+	// evaluated after the packet filters of the dedicated bearers.
+	catchAllPrecedence uint32 = 256
+	sessionURRID       uint32 = 1
+)
+
+var (
+	// ErrNotAssociated indicates that the PFCP association with the PGW-U isn't established yet.
+	ErrNotAssociated = errors.New("no PFCP association with the PGW-U")
+	// ErrRulesRejected indicates that the PGW-U didn't accept the rules of a session.
+	ErrRulesRejected = errors.New("rules rejected by the PGW-U")
+	// ErrUnknownSession indicates that there is no PFCP session for the UE address.
+	ErrUnknownSession = errors.New("unknown PFCP session")
+)
+
+// Sxb programs a remote PGW-U through the PFCP Sxb interface.
+type Sxb struct {
+	mutex       sync.Mutex
+	connection  *pfcp.Conn
+	peer        net.Addr
+	nodeIP      net.IP
+	userPlaneIP net.IP
+	sessions    map[string]*sxbSession
+	teids       map[uint32]bool
+	lastSEID    uint64
+}
+
+type sxbSession struct {
+	localSEID  uint64
+	remoteSEID uint64
+	lastPDRID  uint16
+	lastFARID  uint32
+	teids      []uint32
+	// downlinkFARs indexes the FAR which forwards the downlink traffic of each bearer.
+	downlinkFARs map[uint8]*pfcp.FAR
+}
+
+// NewSxb creates an user plane function which sends the session rules to the given PGW-U.
+func NewSxb(conn *pfcp.Conn, nodeIP net.IP, peer net.Addr) *Sxb {
+	return &Sxb{
+		connection: conn,
+		peer:       peer,
+		nodeIP:     nodeIP,
+		sessions:   map[string]*sxbSession{},
+		teids:      map[uint32]bool{},
+	}
+}
+
+// Associate establishes the PFCP association and learns the S5/S8-U address of the PGW-U.
+func (s *Sxb) Associate() error {
+	response, err := s.connection.Request(s.peer, pfcp.NewNodeMessage(pfcp.MsgTypeAssociationSetupRequest,
+		pfcp.NewNodeID(s.nodeIP), pfcp.NewRecoveryTimeStamp(s.connection.RecoveryTimeStamp())))
+	if err != nil {
+		return errors.Wrap(err, "failed to send an association setup request")
+	}
+
+	if err := checkCause(response); err != nil {
+		return err
+	}
+
+	var userPlaneIP net.IP
+
+	if information := response.Find(pfcp.UserPlaneIPResourceInformation); information != nil {
+		userPlaneIP, err = information.UserPlaneIPResourceInformation()
+	} else if nodeID := response.Find(pfcp.NodeID); nodeID != nil {
+		userPlaneIP, err = nodeID.NodeID()
+	}
+
+	if err != nil || userPlaneIP == nil {
+		return errors.Wrap(ErrRulesRejected, "no PGW-U user plane address")
+	}
+
+	s.mutex.Lock()
+	s.userPlaneIP = userPlaneIP
+	s.mutex.Unlock()
+
+	log.WithFields(log.Fields{
+		"PGW-U": s.peer,
+		"S5-U":  userPlaneIP,
+	}).Info("PFCP association established")
+
+	return nil
+}
+
+// IsAssociated checks if the PFCP association was established.
+func (s *Sxb) IsAssociated() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.userPlaneIP != nil
+}
+
+// NewFTEID allocates the F-TEID of a GTP-U tunnel terminated in the PGW-U.
+func (s *Sxb) NewFTEID(ifType uint8) *ie.IE {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	teid := rand.Uint32() // nolint:gosec
+	for teid == 0 || s.teids[teid] {
+		teid = rand.Uint32() // nolint:gosec
+	}
+
+	s.teids[teid] = true
+
+	return ie.NewFullyQualifiedTEID(ifType, teid, s.userPlaneIP.String(), "")
+}
+
+// Establish creates a PFCP session with the rules of the default bearer.
+func (s *Sxb) Establish(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error {
+	if !s.IsAssociated() {
+		return ErrNotAssociated
+	}
+
+	if _, ok := s.getSession(ms); ok {
+		if err := s.Release(ms); err != nil {
+			log.WithError(err).Warnf("Failed to release previous %s PFCP session", ms)
+		}
+	}
+
+	s.mutex.Lock()
+	s.lastSEID++
+	session := &sxbSession{localSEID: s.lastSEID, downlinkFARs: map[uint8]*pfcp.FAR{}}
+	s.mutex.Unlock()
+
+	ies := []*pfcp.IE{
+		pfcp.NewNodeID(s.nodeIP),
+		pfcp.NewFSEID(session.localSEID, s.nodeIP),
+		(&pfcp.URR{
+			ID:                sessionURRID,
+			MeasurementMethod: pfcp.MeasurementMethodVolume,
+		}).IE(),
+	}
+	ies = append(ies, s.getRules(session, ms, bearer, guaranteed, maximum, true)...)
+
+	response, err := s.connection.Request(s.peer, pfcp.NewSessionMessage(
+		pfcp.MsgTypeSessionEstablishmentRequest, 0, ies...))
+	if err != nil {
+		return errors.Wrap(err, "failed to send a session establishment request")
+	}
+
+	if err := checkCause(response); err != nil {
+		return err
+	}
+
+	fseid := response.Find(pfcp.FSEID)
+	if fseid == nil {
+		return errors.Wrap(pfcp.ErrMissingIE, "no PGW-U F-SEID")
+	}
+
+	if session.remoteSEID, _, err = fseid.FSEID(); err != nil {
+		return errors.Wrap(err, "failed to get PGW-U F-SEID")
+	}
+
+	s.mutex.Lock()
+	s.sessions[ms.String()] = session
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// AddBearer modifies the PFCP session to forward the traffic of a dedicated bearer.
+func (s *Sxb) AddBearer(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error {
+	session, ok := s.getSession(ms)
+	if !ok {
+		return errors.Wrapf(ErrUnknownSession, "%s UE address", ms)
+	}
+
+	response, err := s.connection.Request(s.peer, pfcp.NewSessionMessage(
+		pfcp.MsgTypeSessionModificationRequest, session.remoteSEID,
+		s.getRules(session, ms, bearer, guaranteed, maximum, false)...))
+	if err != nil {
+		return errors.Wrap(err, "failed to send a session modification request")
+	}
+
+	return checkCause(response)
+}
+
+// Update modifies the downlink FAR of a bearer to forward its traffic to a new S-GW endpoint.
+func (s *Sxb) Update(ms net.IP, bearer *domain.BearerBinding) error {
+	session, ok := s.getSession(ms)
+	if !ok {
+		return errors.Wrapf(ErrUnknownSession, "%s UE address", ms)
+	}
+
+	peer, ok := bearer.Peer.(*net.UDPAddr)
+	if !ok {
+		return errors.Wrapf(ErrInvalidPeer, "%s isn't an UDP address", bearer.Peer)
+	}
+
+	s.mutex.Lock()
+	current, ok := session.downlinkFARs[bearer.EBI]
+	if !ok {
+		s.mutex.Unlock()
+
+		return errors.Wrapf(ErrUnknownBearer, "%d bearer of %s", bearer.EBI, ms)
+	}
+
+	updated := *current
+	updated.OuterHeaderTEID = bearer.OutgoingTEID
+	updated.OuterHeaderAddress = peer.IP
+	session.downlinkFARs[bearer.EBI] = &updated
+	s.mutex.Unlock()
+
+	response, err := s.connection.Request(s.peer, pfcp.NewSessionMessage(
+		pfcp.MsgTypeSessionModificationRequest, session.remoteSEID, updated.UpdateIE()))
+	if err != nil {
+		return errors.Wrap(err, "failed to send a session modification request")
+	}
+
+	return checkCause(response)
+}
+
+// Release deletes the PFCP session of the UE address.
+func (s *Sxb) Release(ms net.IP) error {
+	session, ok := s.getSession(ms)
+	if !ok {
+		return errors.Wrapf(ErrUnknownSession, "%s UE address", ms)
+	}
+
+	s.mutex.Lock()
+	delete(s.sessions, ms.String())
+
+	for _, teid := range session.teids {
+		delete(s.teids, teid)
+	}
+	s.mutex.Unlock()
+
+	response, err := s.connection.Request(s.peer, pfcp.NewSessionMessage(
+		pfcp.MsgTypeSessionDeletionRequest, session.remoteSEID))
+	if err != nil {
+		return errors.Wrap(err, "failed to send a session deletion request")
+	}
+
+	return checkCause(response)
+}
+
+// Counters isn't supported by the control function, the traffic is accounted by the PGW-U
+// which doesn't report it through the Sxb interface.
+func (s *Sxb) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	return nil, errors.Wrapf(ErrNotAccounted, "%s traffic is accounted by the PGW-U", ms)
+}
+
+// Close releases the resources used by the user plane function.
+func (s *Sxb) Close() error {
+	return nil
+}
+
+func (s *Sxb) getSession(ms net.IP) (*sxbSession, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[ms.String()]
+
+	return session, ok
+}
+
+// getRules translates a bearer into the PDRs, FARs and QER applied by the PGW-U. The QER ID
+// identifies the bearer, so it's shared by all its PDRs. The rule IDs of the session are
+// allocated while holding the mutex, so the concurrent bearers of an UE don't share them.
+func (s *Sxb) getRules(session *sxbSession, ms net.IP, bearer *domain.BearerBinding,
+	guaranteed, maximum BitRates, isDefault bool,
+) []*pfcp.IE {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session.teids = append(session.teids, bearer.IncomingTEID)
+	qerID := uint32(bearer.EBI)
+	rules := []*pfcp.IE{
+		(&pfcp.QER{
+			ID:          qerID,
+			MBRUplink:   maximum.Uplink / kilo,
+			MBRDownlink: maximum.Downlink / kilo,
+			GBRUplink:   guaranteed.Uplink / kilo,
+			GBRDownlink: guaranteed.Downlink / kilo,
+		}).IE(),
+	}
+
+	session.lastFARID++
+	uplinkFAR := &pfcp.FAR{
+		ID:                   session.lastFARID,
+		ApplyAction:          pfcp.ApplyActionForward,
+		DestinationInterface: pfcp.InterfaceCore,
+	}
+	session.lastPDRID++
+	uplinkPDR := &pfcp.PDR{
+		ID:                 session.lastPDRID,
+		Precedence:         catchAllPrecedence,
+		SourceInterface:    pfcp.InterfaceAccess,
+		TEID:               bearer.IncomingTEID,
+		TEIDAddress:        s.userPlaneIP,
+		OuterHeaderRemoval: true,
+		FARID:              uplinkFAR.ID,
+		QERIDs:             []uint32{qerID},
+		URRIDs:             []uint32{sessionURRID},
+	}
+	rules = append(rules, uplinkFAR.IE(), uplinkPDR.IE())
+
+	var peerIP net.IP
+	if peer, ok := bearer.Peer.(*net.UDPAddr); ok {
+		peerIP = peer.IP
+	}
+
+	session.lastFARID++
+	downlinkFAR := &pfcp.FAR{
+		ID:                   session.lastFARID,
+		ApplyAction:          pfcp.ApplyActionForward,
+		DestinationInterface: pfcp.InterfaceAccess,
+		OuterHeaderTEID:      bearer.OutgoingTEID,
+		OuterHeaderAddress:   peerIP,
+	}
+	rules = append(rules, downlinkFAR.IE())
+	session.downlinkFARs[bearer.EBI] = downlinkFAR
+
+	newDownlinkPDR := func(precedence uint32, filters []*pfcp.Filter) *pfcp.IE {
+		session.lastPDRID++
+
+		return (&pfcp.PDR{
+			ID:              session.lastPDRID,
+			Precedence:      precedence,
+			SourceInterface: pfcp.InterfaceCore,
+			UEAddress:       ms,
+			Filters:         filters,
+			FARID:           downlinkFAR.ID,
+			QERIDs:          []uint32{qerID},
+			URRIDs:          []uint32{sessionURRID},
+		}).IE()
+	}
+
+	if isDefault || bearer.TFT == nil {
+		return append(rules, newDownlinkPDR(catchAllPrecedence, nil))
+	}
+
+	for _, filter := range bearer.TFT.Filters {
+		if filter.Direction == domain.DirectionUplink {
+			continue
+		}
+
+		rules = append(rules, newDownlinkPDR(uint32(filter.Precedence), []*pfcp.Filter{{
+			FlowDescription: filter.FlowDescription(),
+			TypeOfService:   filter.TypeOfService,
+			ToSMask:         filter.ToSMask,
+			SPI:             filter.SPI,
+		}}))
+	}
+
+	return rules
+}
+
+func checkCause(response *pfcp.Message) error {
+	cause, err := response.Cause()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the PFCP cause")
+	}
+
+	if cause != pfcp.CauseRequestAccepted {
+		return errors.Wrapf(ErrRulesRejected, "%d cause", cause)
+	}
+
+	return nil
+}
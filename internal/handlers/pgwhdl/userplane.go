@@ -0,0 +1,373 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+)
+
+// KernelGTPLinkName is the name of the Kernel GTP link which forwards the downlink traffic.
+const KernelGTPLinkName = "gtp-pgw"
+
+// downlinkTable is the route table used for the traffic sent to the UEs from the SGi link.
+const downlinkTable = 3001
+
+var (
+	// ErrInvalidPeer indicates that the S-GW user plane address can't be used for a GTP-U tunnel.
+	ErrInvalidPeer = errors.New("invalid peer address")
+	// ErrUnknownBearer indicates that the bearer isn't forwarded by the user plane.
+	ErrUnknownBearer = errors.New("unknown bearer")
+)
+
+// UserPlaneFunction forwards the traffic of the PDN connections created by the control plane.
+type UserPlaneFunction interface {
+	// NewFTEID allocates the F-TEID of a GTP-U tunnel.
+	NewFTEID(ifType uint8) *ie.IE
+	// Establish forwards the traffic of the default bearer of a PDN connection.
+	Establish(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error
+	// AddBearer forwards the traffic which satisfies the TFT of a dedicated bearer.
+	AddBearer(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error
+	// Update points the downlink traffic of a bearer to a new S-GW endpoint.
+	Update(ms net.IP, bearer *domain.BearerBinding) error
+	// Release stops forwarding the traffic of a PDN connection.
+	Release(ms net.IP) error
+	// Counters retrieves the traffic forwarded for a PDN connection.
+	Counters(ms net.IP) (*domain.TrafficCounters, error)
+	Close() error
+}
+
+// QoSEnforcer limits the bit rates of the traffic sent to and from an UE and accounts it.
+type QoSEnforcer interface {
+	Apply(ms net.IP, tunnel, sgi *domain.Link, guaranteed, maximum BitRates) error
+	Remove(ms net.IP)
+	Counters(ms net.IP) (*domain.TrafficCounters, error)
+}
+
+// UserPlane programs the local GTP-U datapath.
+type UserPlane struct {
+	mutex      sync.Mutex
+	connection *gtpv1.UPlaneConn
+	datapath   ports.Datapath
+	config     *domain.Pgw
+	shaper     QoSEnforcer
+	binder     BearerBinder
+	bearers    map[string][]*domain.BearerBinding
+
+	// addedRoutes and addedRules are guarded by the mutex and keyed by destination and table, so
+	// the routes shared by the sessions are removed once.
+	addedRoutes map[string]*domain.Route
+	addedRules  map[string]*domain.Rule
+}
+
+// NewUserPlane creates an user plane function which uses the local datapath.
+func NewUserPlane(conn *gtpv1.UPlaneConn, datapath ports.Datapath, config *domain.Pgw, shaper QoSEnforcer,
+	binder BearerBinder,
+) *UserPlane {
+	return &UserPlane{
+		connection:  conn,
+		datapath:    datapath,
+		config:      config,
+		shaper:      shaper,
+		binder:      binder,
+		bearers:     map[string][]*domain.BearerBinding{},
+		addedRoutes: map[string]*domain.Route{},
+		addedRules:  map[string]*domain.Rule{},
+	}
+}
+
+// SupportsDedicatedBearers checks if the datapath can steer traffic to dedicated bearers.
+func (u *UserPlane) SupportsDedicatedBearers() bool {
+	return u.binder != nil
+}
+
+// NewFTEID allocates the F-TEID of a GTP-U tunnel terminated in the local datapath.
+func (u *UserPlane) NewFTEID(ifType uint8) *ie.IE {
+	return u.connection.NewFTEID(ifType, u.config.UserPlane.IP, "")
+}
+
+// Establish adds the GTP-U tunnel, routes and rules of the default bearer and enforces its QoS.
+func (u *UserPlane) Establish(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error {
+	peer, ok := bearer.Peer.(*net.UDPAddr)
+	if !ok {
+		return errors.Wrapf(ErrInvalidPeer, "%s isn't an UDP address", bearer.Peer)
+	}
+
+	downlink, err := u.downlinkLink()
+	if err != nil {
+		return err
+	}
+
+	if u.config.UserPlane.UsesKernelGTP() {
+		if err := u.datapath.AddTunnel(peer.IP, ms, bearer.OutgoingTEID, bearer.IncomingTEID); err != nil {
+			return err
+		}
+	}
+
+	u.mutex.Lock()
+	u.bearers[ms.String()] = []*domain.BearerBinding{bearer}
+	u.setupRouting(ms, downlink)
+	u.mutex.Unlock()
+
+	if u.binder != nil {
+		u.binder.Bind(ms, bearer)
+	}
+
+	// a PDN connection which can't be shaped doesn't forward traffic.
+	if err := u.shaper.Apply(ms, downlink, u.config.Sgi.Link, guaranteed, maximum); err != nil {
+		_ = u.Release(ms)
+
+		return errors.Wrap(err, "failed to enforce bearer QoS")
+	}
+
+	return nil
+}
+
+// AddBearer binds a dedicated bearer. Its traffic shares the shaping of the default bearer.
+func (u *UserPlane) AddBearer(ms net.IP, bearer *domain.BearerBinding, guaranteed, maximum BitRates) error {
+	if u.binder == nil {
+		return ErrUnsupportedDatapath
+	}
+
+	u.mutex.Lock()
+	u.bearers[ms.String()] = append(u.bearers[ms.String()], bearer)
+	u.mutex.Unlock()
+
+	u.binder.Bind(ms, bearer)
+
+	return nil
+}
+
+// Update moves the GTP-U tunnel of a bearer to a new S-GW endpoint, keeping its packet filters.
+func (u *UserPlane) Update(ms net.IP, bearer *domain.BearerBinding) error {
+	peer, ok := bearer.Peer.(*net.UDPAddr)
+	if !ok {
+		return errors.Wrapf(ErrInvalidPeer, "%s isn't an UDP address", bearer.Peer)
+	}
+
+	u.mutex.Lock()
+	bearers := u.bearers[ms.String()]
+	index := -1
+
+	for i, current := range bearers {
+		if current.EBI == bearer.EBI {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		u.mutex.Unlock()
+
+		return errors.Wrapf(ErrUnknownBearer, "%d bearer of %s", bearer.EBI, ms)
+	}
+
+	updated := *bearers[index]
+	updated.OutgoingTEID = bearer.OutgoingTEID
+	updated.Peer = bearer.Peer
+	bearers[index] = &updated
+	u.mutex.Unlock()
+
+	if index == 0 && u.config.UserPlane.UsesKernelGTP() {
+		if err := u.datapath.AddTunnel(peer.IP, ms, updated.OutgoingTEID, updated.IncomingTEID); err != nil {
+			return err
+		}
+	}
+
+	if u.binder != nil {
+		u.binder.Bind(ms, &updated)
+	}
+
+	return nil
+}
+
+// Release removes the GTP-U tunnels, the routes, the rules and the QoS enforcement of a PDN
+// connection.
+func (u *UserPlane) Release(ms net.IP) error {
+	u.mutex.Lock()
+	bearers, ok := u.bearers[ms.String()]
+	delete(u.bearers, ms.String())
+
+	if ok {
+		u.teardownRouting(ms)
+	}
+	u.mutex.Unlock()
+
+	if ok && u.config.UserPlane.UsesKernelGTP() {
+		if err := u.datapath.DeleteTunnel(bearers[0].IncomingTEID); err != nil {
+			log.WithError(err).Warnf("Failed to delete %d GTP-U tunnel", bearers[0].IncomingTEID)
+		}
+	}
+
+	u.shaper.Remove(ms)
+
+	if u.binder != nil {
+		u.binder.Release(ms)
+	}
+
+	return nil
+}
+
+// Counters retrieves the traffic accounted for a PDN connection.
+func (u *UserPlane) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	u.mutex.Lock()
+	_, ok := u.bearers[ms.String()]
+	u.mutex.Unlock()
+
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownBearer, "%s PDN connection", ms)
+	}
+
+	counters, err := u.shaper.Counters(ms)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the traffic counters")
+	}
+
+	return counters, nil
+}
+
+// Close removes rules and routes added by the user plane.
+func (u *UserPlane) Close() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	for _, route := range u.addedRoutes {
+		if err := u.datapath.DeleteRoute(route); err != nil {
+			log.WithError(err).Warn("Route Deletion error")
+		}
+	}
+
+	for _, rule := range u.addedRules {
+		if err := u.datapath.DeleteRule(rule); err != nil {
+			log.WithError(err).Warn("Rule Deletion error")
+		}
+	}
+
+	u.addedRoutes = map[string]*domain.Route{}
+	u.addedRules = map[string]*domain.Rule{}
+
+	return nil
+}
+
+// downlinkLink retrieves the link used to route the traffic sent to the UEs.
+func (u *UserPlane) downlinkLink() (*domain.Link, error) {
+	if u.binder != nil {
+		return u.binder.Link(), nil
+	}
+
+	return u.datapath.LinkByName(KernelGTPLinkName)
+}
+
+func (u *UserPlane) appendRoute(route *domain.Route) {
+	if err := u.datapath.ReplaceRoute(route); err != nil {
+		log.WithError(err).Warn("Failed to add User plane route")
+	}
+
+	log.WithFields(log.Fields{
+		"route": route.Destination,
+		"table": route.Table,
+	}).Debug("Adding User plane route")
+
+	u.addedRoutes[getRoutingKey(route.Destination, route.Table)] = route
+}
+
+func (u *UserPlane) findRule(rule *domain.Rule) bool {
+	rules, err := u.datapath.ListRules()
+	if err != nil {
+		log.WithError(err).Warn("Failed to list rules")
+
+		return false
+	}
+
+	for _, current := range rules {
+		if current.Equal(rule) {
+			log.Debugf("%s rule found", rule.Destination)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *UserPlane) appendRule(rule *domain.Rule) {
+	if err := u.datapath.AddRule(rule); err != nil {
+		log.WithError(err).Warn("Failed to add User plane rule")
+	}
+
+	log.WithFields(log.Fields{
+		"rule":  rule.Destination,
+		"iif":   rule.InputInterface,
+		"table": rule.Table,
+	}).Debug("Adding User plane rule")
+
+	u.addedRules[getRoutingKey(rule.Destination, rule.Table)] = rule
+}
+
+// getRoutingKey identifies the routes and rules added by the user plane.
+func getRoutingKey(destination *net.IPNet, table int) string {
+	return fmt.Sprintf("%s@%d", destination, table)
+}
+
+// setupRouting configures the routes and rules for User plane traffic.
+func (u *UserPlane) setupRouting(ms net.IP, downlink *domain.Link) {
+	ms32 := &net.IPNet{
+		IP:   ms,
+		Mask: net.CIDRMask(32, 32),
+	}
+	u.appendRoute(&domain.Route{Destination: ms32, LinkIndex: downlink.Index, Table: downlinkTable})
+	u.appendRoute(&domain.Route{Destination: u.config.GetSubnet(), LinkIndex: u.config.Sgi.Link.Index})
+
+	rule := &domain.Rule{
+		InputInterface: u.config.Sgi.Link.Name,
+		Destination:    ms32,
+		Table:          downlinkTable,
+	}
+	if !u.findRule(rule) {
+		u.appendRule(rule)
+	}
+}
+
+// teardownRouting removes the route and the rule added for the traffic sent to an UE. The route
+// of the SGi subnet is shared by the sessions and it's removed once the user plane is closed.
+func (u *UserPlane) teardownRouting(ms net.IP) {
+	ms32 := &net.IPNet{
+		IP:   ms,
+		Mask: net.CIDRMask(32, 32),
+	}
+	key := getRoutingKey(ms32, downlinkTable)
+
+	if route, ok := u.addedRoutes[key]; ok {
+		if err := u.datapath.DeleteRoute(route); err != nil {
+			log.WithError(err).Warn("Route Deletion error")
+		}
+
+		delete(u.addedRoutes, key)
+	}
+
+	if rule, ok := u.addedRules[key]; ok {
+		if err := u.datapath.DeleteRule(rule); err != nil {
+			log.WithError(err).Warn("Rule Deletion error")
+		}
+
+		delete(u.addedRules, key)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwhdl_test
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+// shaper records the bit rates enforced per UE address and reports the given traffic counters.
+type shaper struct {
+	mutex      sync.Mutex
+	err        error
+	guaranteed map[string]pgwhdl.BitRates
+	maximum    map[string]pgwhdl.BitRates
+	counters   map[string]*domain.TrafficCounters
+}
+
+func newShaper() *shaper {
+	return &shaper{guaranteed: map[string]pgwhdl.BitRates{}, maximum: map[string]pgwhdl.BitRates{}}
+}
+
+func (s *shaper) Apply(ms net.IP, tunnel, sgi *domain.Link, guaranteed, maximum pgwhdl.BitRates) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.guaranteed[ms.String()] = guaranteed
+	s.maximum[ms.String()] = maximum
+
+	return nil
+}
+
+// Rates returns the bit rates enforced for an UE address.
+func (s *shaper) Rates(ms string) (guaranteed, maximum pgwhdl.BitRates) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.guaranteed[ms], s.maximum[ms]
+}
+
+// Fail makes the next QoS enforcements fail with the given error.
+func (s *shaper) Fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = err
+}
+
+func (s *shaper) Remove(ms net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.guaranteed, ms.String())
+	delete(s.maximum, ms.String())
+}
+
+func (s *shaper) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counters, ok := s.counters[ms.String()]
+	if !ok {
+		return nil, pgwhdl.ErrNotAccounted
+	}
+
+	return counters, nil
+}
+
+var _ = Describe("UserPlane", func() {
+	var (
+		ms        = net.ParseIP("10.0.1.2").To4()
+		sgw       = &net.UDPAddr{IP: net.ParseIP("172.25.0.3"), Port: 2152}
+		ambr      = pgwhdl.BitRates{Uplink: 1000, Downlink: 2000}
+		config    *domain.Pgw
+		datapath  *netlinkdp.Memory
+		enforcer  *shaper
+		userPlane *pgwhdl.UserPlane
+		bearer    *domain.BearerBinding
+	)
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+		sgi := netlinkdp.NewLink("eth2", 2)
+		var err error
+		config, err = domain.New("172.25.1.2", "172.25.0.2", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		config.Sgi = &domain.Sgi{Link: sgi, Subnet: subnet}
+		datapath = netlinkdp.NewMemory(sgi, netlinkdp.NewLink(pgwhdl.KernelGTPLinkName, 10))
+		enforcer = newShaper()
+		userPlane = pgwhdl.NewUserPlane(gtpv1.NewUPlaneConn(sgw), datapath, config, enforcer, nil)
+		bearer = &domain.BearerBinding{EBI: 5, OutgoingTEID: 100, IncomingTEID: 200, Peer: sgw}
+	})
+
+	Describe("allocating F-TEIDs", func() {
+		It("should use the S5-U address", func() {
+			fteid := userPlane.NewFTEID(gtpv2.IFTypeS5S8PGWGTPU)
+			ip, err := fteid.IPAddress()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ip).To(Equal("172.25.0.2"))
+		})
+	})
+
+	Describe("establishing a PDN connection", func() {
+		JustBeforeEach(func() {
+			Expect(userPlane.Establish(ms, bearer, pgwhdl.BitRates{}, ambr)).To(Succeed())
+		})
+		It("should add the GTP-U tunnel", func() {
+			Expect(datapath.Tunnels()).To(HaveKeyWithValue(uint32(200), &netlinkdp.Tunnel{
+				Peer: sgw.IP, MS: ms, OutgoingTEID: 100, IncomingTEID: 200,
+			}))
+		})
+		It("should route the downlink traffic through the tunnel link", func() {
+			Expect(datapath.Routes()).To(ContainElement(&domain.Route{
+				Destination: &net.IPNet{IP: ms, Mask: net.CIDRMask(32, 32)},
+				LinkIndex:   10,
+				Table:       3001,
+			}))
+			rules, err := datapath.ListRules()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(1))
+			Expect(rules[0].InputInterface).To(Equal("eth2"))
+		})
+		It("should enforce the APN-AMBR", func() {
+			Expect(enforcer.maximum).To(HaveKeyWithValue(ms.String(), ambr))
+		})
+		Context("when the UE reconnects", func() {
+			It("should not duplicate the routing rule", func() {
+				Expect(userPlane.Establish(ms, bearer, pgwhdl.BitRates{}, ambr)).To(Succeed())
+				rules, err := datapath.ListRules()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).To(HaveLen(1))
+			})
+		})
+		Context("when the userspace datapath is used", func() {
+			BeforeEach(func() {
+				config.UserPlane.Datapath = domain.DatapathUserspace
+				userPlane = pgwhdl.NewUserPlane(gtpv1.NewUPlaneConn(sgw), datapath, config, enforcer, nil)
+			})
+			It("should not add Kernel GTP tunnels", func() {
+				Expect(datapath.Tunnels()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("releasing a PDN connection", func() {
+		BeforeEach(func() {
+			Expect(userPlane.Establish(ms, bearer, pgwhdl.BitRates{}, ambr)).To(Succeed())
+			Expect(userPlane.Release(ms)).To(Succeed())
+		})
+		It("should remove the GTP-U tunnel and the QoS enforcement", func() {
+			Expect(datapath.Tunnels()).To(BeEmpty())
+			Expect(enforcer.maximum).To(BeEmpty())
+		})
+		It("should remove the route and the rule of the UE", func() {
+			Expect(datapath.Routes()).To(ConsistOf(&domain.Route{
+				Destination: config.GetSubnet(),
+				LinkIndex:   2,
+			}))
+			rules, err := datapath.ListRules()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(BeEmpty())
+		})
+		Context("when the user plane is closed", func() {
+			It("should remove the routes and rules", func() {
+				Expect(userPlane.Close()).To(Succeed())
+				Expect(datapath.Routes()).To(BeEmpty())
+				rules, err := datapath.ListRules()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("handling concurrent PDN connections", func() {
+		It("should keep the tunnels and the routing of the established ones", func() {
+			var wg sync.WaitGroup
+
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					address := net.IPv4(10, 0, 1, byte(10+i)).To4()
+					binding := &domain.BearerBinding{
+						EBI: 5, OutgoingTEID: uint32(100 + i), IncomingTEID: uint32(200 + i), Peer: sgw,
+					}
+					Expect(userPlane.Establish(address, binding, pgwhdl.BitRates{}, ambr)).To(Succeed())
+
+					_, err := userPlane.Counters(address)
+					Expect(err).To(MatchError(pgwhdl.ErrNotAccounted))
+
+					if i%2 == 0 {
+						Expect(userPlane.Release(address)).To(Succeed())
+					}
+				}(i)
+			}
+
+			wg.Wait()
+			Expect(datapath.Tunnels()).To(HaveLen(10))
+			// the route of the SGi subnet is kept until the user plane is closed
+			Expect(datapath.Routes()).To(HaveLen(11))
+			rules, err := datapath.ListRules()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(10))
+
+			for i := 1; i < 20; i += 2 {
+				address := net.IPv4(10, 0, 1, byte(10+i)).To4()
+				Expect(datapath.Tunnels()).To(HaveKey(uint32(200 + i)))
+				Expect(datapath.Routes()).To(ContainElement(&domain.Route{
+					Destination: &net.IPNet{IP: address, Mask: net.CIDRMask(32, 32)},
+					LinkIndex:   10,
+					Table:       3001,
+				}))
+				_, maximum := enforcer.Rates(address.String())
+				Expect(maximum).To(Equal(ambr))
+			}
+		})
+	})
+
+	Describe("retrieving the traffic counters", func() {
+		It("should report the traffic accounted for the PDN connection", func() {
+			enforcer.counters = map[string]*domain.TrafficCounters{
+				ms.String(): {UplinkPackets: 1, UplinkBytes: 100, DownlinkPackets: 2, DownlinkBytes: 200},
+			}
+			Expect(userPlane.Establish(ms, bearer, pgwhdl.BitRates{}, ambr)).To(Succeed())
+
+			counters, err := userPlane.Counters(ms)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(counters.DownlinkBytes).To(Equal(uint64(200)))
+		})
+		It("should reject unknown PDN connections", func() {
+			_, err := userPlane.Counters(ms)
+			Expect(err).To(MatchError(pgwhdl.ErrUnknownBearer))
+		})
+	})
+
+	Describe("modifying a PDN connection", func() {
+		Context("when the S-GW endpoint of the default bearer changes", func() {
+			It("should update the GTP-U tunnel", func() {
+				Expect(userPlane.Establish(ms, bearer, pgwhdl.BitRates{}, ambr)).To(Succeed())
+				relocated := &net.UDPAddr{IP: net.ParseIP("172.25.0.4"), Port: 2152}
+				Expect(userPlane.Update(ms, &domain.BearerBinding{
+					EBI: 5, OutgoingTEID: 101, IncomingTEID: 200, Peer: relocated,
+				})).To(Succeed())
+				Expect(datapath.Tunnels()[200].OutgoingTEID).To(Equal(uint32(101)))
+				Expect(datapath.Tunnels()[200].Peer).To(Equal(relocated.IP))
+			})
+		})
+		Context("when the bearer doesn't exist", func() {
+			It("should raise an error", func() {
+				err := userPlane.Update(ms, bearer)
+				Expect(err).To(MatchError(pgwhdl.ErrUnknownBearer))
+			})
+		})
+		Context("when the datapath can't classify downlink traffic", func() {
+			It("should reject dedicated bearers", func() {
+				dedicated := &domain.BearerBinding{EBI: 6, OutgoingTEID: 101, IncomingTEID: 201, Peer: sgw}
+				err := userPlane.AddBearer(ms, dedicated, pgwhdl.BitRates{}, ambr)
+				Expect(err).To(MatchError(pgwhdl.ErrUnsupportedDatapath))
+			})
+		})
+	})
+})
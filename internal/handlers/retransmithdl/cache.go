@@ -0,0 +1,207 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retransmithdl
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Actions taken on the retransmitted requests.
+const (
+	// ActionReplayed indicates that the cached response was sent again.
+	ActionReplayed = "replayed"
+	// ActionDiscarded indicates that the original request was still being handled.
+	ActionDiscarded = "discarded"
+)
+
+// Observer is notified of the retransmitted requests.
+type Observer interface {
+	RetransmissionDetected(peer net.Addr, msg message.Message, action string)
+}
+
+// transaction stores the response of a request while it can be retransmitted.
+type transaction struct {
+	requestType uint8
+	response    []byte
+	received    time.Time
+}
+
+// entry keeps the reception order of the transactions, so the expired ones are purged first.
+type entry struct {
+	key      string
+	received time.Time
+}
+
+// Cache answers the retransmitted requests with the response sent for the original one, so the
+// handlers process every request once.
+type Cache struct {
+	mutex        sync.Mutex
+	window       time.Duration
+	transactions map[string]*transaction
+	order        []entry
+	observer     Observer
+}
+
+// New creates a cache which keeps the responses during the retransmission window, the observer is
+// optional.
+func New(window time.Duration, observer Observer) *Cache {
+	return &Cache{
+		window:       window,
+		transactions: map[string]*transaction{},
+		observer:     observer,
+	}
+}
+
+// Wrap replays the cached response of the retransmitted requests, or discards them while the
+// original request is being handled.
+func (c *Cache) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		if !isRequest(msg) {
+			return apiHandler(connection, sender, msg)
+		}
+
+		key := getKey(sender, msg.Sequence())
+
+		response, retransmitted := c.begin(key, msg.MessageType())
+		if !retransmitted {
+			err := apiHandler(connection, sender, msg)
+			c.end(key)
+
+			return err
+		}
+
+		action := ActionDiscarded
+		if response != nil {
+			action = ActionReplayed
+		}
+
+		if c.observer != nil {
+			c.observer.RetransmissionDetected(sender, msg, action)
+		}
+
+		loggerhdl.FromMessage(msg).WithField("action", action).Info("Retransmitted request detected")
+
+		if response == nil {
+			return nil
+		}
+
+		if _, err := connection.WriteTo(response, sender); err != nil {
+			return errors.Wrap(err, "failed to replay the response")
+		}
+
+		return nil
+	}
+}
+
+// MessageSent keeps the response of a request which is being handled.
+func (c *Cache) MessageSent(peer net.Addr, msg message.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current, ok := c.transactions[getKey(peer, msg.Sequence())]
+	if !ok || current.response != nil || msg.MessageType() != current.requestType+1 {
+		return
+	}
+
+	response, err := message.Marshal(msg)
+	if err != nil {
+		loggerhdl.FromMessage(msg).WithError(err).Warn("Failed to cache the response")
+
+		return
+	}
+
+	current.response = response
+}
+
+// Len returns the number of transactions which are kept.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.transactions)
+}
+
+// begin registers the transaction of a request, or returns the cached response when the request
+// was already received.
+func (c *Cache) begin(key string, requestType uint8) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.purge(now)
+
+	if current, ok := c.transactions[key]; ok && current.requestType == requestType {
+		return current.response, true
+	}
+
+	c.transactions[key] = &transaction{requestType: requestType, received: now}
+	c.order = append(c.order, entry{key: key, received: now})
+
+	return nil, false
+}
+
+// end forgets the transactions without response, so their retransmissions are handled again.
+func (c *Cache) end(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if current, ok := c.transactions[key]; ok && current.response == nil {
+		delete(c.transactions, key)
+	}
+}
+
+// purge deletes the transactions whose requests can't be retransmitted anymore.
+func (c *Cache) purge(now time.Time) {
+	expired := 0
+
+	for _, oldest := range c.order {
+		if now.Sub(oldest.received) < c.window {
+			break
+		}
+
+		if current, ok := c.transactions[oldest.key]; ok && current.received.Equal(oldest.received) {
+			delete(c.transactions, oldest.key)
+		}
+
+		expired++
+	}
+
+	c.order = c.order[expired:]
+}
+
+// isRequest checks if the message is one of the requests initiated by the S-GWs.
+func isRequest(msg message.Message) bool {
+	switch msg.(type) {
+	case *message.CreateSessionRequest, *message.DeleteSessionRequest, *message.ModifyBearerRequest:
+		return true
+	}
+
+	return false
+}
+
+// getKey identifies a transaction by the peer and the sequence number of the request.
+func getKey(peer net.Addr, sequence uint32) string {
+	return fmt.Sprintf("%s/%d", peer, sequence)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retransmithdl_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/handlers/retransmithdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// observer records the actions taken on the retransmitted requests.
+type observer struct {
+	mutex   sync.Mutex
+	actions []string
+}
+
+func (o *observer) RetransmissionDetected(peer net.Addr, msg message.Message, action string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.actions = append(o.actions, action)
+}
+
+func (o *observer) Actions() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]string{}, o.actions...)
+}
+
+var _ = Describe("Cache", func() {
+	var (
+		pgw      = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21233}
+		sgw      *net.UDPConn
+		cancel   context.CancelFunc
+		stopped  chan struct{}
+		watcher  *observer
+		handled  int32
+		release  chan struct{}
+		sequence uint32
+	)
+
+	// serve answers the requests through a cache with the given retransmission window.
+	serve := func(window time.Duration) {
+		cache := retransmithdl.New(window, watcher)
+
+		connection := gtpv2.NewConn(pgw, gtpv2.IFTypeS5S8PGWGTPC, 0)
+		connection.AddHandler(message.MsgTypeCreateSessionRequest, cache.Wrap(
+			func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+				<-release
+
+				// Every response carries a different TEID, so the replayed ones can be identified
+				response := message.NewCreateSessionResponse(uint32(atomic.AddInt32(&handled, 1)), 0,
+					ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil))
+				if err := connection.RespondTo(sender, msg, response); err != nil {
+					return err
+				}
+
+				cache.MessageSent(sender, response)
+
+				return nil
+			}))
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		stopped = make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(stopped)
+
+			Expect(connection.ListenAndServe(ctx)).To(Succeed())
+		}()
+
+		// The echo requests are answered once the connection is listening
+		Eventually(func() error {
+			echo, err := message.Marshal(message.NewEchoRequest(0, ie.NewRecovery(0)))
+			Expect(err).NotTo(HaveOccurred())
+
+			if _, err := sgw.WriteTo(echo, pgw); err != nil {
+				return err
+			}
+
+			_, err = receive(sgw, 100*time.Millisecond)
+
+			return err
+		}, 5*time.Second).Should(Succeed())
+	}
+
+	send := func(sequence uint32) {
+		request, err := message.Marshal(message.NewCreateSessionRequest(0, sequence,
+			ie.NewIMSI("123451234567891")))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = sgw.WriteTo(request, pgw)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		sgw, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		Expect(err).NotTo(HaveOccurred())
+
+		watcher = &observer{}
+		handled = 0
+		release = make(chan struct{})
+		close(release)
+		sequence++
+	})
+
+	AfterEach(func() {
+		// The port is released once the connection stops serving
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+		Expect(sgw.Close()).To(Succeed())
+	})
+
+	It("should replay the response of a retransmitted request", func() {
+		serve(time.Minute)
+
+		send(sequence)
+		first, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		send(sequence)
+		second, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second).To(Equal(first))
+		Expect(atomic.LoadInt32(&handled)).To(Equal(int32(1)))
+		Expect(watcher.Actions()).To(Equal([]string{retransmithdl.ActionReplayed}))
+	})
+
+	It("should discard the retransmissions of a request being handled", func() {
+		release = make(chan struct{})
+		serve(time.Minute)
+
+		send(sequence)
+		send(sequence)
+		Eventually(watcher.Actions).Should(Equal([]string{retransmithdl.ActionDiscarded}))
+		close(release)
+
+		_, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = receive(sgw, 200*time.Millisecond)
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&handled)).To(Equal(int32(1)))
+	})
+
+	It("should handle the requests with different sequence numbers", func() {
+		serve(time.Minute)
+
+		send(sequence)
+		first, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		send(sequence + 1000)
+		second, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second).NotTo(Equal(first))
+		Expect(atomic.LoadInt32(&handled)).To(Equal(int32(2)))
+		Expect(watcher.Actions()).To(BeEmpty())
+	})
+
+	It("should handle the requests again once the window expires", func() {
+		serve(100 * time.Millisecond)
+
+		send(sequence)
+		_, err := receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		time.Sleep(200 * time.Millisecond)
+
+		send(sequence)
+		_, err = receive(sgw, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(atomic.LoadInt32(&handled)).To(Equal(int32(2)))
+		Expect(watcher.Actions()).To(BeEmpty())
+	})
+})
+
+// receive reads the next GTPv2 message sent to the connection.
+func receive(connection *net.UDPConn, timeout time.Duration) ([]byte, error) {
+	buffer := make([]byte, 1500)
+
+	if err := connection.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	n, err := connection.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer[:n], nil
+}
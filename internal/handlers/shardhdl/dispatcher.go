@@ -0,0 +1,160 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardhdl
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// DefaultWorkers is the number of workers which handle the requests of the subscribers. The
+// handlers wait for the AAA server, the datastore and the datapath, so they exceed the CPUs.
+const DefaultWorkers = 64
+
+// queueDepth is the number of requests waiting for a worker before their reception is blocked.
+const queueDepth = 16
+
+// ErrClosed indicates that the requests can't be handled after closing the dispatcher.
+var ErrClosed = errors.New("dispatcher closed")
+
+// job is a request waiting for its worker.
+type job struct {
+	handler    gtpv2.HandlerFunc
+	connection *gtpv2.Conn
+	sender     net.Addr
+	msg        message.Message
+	result     chan error
+}
+
+// Dispatcher handles the requests of every subscriber one at a time, which go-gtp doesn't ensure
+// given that it runs a goroutine per message. The requests are queued in the order their
+// goroutines reach the dispatcher, which may differ from the reception order. The subscribers are
+// sharded among a fixed set of workers, so the requests of different subscribers still run in
+// parallel.
+type Dispatcher struct {
+	queues []chan *job
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// New starts a dispatcher with the given number of workers.
+func New(workers int) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	d := &Dispatcher{
+		queues: make([]chan *job, workers),
+		done:   make(chan struct{}),
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan *job, queueDepth)
+
+		d.wg.Add(1)
+
+		go d.work(d.queues[i])
+	}
+
+	return d
+}
+
+// Wrap passes the requests to the worker of their subscriber and waits until they are handled.
+func (d *Dispatcher) Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		current := &job{
+			handler:    apiHandler,
+			connection: connection,
+			sender:     sender,
+			msg:        msg,
+			result:     make(chan error, 1),
+		}
+
+		select {
+		case d.queues[d.shard(getKey(connection, sender, msg))] <- current:
+		case <-d.done:
+			return ErrClosed
+		}
+
+		select {
+		case err := <-current.result:
+			return err
+		case <-d.done:
+			return ErrClosed
+		}
+	}
+}
+
+// Close stops the workers once they finish the requests being handled, the queued ones are
+// discarded.
+func (d *Dispatcher) Close() error {
+	d.once.Do(func() {
+		close(d.done)
+	})
+
+	d.wg.Wait()
+
+	return nil
+}
+
+func (d *Dispatcher) work(queue chan *job) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case current := <-queue:
+			current.result <- current.handler(current.connection, current.sender, current.msg)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// shard selects the worker of a subscriber.
+func (d *Dispatcher) shard(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return int(hash.Sum32() % uint32(len(d.queues)))
+}
+
+// getKey identifies the subscriber of a request by its IMSI, or by the TEID of its session. The
+// requests whose subscriber is unknown are serialized per peer, they are rejected anyway.
+func getKey(connection *gtpv2.Conn, sender net.Addr, msg message.Message) string {
+	if request, ok := msg.(*message.CreateSessionRequest); ok && request.IMSI != nil {
+		if imsi, err := request.IMSI.IMSI(); err == nil {
+			return imsi
+		}
+	}
+
+	if teid := msg.TEID(); teid != 0 {
+		if imsi, err := connection.GetIMSIByTEID(teid, sender); err == nil {
+			return imsi
+		}
+
+		return sender.String() + "/" + strconv.FormatUint(uint64(teid), 10)
+	}
+
+	return sender.String()
+}
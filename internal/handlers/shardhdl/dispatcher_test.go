@@ -0,0 +1,119 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardhdl_test
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/handlers/shardhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+var _ = Describe("Dispatcher", func() {
+	var (
+		sgw        = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2123}
+		dispatcher *shardhdl.Dispatcher
+		inFlight   int32
+		maxFlight  int32
+	)
+
+	// handle records the maximum number of requests handled at the same time.
+	handle := func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			previous := atomic.LoadInt32(&maxFlight)
+			if current <= previous || atomic.CompareAndSwapInt32(&maxFlight, previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		return nil
+	}
+
+	// send passes concurrently a Create Session Request per IMSI through the dispatcher.
+	send := func(handler func(*gtpv2.Conn, net.Addr, message.Message) error, imsis ...string) []error {
+		var wg sync.WaitGroup
+
+		errs := make([]error, len(imsis))
+
+		for i, imsi := range imsis {
+			wg.Add(1)
+
+			go func(i int, imsi string) {
+				defer wg.Done()
+
+				errs[i] = handler(nil, sgw, message.NewCreateSessionRequest(0, uint32(i+1),
+					ie.NewIMSI(imsi)))
+			}(i, imsi)
+		}
+
+		wg.Wait()
+
+		return errs
+	}
+
+	BeforeEach(func() {
+		dispatcher = shardhdl.New(shardhdl.DefaultWorkers)
+		atomic.StoreInt32(&inFlight, 0)
+		atomic.StoreInt32(&maxFlight, 0)
+	})
+
+	AfterEach(func() {
+		Expect(dispatcher.Close()).To(Succeed())
+	})
+
+	It("handles the requests of a subscriber one at a time", func() {
+		imsis := make([]string, 10)
+		for i := range imsis {
+			imsis[i] = "123451234567891"
+		}
+
+		Expect(send(dispatcher.Wrap(handle), imsis...)).NotTo(ContainElement(HaveOccurred()))
+		Expect(atomic.LoadInt32(&maxFlight)).To(Equal(int32(1)))
+	})
+
+	It("handles the requests of different subscribers in parallel", func() {
+		Expect(send(dispatcher.Wrap(handle), "123451234567891", "123451234567892",
+			"123451234567893", "123451234567894")).NotTo(ContainElement(HaveOccurred()))
+		Expect(atomic.LoadInt32(&maxFlight)).To(BeNumerically(">", 1))
+	})
+
+	It("returns the error of the handler", func() {
+		failure := func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+			return errors.New("failure")
+		}
+
+		Expect(send(dispatcher.Wrap(failure), "123451234567891")).To(
+			ConsistOf(MatchError("failure")))
+	})
+
+	It("rejects the requests once closed", func() {
+		Expect(dispatcher.Close()).To(Succeed())
+
+		Expect(send(dispatcher.Wrap(handle), "123451234567891")).To(
+			ConsistOf(MatchError(shardhdl.ErrClosed)))
+	})
+})
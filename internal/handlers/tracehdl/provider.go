@@ -0,0 +1,103 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracehdl
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Span exporters supported by the tracer provider.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// ErrUnknownExporter indicates that the span exporter isn't supported.
+var ErrUnknownExporter = errors.New("unknown span exporter")
+
+// Setup registers the global tracer provider, which sends the spans to an OTLP/HTTP collector or
+// writes them to a file. The returned function flushes the pending spans.
+func Setup(ctx context.Context, exporterType, target string) (func(context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch exporterType {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithInsecure()}
+		if target != "" {
+			options = append(options, otlptracehttp.WithEndpoint(target))
+		}
+
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case ExporterFile:
+		exporter, err = newFileExporter(target)
+	default:
+		return nil, errors.Wrapf(ErrUnknownExporter, "%q exporter", exporterType)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the %s span exporter", exporterType)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "pgw"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// fileExporter writes the spans to a file and closes it once it's shut down.
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %q file", path)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the stdout exporter")
+	}
+
+	return &fileExporter{Exporter: exporter, file: file}, nil
+}
+
+// Shutdown flushes the exporter and closes the file.
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	if err := e.Exporter.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "failed to shutdown the stdout exporter")
+	}
+
+	return errors.Wrap(e.file.Close(), "failed to close the spans file")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracehdl
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/gw-tester/pgw"
+
+// Attributes added to the spans of the GTP-C transactions.
+const (
+	PeerKey      = attribute.Key("net.peer.name")
+	TEIDKey      = attribute.Key("gtp.teid")
+	LocalTEIDKey = attribute.Key("gtp.local_teid")
+	SequenceKey  = attribute.Key("gtp.sequence")
+	IMSIKey      = attribute.Key("gtp.imsi")
+	APNKey       = attribute.Key("gtp.apn")
+	CauseKey     = attribute.Key("gtp.cause")
+)
+
+// transactions stores the context of the transactions which are being processed by their message.
+var transactions sync.Map
+
+// Wrap ensures that a span is recorded for every GTP-C transaction.
+func Wrap(apiHandler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error) (handler func(connection *gtpv2.Conn,
+	sender net.Addr, msg message.Message) error,
+) {
+	return func(connection *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+		ctx, span := otel.Tracer(instrumentationName).Start(context.Background(), msg.MessageTypeName(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				PeerKey.String(sender.String()),
+				TEIDKey.Int64(int64(msg.TEID())),
+				SequenceKey.Int64(int64(msg.Sequence())),
+			))
+
+		transactions.Store(msg, ctx)
+
+		defer func() {
+			transactions.Delete(msg)
+			span.End()
+		}()
+
+		err := apiHandler(connection, sender, msg)
+		setError(span, err)
+
+		return err
+	}
+}
+
+func getContext(msg message.Message) context.Context {
+	if ctx, ok := transactions.Load(msg); ok {
+		if ctx, ok := ctx.(context.Context); ok {
+			return ctx
+		}
+	}
+
+	return context.Background()
+}
+
+// Start creates the span of a step of the transaction which processes the message.
+func Start(msg message.Message, name string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := otel.Tracer(instrumentationName).Start(getContext(msg), name, trace.WithAttributes(attributes...))
+
+	return span
+}
+
+// End finishes the span of a step recording its error.
+func End(span trace.Span, err error) {
+	setError(span, err)
+	span.End()
+}
+
+// SetAttributes adds attributes to the span of the transaction which processes the message.
+func SetAttributes(msg message.Message, attributes ...attribute.KeyValue) {
+	trace.SpanFromContext(getContext(msg)).SetAttributes(attributes...)
+}
+
+func setError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracehdl_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errHandler = errors.New("handler error")
+
+func getAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+var _ = Describe("Tracer", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		sgw      = &net.UDPAddr{IP: net.ParseIP("172.25.1.3"), Port: 2123}
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	Describe("wrapping a handler", func() {
+		It("should record a span per transaction with its steps", func() {
+			handler := tracehdl.Wrap(func(_ *gtpv2.Conn, _ net.Addr, msg message.Message) error {
+				tracehdl.SetAttributes(msg, tracehdl.IMSIKey.String("123451234567891"),
+					tracehdl.APNKey.String("internet"))
+				tracehdl.End(tracehdl.Start(msg, "decode"), nil)
+				tracehdl.End(tracehdl.Start(msg, "user-plane"), errHandler)
+
+				return nil
+			})
+
+			Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0x10, 1))).To(Succeed())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(3))
+
+			decode, userPlane, root := spans[0], spans[1], spans[2]
+			Expect(root.Name()).To(Equal("Delete Session Request"))
+			Expect(root.Parent().IsValid()).To(BeFalse())
+			Expect(root.Status().Code).To(Equal(codes.Unset))
+			Expect(getAttribute(root, tracehdl.PeerKey).AsString()).To(Equal(sgw.String()))
+			Expect(getAttribute(root, tracehdl.TEIDKey).AsInt64()).To(Equal(int64(0x10)))
+			Expect(getAttribute(root, tracehdl.SequenceKey).AsInt64()).To(Equal(int64(1)))
+			Expect(getAttribute(root, tracehdl.IMSIKey).AsString()).To(Equal("123451234567891"))
+			Expect(getAttribute(root, tracehdl.APNKey).AsString()).To(Equal("internet"))
+
+			Expect(decode.Name()).To(Equal("decode"))
+			Expect(decode.Parent().SpanID()).To(Equal(root.SpanContext().SpanID()))
+			Expect(decode.Status().Code).To(Equal(codes.Unset))
+
+			Expect(userPlane.Name()).To(Equal("user-plane"))
+			Expect(userPlane.Parent().SpanID()).To(Equal(root.SpanContext().SpanID()))
+			Expect(userPlane.Status().Code).To(Equal(codes.Error))
+			Expect(userPlane.Events()).To(HaveLen(1))
+		})
+		It("should record the handler error", func() {
+			handler := tracehdl.Wrap(func(*gtpv2.Conn, net.Addr, message.Message) error {
+				return errHandler
+			})
+
+			Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0, 1))).To(MatchError(errHandler))
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Status().Code).To(Equal(codes.Error))
+			Expect(spans[0].Status().Description).To(Equal(errHandler.Error()))
+		})
+	})
+
+	It("should record steps of messages outside of a transaction as root spans", func() {
+		tracehdl.End(tracehdl.Start(message.NewDeleteSessionRequest(0, 1), "datastore"), nil)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Parent().IsValid()).To(BeFalse())
+	})
+
+	Describe("setting up the provider", func() {
+		It("should write the spans into a file", func() {
+			directory, err := os.MkdirTemp("", "tracehdl")
+			Expect(err).NotTo(HaveOccurred())
+
+			defer os.RemoveAll(directory)
+
+			path := filepath.Join(directory, "spans.json")
+
+			shutdown, err := tracehdl.Setup(context.Background(), tracehdl.ExporterFile, path)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler := tracehdl.Wrap(func(*gtpv2.Conn, net.Addr, message.Message) error {
+				return nil
+			})
+			Expect(handler(nil, sgw, message.NewDeleteSessionRequest(0, 1))).To(Succeed())
+			Expect(shutdown(context.Background())).To(Succeed())
+
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring(`"Name":"Delete Session Request"`))
+			Expect(string(content)).To(ContainSubstring(`"Value":"pgw"`))
+		})
+		It("should not export spans by default", func() {
+			shutdown, err := tracehdl.Setup(context.Background(), tracehdl.ExporterNone, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shutdown(context.Background())).To(Succeed())
+		})
+		It("should fail with unknown exporters", func() {
+			_, err := tracehdl.Setup(context.Background(), "jaeger", "")
+			Expect(err).To(MatchError(tracehdl.ErrUnknownExporter))
+		})
+	})
+})
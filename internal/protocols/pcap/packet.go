@@ -0,0 +1,84 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ipv4HeaderLength = 20
+	ipv6HeaderLength = 40
+	udpHeaderLength  = 8
+	udpProtocol      = 17
+	defaultTTL       = 64
+)
+
+// ErrAddressFamilyMismatch indicates that the source and destination addresses have different families.
+var ErrAddressFamilyMismatch = errors.New("address family mismatch")
+
+// NewUDPPacket builds the IP packet which carries the payload of an UDP datagram, for the
+// messages which are captured once the socket has removed their headers.
+func NewUDPPacket(src, dst *net.UDPAddr, payload []byte) ([]byte, error) {
+	udp := make([]byte, udpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[udpHeaderLength:], payload)
+
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		header := make([]byte, ipv4HeaderLength)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:4], uint16(ipv4HeaderLength+len(udp)))
+		header[8] = defaultTTL
+		header[9] = udpProtocol
+		copy(header[12:16], src4)
+		copy(header[16:20], dst4)
+		binary.BigEndian.PutUint16(header[10:12], checksum(header))
+
+		return append(header, udp...), nil
+	}
+
+	src16, dst16 := src.IP.To16(), dst.IP.To16()
+	if src16 == nil || dst16 == nil || (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		return nil, errors.Wrapf(ErrAddressFamilyMismatch, "%s and %s addresses", src.IP, dst.IP)
+	}
+
+	header := make([]byte, ipv6HeaderLength)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(udp)))
+	header[6] = udpProtocol
+	header[7] = defaultTTL
+	copy(header[8:24], src16)
+	copy(header[24:40], dst16)
+
+	return append(header, udp...), nil
+}
+
+func checksum(data []byte) uint16 {
+	var sum uint32
+
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
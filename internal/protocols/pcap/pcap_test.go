@@ -0,0 +1,148 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/protocols/pcap"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func sum(data []byte) uint16 {
+	var total uint32
+
+	for i := 0; i+1 < len(data); i += 2 {
+		total += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+
+	for total > 0xffff {
+		total = (total >> 16) + (total & 0xffff)
+	}
+
+	return uint16(total)
+}
+
+var _ = Describe("Pcap", func() {
+	var (
+		pgw     = &net.UDPAddr{IP: net.ParseIP("172.25.1.2"), Port: 2123}
+		sgw     = &net.UDPAddr{IP: net.ParseIP("172.25.1.3"), Port: 2123}
+		payload = []byte{0x48, 0x21, 0x00, 0x04}
+	)
+
+	Describe("writing packets", func() {
+		It("should write the file header and the packet records", func() {
+			var buffer bytes.Buffer
+
+			writer, err := pcap.NewWriter(&buffer)
+			Expect(err).NotTo(HaveOccurred())
+
+			timestamp := time.Unix(1600000000, 5000)
+			written, err := writer.WritePacket(timestamp, payload)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(written).To(Equal(16 + len(payload)))
+
+			content := buffer.Bytes()
+			Expect(content).To(HaveLen(24 + 16 + len(payload)))
+			Expect(binary.LittleEndian.Uint32(content[0:4])).To(Equal(uint32(0xa1b2c3d4)))
+			Expect(binary.LittleEndian.Uint32(content[20:24])).To(Equal(uint32(pcap.LinkTypeRaw)))
+			Expect(binary.LittleEndian.Uint32(content[24:28])).To(Equal(uint32(1600000000)))
+			Expect(binary.LittleEndian.Uint32(content[28:32])).To(Equal(uint32(5)))
+			Expect(binary.LittleEndian.Uint32(content[32:36])).To(Equal(uint32(len(payload))))
+			Expect(content[40:]).To(Equal(payload))
+		})
+	})
+
+	Describe("building UDP packets", func() {
+		It("should add a valid IPv4 header", func() {
+			packet, err := pcap.NewUDPPacket(sgw, pgw, payload)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(packet).To(HaveLen(20 + 8 + len(payload)))
+			Expect(packet[0]).To(Equal(byte(0x45)))
+			Expect(packet[9]).To(Equal(byte(17)))
+			Expect(net.IP(packet[12:16]).Equal(sgw.IP)).To(BeTrue())
+			Expect(net.IP(packet[16:20]).Equal(pgw.IP)).To(BeTrue())
+			Expect(sum(packet[:20])).To(Equal(uint16(0xffff)))
+			Expect(binary.BigEndian.Uint16(packet[22:24])).To(Equal(uint16(2123)))
+			Expect(packet[28:]).To(Equal(payload))
+		})
+		It("should add an IPv6 header", func() {
+			packet, err := pcap.NewUDPPacket(&net.UDPAddr{IP: net.ParseIP("2001:db8::3"), Port: 2123},
+				&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2123}, payload)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(packet).To(HaveLen(40 + 8 + len(payload)))
+			Expect(packet[0] >> 4).To(Equal(byte(6)))
+			Expect(binary.BigEndian.Uint16(packet[4:6])).To(Equal(uint16(8 + len(payload))))
+		})
+		It("should fail with addresses of different families", func() {
+			_, err := pcap.NewUDPPacket(sgw, &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2123}, payload)
+			Expect(err).To(MatchError(pcap.ErrAddressFamilyMismatch))
+		})
+	})
+
+	Describe("rotating files", func() {
+		var directory string
+
+		BeforeEach(func() {
+			temp, err := os.MkdirTemp("", "pcap")
+			Expect(err).NotTo(HaveOccurred())
+
+			directory = filepath.Join(temp, "captures")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(directory))).To(Succeed())
+		})
+
+		It("should keep the most recent files", func() {
+			writer, err := pcap.NewRotatingWriter(directory, "pgw", 24+2*(16+int64(len(payload))), 2)
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 5; i++ {
+				Expect(writer.WritePacket(time.Now(), payload)).To(Succeed())
+			}
+
+			Expect(writer.Close()).To(Succeed())
+
+			files := writer.Files()
+			Expect(files).To(HaveLen(2))
+			Expect(files[1]).To(HaveSuffix("-2.pcap"))
+
+			entries, err := os.ReadDir(directory)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+
+			info, err := os.Stat(files[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(24 + 16 + len(payload))))
+		})
+		It("should write a single file without maximum size", func() {
+			writer, err := pcap.NewRotatingWriter(directory, "pgw", 0, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 5; i++ {
+				Expect(writer.WritePacket(time.Now(), payload)).To(Succeed())
+			}
+
+			Expect(writer.Close()).To(Succeed())
+			Expect(writer.Files()).To(HaveLen(1))
+		})
+	})
+})
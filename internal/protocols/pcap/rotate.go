@@ -0,0 +1,126 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RotatingWriter writes the packets into a sequence of capture files, which are rotated once they
+// reach their maximum size keeping only the most recent ones. It isn't safe for concurrent use.
+type RotatingWriter struct {
+	directory string
+	prefix    string
+	maxSize   int64
+	maxFiles  int
+
+	files  []string
+	index  int
+	file   *os.File
+	writer *Writer
+	size   int64
+}
+
+// NewRotatingWriter creates the first capture file in the directory. Files aren't rotated when
+// the maximum size is zero and all of them are kept when the maximum number of files is zero.
+func NewRotatingWriter(directory, prefix string, maxSize int64, maxFiles int) (*RotatingWriter, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s capture directory", directory)
+	}
+
+	w := &RotatingWriter{
+		directory: directory,
+		prefix:    prefix,
+		maxSize:   maxSize,
+		maxFiles:  maxFiles,
+	}
+
+	if err := w.rotate(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// WritePacket appends a packet to the current file, rotating it when it's full.
+func (w *RotatingWriter) WritePacket(timestamp time.Time, data []byte) error {
+	if w.maxSize > 0 && w.size > fileHeaderLength && w.size+int64(packetHeaderLength+len(data)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	written, err := w.writer.WritePacket(timestamp, data)
+	w.size += int64(written)
+
+	return err
+}
+
+// Files returns the capture files which are kept, from the oldest to the current one.
+func (w *RotatingWriter) Files() []string {
+	return append([]string{}, w.files...)
+}
+
+// Close closes the current capture file.
+func (w *RotatingWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return errors.Wrapf(err, "failed to close %s capture file", w.files[len(w.files)-1])
+}
+
+func (w *RotatingWriter) rotate() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	name := filepath.Join(w.directory, fmt.Sprintf("%s-%s-%d.pcap", w.prefix,
+		time.Now().UTC().Format("20060102T150405"), w.index))
+
+	file, err := os.Create(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s capture file", name)
+	}
+
+	writer, err := NewWriter(file)
+	if err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	w.file, w.writer, w.size = file, writer, fileHeaderLength
+	w.files = append(w.files, name)
+	w.index++
+
+	for w.maxFiles > 0 && len(w.files) > w.maxFiles {
+		if err := os.Remove(w.files[0]); err != nil {
+			log.WithError(err).Warnf("Failed to remove %s capture file", w.files[0])
+		}
+
+		w.files = w.files[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	magicNumber  = 0xa1b2c3d4
+	versionMajor = 2
+	versionMinor = 4
+	// SnapLength is the maximum number of bytes captured from each packet.
+	SnapLength = 65535
+	// LinkTypeRaw identifies the packets which start with their IPv4 or IPv6 header.
+	LinkTypeRaw = 101
+
+	fileHeaderLength   = 24
+	packetHeaderLength = 16
+)
+
+// Writer writes packets in the libpcap file format, which can be opened with Wireshark or tcpdump.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the file header of a raw IP capture.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, fileHeaderLength)
+	binary.LittleEndian.PutUint32(header[0:4], magicNumber)
+	binary.LittleEndian.PutUint16(header[4:6], versionMajor)
+	binary.LittleEndian.PutUint16(header[6:8], versionMinor)
+	binary.LittleEndian.PutUint32(header[16:20], SnapLength)
+	binary.LittleEndian.PutUint32(header[20:24], LinkTypeRaw)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, errors.Wrap(err, "failed to write the pcap file header")
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WritePacket appends a packet captured at the given time and returns the number of bytes written.
+func (w *Writer) WritePacket(timestamp time.Time, data []byte) (int, error) {
+	captured := data
+	if len(captured) > SnapLength {
+		captured = captured[:SnapLength]
+	}
+
+	record := make([]byte, packetHeaderLength+len(captured))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(timestamp.Nanosecond()/int(time.Microsecond)))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(captured)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(data)))
+	copy(record[packetHeaderLength:], captured)
+
+	if _, err := w.w.Write(record); err != nil {
+		return 0, errors.Wrap(err, "failed to write the pcap packet record")
+	}
+
+	return len(record), nil
+}
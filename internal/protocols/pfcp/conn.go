@@ -0,0 +1,246 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bufferSize = 1500
+	// defaultTimeout and defaultRetries follow the T1 and N1 timers of 3GPP TS 29.244.
+	defaultTimeout = time.Duration(3) * time.Second
+	defaultRetries = 3
+)
+
+var (
+	// ErrTimeout indicates that the peer didn't answer a request.
+	ErrTimeout = errors.New("PFCP request timeout")
+	// ErrConnClosed indicates that the connection was closed while waiting a response.
+	ErrConnClosed = errors.New("PFCP connection closed")
+)
+
+// HandlerFunc processes a PFCP request received from a peer.
+type HandlerFunc func(conn *Conn, sender net.Addr, msg *Message) error
+
+// Conn sends and receives PFCP messages through an UDP socket, matching responses with the
+// requests sent and dispatching the requests received to their handlers.
+type Conn struct {
+	mutex    sync.Mutex
+	conn     net.PacketConn
+	sequence uint32
+	pending  map[uint32]chan *Message
+	handlers map[uint8]HandlerFunc
+	closed   chan struct{}
+	recovery time.Time
+
+	Timeout time.Duration
+	Retries int
+}
+
+// Listen creates a PFCP connection bound to the given address.
+func Listen(address string) (*Conn, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", address)
+	}
+
+	c := &Conn{
+		conn:     conn,
+		pending:  map[uint32]chan *Message{},
+		handlers: map[uint8]HandlerFunc{},
+		closed:   make(chan struct{}),
+		recovery: time.Now(),
+		Timeout:  defaultTimeout,
+		Retries:  defaultRetries,
+	}
+	c.handlers[MsgTypeHeartbeatRequest] = handleHeartbeat
+
+	return c, nil
+}
+
+// LocalAddr retrieves the address where the connection is bound.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RecoveryTimeStamp retrieves the time when the connection was started.
+func (c *Conn) RecoveryTimeStamp() time.Time {
+	return c.recovery
+}
+
+// AddHandler registers the function which processes the requests of the given type.
+func (c *Conn) AddHandler(msgType uint8, fn HandlerFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.handlers[msgType] = fn
+}
+
+func handleHeartbeat(conn *Conn, sender net.Addr, msg *Message) error {
+	return conn.RespondTo(sender, msg, NewNodeMessage(MsgTypeHeartbeatResponse,
+		NewRecoveryTimeStamp(conn.recovery)))
+}
+
+// ListenAndServe reads messages until the context is done or the connection is closed.
+func (c *Conn) ListenAndServe(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := c.Close(); err != nil {
+				log.WithError(err).Warn("PFCP connection close error")
+			}
+		case <-c.closed:
+		}
+	}()
+
+	buffer := make([]byte, bufferSize)
+
+	for {
+		n, sender, err := c.conn.ReadFrom(buffer)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+
+			return errors.Wrap(err, "failed to read from PFCP connection")
+		}
+
+		msg, err := Parse(append([]byte{}, buffer[:n]...))
+		if err != nil {
+			log.WithError(err).Warnf("Discarding malformed PFCP message from %s", sender)
+
+			continue
+		}
+
+		if msg.IsResponse() {
+			c.deliver(msg)
+
+			continue
+		}
+
+		c.mutex.Lock()
+		handler, ok := c.handlers[msg.Type]
+		c.mutex.Unlock()
+
+		if !ok {
+			log.Warnf("Discarding unsupported %d PFCP message from %s", msg.Type, sender)
+
+			continue
+		}
+
+		go func() {
+			if err := handler(c, sender, msg); err != nil {
+				log.WithError(err).Warnf("Failed to handle %d PFCP message from %s", msg.Type, sender)
+			}
+		}()
+	}
+}
+
+func (c *Conn) deliver(msg *Message) {
+	c.mutex.Lock()
+	ch, ok := c.pending[msg.Sequence]
+	delete(c.pending, msg.Sequence)
+	c.mutex.Unlock()
+
+	if !ok {
+		log.Debugf("Discarding unexpected %d PFCP response", msg.Sequence)
+
+		return
+	}
+
+	ch <- msg
+}
+
+func (c *Conn) nextSequence() uint32 {
+	c.sequence = (c.sequence + 1) & maxSequence
+	if c.sequence == 0 {
+		c.sequence = 1
+	}
+
+	return c.sequence
+}
+
+// Request sends a request to the peer and waits for its response, retransmitting the request
+// when the peer doesn't answer on time.
+func (c *Conn) Request(peer net.Addr, msg *Message) (*Message, error) {
+	ch := make(chan *Message, 1)
+
+	c.mutex.Lock()
+	msg.Sequence = c.nextSequence()
+	c.pending[msg.Sequence] = ch
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, msg.Sequence)
+		c.mutex.Unlock()
+	}()
+
+	payload := msg.Marshal()
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if _, err := c.conn.WriteTo(payload, peer); err != nil {
+			return nil, errors.Wrapf(err, "failed to send %d PFCP message", msg.Type)
+		}
+
+		select {
+		case response := <-ch:
+			return response, nil
+		case <-c.closed:
+			return nil, ErrConnClosed
+		case <-time.After(c.Timeout):
+		}
+	}
+
+	return nil, errors.Wrapf(ErrTimeout, "no response from %s", peer)
+}
+
+// RespondTo sends the response of a request received from the peer.
+func (c *Conn) RespondTo(peer net.Addr, request, response *Message) error {
+	response.Sequence = request.Sequence
+
+	if _, err := c.conn.WriteTo(response.Marshal(), peer); err != nil {
+		return errors.Wrapf(err, "failed to send %d PFCP message", response.Type)
+	}
+
+	return nil
+}
+
+// Close stops serving and closes the UDP socket.
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	select {
+	case <-c.closed:
+		c.mutex.Unlock()
+
+		return nil
+	default:
+		close(c.closed)
+	}
+	c.mutex.Unlock()
+
+	if err := c.conn.Close(); err != nil {
+		return errors.Wrap(err, "failed to close PFCP connection")
+	}
+
+	return nil
+}
@@ -0,0 +1,603 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcp
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IE types (3GPP TS 29.244 table 8.1.2-1).
+const (
+	CreatePDR                        uint16 = 1
+	PDI                              uint16 = 2
+	CreateFAR                        uint16 = 3
+	ForwardingParameters             uint16 = 4
+	CreateURR                        uint16 = 6
+	CreateQER                        uint16 = 7
+	CreatedPDR                       uint16 = 8
+	UpdateFAR                        uint16 = 10
+	UpdateForwardingParameters       uint16 = 11
+	RemovePDR                        uint16 = 15
+	RemoveFAR                        uint16 = 16
+	RemoveURR                        uint16 = 17
+	RemoveQER                        uint16 = 18
+	Cause                            uint16 = 19
+	SourceInterface                  uint16 = 20
+	FTEID                            uint16 = 21
+	NetworkInstance                  uint16 = 22
+	SDFFilter                        uint16 = 23
+	GateStatus                       uint16 = 25
+	MBR                              uint16 = 26
+	GBR                              uint16 = 27
+	Precedence                       uint16 = 29
+	ReportingTriggers                uint16 = 37
+	OffendingIE                      uint16 = 40
+	DestinationInterface             uint16 = 42
+	ApplyAction                      uint16 = 44
+	PDRID                            uint16 = 56
+	FSEID                            uint16 = 57
+	NodeID                           uint16 = 60
+	MeasurementMethod                uint16 = 62
+	URRID                            uint16 = 81
+	OuterHeaderCreation              uint16 = 84
+	UEIPAddress                      uint16 = 93
+	OuterHeaderRemoval               uint16 = 95
+	RecoveryTimeStamp                uint16 = 96
+	FARID                            uint16 = 108
+	QERID                            uint16 = 109
+	UserPlaneIPResourceInformation   uint16 = 116
+	ieHeaderLen                             = 4
+	ntpEpochOffset                          = 2208988800
+	bitRateLen                              = 5
+	ipv4Len                                 = 4
+	outerHeaderCreationGTPUUDPIPv4   uint16 = 0x0100
+	outerHeaderRemovalGTPUUDPIPv4    uint8  = 0
+	userPlaneIPResourceInformationV4 uint8  = 0x01
+)
+
+// Cause values (3GPP TS 29.244 table 8.2.1-1).
+const (
+	CauseRequestAccepted              uint8 = 1
+	CauseRequestRejected              uint8 = 64
+	CauseSessionContextNotFound       uint8 = 65
+	CauseMandatoryIEMissing           uint8 = 66
+	CauseMandatoryIEIncorrect         uint8 = 69
+	CauseNoEstablishedPFCPAssociation uint8 = 72
+	CauseRuleCreationFailure          uint8 = 73
+)
+
+// Interface values used by the Source and Destination Interface IEs.
+const (
+	InterfaceAccess uint8 = 0
+	InterfaceCore   uint8 = 1
+)
+
+// Apply Action flags.
+const (
+	ApplyActionDrop    uint8 = 0x01
+	ApplyActionForward uint8 = 0x02
+	ApplyActionBuffer  uint8 = 0x04
+)
+
+// Measurement Method flags.
+const (
+	MeasurementMethodDuration uint8 = 0x01
+	MeasurementMethodVolume   uint8 = 0x02
+)
+
+const (
+	fteidV4    uint8 = 0x01
+	fseidV4    uint8 = 0x02
+	nodeIDIPv4 uint8 = 0
+	ueIPV4     uint8 = 0x02
+	ueIPSD     uint8 = 0x04
+	sdfFD      uint8 = 0x01
+	sdfTTC     uint8 = 0x02
+	sdfSPI     uint8 = 0x04
+)
+
+var (
+	// ErrInvalidIE indicates that an IE value can't be decoded.
+	ErrInvalidIE = errors.New("invalid IE")
+	// ErrInvalidLength indicates that a message or IE is shorter than its declared length.
+	ErrInvalidLength = errors.New("invalid length")
+)
+
+// IE is a PFCP Information Element. Grouped IEs store their members in ChildIEs.
+type IE struct {
+	Type     uint16
+	Payload  []byte
+	ChildIEs []*IE
+}
+
+var groupedIEs = map[uint16]bool{
+	CreatePDR: true, PDI: true, CreateFAR: true, ForwardingParameters: true, CreateURR: true,
+	CreateQER: true, CreatedPDR: true, RemovePDR: true, RemoveFAR: true, RemoveURR: true,
+	RemoveQER: true, UpdateFAR: true, UpdateForwardingParameters: true,
+}
+
+// NewIE creates an IE with the given value.
+func NewIE(ieType uint16, payload []byte) *IE {
+	return &IE{Type: ieType, Payload: payload}
+}
+
+// NewGroupedIE creates an IE which contains other IEs.
+func NewGroupedIE(ieType uint16, children ...*IE) *IE {
+	grouped := &IE{Type: ieType}
+
+	for _, child := range children {
+		if child != nil {
+			grouped.ChildIEs = append(grouped.ChildIEs, child)
+		}
+	}
+
+	return grouped
+}
+
+// IsGrouped checks if the IE contains other IEs.
+func (i *IE) IsGrouped() bool {
+	return groupedIEs[i.Type]
+}
+
+// MarshalLen returns the length of the encoded IE.
+func (i *IE) MarshalLen() int {
+	if !i.IsGrouped() {
+		return ieHeaderLen + len(i.Payload)
+	}
+
+	length := ieHeaderLen
+	for _, child := range i.ChildIEs {
+		length += child.MarshalLen()
+	}
+
+	return length
+}
+
+// MarshalTo encodes the IE into the given buffer and returns the number of bytes written.
+func (i *IE) MarshalTo(b []byte) int {
+	length := i.MarshalLen()
+	binary.BigEndian.PutUint16(b, i.Type)
+	binary.BigEndian.PutUint16(b[2:], uint16(length-ieHeaderLen))
+
+	if !i.IsGrouped() {
+		copy(b[ieHeaderLen:], i.Payload)
+
+		return length
+	}
+
+	offset := ieHeaderLen
+	for _, child := range i.ChildIEs {
+		offset += child.MarshalTo(b[offset:])
+	}
+
+	return length
+}
+
+// ParseIEs decodes a sequence of IEs.
+func ParseIEs(b []byte) ([]*IE, error) {
+	ies := []*IE{}
+
+	for len(b) > 0 {
+		if len(b) < ieHeaderLen {
+			return nil, errors.Wrap(ErrInvalidLength, "truncated IE header")
+		}
+
+		ieType := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+
+		if len(b) < ieHeaderLen+length {
+			return nil, errors.Wrapf(ErrInvalidLength, "truncated %d IE", ieType)
+		}
+
+		i := &IE{Type: ieType, Payload: b[ieHeaderLen : ieHeaderLen+length]}
+
+		if i.IsGrouped() {
+			children, err := ParseIEs(i.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			i.ChildIEs = children
+		}
+
+		ies = append(ies, i)
+		b = b[ieHeaderLen+length:]
+	}
+
+	return ies, nil
+}
+
+// Find retrieves the first member of a grouped IE with the given type.
+func (i *IE) Find(ieType uint16) *IE {
+	return find(i.ChildIEs, ieType)
+}
+
+// FindAll retrieves the members of a grouped IE with the given type.
+func (i *IE) FindAll(ieType uint16) []*IE {
+	return findAll(i.ChildIEs, ieType)
+}
+
+func find(ies []*IE, ieType uint16) *IE {
+	for _, i := range ies {
+		if i.Type == ieType {
+			return i
+		}
+	}
+
+	return nil
+}
+
+func findAll(ies []*IE, ieType uint16) []*IE {
+	found := []*IE{}
+
+	for _, i := range ies {
+		if i.Type == ieType {
+			found = append(found, i)
+		}
+	}
+
+	return found
+}
+
+func newUint8(ieType uint16, value uint8) *IE {
+	return NewIE(ieType, []byte{value})
+}
+
+func newUint16(ieType uint16, value uint16) *IE {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+
+	return NewIE(ieType, b)
+}
+
+func newUint32(ieType uint16, value uint32) *IE {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+
+	return NewIE(ieType, b)
+}
+
+// Uint8 decodes the first octet of the IE value.
+func (i *IE) Uint8() (uint8, error) {
+	if len(i.Payload) < 1 {
+		return 0, errors.Wrapf(ErrInvalidIE, "empty %d IE", i.Type)
+	}
+
+	return i.Payload[0], nil
+}
+
+// Uint16 decodes the first two octets of the IE value.
+func (i *IE) Uint16() (uint16, error) {
+	if len(i.Payload) < 2 {
+		return 0, errors.Wrapf(ErrInvalidIE, "short %d IE", i.Type)
+	}
+
+	return binary.BigEndian.Uint16(i.Payload), nil
+}
+
+// Uint32 decodes the first four octets of the IE value.
+func (i *IE) Uint32() (uint32, error) {
+	if len(i.Payload) < 4 {
+		return 0, errors.Wrapf(ErrInvalidIE, "short %d IE", i.Type)
+	}
+
+	return binary.BigEndian.Uint32(i.Payload), nil
+}
+
+// NewCause creates a Cause IE.
+func NewCause(cause uint8) *IE {
+	return newUint8(Cause, cause)
+}
+
+// NewPDRID creates a PDR ID IE.
+func NewPDRID(id uint16) *IE {
+	return newUint16(PDRID, id)
+}
+
+// NewFARID creates a FAR ID IE.
+func NewFARID(id uint32) *IE {
+	return newUint32(FARID, id)
+}
+
+// NewQERID creates a QER ID IE.
+func NewQERID(id uint32) *IE {
+	return newUint32(QERID, id)
+}
+
+// NewURRID creates an URR ID IE.
+func NewURRID(id uint32) *IE {
+	return newUint32(URRID, id)
+}
+
+// NewPrecedence creates a Precedence IE.
+func NewPrecedence(precedence uint32) *IE {
+	return newUint32(Precedence, precedence)
+}
+
+// NewSourceInterface creates a Source Interface IE.
+func NewSourceInterface(value uint8) *IE {
+	return newUint8(SourceInterface, value&0x0f)
+}
+
+// NewDestinationInterface creates a Destination Interface IE.
+func NewDestinationInterface(value uint8) *IE {
+	return newUint8(DestinationInterface, value&0x0f)
+}
+
+// NewApplyAction creates an Apply Action IE.
+func NewApplyAction(flags uint8) *IE {
+	return newUint8(ApplyAction, flags)
+}
+
+// NewMeasurementMethod creates a Measurement Method IE.
+func NewMeasurementMethod(flags uint8) *IE {
+	return newUint8(MeasurementMethod, flags)
+}
+
+// NewReportingTriggers creates a Reporting Triggers IE.
+func NewReportingTriggers(flags uint16) *IE {
+	return newUint16(ReportingTriggers, flags)
+}
+
+// NewGateStatus creates a Gate Status IE with both gates open.
+func NewGateStatus() *IE {
+	return newUint8(GateStatus, 0)
+}
+
+// NewOuterHeaderRemoval creates an Outer Header Removal IE for GTP-U/UDP/IPv4 packets.
+func NewOuterHeaderRemoval() *IE {
+	return newUint8(OuterHeaderRemoval, outerHeaderRemovalGTPUUDPIPv4)
+}
+
+// NewOffendingIE creates an Offending IE IE.
+func NewOffendingIE(ieType uint16) *IE {
+	return newUint16(OffendingIE, ieType)
+}
+
+// NewRecoveryTimeStamp creates a Recovery Time Stamp IE.
+func NewRecoveryTimeStamp(t time.Time) *IE {
+	return newUint32(RecoveryTimeStamp, uint32(t.Unix()+ntpEpochOffset))
+}
+
+func ipv4(ip net.IP) ([]byte, error) {
+	v4 := ip.To4()
+	if v4 == nil {
+		return nil, errors.Wrapf(ErrInvalidIE, "%s isn't an IPv4 address", ip)
+	}
+
+	return v4, nil
+}
+
+// NewNodeID creates a Node ID IE from an IPv4 address.
+func NewNodeID(ip net.IP) *IE {
+	return NewIE(NodeID, append([]byte{nodeIDIPv4}, ip.To4()...))
+}
+
+// NodeID decodes the IPv4 address of a Node ID IE.
+func (i *IE) NodeID() (net.IP, error) {
+	if len(i.Payload) < 1+ipv4Len || i.Payload[0]&0x0f != nodeIDIPv4 {
+		return nil, errors.Wrap(ErrInvalidIE, "unsupported Node ID")
+	}
+
+	return net.IP(i.Payload[1 : 1+ipv4Len]), nil
+}
+
+// NewFSEID creates a F-SEID IE.
+func NewFSEID(seid uint64, ip net.IP) *IE {
+	b := make([]byte, 9, 9+ipv4Len)
+	b[0] = fseidV4
+	binary.BigEndian.PutUint64(b[1:], seid)
+
+	return NewIE(FSEID, append(b, ip.To4()...))
+}
+
+// FSEID decodes the SEID and IPv4 address of a F-SEID IE.
+func (i *IE) FSEID() (uint64, net.IP, error) {
+	if len(i.Payload) < 9+ipv4Len || i.Payload[0]&fseidV4 == 0 {
+		return 0, nil, errors.Wrap(ErrInvalidIE, "unsupported F-SEID")
+	}
+
+	return binary.BigEndian.Uint64(i.Payload[1:]), net.IP(i.Payload[9 : 9+ipv4Len]), nil
+}
+
+// NewFTEID creates a F-TEID IE.
+func NewFTEID(teid uint32, ip net.IP) *IE {
+	b := make([]byte, 5, 5+ipv4Len)
+	b[0] = fteidV4
+	binary.BigEndian.PutUint32(b[1:], teid)
+
+	return NewIE(FTEID, append(b, ip.To4()...))
+}
+
+// FTEID decodes the TEID and IPv4 address of a F-TEID IE.
+func (i *IE) FTEID() (uint32, net.IP, error) {
+	if len(i.Payload) < 5+ipv4Len || i.Payload[0]&fteidV4 == 0 {
+		return 0, nil, errors.Wrap(ErrInvalidIE, "unsupported F-TEID")
+	}
+
+	return binary.BigEndian.Uint32(i.Payload[1:]), net.IP(i.Payload[5 : 5+ipv4Len]), nil
+}
+
+// NewOuterHeaderCreation creates an Outer Header Creation IE for GTP-U/UDP/IPv4 tunnels.
+func NewOuterHeaderCreation(teid uint32, ip net.IP) *IE {
+	b := make([]byte, 6, 6+ipv4Len)
+	binary.BigEndian.PutUint16(b, outerHeaderCreationGTPUUDPIPv4)
+	binary.BigEndian.PutUint32(b[2:], teid)
+
+	return NewIE(OuterHeaderCreation, append(b, ip.To4()...))
+}
+
+// OuterHeaderCreation decodes the TEID and IPv4 address of an Outer Header Creation IE.
+func (i *IE) OuterHeaderCreation() (uint32, net.IP, error) {
+	if len(i.Payload) < 6+ipv4Len || binary.BigEndian.Uint16(i.Payload)&outerHeaderCreationGTPUUDPIPv4 == 0 {
+		return 0, nil, errors.Wrap(ErrInvalidIE, "unsupported Outer Header Creation")
+	}
+
+	return binary.BigEndian.Uint32(i.Payload[2:]), net.IP(i.Payload[6 : 6+ipv4Len]), nil
+}
+
+// NewUEIPAddress creates an UE IP Address IE. Destination has to be set for downlink rules.
+func NewUEIPAddress(ip net.IP, destination bool) *IE {
+	flags := ueIPV4
+	if destination {
+		flags |= ueIPSD
+	}
+
+	return NewIE(UEIPAddress, append([]byte{flags}, ip.To4()...))
+}
+
+// UEIPAddress decodes the IPv4 address of an UE IP Address IE.
+func (i *IE) UEIPAddress() (net.IP, error) {
+	if len(i.Payload) < 1+ipv4Len || i.Payload[0]&ueIPV4 == 0 {
+		return nil, errors.Wrap(ErrInvalidIE, "unsupported UE IP Address")
+	}
+
+	return net.IP(i.Payload[1 : 1+ipv4Len]), nil
+}
+
+// NewUserPlaneIPResourceInformation creates an User Plane IP Resource Information IE.
+func NewUserPlaneIPResourceInformation(ip net.IP) *IE {
+	return NewIE(UserPlaneIPResourceInformation, append([]byte{userPlaneIPResourceInformationV4}, ip.To4()...))
+}
+
+// UserPlaneIPResourceInformation decodes the IPv4 address of the GTP-U interface.
+func (i *IE) UserPlaneIPResourceInformation() (net.IP, error) {
+	if len(i.Payload) < 1 || i.Payload[0]&userPlaneIPResourceInformationV4 == 0 {
+		return nil, errors.Wrap(ErrInvalidIE, "unsupported User Plane IP Resource Information")
+	}
+
+	offset := 1
+	if i.Payload[0]&0x1c != 0 { // TEID range indication
+		offset++
+	}
+
+	if len(i.Payload) < offset+ipv4Len {
+		return nil, errors.Wrap(ErrInvalidLength, "truncated User Plane IP Resource Information")
+	}
+
+	return net.IP(i.Payload[offset : offset+ipv4Len]), nil
+}
+
+func putBitRate(b []byte, kbps uint64) {
+	b[0] = byte(kbps >> 32)
+	binary.BigEndian.PutUint32(b[1:], uint32(kbps))
+}
+
+func bitRate(b []byte) uint64 {
+	return uint64(b[0])<<32 | uint64(binary.BigEndian.Uint32(b[1:]))
+}
+
+func newBitRates(ieType uint16, uplink, downlink uint64) *IE {
+	b := make([]byte, 2*bitRateLen)
+	putBitRate(b, uplink)
+	putBitRate(b[bitRateLen:], downlink)
+
+	return NewIE(ieType, b)
+}
+
+// NewMBR creates a MBR IE with the uplink and downlink values in kbps.
+func NewMBR(uplink, downlink uint64) *IE {
+	return newBitRates(MBR, uplink, downlink)
+}
+
+// NewGBR creates a GBR IE with the uplink and downlink values in kbps.
+func NewGBR(uplink, downlink uint64) *IE {
+	return newBitRates(GBR, uplink, downlink)
+}
+
+// BitRates decodes the uplink and downlink values in kbps of MBR and GBR IEs.
+func (i *IE) BitRates() (uint64, uint64, error) {
+	if len(i.Payload) < 2*bitRateLen {
+		return 0, 0, errors.Wrapf(ErrInvalidIE, "short %d bit rate IE", i.Type)
+	}
+
+	return bitRate(i.Payload), bitRate(i.Payload[bitRateLen:]), nil
+}
+
+// Filter stores the fields of a SDF Filter IE supported by the PGW-U.
+type Filter struct {
+	FlowDescription string
+	TypeOfService   *uint8
+	ToSMask         uint8
+	SPI             *uint32
+}
+
+// NewSDFFilter creates a SDF Filter IE.
+func NewSDFFilter(filter *Filter) *IE {
+	b := []byte{0, 0}
+
+	if filter.FlowDescription != "" {
+		b[0] |= sdfFD
+		b = append(b, byte(len(filter.FlowDescription)>>8), byte(len(filter.FlowDescription)))
+		b = append(b, filter.FlowDescription...)
+	}
+
+	if filter.TypeOfService != nil {
+		b[0] |= sdfTTC
+		b = append(b, *filter.TypeOfService, filter.ToSMask)
+	}
+
+	if filter.SPI != nil {
+		b[0] |= sdfSPI
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], *filter.SPI)
+	}
+
+	return NewIE(SDFFilter, b)
+}
+
+// SDFFilter decodes the flow description, type of service and SPI of a SDF Filter IE.
+func (i *IE) SDFFilter() (*Filter, error) {
+	if len(i.Payload) < 2 {
+		return nil, errors.Wrap(ErrInvalidIE, "short SDF Filter")
+	}
+
+	flags, b := i.Payload[0], i.Payload[2:]
+	filter := &Filter{}
+
+	if flags&sdfFD != 0 {
+		if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+			return nil, errors.Wrap(ErrInvalidLength, "truncated flow description")
+		}
+
+		length := int(binary.BigEndian.Uint16(b))
+		filter.FlowDescription = string(b[2 : 2+length])
+		b = b[2+length:]
+	}
+
+	if flags&sdfTTC != 0 {
+		if len(b) < 2 {
+			return nil, errors.Wrap(ErrInvalidLength, "truncated ToS traffic class")
+		}
+
+		tos := b[0]
+		filter.TypeOfService, filter.ToSMask = &tos, b[1]
+		b = b[2:]
+	}
+
+	if flags&sdfSPI != 0 {
+		if len(b) < 4 {
+			return nil, errors.Wrap(ErrInvalidLength, "truncated security parameter index")
+		}
+
+		spi := binary.BigEndian.Uint32(b)
+		filter.SPI = &spi
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcp
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Message types (3GPP TS 29.244 table 7.3-1).
+const (
+	MsgTypeHeartbeatRequest             uint8 = 1
+	MsgTypeHeartbeatResponse            uint8 = 2
+	MsgTypeAssociationSetupRequest      uint8 = 5
+	MsgTypeAssociationSetupResponse     uint8 = 6
+	MsgTypeSessionEstablishmentRequest  uint8 = 50
+	MsgTypeSessionEstablishmentResponse uint8 = 51
+	MsgTypeSessionModificationRequest   uint8 = 52
+	MsgTypeSessionModificationResponse  uint8 = 53
+	MsgTypeSessionDeletionRequest       uint8 = 54
+	MsgTypeSessionDeletionResponse      uint8 = 55
+	version                             uint8 = 1
+	seidFlag                            uint8 = 0x01
+	headerLen                                 = 8
+	seidLen                                   = 8
+	maxSequence                               = 0xffffff
+	nodeResponseTypes                         = "\x02\x04\x06\x08\x0a\x0b\x0d\x0f"
+	sessionResponseTypes                      = "\x33\x35\x37\x39"
+)
+
+var (
+	// ErrInvalidMessage indicates that a message can't be decoded.
+	ErrInvalidMessage = errors.New("invalid PFCP message")
+	// ErrMissingIE indicates that a mandatory IE wasn't included in the message.
+	ErrMissingIE = errors.New("missing mandatory IE")
+)
+
+// Message is a PFCP message. Session related messages carry the SEID of the receiver.
+type Message struct {
+	Type     uint8
+	HasSEID  bool
+	SEID     uint64
+	Sequence uint32
+	IEs      []*IE
+}
+
+// NewNodeMessage creates a node related message.
+func NewNodeMessage(msgType uint8, ies ...*IE) *Message {
+	return &Message{Type: msgType, IEs: compact(ies)}
+}
+
+// NewSessionMessage creates a session related message addressed to the given SEID.
+func NewSessionMessage(msgType uint8, seid uint64, ies ...*IE) *Message {
+	return &Message{Type: msgType, HasSEID: true, SEID: seid, IEs: compact(ies)}
+}
+
+func compact(ies []*IE) []*IE {
+	result := []*IE{}
+
+	for _, i := range ies {
+		if i != nil {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+// IsResponse checks if the message answers a request.
+func (m *Message) IsResponse() bool {
+	for _, response := range nodeResponseTypes + sessionResponseTypes {
+		if uint8(response) == m.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find retrieves the first IE with the given type.
+func (m *Message) Find(ieType uint16) *IE {
+	return find(m.IEs, ieType)
+}
+
+// FindAll retrieves the IEs with the given type.
+func (m *Message) FindAll(ieType uint16) []*IE {
+	return findAll(m.IEs, ieType)
+}
+
+// Cause retrieves the value of the Cause IE.
+func (m *Message) Cause() (uint8, error) {
+	cause := m.Find(Cause)
+	if cause == nil {
+		return 0, errors.Wrap(ErrMissingIE, "no cause")
+	}
+
+	return cause.Uint8()
+}
+
+// Marshal encodes the message.
+func (m *Message) Marshal() []byte {
+	length := headerLen
+	if m.HasSEID {
+		length += seidLen
+	}
+
+	for _, i := range m.IEs {
+		length += i.MarshalLen()
+	}
+
+	b := make([]byte, length)
+	b[0] = version << 5
+	b[1] = m.Type
+	binary.BigEndian.PutUint16(b[2:], uint16(length-4))
+
+	offset := 4
+
+	if m.HasSEID {
+		b[0] |= seidFlag
+		binary.BigEndian.PutUint64(b[offset:], m.SEID)
+		offset += seidLen
+	}
+
+	binary.BigEndian.PutUint32(b[offset:], m.Sequence<<8)
+	offset += 4
+
+	for _, i := range m.IEs {
+		offset += i.MarshalTo(b[offset:])
+	}
+
+	return b
+}
+
+// Parse decodes a PFCP message.
+func Parse(b []byte) (*Message, error) {
+	if len(b) < headerLen {
+		return nil, errors.Wrap(ErrInvalidMessage, "short header")
+	}
+
+	if b[0]>>5 != version {
+		return nil, errors.Wrapf(ErrInvalidMessage, "unsupported %d version", b[0]>>5)
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:])) + 4
+	if len(b) < length || length < headerLen {
+		return nil, errors.Wrap(ErrInvalidLength, "truncated message")
+	}
+
+	m := &Message{Type: b[1], HasSEID: b[0]&seidFlag != 0}
+	offset := 4
+
+	if m.HasSEID {
+		if length < headerLen+seidLen {
+			return nil, errors.Wrap(ErrInvalidMessage, "short session header")
+		}
+
+		m.SEID = binary.BigEndian.Uint64(b[offset:])
+		offset += seidLen
+	}
+
+	m.Sequence = binary.BigEndian.Uint32(b[offset:]) >> 8
+	offset += 4
+
+	ies, err := ParseIEs(b[offset:length])
+	if err != nil {
+		return nil, err
+	}
+
+	m.IEs = ies
+
+	return m, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcp_test
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/protocols/pfcp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Message", func() {
+	var (
+		upf = net.ParseIP("172.25.0.2").To4()
+		sgw = net.ParseIP("172.25.0.3").To4()
+		ms  = net.ParseIP("10.0.1.2").To4()
+	)
+
+	Describe("encoding and decoding messages", func() {
+		It("should keep the header and the IEs", func() {
+			msg := pfcp.NewSessionMessage(pfcp.MsgTypeSessionEstablishmentResponse, 10,
+				pfcp.NewCause(pfcp.CauseRequestAccepted), pfcp.NewFSEID(20, upf))
+			msg.Sequence = 30
+
+			decoded, err := pfcp.Parse(msg.Marshal())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded.Type).To(Equal(pfcp.MsgTypeSessionEstablishmentResponse))
+			Expect(decoded.IsResponse()).To(BeTrue())
+			Expect(decoded.HasSEID).To(BeTrue())
+			Expect(decoded.SEID).To(Equal(uint64(10)))
+			Expect(decoded.Sequence).To(Equal(uint32(30)))
+
+			cause, err := decoded.Cause()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cause).To(Equal(pfcp.CauseRequestAccepted))
+
+			seid, ip, err := decoded.Find(pfcp.FSEID).FSEID()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(seid).To(Equal(uint64(20)))
+			Expect(ip.Equal(upf)).To(BeTrue())
+		})
+		Context("when the message is truncated", func() {
+			It("should raise an error", func() {
+				encoded := pfcp.NewNodeMessage(pfcp.MsgTypeHeartbeatRequest).Marshal()
+				_, err := pfcp.Parse(encoded[:len(encoded)-1])
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("encoding and decoding rules", func() {
+		It("should keep the PDR components", func() {
+			pdr := &pfcp.PDR{
+				ID:              2,
+				Precedence:      10,
+				SourceInterface: pfcp.InterfaceCore,
+				UEAddress:       ms,
+				Filters:         []*pfcp.Filter{{FlowDescription: "permit out 17 from any 5060 to assigned"}},
+				FARID:           3,
+				QERIDs:          []uint32{6},
+				URRIDs:          []uint32{1},
+			}
+
+			ies, err := pfcp.ParseIEs(marshal(pdr.IE()))
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := pfcp.ParsePDR(ies[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(pdr))
+		})
+		It("should keep the FAR components", func() {
+			far := &pfcp.FAR{
+				ID:                   3,
+				ApplyAction:          pfcp.ApplyActionForward,
+				DestinationInterface: pfcp.InterfaceAccess,
+				OuterHeaderTEID:      100,
+				OuterHeaderAddress:   sgw,
+			}
+
+			ies, err := pfcp.ParseIEs(marshal(far.IE()))
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := pfcp.ParseFAR(ies[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(far))
+		})
+		It("should keep the QER bit rates", func() {
+			qer := &pfcp.QER{ID: 5, MBRUplink: 1000, MBRDownlink: 2000, GBRUplink: 100, GBRDownlink: 200}
+
+			ies, err := pfcp.ParseIEs(marshal(qer.IE()))
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := pfcp.ParseQER(ies[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(qer))
+		})
+		Context("when a mandatory IE is missing", func() {
+			It("should raise an error", func() {
+				_, err := pfcp.ParseFAR(pfcp.NewGroupedIE(pfcp.CreateFAR, pfcp.NewFARID(1)))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
+
+func marshal(i *pfcp.IE) []byte {
+	b := make([]byte, i.MarshalLen())
+	i.MarshalTo(b)
+
+	return b
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pfcp
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// PDR stores a Packet Detection Rule.
+type PDR struct {
+	ID                 uint16
+	Precedence         uint32
+	SourceInterface    uint8
+	TEID               uint32
+	TEIDAddress        net.IP
+	UEAddress          net.IP
+	Filters            []*Filter
+	OuterHeaderRemoval bool
+	FARID              uint32
+	QERIDs             []uint32
+	URRIDs             []uint32
+}
+
+// FAR stores a Forwarding Action Rule.
+type FAR struct {
+	ID                   uint32
+	ApplyAction          uint8
+	DestinationInterface uint8
+	OuterHeaderTEID      uint32
+	OuterHeaderAddress   net.IP
+}
+
+// QER stores a QoS Enforcement Rule. Bit rates are expressed in kbps.
+type QER struct {
+	ID          uint32
+	MBRUplink   uint64
+	MBRDownlink uint64
+	GBRUplink   uint64
+	GBRDownlink uint64
+}
+
+// URR stores an Usage Reporting Rule.
+type URR struct {
+	ID                uint32
+	MeasurementMethod uint8
+	ReportingTriggers uint16
+}
+
+// IE encodes the rule as a Create PDR IE.
+func (p *PDR) IE() *IE {
+	pdi := NewGroupedIE(PDI, NewSourceInterface(p.SourceInterface))
+
+	if p.TEIDAddress != nil {
+		pdi.ChildIEs = append(pdi.ChildIEs, NewFTEID(p.TEID, p.TEIDAddress))
+	}
+
+	if p.UEAddress != nil {
+		pdi.ChildIEs = append(pdi.ChildIEs, NewUEIPAddress(p.UEAddress, p.SourceInterface == InterfaceCore))
+	}
+
+	for _, filter := range p.Filters {
+		pdi.ChildIEs = append(pdi.ChildIEs, NewSDFFilter(filter))
+	}
+
+	pdr := NewGroupedIE(CreatePDR, NewPDRID(p.ID), NewPrecedence(p.Precedence), pdi)
+
+	if p.OuterHeaderRemoval {
+		pdr.ChildIEs = append(pdr.ChildIEs, NewOuterHeaderRemoval())
+	}
+
+	pdr.ChildIEs = append(pdr.ChildIEs, NewFARID(p.FARID))
+
+	for _, id := range p.URRIDs {
+		pdr.ChildIEs = append(pdr.ChildIEs, NewURRID(id))
+	}
+
+	for _, id := range p.QERIDs {
+		pdr.ChildIEs = append(pdr.ChildIEs, NewQERID(id))
+	}
+
+	return pdr
+}
+
+// ParsePDR decodes a Create PDR IE.
+func ParsePDR(i *IE) (*PDR, error) {
+	var err error
+
+	pdr := &PDR{}
+
+	if pdr.ID, err = mandatory(i, PDRID).Uint16(); err != nil {
+		return nil, errors.Wrap(err, "failed to get PDR ID")
+	}
+
+	if pdr.Precedence, err = mandatory(i, Precedence).Uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to get precedence")
+	}
+
+	if pdr.FARID, err = mandatory(i, FARID).Uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to get FAR ID")
+	}
+
+	pdr.OuterHeaderRemoval = i.Find(OuterHeaderRemoval) != nil
+
+	for _, child := range i.FindAll(QERID) {
+		id, err := child.Uint32()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get QER ID")
+		}
+
+		pdr.QERIDs = append(pdr.QERIDs, id)
+	}
+
+	for _, child := range i.FindAll(URRID) {
+		id, err := child.Uint32()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get URR ID")
+		}
+
+		pdr.URRIDs = append(pdr.URRIDs, id)
+	}
+
+	if err := pdr.parsePDI(mandatory(i, PDI)); err != nil {
+		return nil, err
+	}
+
+	return pdr, nil
+}
+
+func (p *PDR) parsePDI(pdi *IE) error {
+	var err error
+
+	if p.SourceInterface, err = mandatory(pdi, SourceInterface).Uint8(); err != nil {
+		return errors.Wrap(err, "failed to get source interface")
+	}
+
+	if fteid := pdi.Find(FTEID); fteid != nil {
+		if p.TEID, p.TEIDAddress, err = fteid.FTEID(); err != nil {
+			return err
+		}
+	}
+
+	if ueIP := pdi.Find(UEIPAddress); ueIP != nil {
+		if p.UEAddress, err = ueIP.UEIPAddress(); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range pdi.FindAll(SDFFilter) {
+		filter, err := child.SDFFilter()
+		if err != nil {
+			return err
+		}
+
+		p.Filters = append(p.Filters, filter)
+	}
+
+	return nil
+}
+
+// IE encodes the rule as a Create FAR IE.
+func (f *FAR) IE() *IE {
+	far := NewGroupedIE(CreateFAR, NewFARID(f.ID), NewApplyAction(f.ApplyAction))
+
+	if f.ApplyAction&ApplyActionForward != 0 {
+		parameters := NewGroupedIE(ForwardingParameters, NewDestinationInterface(f.DestinationInterface))
+		if f.OuterHeaderAddress != nil {
+			parameters.ChildIEs = append(parameters.ChildIEs,
+				NewOuterHeaderCreation(f.OuterHeaderTEID, f.OuterHeaderAddress))
+		}
+
+		far.ChildIEs = append(far.ChildIEs, parameters)
+	}
+
+	return far
+}
+
+// UpdateIE encodes the forwarding parameters of the rule as an Update FAR IE.
+func (f *FAR) UpdateIE() *IE {
+	parameters := NewGroupedIE(UpdateForwardingParameters, NewDestinationInterface(f.DestinationInterface))
+	if f.OuterHeaderAddress != nil {
+		parameters.ChildIEs = append(parameters.ChildIEs,
+			NewOuterHeaderCreation(f.OuterHeaderTEID, f.OuterHeaderAddress))
+	}
+
+	return NewGroupedIE(UpdateFAR, NewFARID(f.ID), NewApplyAction(f.ApplyAction), parameters)
+}
+
+// ParseFAR decodes a Create FAR or an Update FAR IE.
+func ParseFAR(i *IE) (*FAR, error) {
+	var err error
+
+	far := &FAR{}
+
+	if far.ID, err = mandatory(i, FARID).Uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to get FAR ID")
+	}
+
+	if far.ApplyAction, err = mandatory(i, ApplyAction).Uint8(); err != nil {
+		return nil, errors.Wrap(err, "failed to get apply action")
+	}
+
+	parameters := i.Find(ForwardingParameters)
+	if parameters == nil {
+		parameters = i.Find(UpdateForwardingParameters)
+	}
+
+	if parameters == nil {
+		return far, nil
+	}
+
+	if far.DestinationInterface, err = mandatory(parameters, DestinationInterface).Uint8(); err != nil {
+		return nil, errors.Wrap(err, "failed to get destination interface")
+	}
+
+	if creation := parameters.Find(OuterHeaderCreation); creation != nil {
+		if far.OuterHeaderTEID, far.OuterHeaderAddress, err = creation.OuterHeaderCreation(); err != nil {
+			return nil, err
+		}
+	}
+
+	return far, nil
+}
+
+// IE encodes the rule as a Create QER IE.
+func (q *QER) IE() *IE {
+	return NewGroupedIE(CreateQER, NewQERID(q.ID), NewGateStatus(),
+		NewMBR(q.MBRUplink, q.MBRDownlink), NewGBR(q.GBRUplink, q.GBRDownlink))
+}
+
+// ParseQER decodes a Create QER IE.
+func ParseQER(i *IE) (*QER, error) {
+	var err error
+
+	qer := &QER{}
+
+	if qer.ID, err = mandatory(i, QERID).Uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to get QER ID")
+	}
+
+	if mbr := i.Find(MBR); mbr != nil {
+		if qer.MBRUplink, qer.MBRDownlink, err = mbr.BitRates(); err != nil {
+			return nil, err
+		}
+	}
+
+	if gbr := i.Find(GBR); gbr != nil {
+		if qer.GBRUplink, qer.GBRDownlink, err = gbr.BitRates(); err != nil {
+			return nil, err
+		}
+	}
+
+	return qer, nil
+}
+
+// IE encodes the rule as a Create URR IE.
+func (u *URR) IE() *IE {
+	return NewGroupedIE(CreateURR, NewURRID(u.ID), NewMeasurementMethod(u.MeasurementMethod),
+		NewReportingTriggers(u.ReportingTriggers))
+}
+
+// ParseURR decodes a Create URR IE.
+func ParseURR(i *IE) (*URR, error) {
+	var err error
+
+	urr := &URR{}
+
+	if urr.ID, err = mandatory(i, URRID).Uint32(); err != nil {
+		return nil, errors.Wrap(err, "failed to get URR ID")
+	}
+
+	if urr.MeasurementMethod, err = mandatory(i, MeasurementMethod).Uint8(); err != nil {
+		return nil, errors.Wrap(err, "failed to get measurement method")
+	}
+
+	if triggers := i.Find(ReportingTriggers); triggers != nil {
+		if urr.ReportingTriggers, err = triggers.Uint16(); err != nil {
+			return nil, err
+		}
+	}
+
+	return urr, nil
+}
+
+// mandatory retrieves a member of a grouped IE, returning an empty IE when it's missing
+// so its decoding fails.
+func mandatory(grouped *IE, ieType uint16) *IE {
+	if found := grouped.Find(ieType); found != nil {
+		return found
+	}
+
+	return &IE{Type: ieType}
+}
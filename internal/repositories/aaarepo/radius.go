@@ -0,0 +1,300 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aaarepo
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RADIUS codes and attributes used by the client (RFC 2865).
+const (
+	codeAccessRequest uint8 = 1
+	codeAccessAccept  uint8 = 2
+	codeAccessReject  uint8 = 3
+
+	attrUserName         uint8 = 1
+	attrUserPassword     uint8 = 2
+	attrCHAPPassword     uint8 = 3
+	attrNASIPAddress     uint8 = 4
+	attrFramedIPAddress  uint8 = 8
+	attrVendorSpecific   uint8 = 26
+	attrCalledStationID  uint8 = 30
+	attrCallingStationID uint8 = 31
+	attrCHAPChallenge    uint8 = 60
+
+	vendor3GPP     uint32 = 10415
+	vendor3GPPIMSI uint8  = 1
+
+	headerLength        = 20
+	authenticatorLength = 16
+	maxPacketLength     = 4096
+)
+
+var (
+	// ErrInvalidResponse indicates that the AAA server sent an unexpected response.
+	ErrInvalidResponse = errors.New("invalid RADIUS response")
+	// ErrNoCredentials indicates that the UE didn't provide PAP or CHAP credentials.
+	ErrNoCredentials = errors.New("no credentials provided")
+)
+
+type radiusClient struct {
+	url        string
+	secret     []byte
+	timeout    time.Duration
+	retries    int
+	identifier uint32
+}
+
+// NewRadius creates a new instance to authenticate subscribers against a RADIUS Server.
+func NewRadius(url, secret string) ports.Authenticator {
+	log.WithFields(log.Fields{
+		"RADIUS URL": url,
+	}).Debug("Creating RADIUS client")
+
+	return &radiusClient{
+		url:     url,
+		secret:  []byte(secret),
+		timeout: time.Duration(3) * time.Second,
+		retries: 3,
+	}
+}
+
+// Authenticate sends an Access-Request and waits for the server decision.
+func (c *radiusClient) Authenticate(credentials *domain.Credentials) (*domain.Authorization, error) {
+	if credentials.Protocol == domain.AuthenticationNone {
+		return nil, errors.Wrap(domain.ErrAuthenticationRejected, ErrNoCredentials.Error())
+	}
+
+	request, err := c.newAccessRequest(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.exchange(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response[0] {
+	case codeAccessAccept:
+		authorization := &domain.Authorization{}
+
+		if value, ok := getAttribute(response[headerLength:], attrFramedIPAddress); ok && len(value) == net.IPv4len {
+			authorization.FramedIP = net.IP(value)
+		}
+
+		log.WithFields(log.Fields{
+			"username": credentials.Username,
+			"ip":       authorization.FramedIP,
+		}).Debug("Subscriber authenticated")
+
+		return authorization, nil
+	case codeAccessReject:
+		return nil, errors.Wrapf(domain.ErrAuthenticationRejected, "%s user", credentials.Username)
+	default:
+		return nil, errors.Wrapf(ErrInvalidResponse, "unexpected %d code", response[0])
+	}
+}
+
+// Status is used for performing a RADIUS check against a dependency.
+func (c *radiusClient) Status() (interface{}, error) {
+	return nil, nil
+}
+
+func (c *radiusClient) newAccessRequest(credentials *domain.Credentials) ([]byte, error) {
+	authenticator := make([]byte, authenticatorLength)
+	if _, err := rand.Read(authenticator); err != nil {
+		return nil, errors.Wrap(err, "failed to generate request authenticator")
+	}
+
+	attributes := &bytes.Buffer{}
+	appendAttribute(attributes, attrUserName, []byte(credentials.Username))
+
+	switch credentials.Protocol {
+	case domain.AuthenticationPAP:
+		appendAttribute(attributes, attrUserPassword, c.hidePassword([]byte(credentials.Password), authenticator))
+	case domain.AuthenticationCHAP:
+		appendAttribute(attributes, attrCHAPPassword, append([]byte{credentials.ChapID}, credentials.Response...))
+		// the server uses the Request Authenticator as challenge when it's omitted (RFC 2865 section 2.2)
+		if len(credentials.Challenge) > 0 {
+			appendAttribute(attributes, attrCHAPChallenge, credentials.Challenge)
+		}
+	case domain.AuthenticationNone:
+	}
+
+	if ip := net.ParseIP(credentials.NasAddress).To4(); ip != nil {
+		appendAttribute(attributes, attrNASIPAddress, ip)
+	}
+
+	if credentials.Apn != "" {
+		appendAttribute(attributes, attrCalledStationID, []byte(credentials.Apn))
+	}
+
+	if credentials.MSISDN != "" {
+		appendAttribute(attributes, attrCallingStationID, []byte(credentials.MSISDN))
+	}
+
+	if credentials.IMSI != "" {
+		vsa := make([]byte, 6, 6+len(credentials.IMSI))
+		binary.BigEndian.PutUint32(vsa, vendor3GPP)
+		vsa[4] = vendor3GPPIMSI
+		vsa[5] = uint8(2 + len(credentials.IMSI))
+		appendAttribute(attributes, attrVendorSpecific, append(vsa, credentials.IMSI...))
+	}
+
+	packet := make([]byte, headerLength, headerLength+attributes.Len())
+	packet[0] = codeAccessRequest
+	packet[1] = uint8(atomic.AddUint32(&c.identifier, 1))
+	binary.BigEndian.PutUint16(packet[2:4], uint16(headerLength+attributes.Len()))
+	copy(packet[4:headerLength], authenticator)
+
+	return append(packet, attributes.Bytes()...), nil
+}
+
+// hidePassword encrypts the User-Password attribute value (RFC 2865 section 5.2).
+func (c *radiusClient) hidePassword(password, authenticator []byte) []byte {
+	length := (len(password) + authenticatorLength - 1) / authenticatorLength * authenticatorLength
+	if length == 0 {
+		length = authenticatorLength
+	}
+
+	result := make([]byte, length)
+	copy(result, password)
+
+	previous := authenticator
+	for i := 0; i < length; i += authenticatorLength {
+		hash := md5.Sum(append(append([]byte{}, c.secret...), previous...)) //nolint:gosec
+		for j := range hash {
+			result[i+j] ^= hash[j]
+		}
+
+		previous = result[i : i+authenticatorLength]
+	}
+
+	return result
+}
+
+func (c *radiusClient) exchange(request []byte) ([]byte, error) {
+	conn, err := net.Dial("udp", c.url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the RADIUS server")
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, maxPacketLength)
+
+	for attempt := 0; attempt < c.retries; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, errors.Wrap(err, "failed to send the Access-Request")
+		}
+
+		if err := conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, errors.Wrap(err, "failed to set RADIUS read deadline")
+		}
+
+		response, err := c.read(conn, buffer, request)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.WithField("attempt", attempt+1).Debug("RADIUS request timed out")
+
+				continue
+			}
+
+			return nil, err
+		}
+
+		return response, nil
+	}
+
+	return nil, errors.Wrapf(ErrInvalidResponse, "no answer from %s", c.url)
+}
+
+// read waits until the read deadline for the response of the request, the late responses to
+// previous requests are dropped.
+func (c *radiusClient) read(conn net.Conn, buffer, request []byte) ([]byte, error) {
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the RADIUS response")
+		}
+
+		response := buffer[:n]
+		if len(response) >= headerLength && response[1] != request[1] {
+			log.WithField("identifier", response[1]).Debug("Dropping unexpected RADIUS response")
+
+			continue
+		}
+
+		if err := c.verify(request, response); err != nil {
+			return nil, err
+		}
+
+		return response, nil
+	}
+}
+
+func (c *radiusClient) verify(request, response []byte) error {
+	if len(response) < headerLength || int(binary.BigEndian.Uint16(response[2:4])) > len(response) {
+		return errors.Wrap(ErrInvalidResponse, "malformed packet")
+	}
+
+	response = response[:binary.BigEndian.Uint16(response[2:4])]
+
+	hash := md5.New() //nolint:gosec
+	hash.Write(response[:4])
+	hash.Write(request[4:headerLength])
+	hash.Write(response[headerLength:])
+	hash.Write(c.secret)
+
+	if !bytes.Equal(hash.Sum(nil), response[4:headerLength]) {
+		return errors.Wrap(ErrInvalidResponse, "response authenticator mismatch")
+	}
+
+	return nil
+}
+
+func appendAttribute(buffer *bytes.Buffer, attrType uint8, value []byte) {
+	buffer.WriteByte(attrType)
+	buffer.WriteByte(uint8(2 + len(value)))
+	buffer.Write(value)
+}
+
+func getAttribute(attributes []byte, attrType uint8) ([]byte, bool) {
+	for len(attributes) >= 2 {
+		length := int(attributes[1])
+		if length < 2 || length > len(attributes) {
+			return nil, false
+		}
+
+		if attributes[0] == attrType {
+			return attributes[2:length], true
+		}
+
+		attributes = attributes[length:]
+	}
+
+	return nil, false
+}
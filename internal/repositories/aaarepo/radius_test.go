@@ -0,0 +1,278 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aaarepo_test
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"encoding/binary"
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/repositories/aaarepo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	secret   = "testing123"
+	username = "corporate"
+	password = "secret"
+)
+
+var framedIP = net.IPv4(10, 0, 1, 20).To4()
+
+// serveRadius answers Access-Requests accepting only the PAP password or the CHAP response of the
+// testing user, the stale responses are preceded by the answer to a previous request.
+func serveRadius(conn net.PacketConn, stale bool) {
+	buffer := make([]byte, 4096)
+
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		request := buffer[:n]
+		code, attributes := uint8(3), []byte{}
+
+		if isAuthenticated(request) {
+			code = 2
+			attributes = append([]byte{8, 6}, framedIP...)
+		}
+
+		if stale {
+			if _, err := conn.WriteTo(newResponse(request, request[1]-1, 3, nil), addr); err != nil {
+				return
+			}
+		}
+
+		if _, err := conn.WriteTo(newResponse(request, request[1], code, attributes), addr); err != nil {
+			return
+		}
+	}
+}
+
+// isAuthenticated verifies the credentials of the request, the CHAP challenge defaults to the
+// Request Authenticator.
+func isAuthenticated(request []byte) bool {
+	if hidden := getAttribute(request[20:], 2); hidden != nil {
+		return reveal(hidden, request[4:20]) == password
+	}
+
+	chap := getAttribute(request[20:], 3)
+	if len(chap) < 2 {
+		return false
+	}
+
+	challenge := getAttribute(request[20:], 60)
+	if challenge == nil {
+		challenge = request[4:20]
+	}
+
+	return bytes.Equal(chap[1:], aaarepo.CHAPResponse(chap[0], password, challenge))
+}
+
+func newResponse(request []byte, identifier, code uint8, attributes []byte) []byte {
+	response := make([]byte, 20, 20+len(attributes))
+	response[0] = code
+	response[1] = identifier
+	binary.BigEndian.PutUint16(response[2:4], uint16(20+len(attributes)))
+	response = append(response, attributes...)
+
+	hash := md5.New() //nolint:gosec
+	hash.Write(response[:4])
+	hash.Write(request[4:20])
+	hash.Write(attributes)
+	hash.Write([]byte(secret))
+	copy(response[4:20], hash.Sum(nil))
+
+	return response
+}
+
+func getAttribute(attributes []byte, attrType uint8) []byte {
+	for len(attributes) >= 2 {
+		if attributes[0] == attrType {
+			return attributes[2:attributes[1]]
+		}
+
+		attributes = attributes[attributes[1]:]
+	}
+
+	return nil
+}
+
+func reveal(hidden, authenticator []byte) string {
+	result := make([]byte, len(hidden))
+	previous := authenticator
+
+	for i := 0; i+16 <= len(hidden); i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...)) //nolint:gosec
+		for j := range hash {
+			result[i+j] = hidden[i+j] ^ hash[j]
+		}
+
+		previous = hidden[i : i+16]
+	}
+
+	return string(bytes.TrimRight(result, "\x00"))
+}
+
+var _ = Describe("Radius", func() {
+	var (
+		authenticator ports.Authenticator
+		server        net.PacketConn
+		stale         bool
+	)
+
+	BeforeEach(func() {
+		stale = false
+	})
+
+	JustBeforeEach(func() {
+		var err error
+
+		server, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		go serveRadius(server, stale)
+
+		authenticator = aaarepo.NewRadius(server.LocalAddr().String(), secret)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("authenticating PAP credentials", func() {
+		Context("when the password is valid", func() {
+			It("should return the Framed IP address", func() {
+				authorization, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol: domain.AuthenticationPAP,
+					Username: username,
+					Password: password,
+					IMSI:     "123451234567891",
+					Apn:      "corporate.apn",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(authorization.FramedIP.Equal(framedIP)).To(BeTrue())
+			})
+		})
+		Context("when the password is invalid", func() {
+			It("should be rejected", func() {
+				_, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol: domain.AuthenticationPAP,
+					Username: username,
+					Password: "invalid",
+				})
+				Expect(err).To(MatchError(domain.ErrAuthenticationRejected))
+			})
+		})
+	})
+
+	Describe("authenticating CHAP credentials", func() {
+		Context("when the UE doesn't send a challenge", func() {
+			It("should use the Request Authenticator as challenge", func() {
+				// The response calculated for an empty challenge only matches when the attribute is sent
+				_, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol: domain.AuthenticationCHAP,
+					Username: username,
+					ChapID:   1,
+					Response: aaarepo.CHAPResponse(1, password, nil),
+				})
+				Expect(err).To(MatchError(domain.ErrAuthenticationRejected))
+			})
+		})
+		Context("when the UE sends a challenge", func() {
+			It("should return the Framed IP address", func() {
+				challenge := []byte("0123456789abcdef")
+
+				authorization, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol:  domain.AuthenticationCHAP,
+					Username:  username,
+					ChapID:    1,
+					Challenge: challenge,
+					Response:  aaarepo.CHAPResponse(1, password, challenge),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(authorization.FramedIP.Equal(framedIP)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("receiving a late response to a previous request", func() {
+		BeforeEach(func() {
+			stale = true
+		})
+		It("should drop it and wait for the response", func() {
+			authorization, err := authenticator.Authenticate(&domain.Credentials{
+				Protocol: domain.AuthenticationPAP,
+				Username: username,
+				Password: password,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authorization.FramedIP.Equal(framedIP)).To(BeTrue())
+		})
+	})
+
+	Describe("authenticating without credentials", func() {
+		It("should be rejected", func() {
+			_, err := authenticator.Authenticate(&domain.Credentials{})
+			Expect(err).To(MatchError(domain.ErrAuthenticationRejected))
+		})
+	})
+})
+
+var _ = Describe("Stub", func() {
+	var authenticator ports.Authenticator
+
+	BeforeEach(func() {
+		authenticator = aaarepo.NewStub(aaarepo.Account{
+			Username: username,
+			Password: password,
+			FramedIP: framedIP,
+		})
+	})
+
+	Describe("authenticating CHAP credentials", func() {
+		challenge := []byte("0123456789abcdef")
+
+		Context("when the response is valid", func() {
+			It("should return the Framed IP address", func() {
+				authorization, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol:  domain.AuthenticationCHAP,
+					Username:  username,
+					ChapID:    1,
+					Challenge: challenge,
+					Response:  aaarepo.CHAPResponse(1, password, challenge),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(authorization.FramedIP.Equal(framedIP)).To(BeTrue())
+			})
+		})
+		Context("when the response is invalid", func() {
+			It("should be rejected", func() {
+				_, err := authenticator.Authenticate(&domain.Credentials{
+					Protocol:  domain.AuthenticationCHAP,
+					Username:  username,
+					ChapID:    1,
+					Challenge: challenge,
+					Response:  aaarepo.CHAPResponse(2, password, challenge),
+				})
+				Expect(err).To(MatchError(domain.ErrAuthenticationRejected))
+			})
+		})
+	})
+})
@@ -0,0 +1,83 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aaarepo
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+// Account stores the credentials and authorization values of a subscriber known by the stub.
+type Account struct {
+	Username string
+	Password string
+	FramedIP net.IP
+}
+
+type stub struct {
+	accounts map[string]Account
+}
+
+// NewStub creates a new local AAA instance which authenticates the given accounts.
+func NewStub(accounts ...Account) ports.Authenticator {
+	repo := &stub{accounts: map[string]Account{}}
+	for _, account := range accounts {
+		repo.accounts[account.Username] = account
+	}
+
+	return repo
+}
+
+// Authenticate verifies PAP or CHAP credentials against the local accounts.
+func (repo *stub) Authenticate(credentials *domain.Credentials) (*domain.Authorization, error) {
+	account, ok := repo.accounts[credentials.Username]
+	if !ok {
+		return nil, errors.Wrapf(domain.ErrAuthenticationRejected, "unknown %s user", credentials.Username)
+	}
+
+	switch credentials.Protocol {
+	case domain.AuthenticationPAP:
+		if credentials.Password != account.Password {
+			return nil, errors.Wrapf(domain.ErrAuthenticationRejected, "invalid %s user password", credentials.Username)
+		}
+	case domain.AuthenticationCHAP:
+		if !bytes.Equal(CHAPResponse(credentials.ChapID, account.Password, credentials.Challenge), credentials.Response) {
+			return nil, errors.Wrapf(domain.ErrAuthenticationRejected, "invalid %s user response", credentials.Username)
+		}
+	case domain.AuthenticationNone:
+		return nil, errors.Wrap(domain.ErrAuthenticationRejected, ErrNoCredentials.Error())
+	}
+
+	return &domain.Authorization{FramedIP: account.FramedIP}, nil
+}
+
+// Status is used for performing a stub check against a dependency.
+func (repo *stub) Status() (interface{}, error) {
+	return nil, nil
+}
+
+// CHAPResponse calculates the expected CHAP response value (RFC 1994).
+func CHAPResponse(id uint8, password string, challenge []byte) []byte {
+	hash := md5.New() //nolint:gosec
+	hash.Write([]byte{id})
+	hash.Write([]byte(password))
+	hash.Write(challenge)
+
+	return hash.Sum(nil)
+}
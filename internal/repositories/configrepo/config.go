@@ -0,0 +1,573 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configrepo
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidConfig indicates that the configuration has unknown, missing or wrong values.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// plmnPattern matches the MCC and MNC digits of a PLMN, which are optional in the firewall rules.
+var plmnPattern = regexp.MustCompile(`^([0-9]{5,6})?$`)
+
+// Config stores the settings of the P-GW which can be provided through a YAML file.
+type Config struct {
+	Log            Log            `yaml:"log"`
+	Datastore      Datastore      `yaml:"datastore"`
+	Networks       Networks       `yaml:"networks"`
+	Sgi            Sgi            `yaml:"sgi"`
+	Aaa            Aaa            `yaml:"aaa"`
+	Datapath       string         `yaml:"datapath"`
+	Function       string         `yaml:"function"`
+	Sxb            Sxb            `yaml:"sxb"`
+	Traces         Traces         `yaml:"traces"`
+	Capture        Capture        `yaml:"capture"`
+	Events         Events         `yaml:"events"`
+	Drain          Drain          `yaml:"drain"`
+	Retransmission Retransmission `yaml:"retransmission"`
+	Overload       Overload       `yaml:"overload"`
+	Firewall       Firewall       `yaml:"firewall"`
+	Apns           []Apn          `yaml:"apns"`
+}
+
+// Log stores the logging settings.
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Datastore stores the connection settings of the shared datastore.
+type Datastore struct {
+	RedisURL      string `yaml:"redisURL"`
+	RedisPassword string `yaml:"redisPassword"`
+	EtcdURL       string `yaml:"etcdURL"`
+}
+
+// Networks stores the CIDRs used for discovering the S5 addresses.
+type Networks struct {
+	S5u string `yaml:"s5u"`
+	S5c string `yaml:"s5c"`
+}
+
+// Sgi stores the SGi interface and the subnet of the UE addresses.
+type Sgi struct {
+	Nic    string `yaml:"nic"`
+	Subnet string `yaml:"subnet"`
+}
+
+// Aaa stores the RADIUS server used for authenticating subscribers.
+type Aaa struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+}
+
+// Sxb stores the PFCP settings of the control and user functions.
+type Sxb struct {
+	Address string `yaml:"address"`
+	Peer    string `yaml:"peer"`
+}
+
+// Traces stores the exporter of the GTP-C transaction spans.
+type Traces struct {
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Capture stores the settings of the packet capture files.
+type Capture struct {
+	Directory string `yaml:"directory"`
+	FileSize  int64  `yaml:"fileSize"`
+	Files     int    `yaml:"files"`
+}
+
+// Events stores the sink of the session events.
+type Events struct {
+	Sink   string `yaml:"sink"`
+	Target string `yaml:"target"`
+}
+
+// Drain stores how the sessions are drained on SIGTERM.
+type Drain struct {
+	Timeout       time.Duration `yaml:"timeout"`
+	DeleteBearers bool          `yaml:"deleteBearers"`
+}
+
+// Retransmission stores the timer and counter used by the S-GWs to retransmit their requests.
+type Retransmission struct {
+	T3 time.Duration `yaml:"t3"`
+	N3 int           `yaml:"n3"`
+}
+
+// Overload stores the admission limits of the new sessions and the load reported to the S-GWs,
+// a zero limit is disabled.
+type Overload struct {
+	MaxSessions        int           `yaml:"maxSessions"`
+	MaxApnSessions     int           `yaml:"maxApnSessions"`
+	MaxCreateRate      int           `yaml:"maxCreateRate"`
+	MaxPendingRequests int           `yaml:"maxPendingRequests"`
+	Threshold          int           `yaml:"threshold"`
+	Validity           time.Duration `yaml:"validity"`
+}
+
+// Firewall stores the S5/S8 peers allowed to create sessions, any peer is allowed when
+// the list is empty.
+type Firewall struct {
+	Action         string `yaml:"action"`
+	ValidateSender bool   `yaml:"validateSender"`
+	Peers          []Peer `yaml:"peers"`
+}
+
+// Peer stores the networks of the S-GWs serving a PLMN, any PLMN matches when it's empty.
+type Peer struct {
+	PLMN     string   `yaml:"plmn"`
+	Networks []string `yaml:"networks"`
+}
+
+// Apn stores the settings of an Access Point Name.
+type Apn struct {
+	Name         string   `yaml:"name"`
+	Authenticate bool     `yaml:"authenticate"`
+	DNS          []string `yaml:"dns"`
+}
+
+// Default creates a configuration with the default values of the P-GW.
+func Default() *Config {
+	return &Config{
+		Log: Log{
+			Level:  log.InfoLevel.String(),
+			Format: "text",
+		},
+		Datapath: domain.DatapathKernel,
+		Function: domain.FunctionCombined,
+		Sxb: Sxb{
+			Address: domain.PFCPPort,
+		},
+		Traces: Traces{
+			Exporter: "none",
+		},
+		Capture: Capture{
+			Directory: domain.DefaultCaptureDirectory,
+			FileSize:  domain.DefaultCaptureMaxFileSize,
+			Files:     domain.DefaultCaptureMaxFiles,
+		},
+		Events: Events{
+			Sink: "none",
+		},
+		Drain: Drain{
+			Timeout: domain.DefaultDrainTimeout,
+		},
+		Retransmission: Retransmission{
+			T3: domain.DefaultT3,
+			N3: domain.DefaultN3,
+		},
+		Overload: Overload{
+			Threshold: domain.DefaultOverloadThreshold,
+			Validity:  domain.DefaultOverloadValidity,
+		},
+		Firewall: Firewall{
+			Action: domain.FirewallActionReject,
+		},
+	}
+}
+
+// Load reads a YAML configuration file on top of the default values. Unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s configuration file", path)
+	}
+
+	config := Default()
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.SetStrict(true)
+
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrapf(ErrInvalidConfig, "%s: %s", path, err)
+	}
+
+	return config, nil
+}
+
+// Validate checks the values which can't be used by the P-GW, the errors refer to the YAML fields.
+func (c *Config) Validate() error {
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		return errors.Wrapf(ErrInvalidConfig, "log.level: unknown %q level", c.Log.Level)
+	}
+
+	if _, err := c.Log.Formatter(); err != nil {
+		return err
+	}
+
+	if err := c.validateFunction(); err != nil {
+		return err
+	}
+
+	if err := c.validateSgi(); err != nil {
+		return err
+	}
+
+	if err := c.validateOutputs(); err != nil {
+		return err
+	}
+
+	if err := c.validateOverload(); err != nil {
+		return err
+	}
+
+	if err := c.validateFirewall(); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+
+	for i, apn := range c.Apns {
+		if apn.Name == "" {
+			return errors.Wrapf(ErrInvalidConfig, "apns[%d].name: required", i)
+		}
+
+		if names[apn.Name] {
+			return errors.Wrapf(ErrInvalidConfig, "apns[%d].name: duplicated %q APN", i, apn.Name)
+		}
+
+		names[apn.Name] = true
+
+		if apn.Authenticate && c.Aaa.URL == "" {
+			return errors.Wrapf(ErrInvalidConfig, "apns[%d].authenticate: the %q APN requires aaa.url", i, apn.Name)
+		}
+
+		for j, server := range apn.DNS {
+			if net.ParseIP(server) == nil {
+				return errors.Wrapf(ErrInvalidConfig, "apns[%d].dns[%d]: invalid %q IP address", i, j, server)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFunction checks the planes served by the P-GW and their datapath.
+func (c *Config) validateFunction() error {
+	switch c.Function {
+	case domain.FunctionCombined:
+	case domain.FunctionControlPlane:
+		if c.Sxb.Peer == "" {
+			return errors.Wrap(ErrInvalidConfig, "sxb.peer: required by the control function")
+		}
+	case domain.FunctionUserPlane:
+		if c.Sxb.Address == "" {
+			return errors.Wrap(ErrInvalidConfig, "sxb.address: required by the user function")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "function: unsupported %q function", c.Function)
+	}
+
+	switch c.Datapath {
+	case domain.DatapathKernel, domain.DatapathTFT, domain.DatapathUserspace, domain.DatapathAuto:
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "datapath: unsupported %q datapath", c.Datapath)
+	}
+
+	// the S5 addresses are discovered in the networks of the planes served by the function.
+	networks := []struct {
+		field    string
+		value    string
+		required bool
+	}{
+		{"networks.s5u", c.Networks.S5u, c.Function != domain.FunctionControlPlane},
+		{"networks.s5c", c.Networks.S5c, c.Function != domain.FunctionUserPlane},
+	}
+
+	for _, network := range networks {
+		if network.value == "" {
+			if network.required {
+				return errors.Wrapf(ErrInvalidConfig, "%s: required by the %s function", network.field, c.Function)
+			}
+
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(network.value); err != nil {
+			return errors.Wrapf(ErrInvalidConfig, "%s: invalid %q CIDR", network.field, network.value)
+		}
+	}
+
+	return nil
+}
+
+// validateSgi checks that the UE pool doesn't overlap with the S5 networks, the SGi interface is
+// only required when the function forwards the user plane traffic.
+func (c *Config) validateSgi() error {
+	if c.Sgi.Nic == "" && c.Function != domain.FunctionControlPlane {
+		return errors.Wrapf(ErrInvalidConfig, "sgi.nic: required by the %s function", c.Function)
+	}
+
+	_, pool, err := net.ParseCIDR(c.Sgi.Subnet)
+	if err != nil {
+		return errors.Wrapf(ErrInvalidConfig, "sgi.subnet: invalid %q CIDR", c.Sgi.Subnet)
+	}
+
+	for field, network := range map[string]string{"networks.s5u": c.Networks.S5u, "networks.s5c": c.Networks.S5c} {
+		_, subnet, err := net.ParseCIDR(network)
+		if err != nil {
+			continue
+		}
+
+		if pool.Contains(subnet.IP) || subnet.Contains(pool.IP) {
+			return errors.Wrapf(ErrInvalidConfig, "sgi.subnet: %q overlaps with the %s %q network",
+				c.Sgi.Subnet, field, network)
+		}
+	}
+
+	return nil
+}
+
+// validateFirewall checks the action and the rules applied to the S5/S8 peers.
+func (c *Config) validateFirewall() error {
+	switch c.Firewall.Action {
+	case domain.FirewallActionReject, domain.FirewallActionDrop:
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "firewall.action: unsupported %q action", c.Firewall.Action)
+	}
+
+	for i, peer := range c.Firewall.Peers {
+		if !plmnPattern.MatchString(peer.PLMN) {
+			return errors.Wrapf(ErrInvalidConfig, "firewall.peers[%d].plmn: invalid %q PLMN", i, peer.PLMN)
+		}
+
+		if len(peer.Networks) == 0 {
+			return errors.Wrapf(ErrInvalidConfig, "firewall.peers[%d].networks: required", i)
+		}
+
+		for j, network := range peer.Networks {
+			if _, _, err := net.ParseCIDR(network); err != nil {
+				return errors.Wrapf(ErrInvalidConfig, "firewall.peers[%d].networks[%d]: invalid %q CIDR", i, j, network)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateOutputs checks the settings of the spans, packet captures, events, draining and
+// retransmissions.
+func (c *Config) validateOutputs() error {
+	switch c.Traces.Exporter {
+	case "none", "otlp", "file":
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "traces.exporter: unsupported %q exporter", c.Traces.Exporter)
+	}
+
+	if c.Capture.FileSize <= 0 {
+		return errors.Wrapf(ErrInvalidConfig, "capture.fileSize: %d isn't positive", c.Capture.FileSize)
+	}
+
+	if c.Capture.Files <= 0 {
+		return errors.Wrapf(ErrInvalidConfig, "capture.files: %d isn't positive", c.Capture.Files)
+	}
+
+	switch c.Events.Sink {
+	case "none":
+	case "webhook", "file":
+		if c.Events.Target == "" {
+			return errors.Wrapf(ErrInvalidConfig, "events.target: required by the %s sink", c.Events.Sink)
+		}
+	case "redis":
+		if c.Datastore.RedisURL == "" {
+			return errors.Wrap(ErrInvalidConfig, "events.sink: the redis sink requires datastore.redisURL")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "events.sink: unsupported %q sink", c.Events.Sink)
+	}
+
+	if c.Drain.Timeout < 0 {
+		return errors.Wrapf(ErrInvalidConfig, "drain.timeout: %s is negative", c.Drain.Timeout)
+	}
+
+	if c.Retransmission.T3 <= 0 {
+		return errors.Wrapf(ErrInvalidConfig, "retransmission.t3: %s isn't positive", c.Retransmission.T3)
+	}
+
+	if c.Retransmission.N3 <= 0 {
+		return errors.Wrapf(ErrInvalidConfig, "retransmission.n3: %d isn't positive", c.Retransmission.N3)
+	}
+
+	return nil
+}
+
+// validateOverload checks the admission limits and the load reported to the S-GWs, whose validity
+// period has to be encoded as an EPC timer.
+func (c *Config) validateOverload() error {
+	limits := []struct {
+		field string
+		value int
+	}{
+		{"overload.maxSessions", c.Overload.MaxSessions},
+		{"overload.maxApnSessions", c.Overload.MaxApnSessions},
+		{"overload.maxCreateRate", c.Overload.MaxCreateRate},
+		{"overload.maxPendingRequests", c.Overload.MaxPendingRequests},
+	}
+
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return errors.Wrapf(ErrInvalidConfig, "%s: %d is negative", limit.field, limit.value)
+		}
+	}
+
+	if c.Overload.Threshold <= 0 || c.Overload.Threshold > 100 {
+		return errors.Wrapf(ErrInvalidConfig, "overload.threshold: %d isn't a percentage", c.Overload.Threshold)
+	}
+
+	if !isEPCTimer(c.Overload.Validity) {
+		return errors.Wrapf(ErrInvalidConfig, "overload.validity: %s isn't a multiple of 2s up to 62s "+
+			"or of a minute up to 31m", c.Overload.Validity)
+	}
+
+	return nil
+}
+
+// isEPCTimer checks if the duration can be encoded with the units of the EPC timer.
+func isEPCTimer(duration time.Duration) bool {
+	switch {
+	case duration <= 0:
+		return false
+	case duration%(2*time.Second) == 0 && duration <= 62*time.Second:
+		return true
+	}
+
+	return duration%time.Minute == 0 && duration <= 31*time.Minute
+}
+
+// Formatter returns the logrus formatter of the log format.
+func (l Log) Formatter() (log.Formatter, error) {
+	switch l.Format {
+	case "text":
+		return &log.TextFormatter{}, nil
+	case "json":
+		return &log.JSONFormatter{}, nil
+	default:
+		return nil, errors.Wrapf(ErrInvalidConfig, "log.format: unknown %q format", l.Format)
+	}
+}
+
+// Authenticate requires the authentication of the APN subscribers, adding the APN when it's missing.
+func (c *Config) Authenticate(name string) {
+	for i := range c.Apns {
+		if c.Apns[i].Name == name {
+			c.Apns[i].Authenticate = true
+
+			return
+		}
+	}
+
+	c.Apns = append(c.Apns, Apn{Name: name, Authenticate: true})
+}
+
+// NewPgw creates the PGW domain object with the discovered S5 addresses.
+func (c *Config) NewPgw(s5cIP, s5uIP string) (*domain.Pgw, error) {
+	// the PGW-C doesn't forward the UE traffic, so its SGi interface isn't resolved
+	nic := c.Sgi.Nic
+	if c.Function == domain.FunctionControlPlane {
+		nic = ""
+	}
+
+	pgw, err := domain.New(s5cIP, s5uIP, nic, c.Sgi.Subnet)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the PGW domain object")
+	}
+
+	pgw.UserPlane.Datapath = c.Datapath
+	pgw.Function = c.Function
+	pgw.Sxb.Address = c.Sxb.Address
+	pgw.Sxb.Peer = c.Sxb.Peer
+	pgw.Capture = &domain.Capture{
+		Directory:   c.Capture.Directory,
+		MaxFileSize: c.Capture.FileSize,
+		MaxFiles:    c.Capture.Files,
+	}
+	pgw.Shutdown = &domain.Shutdown{
+		DrainTimeout:       c.Drain.Timeout,
+		DeactivateSessions: c.Drain.DeleteBearers,
+	}
+	pgw.Retransmission = &domain.Retransmission{
+		T3: c.Retransmission.T3,
+		N3: c.Retransmission.N3,
+	}
+
+	pgw.Overload = c.getOverload()
+	pgw.Firewall = c.getFirewall()
+	pgw.ReplaceApns(c.getApns()...)
+
+	return pgw, nil
+}
+
+// getApns converts the APN settings into domain objects.
+func (c *Config) getApns() []*domain.Apn {
+	apns := make([]*domain.Apn, 0, len(c.Apns))
+
+	for _, apn := range c.Apns {
+		settings := &domain.Apn{Name: apn.Name, Authenticate: apn.Authenticate}
+
+		for _, server := range apn.DNS {
+			settings.DNS = append(settings.DNS, net.ParseIP(server))
+		}
+
+		apns = append(apns, settings)
+	}
+
+	return apns
+}
+
+// getOverload converts the admission limits into a domain object.
+func (c *Config) getOverload() *domain.Overload {
+	return &domain.Overload{
+		MaxSessions:    c.Overload.MaxSessions,
+		MaxApnSessions: c.Overload.MaxApnSessions,
+		MaxCreateRate:  c.Overload.MaxCreateRate,
+		MaxPending:     c.Overload.MaxPendingRequests,
+		Threshold:      c.Overload.Threshold,
+		Validity:       c.Overload.Validity,
+	}
+}
+
+// getFirewall converts the firewall settings into a domain object.
+func (c *Config) getFirewall() *domain.Firewall {
+	firewall := &domain.Firewall{Action: c.Firewall.Action, ValidateSender: c.Firewall.ValidateSender}
+
+	for _, peer := range c.Firewall.Peers {
+		rule := &domain.PeerRule{PLMN: peer.PLMN}
+
+		for _, network := range peer.Networks {
+			if _, subnet, err := net.ParseCIDR(network); err == nil {
+				rule.Networks = append(rule.Networks, subnet)
+			}
+		}
+
+		firewall.Peers = append(firewall.Peers, rule)
+	}
+
+	return firewall
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configrepo_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/repositories/configrepo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var dir string
+
+	writeConfig := func(content string) string {
+		path := filepath.Join(dir, "pgw.yml")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		dir, err = os.MkdirTemp("", "configrepo")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("loading a file", func() {
+		It("should keep the default values of the omitted fields", func() {
+			config, err := configrepo.Load(writeConfig(`
+sgi:
+  nic: eth2
+  subnet: 10.0.1.0/24
+networks:
+  s5c: 172.25.1.0/24
+function: control
+sxb:
+  peer: 10.0.3.2
+aaa:
+  url: 10.0.0.10:1812
+drain:
+  timeout: 5s
+  deleteBearers: true
+retransmission:
+  t3: 2s
+overload:
+  maxSessions: 1000
+  validity: 10m
+apns:
+  - name: internet
+  - name: ims
+    authenticate: true
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Validate()).To(Succeed())
+			Expect(config.Log.Level).To(Equal("info"))
+			Expect(config.Datapath).To(Equal(domain.DatapathKernel))
+			Expect(config.Sxb.Address).To(Equal(domain.PFCPPort))
+			Expect(config.Function).To(Equal(domain.FunctionControlPlane))
+			Expect(config.Drain).To(Equal(configrepo.Drain{Timeout: 5 * time.Second, DeleteBearers: true}))
+			Expect(config.Retransmission).To(Equal(configrepo.Retransmission{T3: 2 * time.Second, N3: domain.DefaultN3}))
+			Expect(config.Overload).To(Equal(configrepo.Overload{
+				MaxSessions: 1000,
+				Threshold:   domain.DefaultOverloadThreshold,
+				Validity:    10 * time.Minute,
+			}))
+			Expect(config.Apns).To(Equal([]configrepo.Apn{{Name: "internet"}, {Name: "ims", Authenticate: true}}))
+		})
+
+		It("should accept an empty file", func() {
+			config, err := configrepo.Load(writeConfig(""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(configrepo.Default()))
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := configrepo.Load(writeConfig("sgi:\n  interface: eth2\n"))
+			Expect(err).To(MatchError(ContainSubstring("field interface not found")))
+			Expect(err).To(MatchError(configrepo.ErrInvalidConfig))
+		})
+
+		It("should fail when the file doesn't exist", func() {
+			_, err := configrepo.Load(filepath.Join(dir, "missing.yml"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("validating the values", func() {
+		var config *configrepo.Config
+
+		BeforeEach(func() {
+			config = configrepo.Default()
+			config.Sgi = configrepo.Sgi{Nic: "eth2", Subnet: "10.0.1.0/24"}
+			config.Networks = configrepo.Networks{S5u: "172.25.0.0/24", S5c: "172.25.1.0/24"}
+		})
+
+		It("should accept the default values", func() {
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should only require the S5 networks of the planes served by the function", func() {
+			config.Function = domain.FunctionUserPlane
+			config.Networks.S5c = ""
+			Expect(config.Validate()).To(Succeed())
+
+			config.Function = domain.FunctionControlPlane
+			config.Sxb.Peer = "10.0.3.2"
+			config.Networks = configrepo.Networks{S5c: "172.25.1.0/24"}
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should not require the SGi interface of the control function", func() {
+			config.Function = domain.FunctionControlPlane
+			config.Sxb.Peer = "10.0.3.2"
+			config.Sgi.Nic = ""
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("should accept the events sinks with their target", func() {
+			config.Events = configrepo.Events{Sink: "webhook", Target: "http://collector/events"}
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		DescribeTable("should reject wrong values referring their field",
+			func(change func(*configrepo.Config), field string) {
+				change(config)
+
+				err := config.Validate()
+				Expect(err).To(MatchError(configrepo.ErrInvalidConfig))
+				Expect(err).To(MatchError(HavePrefix(field + ":")))
+			},
+			Entry("log level", func(c *configrepo.Config) { c.Log.Level = "verbose" }, "log.level"),
+			Entry("log format", func(c *configrepo.Config) { c.Log.Format = "xml" }, "log.format"),
+			Entry("missing SGi NIC", func(c *configrepo.Config) { c.Sgi.Nic = "" }, "sgi.nic"),
+			Entry("SGi subnet", func(c *configrepo.Config) { c.Sgi.Subnet = "10.0.1.0" }, "sgi.subnet"),
+			Entry("S5-U network", func(c *configrepo.Config) { c.Networks.S5u = "172.25.0.0" }, "networks.s5u"),
+			Entry("missing S5-U network", func(c *configrepo.Config) { c.Networks.S5u = "" }, "networks.s5u"),
+			Entry("missing S5-C network", func(c *configrepo.Config) { c.Networks.S5c = "" }, "networks.s5c"),
+			Entry("missing S5-C network of the control function", func(c *configrepo.Config) {
+				c.Function, c.Sxb.Peer = domain.FunctionControlPlane, "10.0.3.2"
+				c.Networks.S5c = ""
+			}, "networks.s5c"),
+			Entry("SGi subnet overlapping S5-U", func(c *configrepo.Config) {
+				c.Networks.S5u = "10.0.0.0/16"
+			}, "sgi.subnet"),
+			Entry("SGi subnet overlapping S5-C", func(c *configrepo.Config) {
+				c.Networks.S5c = "10.0.1.128/25"
+			}, "sgi.subnet"),
+			Entry("function", func(c *configrepo.Config) { c.Function = "sgw" }, "function"),
+			Entry("missing Sxb peer", func(c *configrepo.Config) { c.Function = domain.FunctionControlPlane }, "sxb.peer"),
+			Entry("datapath", func(c *configrepo.Config) { c.Datapath = "ebpf" }, "datapath"),
+			Entry("traces exporter", func(c *configrepo.Config) { c.Traces.Exporter = "jaeger" }, "traces.exporter"),
+			Entry("capture files", func(c *configrepo.Config) { c.Capture.Files = 0 }, "capture.files"),
+			Entry("redis sink without datastore", func(c *configrepo.Config) { c.Events.Sink = "redis" }, "events.sink"),
+			Entry("webhook sink without target", func(c *configrepo.Config) { c.Events.Sink = "webhook" }, "events.target"),
+			Entry("file sink without target", func(c *configrepo.Config) { c.Events.Sink = "file" }, "events.target"),
+			Entry("drain timeout", func(c *configrepo.Config) { c.Drain.Timeout = -time.Second }, "drain.timeout"),
+			Entry("retransmission timer", func(c *configrepo.Config) { c.Retransmission.T3 = 0 }, "retransmission.t3"),
+			Entry("retransmission counter", func(c *configrepo.Config) { c.Retransmission.N3 = -1 }, "retransmission.n3"),
+			Entry("negative session limit", func(c *configrepo.Config) { c.Overload.MaxSessions = -1 }, "overload.maxSessions"),
+			Entry("overload threshold", func(c *configrepo.Config) { c.Overload.Threshold = 120 }, "overload.threshold"),
+			Entry("overload validity", func(c *configrepo.Config) { c.Overload.Validity = 90 * time.Second }, "overload.validity"),
+			Entry("firewall action", func(c *configrepo.Config) { c.Firewall.Action = "deny" }, "firewall.action"),
+			Entry("firewall PLMN", func(c *configrepo.Config) {
+				c.Firewall.Peers = []configrepo.Peer{{PLMN: "440", Networks: []string{"172.25.0.0/24"}}}
+			}, "firewall.peers[0].plmn"),
+			Entry("missing firewall networks", func(c *configrepo.Config) {
+				c.Firewall.Peers = []configrepo.Peer{{PLMN: "44010"}}
+			}, "firewall.peers[0].networks"),
+			Entry("firewall network", func(c *configrepo.Config) {
+				c.Firewall.Peers = []configrepo.Peer{{Networks: []string{"172.25.0.3"}}}
+			}, "firewall.peers[0].networks[0]"),
+			Entry("duplicated APN", func(c *configrepo.Config) {
+				c.Apns = []configrepo.Apn{{Name: "internet"}, {Name: "internet"}}
+			}, "apns[1].name"),
+			Entry("authenticated APN without AAA server", func(c *configrepo.Config) {
+				c.Apns = []configrepo.Apn{{Name: "internet"}, {Name: "corporate", Authenticate: true}}
+			}, "apns[1].authenticate"),
+		)
+	})
+
+	Describe("creating the PGW domain object", func() {
+		It("should carry the configured settings", func() {
+			config := configrepo.Default()
+			config.Sgi = configrepo.Sgi{Nic: "lo", Subnet: "10.0.1.0/24"}
+			config.Datapath = domain.DatapathUserspace
+			config.Apns = []configrepo.Apn{{Name: "internet"}}
+			config.Authenticate("ims")
+			config.Authenticate("internet")
+
+			pgw, err := config.NewPgw("127.0.0.1", "127.0.0.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgw.Validate()).To(Succeed())
+			Expect(pgw.UserPlane.Datapath).To(Equal(domain.DatapathUserspace))
+			Expect(pgw.Sgi.Subnet.String()).To(Equal("10.0.1.0/24"))
+			Expect(pgw.Shutdown.DrainTimeout).To(Equal(domain.DefaultDrainTimeout))
+			Expect(pgw.Retransmission.Window()).To(Equal(9 * time.Second))
+			Expect(pgw.Overload.Reports()).To(BeFalse())
+			Expect(pgw.RequiresAuthentication("internet")).To(BeTrue())
+			Expect(pgw.RequiresAuthentication("ims")).To(BeTrue())
+			Expect(pgw.RequiresAuthentication("web")).To(BeFalse())
+		})
+
+		It("should not resolve the SGi interface of the control function", func() {
+			config := configrepo.Default()
+			config.Function = domain.FunctionControlPlane
+			config.Sxb.Peer = "10.0.3.2"
+			config.Sgi = configrepo.Sgi{Subnet: "10.0.1.0/24"}
+			config.Networks = configrepo.Networks{S5c: "172.25.1.0/24"}
+			Expect(config.Validate()).To(Succeed())
+
+			pgw, err := config.NewPgw("127.0.0.1", "127.0.0.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgw.Validate()).To(Succeed())
+			Expect(pgw.Sgi.Link).To(BeNil())
+			Expect(pgw.Sgi.Subnet.String()).To(Equal("10.0.1.0/24"))
+		})
+
+		It("should refuse a missing SGi interface", func() {
+			config := configrepo.Default()
+			config.Sgi = configrepo.Sgi{Nic: "sgi-missing", Subnet: "10.0.1.0/24"}
+
+			_, err := config.NewPgw("127.0.0.1", "127.0.0.2")
+			Expect(err).To(MatchError(domain.ErrLinkNotFound))
+			Expect(err).To(MatchError(ContainSubstring("sgi.nic")))
+		})
+	})
+})
@@ -0,0 +1,212 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configrepo
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	log "github.com/sirupsen/logrus"
+)
+
+// redacted replaces the values of the secret fields in the reported changes.
+const redacted = "<redacted>"
+
+// secretFields are the settings whose values aren't reported.
+var secretFields = map[string]bool{
+	"datastore.redisPassword": true,
+	"aaa.secret":              true,
+}
+
+type reloader struct {
+	mutex     sync.Mutex
+	path      string
+	overrides func(*Config)
+	running   *Config
+	pgw       *domain.Pgw
+}
+
+// NewReloader creates a reloader which reads the configuration file again, with the overrides
+// used at startup, and applies the log, APN, overload, firewall and SGi subnet changes to the running P-GW.
+// The SGi subnet can only grow, so the addresses of the existing sessions remain valid.
+func NewReloader(path string, running *Config, overrides func(*Config), pgw *domain.Pgw) ports.ConfigReloader {
+	return &reloader{
+		path:      path,
+		overrides: overrides,
+		running:   running,
+		pgw:       pgw,
+	}
+}
+
+// Reload applies the changes of the configuration, nothing is changed when it isn't valid.
+func (r *reloader) Reload() ([]*domain.ConfigChange, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	config := Default()
+
+	if r.path != "" {
+		var err error
+		if config, err = Load(r.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.overrides != nil {
+		r.overrides(config)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	changes := Diff(r.running, config)
+	running := *r.running
+
+	for _, change := range changes {
+		switch {
+		case strings.HasPrefix(change.Field, "log."):
+			change.Applied = true
+			running.Log = config.Log
+		case strings.HasPrefix(change.Field, "apns["):
+			change.Applied = true
+			running.Apns = config.Apns
+		case strings.HasPrefix(change.Field, "overload."):
+			change.Applied = true
+			running.Overload = config.Overload
+		case strings.HasPrefix(change.Field, "firewall."):
+			change.Applied = true
+			running.Firewall = config.Firewall
+		case change.Field == "sgi.subnet":
+			change.Applied = grows(change.Old, change.New)
+			if change.Applied {
+				running.Sgi.Subnet = config.Sgi.Subnet
+			}
+		}
+	}
+
+	r.apply(&running)
+	r.running = &running
+
+	return changes, nil
+}
+
+// apply updates the running P-GW, the settings which didn't change are applied again.
+func (r *reloader) apply(config *Config) {
+	level, _ := log.ParseLevel(config.Log.Level)
+	formatter, _ := config.Log.Formatter()
+
+	log.SetLevel(level)
+	log.SetFormatter(formatter)
+
+	r.pgw.ReplaceApns(config.getApns()...)
+	r.pgw.SetOverload(config.getOverload())
+	r.pgw.SetFirewall(config.getFirewall())
+
+	_, subnet, _ := net.ParseCIDR(config.Sgi.Subnet)
+	r.pgw.SetSubnet(subnet)
+}
+
+// grows checks if the new subnet includes all the addresses of the old one.
+func grows(oldSubnet, newSubnet string) bool {
+	_, older, err := net.ParseCIDR(oldSubnet)
+	if err != nil {
+		return false
+	}
+
+	_, newer, err := net.ParseCIDR(newSubnet)
+	if err != nil {
+		return false
+	}
+
+	olderOnes, _ := older.Mask.Size()
+	newerOnes, _ := newer.Mask.Size()
+
+	return newerOnes <= olderOnes && newer.Contains(older.IP)
+}
+
+// Diff reports the fields whose values differ between both configurations, the elements of the
+// lists are compared by name when they have one.
+func Diff(older, newer *Config) []*domain.ConfigChange {
+	olderFields, newerFields := map[string]string{}, map[string]string{}
+	flatten("", reflect.ValueOf(*older), olderFields)
+	flatten("", reflect.ValueOf(*newer), newerFields)
+
+	for field := range olderFields {
+		if _, ok := newerFields[field]; !ok {
+			newerFields[field] = ""
+		}
+	}
+
+	changes := []*domain.ConfigChange{}
+
+	for field, value := range newerFields {
+		if olderFields[field] == value {
+			continue
+		}
+
+		change := &domain.ConfigChange{Field: field, Old: olderFields[field], New: value}
+		if secretFields[field] {
+			change.Old, change.New = redacted, redacted
+		}
+
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// flatten collects the values of the configuration indexed by their YAML path.
+func flatten(path string, value reflect.Value, fields map[string]string) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			name := strings.Split(value.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if path != "" {
+				name = path + "." + name
+			}
+
+			flatten(name, value.Field(i), fields)
+		}
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Struct {
+			fields[path] = fmt.Sprint(value.Interface())
+
+			return
+		}
+
+		for i := 0; i < value.Len(); i++ {
+			element := value.Index(i)
+
+			var key interface{} = i
+			if name := element.FieldByName("Name"); name.IsValid() {
+				key = name.Interface()
+			}
+
+			fields[fmt.Sprintf("%s[%v]", path, key)] = fmt.Sprintf("%+v", element.Interface())
+		}
+	default:
+		fields[path] = fmt.Sprint(value.Interface())
+	}
+}
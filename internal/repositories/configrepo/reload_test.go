@@ -0,0 +1,212 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configrepo_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/repositories/configrepo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+const runningConfig = `
+log:
+  level: info
+aaa:
+  url: 10.0.0.10:1812
+  secret: testing123
+networks:
+  s5u: 172.25.0.0/24
+  s5c: 172.25.1.0/24
+sgi:
+  nic: lo
+  subnet: 10.0.1.0/24
+apns:
+  - name: internet
+`
+
+var _ = Describe("Reloader", func() {
+	var (
+		dir      string
+		path     string
+		level    log.Level
+		pgw      *domain.Pgw
+		reloader ports.ConfigReloader
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		level = log.GetLevel()
+		dir, err = os.MkdirTemp("", "configrepo")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "pgw.yml")
+		Expect(os.WriteFile(path, []byte(runningConfig), 0o600)).To(Succeed())
+
+		config, err := configrepo.Load(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		pgw, err = config.NewPgw("127.0.0.1", "127.0.0.2")
+		Expect(err).NotTo(HaveOccurred())
+		reloader = configrepo.NewReloader(path, config, nil, pgw)
+	})
+
+	AfterEach(func() {
+		log.SetLevel(level)
+		log.SetFormatter(&log.TextFormatter{})
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	reload := func(content string) ([]*domain.ConfigChange, error) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+
+		return reloader.Reload()
+	}
+
+	It("should report no changes when the file is the same", func() {
+		Expect(reloader.Reload()).To(BeEmpty())
+	})
+
+	It("should apply the changes supported at runtime", func() {
+		changes, err := reload(`
+log:
+  level: debug
+  format: json
+aaa:
+  url: 10.0.0.10:1812
+  secret: secret
+networks:
+  s5u: 172.25.0.0/24
+  s5c: 172.25.1.0/24
+sgi:
+  nic: lo
+  subnet: 10.0.0.0/16
+datapath: tft
+apns:
+  - name: ims
+    authenticate: true
+    dns: [10.0.0.53]
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]*domain.ConfigChange{
+			{Field: "aaa.secret", Old: "<redacted>", New: "<redacted>"},
+			{Field: "apns[ims]", New: "{Name:ims Authenticate:true DNS:[10.0.0.53]}", Applied: true},
+			{Field: "apns[internet]", Old: "{Name:internet Authenticate:false DNS:[]}", Applied: true},
+			{Field: "datapath", Old: "kernel", New: "tft"},
+			{Field: "log.format", Old: "text", New: "json", Applied: true},
+			{Field: "log.level", Old: "info", New: "debug", Applied: true},
+			{Field: "sgi.subnet", Old: "10.0.1.0/24", New: "10.0.0.0/16", Applied: true},
+		}))
+
+		Expect(log.GetLevel()).To(Equal(log.DebugLevel))
+		Expect(pgw.GetApn("internet")).To(BeNil())
+		Expect(pgw.GetApn("ims").DNS).To(Equal([]net.IP{net.ParseIP("10.0.0.53")}))
+		Expect(pgw.RequiresAuthentication("ims")).To(BeTrue())
+		Expect(pgw.GetSubnet().String()).To(Equal("10.0.0.0/16"))
+		Expect(pgw.UserPlane.Datapath).To(Equal(domain.DatapathKernel))
+	})
+
+	It("should keep the subnet when it doesn't grow", func() {
+		changes, err := reload(`
+aaa:
+  url: 10.0.0.10:1812
+  secret: testing123
+networks:
+  s5u: 172.25.0.0/24
+  s5c: 172.25.1.0/24
+sgi:
+  nic: lo
+  subnet: 10.0.1.0/25
+apns:
+  - name: internet
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]*domain.ConfigChange{
+			{Field: "sgi.subnet", Old: "10.0.1.0/24", New: "10.0.1.0/25"},
+		}))
+		Expect(pgw.GetSubnet().String()).To(Equal("10.0.1.0/24"))
+
+		By("reporting the pending change again")
+		Expect(reloader.Reload()).To(HaveLen(1))
+	})
+
+	It("should replace the firewall rules", func() {
+		changes, err := reload(`
+aaa:
+  url: 10.0.0.10:1812
+  secret: testing123
+networks:
+  s5u: 172.25.0.0/24
+  s5c: 172.25.1.0/24
+sgi:
+  nic: lo
+  subnet: 10.0.1.0/24
+firewall:
+  action: drop
+  peers:
+    - plmn: "44010"
+      networks: [172.25.0.0/24]
+apns:
+  - name: internet
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]*domain.ConfigChange{
+			{Field: "firewall.action", Old: "reject", New: "drop", Applied: true},
+			{Field: "firewall.peers[0]", New: "{PLMN:44010 Networks:[172.25.0.0/24]}", Applied: true},
+		}))
+
+		firewall := pgw.GetFirewall()
+		Expect(firewall.Drops()).To(BeTrue())
+		Expect(firewall.Allows(net.ParseIP("172.25.0.3"), "44010")).To(BeTrue())
+		Expect(firewall.Allows(net.ParseIP("172.25.1.3"), "44010")).To(BeFalse())
+	})
+
+	It("should replace the admission limits", func() {
+		changes, err := reload(`
+aaa:
+  url: 10.0.0.10:1812
+  secret: testing123
+networks:
+  s5u: 172.25.0.0/24
+  s5c: 172.25.1.0/24
+sgi:
+  nic: lo
+  subnet: 10.0.1.0/24
+overload:
+  maxSessions: 500
+apns:
+  - name: internet
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(Equal([]*domain.ConfigChange{
+			{Field: "overload.maxSessions", Old: "0", New: "500", Applied: true},
+		}))
+		Expect(pgw.GetOverload().MaxSessions).To(Equal(500))
+		Expect(pgw.GetOverload().Threshold).To(Equal(domain.DefaultOverloadThreshold))
+	})
+
+	It("should apply nothing when the configuration is invalid", func() {
+		_, err := reload("log:\n  level: verbose\napns:\n  - name: ims\n")
+		Expect(err).To(MatchError(configrepo.ErrInvalidConfig))
+		Expect(pgw.GetApn("internet")).NotTo(BeNil())
+		Expect(pgw.GetApn("ims")).To(BeNil())
+	})
+})
@@ -0,0 +1,75 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo
+
+import (
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrQueueFull indicates that the event was dropped because the sink can't keep up.
+var ErrQueueFull = errors.New("events queue full")
+
+type asyncPublisher struct {
+	publisher ports.EventPublisher
+	queue     chan *domain.SessionEvent
+	done      sync.WaitGroup
+}
+
+// NewAsync creates a publisher which queues the events and sends them in the background, so
+// slow sinks don't delay the GTP-C transactions.
+func NewAsync(publisher ports.EventPublisher, capacity int) ports.EventPublisher {
+	p := &asyncPublisher{
+		publisher: publisher,
+		queue:     make(chan *domain.SessionEvent, capacity),
+	}
+
+	p.done.Add(1)
+
+	go p.run()
+
+	return p
+}
+
+func (p *asyncPublisher) run() {
+	defer p.done.Done()
+
+	for event := range p.queue {
+		if err := p.publisher.Publish(event); err != nil {
+			log.WithError(err).WithField("IMSI", event.IMSI).Warnf("Failed to publish %s session event", event.Type)
+		}
+	}
+}
+
+// Publish queues the event.
+func (p *asyncPublisher) Publish(event *domain.SessionEvent) error {
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		return errors.Wrapf(ErrQueueFull, "%s session event dropped", event.Type)
+	}
+}
+
+// Close sends the queued events and closes the sink.
+func (p *asyncPublisher) Close() error {
+	close(p.queue)
+	p.done.Wait()
+
+	return errors.Wrap(p.publisher.Close(), "failed to close the events sink")
+}
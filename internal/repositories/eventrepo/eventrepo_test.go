@@ -0,0 +1,186 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/repositories/eventrepo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowSink blocks the publication of the events until it's released.
+type slowSink struct {
+	mutex    sync.Mutex
+	release  chan struct{}
+	events   []*domain.SessionEvent
+	isClosed bool
+}
+
+func (s *slowSink) Publish(event *domain.SessionEvent) error {
+	<-s.release
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+func (s *slowSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.isClosed = true
+
+	return nil
+}
+
+var _ = Describe("Session events", func() {
+	var event *domain.SessionEvent
+
+	BeforeEach(func() {
+		event = &domain.SessionEvent{
+			Type:      domain.SessionCreated,
+			Time:      time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+			IMSI:      "123451234567891",
+			MSISDN:    "123456789",
+			APN:       "internet",
+			UEAddress: "10.0.1.2",
+			SGW:       "172.25.1.3:2123",
+			EBI:       5,
+			Cause:     16,
+		}
+	})
+
+	expectFields := func(fields map[string]interface{}) {
+		Expect(fields).To(Equal(map[string]interface{}{
+			"type":      "created",
+			"time":      "2021-03-01T10:00:00Z",
+			"imsi":      "123451234567891",
+			"msisdn":    "123456789",
+			"apn":       "internet",
+			"ueAddress": "10.0.1.2",
+			"sgw":       "172.25.1.3:2123",
+			"ebi":       float64(5),
+			"cause":     float64(16),
+		}))
+	}
+
+	Describe("writing to a file", func() {
+		var directory string
+
+		BeforeEach(func() {
+			var err error
+
+			directory, err = os.MkdirTemp("", "eventrepo")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(directory)).To(Succeed())
+		})
+
+		It("should append a JSON line per event", func() {
+			path := filepath.Join(directory, "events.ndjson")
+
+			sink, err := eventrepo.NewFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Publish(event)).To(Succeed())
+			Expect(sink.Publish(event)).To(Succeed())
+			Expect(sink.Close()).To(Succeed())
+
+			file, err := os.Open(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			defer file.Close()
+
+			lines := 0
+
+			for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+				fields := map[string]interface{}{}
+				Expect(json.Unmarshal(scanner.Bytes(), &fields)).To(Succeed())
+				expectFields(fields)
+			}
+
+			Expect(lines).To(Equal(2))
+		})
+	})
+
+	Describe("posting to a webhook", func() {
+		var (
+			server   *httptest.Server
+			received chan map[string]interface{}
+			status   int
+		)
+
+		BeforeEach(func() {
+			received = make(chan map[string]interface{}, 1)
+			status = http.StatusAccepted
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fields := map[string]interface{}{}
+				Expect(json.NewDecoder(r.Body).Decode(&fields)).To(Succeed())
+				received <- fields
+
+				w.WriteHeader(status)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should post the event", func() {
+			sink := eventrepo.NewWebhook(server.URL)
+			Expect(sink.Publish(event)).To(Succeed())
+			expectFields(<-received)
+			Expect(sink.Close()).To(Succeed())
+		})
+		It("should fail when the event isn't accepted", func() {
+			status = http.StatusInternalServerError
+
+			sink := eventrepo.NewWebhook(server.URL)
+			Expect(sink.Publish(event)).To(MatchError(eventrepo.ErrWebhookRejected))
+		})
+	})
+
+	Describe("publishing in the background", func() {
+		It("should send the queued events before closing", func() {
+			sink := &slowSink{release: make(chan struct{})}
+			publisher := eventrepo.NewAsync(sink, 1)
+
+			// the first event is being sent while the second one waits in the queue.
+			Expect(publisher.Publish(event)).To(Succeed())
+			Eventually(func() error {
+				return publisher.Publish(event)
+			}).Should(Succeed())
+			Expect(publisher.Publish(event)).To(MatchError(eventrepo.ErrQueueFull))
+
+			close(sink.release)
+			Expect(publisher.Close()).To(Succeed())
+			Expect(sink.events).To(HaveLen(2))
+			Expect(sink.isClosed).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,35 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo
+
+import (
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+)
+
+// getFields flattens a session event into the fields published by the sinks.
+func getFields(event *domain.SessionEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      event.Type,
+		"time":      event.Time.UTC().Format(time.RFC3339Nano),
+		"imsi":      event.IMSI,
+		"msisdn":    event.MSISDN,
+		"apn":       event.APN,
+		"ueAddress": event.UEAddress,
+		"sgw":       event.SGW,
+		"ebi":       event.EBI,
+		"cause":     event.Cause,
+	}
+}
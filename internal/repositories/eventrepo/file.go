@@ -0,0 +1,60 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+type fileSink struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewFile creates a publisher which appends the events to a newline delimited JSON file.
+func NewFile(path string) (ports.EventPublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s events file", path)
+	}
+
+	return &fileSink{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Publish writes the event as a JSON line.
+func (s *fileSink) Publish(event *domain.SessionEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.encoder.Encode(getFields(event)); err != nil {
+		return errors.Wrap(err, "failed to write the event")
+	}
+
+	return nil
+}
+
+// Close closes the events file.
+func (s *fileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return errors.Wrap(s.file.Close(), "failed to close the events file")
+}
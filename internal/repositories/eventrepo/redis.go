@@ -0,0 +1,55 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+// streamMaxLength bounds the events kept by the stream, older events are trimmed.
+const streamMaxLength = 100000
+
+type redisStream struct {
+	client *redis.Client
+	stream string
+}
+
+// NewRedisStream creates a publisher which appends the events to a Redis Stream.
+func NewRedisStream(client *redis.Client, stream string) ports.EventPublisher {
+	return &redisStream{
+		client: client,
+		stream: stream,
+	}
+}
+
+// Publish adds the event to the stream.
+func (s *redisStream) Publish(event *domain.SessionEvent) error {
+	if err := s.client.XAdd(&redis.XAddArgs{
+		Stream:       s.stream,
+		MaxLenApprox: streamMaxLength,
+		Values:       getFields(event),
+	}).Err(); err != nil {
+		return errors.Wrapf(err, "failed to add the event to %s stream", s.stream)
+	}
+
+	return nil
+}
+
+// Close keeps the client open given that it's shared with the datastore.
+func (s *redisStream) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+const webhookTimeout = time.Duration(5) * time.Second
+
+// ErrWebhookRejected indicates that the webhook didn't accept the event.
+var ErrWebhookRejected = errors.New("event rejected by the webhook")
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a publisher which posts every event as a JSON document to the URL.
+func NewWebhook(url string) ports.EventPublisher {
+	return &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Publish posts the event to the webhook.
+func (s *webhookSink) Publish(event *domain.SessionEvent) error {
+	body, err := json.Marshal(getFields(event))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the event")
+	}
+
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post the event")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return errors.Wrapf(ErrWebhookRejected, "%d status code", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Close releases the idle connections to the webhook.
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrepo
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+// Prefixes of the datastore entries which keep the static UE addresses by subscriber and by address.
+const (
+	subscriberAddressPrefix = "static-address/imsi/"
+	reservedAddressPrefix   = "static-address/ip/"
+)
+
+type addresses struct {
+	store ports.IPRepository
+}
+
+// NewAddresses creates a repository which keeps the static UE addresses in the datastore shared by
+// the P-GWs. Every address is reserved for a single subscriber.
+func NewAddresses(store ports.IPRepository) ports.AddressRepository {
+	return &addresses{store: store}
+}
+
+// Get retrieves the address of the subscriber for the APN or, otherwise, for any APN.
+func (repo *addresses) Get(imsi, apn string) (*domain.StaticAddress, error) {
+	for _, key := range []string{subscriberKey(imsi, apn), subscriberKey(imsi, "")} {
+		address, err := repo.get(key)
+		if err != nil || address != nil {
+			return address, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Owner retrieves the reservation of an address, nil when it isn't reserved.
+func (repo *addresses) Owner(ip string) (*domain.StaticAddress, error) {
+	return repo.get(reservedKey(ip))
+}
+
+// Reserve stores the address of the subscriber, replacing the previous one of the same APN.
+func (repo *addresses) Reserve(address *domain.StaticAddress) error {
+	if err := address.Validate(); err != nil {
+		return err
+	}
+
+	owner, err := repo.Owner(address.IP)
+	if err != nil {
+		return err
+	}
+
+	if owner != nil && (owner.IMSI != address.IMSI || owner.APN != address.APN) {
+		return errors.Wrapf(domain.ErrAddressReserved, "%s address for %s IMSI", address.IP, owner.IMSI)
+	}
+
+	if err := repo.Release(address.IMSI, address.APN); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(address)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the static address")
+	}
+
+	if err := repo.store.Save(reservedKey(address.IP), string(value)); err != nil {
+		return errors.Wrap(err, "failed to reserve the static address")
+	}
+
+	if err := repo.store.Save(subscriberKey(address.IMSI, address.APN), string(value)); err != nil {
+		return errors.Wrap(err, "failed to store the static address")
+	}
+
+	return nil
+}
+
+// Release deletes the address of the subscriber for the APN.
+func (repo *addresses) Release(imsi, apn string) error {
+	key := subscriberKey(imsi, apn)
+
+	address, err := repo.get(key)
+	if err != nil || address == nil {
+		return err
+	}
+
+	repo.store.Delete(reservedKey(address.IP))
+	repo.store.Delete(key)
+
+	return nil
+}
+
+func (repo *addresses) get(key string) (*domain.StaticAddress, error) {
+	value, err := repo.store.Get(key)
+	if errors.Is(err, ErrNotFound) || (err == nil && value == "") {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the static address")
+	}
+
+	address := &domain.StaticAddress{}
+	if err := json.Unmarshal([]byte(value), address); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the static address")
+	}
+
+	return address, nil
+}
+
+func subscriberKey(imsi, apn string) string {
+	if apn == "" {
+		return subscriberAddressPrefix + imsi
+	}
+
+	return subscriberAddressPrefix + imsi + "/" + apn
+}
+
+func reservedKey(ip string) string {
+	return reservedAddressPrefix + net.ParseIP(ip).String()
+}
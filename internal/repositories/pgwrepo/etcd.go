@@ -20,6 +20,9 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// etcdKeyNotFound is the error code returned by ETCD for missing keys.
+const etcdKeyNotFound = 100
+
 type etcdStore struct {
 	client *etcd.Client
 }
@@ -53,6 +56,12 @@ func (repo *etcdStore) Save(id, ip string) error {
 // Get retrieves the value of a specific id entry.
 func (repo *etcdStore) Get(id string) (string, error) {
 	response, err := repo.client.Get(id, false, false)
+
+	var etcdErr *etcd.EtcdError
+	if errors.As(err, &etcdErr) && etcdErr.ErrorCode == etcdKeyNotFound {
+		return "", errors.Wrapf(ErrNotFound, "%s ETCD key", id)
+	}
+
 	if err != nil {
 		return "", errors.Wrap(err, "Error getting ETCD value")
 	}
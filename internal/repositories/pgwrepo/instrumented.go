@@ -0,0 +1,62 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrepo
+
+import (
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type instrumented struct {
+	repository ports.IPRepository
+	errors     *prometheus.CounterVec
+}
+
+// NewInstrumented counts the failed operations of a repository by their operation label.
+func NewInstrumented(repository ports.IPRepository, errors *prometheus.CounterVec) ports.IPRepository {
+	return &instrumented{repository: repository, errors: errors}
+}
+
+// Save stores an IP address with specific Identifier.
+func (repo *instrumented) Save(id, ip string) error {
+	return repo.count("save", repo.repository.Save(id, ip))
+}
+
+// Get retrieves the value of a specific id entry.
+func (repo *instrumented) Get(id string) (string, error) {
+	ip, err := repo.repository.Get(id)
+
+	return ip, repo.count("get", err)
+}
+
+// Delete removes the given id entry from the datastore.
+func (repo *instrumented) Delete(id string) {
+	repo.repository.Delete(id)
+}
+
+// Status is used for performing a check against the wrapped repository.
+func (repo *instrumented) Status() (interface{}, error) {
+	status, err := repo.repository.Status()
+
+	return status, repo.count("status", err)
+}
+
+func (repo *instrumented) count(operation string, err error) error {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		repo.errors.WithLabelValues(operation).Inc()
+	}
+
+	return err
+}
@@ -14,11 +14,14 @@ limitations under the License.
 package pgwrepo
 
 import (
+	"sync"
+
 	"github.com/gw-tester/pgw/internal/core/ports"
 )
 
 type memkvs struct {
-	kvs map[string]string
+	mutex sync.RWMutex
+	kvs   map[string]string
 }
 
 // NewMemKVS creates a new instance for Key/Value store.
@@ -28,6 +31,9 @@ func NewMemKVS() ports.IPRepository {
 
 // Save stores an IP address with specific Identifier.
 func (repo *memkvs) Save(id, ip string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
 	repo.kvs[id] = ip
 
 	return nil
@@ -35,11 +41,17 @@ func (repo *memkvs) Save(id, ip string) error {
 
 // Get retrieves the value of a specific id entry.
 func (repo *memkvs) Get(id string) (string, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
 	return repo.kvs[id], nil
 }
 
 // Delete removes the given id entry from the datastore.
 func (repo *memkvs) Delete(id string) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
 	delete(repo.kvs, id)
 }
 
@@ -0,0 +1,78 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrepo
+
+import (
+	"encoding/json"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/pkg/errors"
+)
+
+// policiesKey is the datastore entry which keeps the subscriber policies as a JSON list.
+const policiesKey = "subscriber-policies"
+
+type policies struct {
+	store ports.IPRepository
+}
+
+// NewPolicies creates a repository which keeps the subscriber policies in the datastore shared
+// by the P-GWs.
+func NewPolicies(store ports.IPRepository) ports.PolicyRepository {
+	return &policies{store: store}
+}
+
+// List retrieves the subscriber policies in their evaluation order.
+func (repo *policies) List() ([]*domain.SubscriberPolicy, error) {
+	value, err := repo.store.Get(policiesKey)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the subscriber policies")
+	}
+
+	if value == "" {
+		return nil, nil
+	}
+
+	list := []*domain.SubscriberPolicy{}
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the subscriber policies")
+	}
+
+	return list, nil
+}
+
+// Replace validates and stores the subscriber policies.
+func (repo *policies) Replace(list []*domain.SubscriberPolicy) error {
+	for i, policy := range list {
+		if err := policy.Validate(); err != nil {
+			return errors.Wrapf(err, "policies[%d]", i)
+		}
+	}
+
+	value, err := json.Marshal(list)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the subscriber policies")
+	}
+
+	if err := repo.store.Save(policiesKey, string(value)); err != nil {
+		return errors.Wrap(err, "failed to store the subscriber policies")
+	}
+
+	return nil
+}
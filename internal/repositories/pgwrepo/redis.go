@@ -24,8 +24,9 @@ type redisStore struct {
 	client *redis.Client
 }
 
-// NewRedis creates a new instance to connect to Redis Server.
-func NewRedis(url, password string) ports.IPRepository {
+// NewRedisClient creates a new client connected to Redis Server, which can be shared by the
+// datastore and the session events.
+func NewRedisClient(url, password string) *redis.Client {
 	log.WithFields(log.Fields{
 		"Redis URL": url,
 	}).Debug("Creating Redis client")
@@ -39,6 +40,11 @@ func NewRedis(url, password string) ports.IPRepository {
 		log.WithError(err).Panic("Error getting response from Redis server")
 	}
 
+	return client
+}
+
+// NewRedis creates a new instance to store the values in Redis Server.
+func NewRedis(client *redis.Client) ports.IPRepository {
 	return &redisStore{client: client}
 }
 
@@ -59,6 +65,10 @@ func (repo *redisStore) Save(id, ip string) error {
 // Get retrieves the value of a specific id entry.
 func (repo *redisStore) Get(id string) (string, error) {
 	val, err := repo.client.Get(id).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errors.Wrapf(ErrNotFound, "%s Redis key", id)
+	}
+
 	if err != nil {
 		return "", errors.Wrap(err, "Error getting Redis value")
 	}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleAddresses reports (GET), reserves (PUT) or releases (DELETE) the static UE address of the
+// subscriber given by the imsi and apn query parameters.
+func (r *router) handleAddresses(w http.ResponseWriter, req *http.Request) {
+	if r.addresses == nil {
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		return
+	}
+
+	imsi, apn := req.URL.Query().Get("imsi"), req.URL.Query().Get("apn")
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		address := &domain.StaticAddress{}
+		if err := json.NewDecoder(req.Body).Decode(address); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := r.addresses.Reserve(address); err != nil {
+			http.Error(w, err.Error(), getAddressStatus(err))
+
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"IMSI": address.IMSI,
+			"APN":  address.APN,
+			"ip":   address.IP,
+		}).Info("Static address reserved")
+
+		imsi, apn = address.IMSI, address.APN
+	case http.MethodDelete:
+		if err := r.addresses.Release(imsi, apn); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		log.WithFields(log.Fields{"IMSI": imsi, "APN": apn}).Info("Static address released")
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	address, err := r.addresses.Get(imsi, apn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if address == nil {
+		http.NotFound(w, req)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(address); err != nil {
+		log.WithError(err).Warn("Static address response encoding error")
+	}
+}
+
+func getAddressStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidReservation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrAddressReserved):
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+// ErrInvalidFilter indicates that a packet filter of the bearer request can't be used.
+var ErrInvalidFilter = errors.New("invalid packet filter")
+
+type packetFilterRequest struct {
+	ID            uint8    `json:"id"`
+	Direction     string   `json:"direction"`
+	Precedence    uint8    `json:"precedence"`
+	RemoteAddress string   `json:"remoteAddress,omitempty"`
+	LocalAddress  string   `json:"localAddress,omitempty"`
+	Protocol      *uint8   `json:"protocol,omitempty"`
+	RemotePorts   []uint16 `json:"remotePorts,omitempty"`
+	LocalPorts    []uint16 `json:"localPorts,omitempty"`
+	SPI           *uint32  `json:"spi,omitempty"`
+	TypeOfService *uint8   `json:"tos,omitempty"`
+	ToSMask       uint8    `json:"tosMask,omitempty"`
+}
+
+type bearerRequest struct {
+	IMSI        string                `json:"imsi"`
+	QCI         uint8                 `json:"qci"`
+	MBRUplink   uint64                `json:"mbrUplink"`
+	MBRDownlink uint64                `json:"mbrDownlink"`
+	GBRUplink   uint64                `json:"gbrUplink"`
+	GBRDownlink uint64                `json:"gbrDownlink"`
+	Filters     []packetFilterRequest `json:"filters"`
+}
+
+type bearerResponse struct {
+	EBI uint8 `json:"ebi"`
+}
+
+func getDirection(direction string) (domain.PacketFilterDirection, error) {
+	switch direction {
+	case "downlink":
+		return domain.DirectionDownlink, nil
+	case "uplink":
+		return domain.DirectionUplink, nil
+	case "", "bidirectional":
+		return domain.DirectionBidirectional, nil
+	default:
+		return 0, errors.Wrapf(ErrInvalidFilter, "unknown %q direction", direction)
+	}
+}
+
+func getNetwork(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid %q network", cidr)
+	}
+
+	// the packet filters only classify IPv4 traffic.
+	if network.IP.To4() == nil {
+		return nil, errors.Wrapf(ErrInvalidFilter, "%q isn't an IPv4 network", cidr)
+	}
+
+	return network, nil
+}
+
+func getPortRange(ports []uint16) (*domain.PortRange, error) {
+	switch len(ports) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &domain.PortRange{Low: ports[0], High: ports[0]}, nil
+	case 2:
+		return &domain.PortRange{Low: ports[0], High: ports[1]}, nil
+	default:
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid %v port range", ports)
+	}
+}
+
+func (f *packetFilterRequest) toDomain() (*domain.PacketFilter, error) {
+	var err error
+
+	if f.ID > domain.MaxPacketFilters {
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid %d identifier", f.ID)
+	}
+
+	filter := &domain.PacketFilter{
+		ID:            f.ID,
+		Precedence:    f.Precedence,
+		Protocol:      f.Protocol,
+		SPI:           f.SPI,
+		TypeOfService: f.TypeOfService,
+		ToSMask:       f.ToSMask,
+	}
+
+	if filter.Direction, err = getDirection(f.Direction); err != nil {
+		return nil, err
+	}
+
+	if filter.RemoteAddress, err = getNetwork(f.RemoteAddress); err != nil {
+		return nil, err
+	}
+
+	if filter.LocalAddress, err = getNetwork(f.LocalAddress); err != nil {
+		return nil, err
+	}
+
+	if filter.RemotePorts, err = getPortRange(f.RemotePorts); err != nil {
+		return nil, err
+	}
+
+	if filter.LocalPorts, err = getPortRange(f.LocalPorts); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// createBearer activates a dedicated bearer for the subscriber given in the request body. Like the
+// rest of the management API it isn't authenticated, so it's meant for lab deployments.
+func (r *router) createBearer(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	var request bearerRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if len(request.Filters) > domain.MaxPacketFilters {
+		http.Error(w, errors.Wrapf(ErrInvalidFilter, "%d packet filters", len(request.Filters)).Error(),
+			http.StatusBadRequest)
+
+		return
+	}
+
+	tft := &domain.TrafficFlowTemplate{Operation: domain.TFTOperationCreate}
+
+	for i := range request.Filters {
+		filter, err := request.Filters[i].toDomain()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		tft.Filters = append(tft.Filters, filter)
+	}
+
+	ebi, err := r.control.Activate(request.IMSI, &gtpv2.QoSProfile{
+		QCI:   request.QCI,
+		MBRUL: request.MBRUplink,
+		MBRDL: request.MBRDownlink,
+		GBRUL: request.GBRUplink,
+		GBRDL: request.GBRDownlink,
+	}, tft)
+	if err != nil {
+		log.WithError(err).Warn("Dedicated bearer activation error")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(bearerResponse{EBI: ebi}); err != nil {
+		log.WithError(err).Warn("Bearer response encoding error")
+	}
+}
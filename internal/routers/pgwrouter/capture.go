@@ -0,0 +1,161 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/capturehdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+// ErrInvalidPeer indicates that the peer of the capture filter isn't an IP address.
+var ErrInvalidPeer = errors.New("invalid peer address")
+
+type captureRequest struct {
+	IMSI      string `json:"imsi,omitempty"`
+	Peer      string `json:"peer,omitempty"`
+	UserPlane bool   `json:"userPlane"`
+}
+
+type captureResponse struct {
+	Active    bool      `json:"active"`
+	IMSI      string    `json:"imsi,omitempty"`
+	Peer      string    `json:"peer,omitempty"`
+	UserPlane bool      `json:"userPlane"`
+	Started   time.Time `json:"started"`
+	Packets   int       `json:"packets"`
+	Files     []string  `json:"files"`
+}
+
+// newCapturer creates the capturer of the planes served by the PGW function.
+func newCapturer(config *domain.Pgw, connection *gtpv2.Conn) *capturehdl.Capturer {
+	var (
+		local       *net.UDPAddr
+		userPlaneIP net.IP
+	)
+
+	if config.HasControlPlane() {
+		if addr, err := config.ControlPlane.GetAddress(); err == nil {
+			local = addr
+		}
+	}
+
+	if config.HasUserPlane() {
+		userPlaneIP = net.ParseIP(config.UserPlane.IP)
+	}
+
+	return capturehdl.New(config.Capture, connection, local, userPlaneIP)
+}
+
+func (f *captureRequest) toFilter() (capturehdl.Filter, error) {
+	filter := capturehdl.Filter{IMSI: f.IMSI, UserPlane: f.UserPlane}
+
+	if f.Peer != "" {
+		if filter.Peer = net.ParseIP(f.Peer); filter.Peer == nil {
+			return filter, errors.Wrapf(ErrInvalidPeer, "%q peer", f.Peer)
+		}
+	}
+
+	return filter, nil
+}
+
+func newCaptureResponse(status *capturehdl.Status) *captureResponse {
+	response := &captureResponse{
+		Active:    status.Active,
+		IMSI:      status.Filter.IMSI,
+		UserPlane: status.Filter.UserPlane,
+		Started:   status.Started,
+		Packets:   status.Packets,
+		Files:     status.Files,
+	}
+
+	if status.Filter.Peer != nil {
+		response.Peer = status.Filter.Peer.String()
+	}
+
+	if response.Files == nil {
+		response.Files = []string{}
+	}
+
+	return response
+}
+
+// handleCapture reports (GET), starts (POST) or stops (DELETE) the packet capture.
+func (r *router) handleCapture(w http.ResponseWriter, req *http.Request) {
+	var (
+		status *capturehdl.Status
+		err    error
+	)
+
+	switch req.Method {
+	case http.MethodGet:
+		status = r.capture.Status()
+	case http.MethodPost:
+		var request captureRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		filter, err := request.toFilter()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := r.capture.Start(filter); err != nil {
+			http.Error(w, err.Error(), getCaptureErrorStatus(err))
+
+			return
+		}
+
+		status = r.capture.Status()
+	case http.MethodDelete:
+		if status, err = r.capture.Stop(); err != nil {
+			http.Error(w, err.Error(), getCaptureErrorStatus(err))
+
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(newCaptureResponse(status)); err != nil {
+		log.WithError(err).Warn("Capture response encoding error")
+	}
+}
+
+func getCaptureErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, capturehdl.ErrCaptureActive), errors.Is(err, capturehdl.ErrNoCapture):
+		return http.StatusConflict
+	case errors.Is(err, capturehdl.ErrUnsupportedPlane):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
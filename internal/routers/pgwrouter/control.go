@@ -0,0 +1,173 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"context"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/handlers/capturehdl"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/handlers/retransmithdl"
+	"github.com/gw-tester/pgw/internal/handlers/shardhdl"
+	"github.com/gw-tester/pgw/internal/handlers/tracehdl"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Services are the repositories and observers used by the control function, all of them are optional.
+type Services struct {
+	Authenticator ports.Authenticator
+	Policies      ports.PolicyRepository
+	Addresses     ports.AddressRepository
+	Events        ports.EventPublisher
+	Metrics       *counterhdl.Metrics
+	Capture       *capturehdl.Capturer
+}
+
+// ControlFunction serves the S5/S8-C interface of the P-GW.
+type ControlFunction struct {
+	connection      *gtpv2.Conn
+	metrics         *counterhdl.Metrics
+	capture         *capturehdl.Capturer
+	retransmissions *retransmithdl.Cache
+	dispatcher      *shardhdl.Dispatcher
+	dedicated       *pgwhdl.Dedicated
+	drain           *pgwhdl.Drain
+	handlers        []pgwhdl.Handler
+}
+
+// NewControlFunction registers the handlers of the S5/S8-C messages in the connection. The
+// dedicated bearers are rejected when the user plane function which binds them is nil.
+func NewControlFunction(config *domain.Pgw, connection *gtpv2.Conn, userPlane, bearers pgwhdl.UserPlaneFunction,
+	services *Services,
+) *ControlFunction {
+	c := &ControlFunction{
+		connection: connection,
+		metrics:    services.Metrics,
+		capture:    services.Capture,
+		dispatcher: shardhdl.New(shardhdl.DefaultWorkers),
+	}
+
+	hooks := &pgwhdl.Hooks{Publisher: services.Events}
+
+	var (
+		blockObserver     pgwhdl.BlockObserver
+		admissionObserver pgwhdl.AdmissionObserver
+		cacheObserver     retransmithdl.Observer
+	)
+
+	if c.metrics != nil {
+		blockObserver, admissionObserver, cacheObserver = c.metrics, c.metrics, c.metrics
+		hooks.Observers = append(hooks.Observers, c.metrics)
+	}
+
+	if c.capture != nil {
+		hooks.Observers = append(hooks.Observers, c.capture)
+	}
+
+	c.retransmissions = retransmithdl.New(config.Retransmission.Window(), cacheObserver)
+	hooks.Observers = append(hooks.Observers, c.retransmissions)
+
+	createHdl := pgwhdl.NewCreate(config, services.Authenticator, services.Policies, services.Addresses, userPlane,
+		hooks)
+	deleteHdl := pgwhdl.NewDelete(userPlane, hooks)
+	modifyHdl := pgwhdl.NewModify(userPlane, hooks)
+	c.dedicated = pgwhdl.NewDedicated(bearers, hooks)
+	c.drain = pgwhdl.NewDrain(userPlane, hooks)
+	firewall := pgwhdl.NewFirewall(config, blockObserver, hooks)
+	overload := pgwhdl.NewOverload(config, admissionObserver, hooks)
+	hooks.Reporter = overload
+	c.handlers = append(c.handlers, createHdl, deleteHdl, modifyHdl, c.dedicated, c.drain)
+
+	create := c.drain.Wrap(createHdl.Handle)
+	if c.metrics != nil {
+		create = counterhdl.Wrap(create, c.metrics.SessionsCreated)
+	}
+
+	connection.AddHandler(message.MsgTypeCreateSessionRequest,
+		c.wrap(firewall.Wrap(overload.Wrap(c.dispatcher.Wrap(create)))))
+	connection.AddHandler(message.MsgTypeDeleteSessionRequest,
+		c.wrap(overload.Wrap(c.dispatcher.Wrap(deleteHdl.Handle))))
+	connection.AddHandler(message.MsgTypeModifyBearerRequest,
+		c.wrap(overload.Wrap(c.dispatcher.Wrap(modifyHdl.Handle))))
+	// The responses aren't serialized, the handlers of the requests may be waiting for them
+	connection.AddHandler(message.MsgTypeCreateBearerResponse, c.wrap(c.dedicated.Handle))
+	connection.AddHandler(message.MsgTypeDeleteBearerResponse, c.wrap(c.drain.Handle))
+
+	if c.metrics != nil {
+		// The PGW-U accounts the traffic of the sessions created by a control function
+		var traffic counterhdl.TrafficSource
+		if config.HasUserPlane() {
+			traffic = userPlane
+		}
+
+		if err := c.metrics.ObserveSessions(connection, config.Sgi.Subnet, traffic); err != nil {
+			log.WithError(err).Warn("Failed to export the session metrics")
+		}
+	}
+
+	return c
+}
+
+// wrap traces, logs, measures and captures the GTP-C transactions processed by a handler, the
+// retransmitted requests are answered with the cached responses.
+func (c *ControlFunction) wrap(handler gtpv2.HandlerFunc) gtpv2.HandlerFunc {
+	handler = c.retransmissions.Wrap(handler)
+
+	if c.capture != nil {
+		handler = c.capture.Wrap(handler)
+	}
+
+	if c.metrics != nil {
+		handler = c.metrics.Wrap(handler)
+	}
+
+	return tracehdl.Wrap(loggerhdl.Wrap(handler))
+}
+
+// Activate creates a dedicated bearer of the subscriber session for the traffic of the TFT.
+func (c *ControlFunction) Activate(imsi string, qos *gtpv2.QoSProfile, tft *domain.TrafficFlowTemplate) (uint8, error) {
+	return c.dedicated.Activate(c.connection, imsi, qos, tft)
+}
+
+// Drain rejects the new sessions and waits until the active ones are deleted or the context is done.
+func (c *ControlFunction) Drain(ctx context.Context, deactivate bool) {
+	c.drain.Drain(ctx, c.connection, deactivate)
+}
+
+// IsDraining indicates if the new sessions are rejected.
+func (c *ControlFunction) IsDraining() bool {
+	return c.drain.IsDraining()
+}
+
+// Close stops the handlers, the requests received afterwards aren't handled.
+func (c *ControlFunction) Close() error {
+	if err := c.dispatcher.Close(); err != nil {
+		return errors.Wrap(err, "failed to close the dispatcher")
+	}
+
+	for _, handler := range c.handlers {
+		if err := handler.Close(); err != nil {
+			return errors.Wrap(err, "failed to close a handler")
+		}
+	}
+
+	return nil
+}
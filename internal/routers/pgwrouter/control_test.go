@@ -0,0 +1,200 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	"github.com/gw-tester/pgw/internal/routers/pgwrouter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+type shaper struct{}
+
+func (shaper) Apply(ms net.IP, tunnel, sgi *domain.Link, guaranteed, maximum pgwhdl.BitRates) error {
+	return nil
+}
+
+func (shaper) Remove(ms net.IP) {}
+
+func (shaper) Counters(ms net.IP) (*domain.TrafficCounters, error) {
+	return nil, pgwhdl.ErrNotAccounted
+}
+
+// getFreeAddress retrieves a loopback address which isn't used.
+func getFreeAddress() *net.UDPAddr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	Expect(err).NotTo(HaveOccurred())
+
+	defer conn.Close()
+
+	laddr, _ := conn.LocalAddr().(*net.UDPAddr)
+
+	return laddr
+}
+
+// exchange sends a request from the S-GW socket and reads the response of the P-GW.
+func exchange(sgw *net.UDPConn, pgw net.Addr, request []byte, timeout time.Duration) ([]byte, error) {
+	if _, err := sgw.WriteTo(request, pgw); err != nil {
+		return nil, err
+	}
+
+	if err := sgw.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+
+	n, _, err := sgw.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func newCreateSessionRequest(seq uint32, imsi, ip string) *message.CreateSessionRequest {
+	return message.NewCreateSessionRequest(0, seq,
+		ie.NewIMSI(imsi),
+		ie.NewMSISDN("814000000000"),
+		ie.NewMobileEquipmentIdentity("123450000000000"),
+		ie.NewServingNetwork("440", "10"),
+		ie.NewRATType(gtpv2.RATTypeEUTRAN),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPC, 0x100, "127.0.0.1", ""),
+		ie.NewAccessPointName("internet"),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4),
+		ie.NewPDNAddressAllocation(ip),
+		ie.NewBearerContext(
+			ie.NewEPSBearerID(5),
+			ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPU, 0x200, "127.0.0.1", "").WithInstance(2),
+			ie.NewBearerQoS(1, 2, 1, 9, 0, 0, 0, 0),
+		),
+	)
+}
+
+var _ = Describe("ControlFunction", func() {
+	var (
+		cancel   context.CancelFunc
+		conn     *gtpv2.Conn
+		pgw      *net.UDPAddr
+		sgw      *net.UDPConn
+		metrics  *counterhdl.Metrics
+		datapath *netlinkdp.Memory
+		control  *pgwrouter.ControlFunction
+	)
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+		sgi := netlinkdp.NewLink("eth2", 2)
+		config, err := domain.New("127.0.0.1", "127.0.0.1", "", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		config.Sgi = &domain.Sgi{Link: sgi, Subnet: subnet}
+		datapath = netlinkdp.NewMemory(sgi, netlinkdp.NewLink(pgwhdl.KernelGTPLinkName, 10))
+		userPlane := pgwhdl.NewUserPlane(gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}),
+			datapath, config, shaper{}, nil)
+		metrics = counterhdl.NewMetrics(prometheus.NewRegistry())
+		pgw = getFreeAddress()
+		conn = gtpv2.NewConn(pgw, gtpv2.IFTypeS5S8PGWGTPC, 0)
+		control = pgwrouter.NewControlFunction(config, conn, userPlane, nil, &pgwrouter.Services{Metrics: metrics})
+
+		var ctx context.Context
+
+		ctx, cancel = context.WithCancel(context.Background())
+
+		go func() {
+			_ = conn.ListenAndServe(ctx)
+		}()
+
+		sgw, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		Expect(err).NotTo(HaveOccurred())
+
+		echo, err := message.NewEchoRequest(0, ie.NewRecovery(0)).Marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() error {
+			_, err := exchange(sgw, pgw, echo, 50*time.Millisecond)
+
+			return err
+		}, 10*time.Second).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(sgw.Close()).To(Succeed())
+		Expect(control.Close()).To(Succeed())
+		cancel()
+	})
+
+	Describe("receiving a retransmitted Create Session Request", func() {
+		It("should replay the response without creating the session again", func() {
+			request, err := newCreateSessionRequest(10, "123451234567891", "10.0.1.2").Marshal()
+			Expect(err).NotTo(HaveOccurred())
+
+			response, err := exchange(sgw, pgw, request, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			created, err := message.ParseCreateSessionResponse(response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Cause.MustCause()).To(Equal(gtpv2.CauseRequestAccepted))
+
+			replayed, err := exchange(sgw, pgw, request, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replayed).To(Equal(response))
+			Eventually(conn.SessionCount).Should(Equal(1))
+			Eventually(func() float64 {
+				return testutil.ToFloat64(metrics.SessionsCreated)
+			}).Should(Equal(float64(1)))
+			Eventually(datapath.Tunnels).Should(HaveLen(1))
+		})
+	})
+
+	Describe("activating a dedicated bearer", func() {
+		It("should be rejected when the datapath doesn't support them", func() {
+			_, err := control.Activate("123451234567891", &gtpv2.QoSProfile{QCI: 1}, &domain.TrafficFlowTemplate{})
+			Expect(err).To(MatchError(pgwhdl.ErrUnsupportedDatapath))
+		})
+	})
+
+	Describe("draining the sessions", func() {
+		It("should reject the new sessions", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			control.Drain(ctx, false)
+			Expect(control.IsDraining()).To(BeTrue())
+
+			request, err := newCreateSessionRequest(11, "123451234567892", "10.0.1.3").Marshal()
+			Expect(err).NotTo(HaveOccurred())
+
+			response, err := exchange(sgw, pgw, request, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			rejected, err := message.ParseCreateSessionResponse(response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rejected.Cause.MustCause()).To(Equal(gtpv2.CauseNoResourcesAvailable))
+		})
+	})
+})
@@ -0,0 +1,79 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"context"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// stuckUserPlane is an user plane function whose closing waits until it's released.
+type stuckUserPlane struct {
+	pgwhdl.UserPlaneFunction
+	release chan struct{}
+	closes  chan struct{}
+}
+
+func (s *stuckUserPlane) Close() error {
+	s.closes <- struct{}{}
+	<-s.release
+
+	return nil
+}
+
+var _ = Describe("Router teardown", func() {
+	var (
+		r         *router
+		userPlane *stuckUserPlane
+	)
+
+	BeforeEach(func() {
+		userPlane = &stuckUserPlane{release: make(chan struct{}), closes: make(chan struct{}, 2)}
+		r = &router{userPlaneFunction: userPlane}
+	})
+
+	AfterEach(func() {
+		close(userPlane.release)
+	})
+
+	It("should be cut short by the drain deadline", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			r.closeUntil(ctx)
+		}()
+
+		Eventually(done, time.Second).Should(BeClosed())
+		Expect(userPlane.closes).To(HaveLen(1))
+	})
+
+	It("should be torn down once", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		r.closeUntil(ctx)
+
+		Expect(r.Close()).To(Succeed())
+		Expect(userPlane.closes).To(HaveLen(1))
+	})
+})
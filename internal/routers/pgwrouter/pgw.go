@@ -15,41 +15,56 @@ package pgwrouter
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/InVisionApp/go-health/v2"
 	"github.com/InVisionApp/go-health/v2/handlers"
 	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/core/ports"
+	"github.com/gw-tester/pgw/internal/datapaths/netlinkdp"
+	"github.com/gw-tester/pgw/internal/datapaths/userdp"
+	"github.com/gw-tester/pgw/internal/handlers/capturehdl"
 	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
-	"github.com/gw-tester/pgw/internal/handlers/loggerhdl"
+	"github.com/gw-tester/pgw/internal/handlers/pfcphdl"
 	"github.com/gw-tester/pgw/internal/handlers/pgwhdl"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/gw-tester/pgw/internal/protocols/pfcp"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"github.com/wmnsk/go-gtp/gtpv1"
 	"github.com/wmnsk/go-gtp/gtpv2"
-	"github.com/wmnsk/go-gtp/gtpv2/message"
 )
 
 type router struct {
+	// mutex guards the readiness of the planes, which are served by their own goroutines
 	mutex           sync.Mutex
 	ControlPlane    controlPlane
 	UserPlane       userPlane
+	SxbPlane        sxbPlane
 	ManagementPlane managementPlane
 
-	sessionsProcessed prometheus.Counter
-	handlers          []pgwhdl.Handler
+	mux               *http.ServeMux
+	metrics           *counterhdl.Metrics
+	control           *ControlFunction
+	userPlaneFunction pgwhdl.UserPlaneFunction
+	shaper            *pgwhdl.TrafficShaper
+	config            *domain.Pgw
+	reloader          ports.ConfigReloader
+	policies          ports.PolicyRepository
+	addresses         ports.AddressRepository
+	capture           *capturehdl.Capturer
 
 	errorChan chan error
+	// closed is set once the router is torn down, by the drain or by its owner
+	closed int32
 }
 
 type controlPlane struct {
@@ -60,14 +75,26 @@ type controlPlane struct {
 
 type userPlane struct {
 	Connection *gtpv1.UPlaneConn
+	Forwarder  *userdp.Forwarder
+	Datapath   ports.Datapath
 	Address    string
 	isReady    bool
 }
 
+type sxbPlane struct {
+	Connection *pfcp.Conn
+	Address    string
+	sxb        *pgwhdl.Sxb
+	isReady    bool
+}
+
 type managementPlane struct {
 	health *health.Health
 }
 
+// associationRetryInterval is the time waited by the PGW-C before retrying the PFCP association.
+const associationRetryInterval = time.Duration(5) * time.Second
+
 // ErrPlaneNotReady indicates that user and/or control plane services are not ready yet.
 var ErrPlaneNotReady = errors.New("not ready")
 
@@ -77,62 +104,97 @@ type Router interface {
 	Close() error
 }
 
-func (r *router) registerHandlers(config *domain.Pgw) {
-	createHdl := pgwhdl.NewCreate(r.UserPlane.Connection, config)
-	r.handlers = append(r.handlers, createHdl)
+func (r *router) registerHandlers(config *domain.Pgw, authenticator ports.Authenticator,
+	events ports.EventPublisher,
+) {
+	r.mux.HandleFunc("/healthcheck", handlers.NewJSONHandlerFunc(r.ManagementPlane.health, nil))
+	r.mux.Handle("/metrics", promhttp.Handler())
+	r.mux.HandleFunc("/log/level", logLevel)
+	r.mux.HandleFunc("/config/reload", r.handleReload)
+
+	r.capture = newCapturer(config, r.ControlPlane.Connection)
+	r.mux.HandleFunc("/capture", r.handleCapture)
+
+	var bearers pgwhdl.UserPlaneFunction
+
+	if r.SxbPlane.sxb != nil {
+		r.userPlaneFunction = r.SxbPlane.sxb
+		bearers = r.SxbPlane.sxb
+	} else {
+		var binder pgwhdl.BearerBinder
+		if r.UserPlane.Forwarder != nil {
+			binder = r.UserPlane.Forwarder
+		}
 
-	r.ControlPlane.Connection.AddHandler(message.MsgTypeCreateSessionRequest, loggerhdl.Wrap(counterhdl.Wrap(
-		createHdl.Handle, r.sessionsProcessed)))
+		userPlane := pgwhdl.NewUserPlane(r.UserPlane.Connection, r.UserPlane.Datapath, config, r.shaper, binder)
+		r.userPlaneFunction = userPlane
 
-	r.ControlPlane.Connection.AddHandler(message.MsgTypeDeleteSessionRequest, loggerhdl.Wrap(
-		pgwhdl.Handle))
+		if !config.HasControlPlane() {
+			r.registerSxbHandlers(userPlane, net.ParseIP(config.UserPlane.IP))
 
-	http.HandleFunc("/healthcheck", handlers.NewJSONHandlerFunc(r.ManagementPlane.health, nil))
-	http.Handle("/metrics", promhttp.Handler())
-}
-
-// New initialize a router object with user and control plane connections.
-func New(config *domain.Pgw, h *health.Health) Router {
-	if err := config.Validate(); err != nil {
-		log.WithError(err).Error("Invalid PGW domain object")
+			return
+		}
 
-		return nil
+		if userPlane.SupportsDedicatedBearers() {
+			bearers = userPlane
+		}
 	}
 
-	controlPlaneAddr, err := config.ControlPlane.GetAddress()
-	if err != nil {
-		log.WithError(err).Error("Control Plane get address error")
+	r.control = NewControlFunction(config, r.ControlPlane.Connection, r.userPlaneFunction, bearers, &Services{
+		Authenticator: authenticator,
+		Policies:      r.policies,
+		Addresses:     r.addresses,
+		Events:        events,
+		Metrics:       r.metrics,
+		Capture:       r.capture,
+	})
+
+	r.mux.HandleFunc("/bearers", r.createBearer)
+	r.mux.HandleFunc("/sessions", r.listSessions)
+	r.mux.HandleFunc("/policies", r.handlePolicies)
+	r.mux.HandleFunc("/addresses", r.handleAddresses)
+}
 
-		return nil
-	}
+// registerSxbHandlers applies the rules received from the PGW-C to the local user plane.
+func (r *router) registerSxbHandlers(userPlane pgwhdl.UserPlaneFunction, userPlaneIP net.IP) {
+	sessions := pfcphdl.New(userPlane, userPlaneIP, userPlaneIP)
 
-	userPlaneAddr, err := config.UserPlane.GetAddress()
-	if err != nil {
-		log.WithError(err).Error("User Plane get address error")
+	r.SxbPlane.Connection.AddHandler(pfcp.MsgTypeAssociationSetupRequest, sessions.HandleAssociationSetup)
+	r.SxbPlane.Connection.AddHandler(pfcp.MsgTypeSessionEstablishmentRequest, sessions.HandleSessionEstablishment)
+	r.SxbPlane.Connection.AddHandler(pfcp.MsgTypeSessionModificationRequest, sessions.HandleSessionModification)
+	r.SxbPlane.Connection.AddHandler(pfcp.MsgTypeSessionDeletionRequest, sessions.HandleSessionDeletion)
+}
+
+// New initialize a router object with the connections of the planes served by the PGW function.
+func New(config *domain.Pgw, h *health.Health, authenticator ports.Authenticator,
+	policies ports.PolicyRepository, addresses ports.AddressRepository, metrics *counterhdl.Metrics,
+	events ports.EventPublisher, reloader ports.ConfigReloader,
+) Router {
+	if err := config.Validate(); err != nil {
+		log.WithError(err).Error("Invalid PGW domain object")
 
 		return nil
 	}
 
 	router := &router{
-		ControlPlane: controlPlane{
-			Connection: gtpv2.NewConn(controlPlaneAddr, gtpv2.IFTypeS5S8PGWGTPC, 0),
-			Address:    controlPlaneAddr.String(),
-		},
-		UserPlane: userPlane{
-			Connection: gtpv1.NewUPlaneConn(userPlaneAddr),
-			Address:    userPlaneAddr.String(),
-		},
 		ManagementPlane: managementPlane{
 			health: h,
 		},
-		sessionsProcessed: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "sessions_created_total",
-			Help: "Create Session Request",
-		}),
-		handlers:  []pgwhdl.Handler{},
+		mux:       http.NewServeMux(),
+		metrics:   metrics,
+		config:    config,
+		reloader:  reloader,
+		policies:  policies,
+		addresses: addresses,
 		errorChan: nil,
 	}
 
+	if err := router.setupPlanes(config); err != nil {
+		log.WithError(err).Error("PGW planes setup error")
+
+		return nil
+	}
+
 	if err := h.AddChecks([]*health.Config{
 		{
 			Name:     "main-check",
@@ -144,27 +206,132 @@ func New(config *domain.Pgw, h *health.Health) Router {
 		log.WithError(err).Warn("Add main check error")
 	}
 
-	router.registerHandlers(config)
+	router.registerHandlers(config, authenticator, events)
 
 	return router
 }
 
+// setupPlanes creates the connections of the control, user and Sxb planes used by the PGW function.
+func (r *router) setupPlanes(config *domain.Pgw) error {
+	if config.HasControlPlane() {
+		controlPlaneAddr, err := config.ControlPlane.GetAddress()
+		if err != nil {
+			return errors.Wrap(err, "failed to get control plane address")
+		}
+
+		r.ControlPlane = controlPlane{
+			Connection: gtpv2.NewConn(controlPlaneAddr, gtpv2.IFTypeS5S8PGWGTPC, 0),
+			Address:    controlPlaneAddr.String(),
+		}
+	}
+
+	if config.HasUserPlane() {
+		userPlaneAddr, err := config.UserPlane.GetAddress()
+		if err != nil {
+			return errors.Wrap(err, "failed to get user plane address")
+		}
+
+		r.UserPlane = userPlane{
+			Connection: gtpv1.NewUPlaneConn(userPlaneAddr),
+			Address:    userPlaneAddr.String(),
+		}
+		if err := r.setupDatapath(config.UserPlane); err != nil {
+			return errors.Wrap(err, "failed to setup user plane datapath")
+		}
+	}
+
+	if config.Function != domain.FunctionControlPlane && config.Function != domain.FunctionUserPlane {
+		return nil
+	}
+
+	conn, err := pfcp.Listen(config.Sxb.Address)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Sxb connection")
+	}
+
+	r.SxbPlane = sxbPlane{
+		Connection: conn,
+		Address:    conn.LocalAddr().String(),
+	}
+
+	if config.Function == domain.FunctionControlPlane {
+		peer, err := config.Sxb.GetPeerAddress()
+		if err != nil {
+			return err
+		}
+
+		r.SxbPlane.sxb = pgwhdl.NewSxb(conn, net.ParseIP(config.ControlPlane.IP), peer)
+	}
+
+	return nil
+}
+
+// setupDatapath prepares the GTP-U forwarding of the selected datapath mode, falling back
+// to userspace forwarding in auto mode when the kernel GTP module can't be used. The configured
+// mode is kept, the userspace datapath handles the tunnels of the fallback.
+func (r *router) setupDatapath(config *domain.UserPlane) error {
+	host := netlinkdp.NewNetlink(r.UserPlane.Connection)
+	mode := config.Datapath
+
+	r.UserPlane.Datapath = host
+	r.shaper = pgwhdl.NewTrafficShaper(netlinkdp.NewTrafficControl())
+
+	switch mode {
+	case domain.DatapathUserspace:
+	case domain.DatapathAuto:
+		err := r.UserPlane.Connection.EnableKernelGTP(pgwhdl.KernelGTPLinkName, gtpv1.RoleGGSN)
+		if err == nil {
+			return nil
+		}
+
+		log.WithError(err).Warn("Kernel GTP is unavailable, falling back to userspace datapath")
+
+		r.UserPlane.Connection.KernelGTP.Link = nil
+		mode = domain.DatapathUserspace
+	default:
+		if err := r.UserPlane.Connection.EnableKernelGTP(pgwhdl.KernelGTPLinkName, gtpv1.RoleGGSN); err != nil {
+			return errors.Wrap(err, "failed to enable Kernel GTP")
+		}
+
+		if mode != domain.DatapathTFT {
+			return nil
+		}
+	}
+
+	forwarder, err := userdp.New(r.UserPlane.Connection, mode+"-pgw")
+	if err != nil {
+		return errors.Wrap(err, "failed to create the User Plane forwarder")
+	}
+
+	r.UserPlane.Forwarder = forwarder
+
+	if mode == domain.DatapathUserspace {
+		datapath := userdp.NewDatapath(forwarder, host, netlinkdp.NewTrafficControl())
+		r.UserPlane.Datapath = datapath
+		r.shaper = pgwhdl.NewTrafficShaper(datapath)
+	}
+
+	log.WithFields(log.Fields{
+		"datapath":    mode,
+		"device":      forwarder.Link().Name,
+		"provisioned": forwarder.Provisioned(),
+	}).Info("Userspace GTP-U forwarding enabled")
+
+	return nil
+}
+
 // ListenAndServe initiates user and control plane connections and waits for incomming requests.
+// The configuration is reloaded on SIGHUP and the sessions are drained before returning when the
+// process is terminated.
 func (r *router) ListenAndServe() {
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGHUP)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	fatalCh := make(chan error)
 
-	if err := r.UserPlane.Connection.EnableKernelGTP("gtp-pgw", gtpv1.RoleGGSN); err != nil {
-		log.WithError(err).Error("Enable Kernel GTP error")
-
-		return
-	}
-
 	go func() {
 		if err := r.run(ctx); err != nil {
 			fatalCh <- err
@@ -174,7 +341,18 @@ func (r *router) ListenAndServe() {
 	for {
 		select {
 		case sig := <-sigCh:
-			log.Println(sig)
+			log.WithField("signal", sig).Info("Signal received")
+
+			switch sig {
+			case syscall.SIGHUP:
+				if _, err := r.reload(); err != nil {
+					log.WithError(err).Error("Failed to reload the configuration")
+				}
+
+				continue
+			case syscall.SIGTERM:
+				r.drainSessions()
+			}
 
 			return
 		case err := <-r.errorChan:
@@ -187,45 +365,57 @@ func (r *router) ListenAndServe() {
 	}
 }
 
-func (r *router) run(ctx context.Context) error {
-	go func() {
-		r.ControlPlane.isReady = true
-		if err := r.ControlPlane.Connection.ListenAndServe(ctx); err != nil {
-			log.WithError(err).Warn("Control Plane Listen and Serve error")
+// drainSessions rejects the new sessions and gives the active ones until the drain timeout for
+// being deleted, while the control plane is still served. The router is torn down afterwards
+// within the same deadline.
+func (r *router) drainSessions() {
+	if r.control == nil || r.config.Shutdown == nil {
+		return
+	}
 
-			r.ControlPlane.isReady = false
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Shutdown.DrainTimeout)
+	defer cancel()
 
-			return
-		}
+	r.control.Drain(ctx, r.config.Shutdown.DeactivateSessions)
+	r.closeUntil(ctx)
+}
 
-		r.ControlPlane.isReady = false
-	}()
-	log.WithFields(log.Fields{
-		"S5-C": r.ControlPlane.Address,
-	}).Info("Started serving S5-C")
+// closeUntil tears the router down, giving up when the context is done before it finishes.
+func (r *router) closeUntil(ctx context.Context) {
+	done := make(chan struct{})
 
 	go func() {
-		r.UserPlane.isReady = true
-		if err := r.UserPlane.Connection.ListenAndServe(ctx); err != nil {
-			log.WithError(err).Warn("User Plane Listen and Serve error")
+		defer close(done)
 
-			r.UserPlane.isReady = false
+		_ = r.Close()
+	}()
 
-			return
-		}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.WithError(ctx.Err()).Warn("Teardown cut short by the drain deadline")
+	}
+}
 
-		r.UserPlane.isReady = false
-	}()
-	log.WithFields(log.Fields{
-		"S5-U": r.UserPlane.Address,
-	}).Info("Started serving S5-U")
+func (r *router) run(ctx context.Context) error {
+	if r.ControlPlane.Connection != nil {
+		r.serveControlPlane(ctx)
+	}
+
+	if r.UserPlane.Connection != nil {
+		r.serveUserPlane(ctx)
+	}
+
+	if r.SxbPlane.Connection != nil {
+		r.serveSxb(ctx)
+	}
 
 	go func() {
 		if err := r.ManagementPlane.health.Start(); err != nil {
 			log.WithError(err).Warn("Unable to start healthcheck")
 		}
 
-		if err := http.ListenAndServe(":8080", nil); err != nil {
+		if err := http.ListenAndServe(":8080", r.mux); err != nil {
 			log.WithError(err).Warn("Management Plane Listen and Serve error")
 
 			return
@@ -234,7 +424,7 @@ func (r *router) run(ctx context.Context) error {
 		log.Warn("Management Plane Connection ListenAndServe method exitted")
 	}()
 
-	fmt.Println("P-GW server has started") //nolint:forbidigo
+	log.Info("P-GW server has started")
 
 	for {
 		select {
@@ -248,17 +438,126 @@ func (r *router) run(ctx context.Context) error {
 	}
 }
 
-// Close removes rules and routes added by the Router and closes user plane connnection.
+func (r *router) serveControlPlane(ctx context.Context) {
+	go func() {
+		r.setReady(&r.ControlPlane.isReady, true)
+		defer r.setReady(&r.ControlPlane.isReady, false)
+
+		if err := r.ControlPlane.Connection.ListenAndServe(ctx); err != nil {
+			log.WithError(err).Warn("Control Plane Listen and Serve error")
+		}
+	}()
+	log.WithFields(log.Fields{
+		"S5-C": r.ControlPlane.Address,
+	}).Info("Started serving S5-C")
+}
+
+func (r *router) serveUserPlane(ctx context.Context) {
+	go func() {
+		r.setReady(&r.UserPlane.isReady, true)
+		defer r.setReady(&r.UserPlane.isReady, false)
+
+		if err := r.UserPlane.Connection.ListenAndServe(ctx); err != nil {
+			log.WithError(err).Warn("User Plane Listen and Serve error")
+		}
+	}()
+	log.WithFields(log.Fields{
+		"S5-U": r.UserPlane.Address,
+	}).Info("Started serving S5-U")
+
+	if r.UserPlane.Forwarder != nil {
+		go func() {
+			if err := r.UserPlane.Forwarder.ListenAndServe(ctx); err != nil {
+				log.WithError(err).Warn("User Plane Forwarder Listen and Serve error")
+			}
+		}()
+	}
+}
+
+// serveSxb listens for PFCP messages. The PGW-C is ready once the association with its PGW-U
+// is established, which is retried until it succeeds.
+func (r *router) serveSxb(ctx context.Context) {
+	go func() {
+		if err := r.SxbPlane.Connection.ListenAndServe(ctx); err != nil {
+			log.WithError(err).Warn("Sxb Listen and Serve error")
+		}
+
+		r.setReady(&r.SxbPlane.isReady, false)
+	}()
+	log.WithFields(log.Fields{
+		"Sxb": r.SxbPlane.Address,
+	}).Info("Started serving Sxb")
+
+	if r.SxbPlane.sxb == nil {
+		r.setReady(&r.SxbPlane.isReady, true)
+
+		return
+	}
+
+	go func() {
+		for {
+			err := r.SxbPlane.sxb.Associate()
+			if err == nil {
+				r.setReady(&r.SxbPlane.isReady, true)
+
+				return
+			}
+
+			log.WithError(err).Warn("PFCP association error")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(associationRetryInterval):
+			}
+		}
+	}()
+}
+
+// setReady changes the readiness of a plane, which is read by the health check.
+func (r *router) setReady(isReady *bool, ready bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	*isReady = ready
+}
+
+// Close removes rules and routes added by the Router and closes user plane connnection. The
+// router is only torn down once.
 func (r *router) Close() error {
-	for _, handler := range r.handlers {
-		if err := handler.Close(); err != nil {
-			log.WithError(err).Warn("Close Handler error")
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+
+	if r.capture != nil {
+		if err := r.capture.Close(); err != nil {
+			log.WithError(err).Warn("Close Capture error")
+		}
+	}
+
+	if r.control != nil {
+		if err := r.control.Close(); err != nil {
+			log.WithError(err).Warn("Close Control Function error")
+		}
+	}
+
+	if r.userPlaneFunction != nil {
+		if err := r.userPlaneFunction.Close(); err != nil {
+			log.WithError(err).Warn("Close User Plane Function error")
+		}
+	}
+
+	if r.shaper != nil {
+		if err := r.shaper.Close(); err != nil {
+			log.WithError(err).Warn("Close Traffic Shaper error")
 		}
 	}
 
 	if r.UserPlane.Connection != nil {
-		if err := netlink.LinkDel(r.UserPlane.Connection.KernelGTP.Link); err != nil {
-			log.WithError(err).Warn("Kernel GTP Link Deletion error")
+		if r.UserPlane.Connection.KernelGTP.Link != nil {
+			if err := netlink.LinkDel(r.UserPlane.Connection.KernelGTP.Link); err != nil {
+				log.WithError(err).Warn("Kernel GTP Link Deletion error")
+			}
 		}
 
 		if err := r.UserPlane.Connection.Close(); err != nil {
@@ -266,7 +565,21 @@ func (r *router) Close() error {
 		}
 	}
 
-	close(r.errorChan)
+	if r.UserPlane.Forwarder != nil {
+		if err := r.UserPlane.Forwarder.Close(); err != nil {
+			log.WithError(err).Warn("Close User Plane Forwarder error")
+		}
+	}
+
+	if r.SxbPlane.Connection != nil {
+		if err := r.SxbPlane.Connection.Close(); err != nil {
+			log.WithError(err).Warn("Close Sxb Connection error")
+		}
+	}
+
+	if r.errorChan != nil {
+		close(r.errorChan)
+	}
 
 	return nil
 }
@@ -275,9 +588,30 @@ func (r *router) Status() (interface{}, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	response := map[string]bool{"ControlPlane": r.ControlPlane.isReady, "UserPlane": r.UserPlane.isReady}
+	response := map[string]bool{}
+	ready := true
+
+	for name, plane := range map[string]struct {
+		enabled bool
+		isReady bool
+	}{
+		"ControlPlane": {r.ControlPlane.Connection != nil, r.ControlPlane.isReady},
+		"UserPlane":    {r.UserPlane.Connection != nil, r.UserPlane.isReady},
+		"Sxb":          {r.SxbPlane.Connection != nil, r.SxbPlane.isReady},
+	} {
+		if plane.enabled {
+			response[name] = plane.isReady
+			ready = ready && plane.isReady
+		}
+	}
+
+	// The sessions are moved to other P-GWs once the draining starts
+	if r.control != nil && r.control.IsDraining() {
+		response["Draining"] = true
+		ready = false
+	}
 
-	if r.ControlPlane.isReady && r.UserPlane.isReady {
+	if ready {
 		return response, nil
 	}
 
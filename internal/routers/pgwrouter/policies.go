@@ -0,0 +1,77 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// handlePolicies reports (GET) or replaces (PUT) the subscriber policies shared through the datastore.
+func (r *router) handlePolicies(w http.ResponseWriter, req *http.Request) {
+	if r.policies == nil {
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		policies := []*domain.SubscriberPolicy{}
+		if err := json.NewDecoder(req.Body).Decode(&policies); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if err := r.policies.Replace(policies); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, domain.ErrInvalidPolicy) {
+				status = http.StatusUnprocessableEntity
+			}
+
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+
+		log.WithField("policies", len(policies)).Info("Subscriber policies replaced")
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	policies, err := r.policies.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if policies == nil {
+		policies = []*domain.SubscriberPolicy{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(policies); err != nil {
+		log.WithError(err).Warn("Subscriber policies response encoding error")
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"net"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv1"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Router readiness", func() {
+	var r *router
+
+	BeforeEach(func() {
+		r = &router{
+			ControlPlane: controlPlane{
+				Connection: gtpv2.NewConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, gtpv2.IFTypeS5S8PGWGTPC, 0),
+			},
+			UserPlane: userPlane{
+				Connection: gtpv1.NewUPlaneConn(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}),
+			},
+		}
+	})
+
+	It("should not be ready until every plane is served", func() {
+		r.setReady(&r.ControlPlane.isReady, true)
+
+		status, err := r.Status()
+		Expect(err).To(MatchError(ErrPlaneNotReady))
+		Expect(status).To(Equal(map[string]bool{"ControlPlane": true, "UserPlane": false}))
+	})
+
+	It("should report the readiness changed by the serving goroutines", func() {
+		var wg sync.WaitGroup
+
+		for _, isReady := range []*bool{&r.ControlPlane.isReady, &r.UserPlane.isReady} {
+			wg.Add(1)
+
+			go func(isReady *bool) {
+				defer wg.Done()
+
+				for i := 0; i < 100; i++ {
+					r.setReady(isReady, i%2 == 0)
+				}
+
+				r.setReady(isReady, true)
+			}(isReady)
+		}
+
+		for i := 0; i < 100; i++ {
+			_, _ = r.Status()
+		}
+
+		wg.Wait()
+
+		status, err := r.Status()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(map[string]bool{"ControlPlane": true, "UserPlane": true}))
+	})
+})
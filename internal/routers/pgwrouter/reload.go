@@ -0,0 +1,99 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrReloadUnsupported indicates that the P-GW wasn't started with a configuration reloader.
+var ErrReloadUnsupported = errors.New("configuration reload isn't supported")
+
+type configChange struct {
+	Field   string `json:"field"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Applied bool   `json:"applied"`
+}
+
+// reload applies the configuration changes to the new sessions, the existing ones keep running.
+func (r *router) reload() ([]*domain.ConfigChange, error) {
+	if r.reloader == nil {
+		return nil, ErrReloadUnsupported
+	}
+
+	changes, err := r.reloader.Reload()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reload the configuration")
+	}
+
+	if r.metrics != nil {
+		r.metrics.ResizePool(r.config.GetSubnet())
+	}
+
+	for _, change := range changes {
+		log.WithFields(log.Fields{
+			"field":   change.Field,
+			"old":     change.Old,
+			"new":     change.New,
+			"applied": change.Applied,
+		}).Info("Configuration changed")
+	}
+
+	log.WithField("changes", len(changes)).Info("Configuration reloaded")
+
+	return changes, nil
+}
+
+// handleReload reloads the configuration (POST) and reports the changes.
+func (r *router) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	changes, err := r.reload()
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, ErrReloadUnsupported) {
+			status = http.StatusNotImplemented
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	response := make([]configChange, 0, len(changes))
+	for _, change := range changes {
+		response = append(response, configChange{
+			Field:   change.Field,
+			Old:     change.Old,
+			New:     change.New,
+			Applied: change.Applied,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithError(err).Warn("Configuration reload response encoding error")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"net"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	"github.com/gw-tester/pgw/internal/handlers/counterhdl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reloader reports a fixed set of configuration changes.
+type reloader struct {
+	changes []*domain.ConfigChange
+}
+
+func (r reloader) Reload() ([]*domain.ConfigChange, error) {
+	return r.changes, nil
+}
+
+var _ = Describe("Router reload", func() {
+	var r *router
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+		config, err := domain.New("127.0.0.1", "127.0.0.1", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		config.Sgi = &domain.Sgi{Subnet: subnet}
+
+		r = &router{
+			config:   config,
+			reloader: reloader{changes: []*domain.ConfigChange{{Field: "log.level", Old: "info", New: "debug", Applied: true}}},
+		}
+	})
+
+	It("should reload the configuration without metrics", func() {
+		changes, err := r.reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+	})
+
+	It("should reload the configuration with metrics", func() {
+		r.metrics = counterhdl.NewMetrics(prometheus.NewRegistry())
+
+		changes, err := r.reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+	})
+
+	It("should refuse the reload without a reloader", func() {
+		r.reloader = nil
+
+		_, err := r.reload()
+		Expect(err).To(MatchError(ErrReloadUnsupported))
+	})
+})
@@ -0,0 +1,105 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pgwrouter
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gw-tester/pgw/internal/core/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+type trafficResponse struct {
+	UplinkPackets   uint64 `json:"uplinkPackets"`
+	UplinkBytes     uint64 `json:"uplinkBytes"`
+	DownlinkPackets uint64 `json:"downlinkPackets"`
+	DownlinkBytes   uint64 `json:"downlinkBytes"`
+}
+
+type sessionResponse struct {
+	IMSI    string           `json:"imsi"`
+	MSISDN  string           `json:"msisdn"`
+	APN     string           `json:"apn"`
+	IP      string           `json:"ip"`
+	Bearers int              `json:"bearers"`
+	Traffic *trafficResponse `json:"traffic,omitempty"`
+}
+
+type sessionsResponse struct {
+	Sessions []*sessionResponse          `json:"sessions"`
+	APNs     map[string]*trafficResponse `json:"apns"`
+}
+
+func newTrafficResponse(counters *domain.TrafficCounters) *trafficResponse {
+	return &trafficResponse{
+		UplinkPackets:   counters.UplinkPackets,
+		UplinkBytes:     counters.UplinkBytes,
+		DownlinkPackets: counters.DownlinkPackets,
+		DownlinkBytes:   counters.DownlinkBytes,
+	}
+}
+
+// listSessions reports the active sessions and their user plane traffic aggregated by APN.
+func (r *router) listSessions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	response := sessionsResponse{
+		Sessions: []*sessionResponse{},
+		APNs:     map[string]*trafficResponse{},
+	}
+	apns := map[string]*domain.TrafficCounters{}
+
+	for _, session := range r.ControlPlane.Connection.Sessions() {
+		bearer := session.GetDefaultBearer()
+		if !session.IsActive() || bearer == nil {
+			continue
+		}
+
+		item := &sessionResponse{
+			IMSI:    session.IMSI,
+			MSISDN:  session.MSISDN,
+			APN:     bearer.APN,
+			IP:      bearer.SubscriberIP,
+			Bearers: session.BearerCount(),
+		}
+
+		if counters, err := r.userPlaneFunction.Counters(net.ParseIP(bearer.SubscriberIP)); err == nil {
+			item.Traffic = newTrafficResponse(counters)
+
+			if _, ok := apns[bearer.APN]; !ok {
+				apns[bearer.APN] = &domain.TrafficCounters{}
+			}
+
+			apns[bearer.APN].Add(counters)
+		}
+
+		response.Sessions = append(response.Sessions, item)
+	}
+
+	for apn, counters := range apns {
+		response.APNs[apn] = newTrafficResponse(counters)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithError(err).Warn("Sessions response encoding error")
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInvalidConfig indicates that the load can't be generated with the given configuration.
+var ErrInvalidConfig = errors.New("invalid load generator configuration")
+
+// Config defines the load driven against the P-GW.
+type Config struct {
+	// AttachRate and DetachRate are expressed in requests per second.
+	AttachRate float64
+	DetachRate float64
+	Duration   time.Duration
+	// MaxSessions limits the attached subscribers, zero means no limit.
+	MaxSessions int
+	// DetachAll releases the remaining sessions once the duration is over.
+	DetachAll  bool
+	IMSIBase   string
+	MSISDNBase string
+	APN        string
+	Subnet     *net.IPNet
+}
+
+// Validate checks that the configuration can be used to generate load.
+func (c *Config) Validate() error {
+	if c.AttachRate <= 0 {
+		return errors.Wrap(ErrInvalidConfig, "attach rate has to be positive")
+	}
+
+	if c.DetachRate < 0 {
+		return errors.Wrap(ErrInvalidConfig, "detach rate can't be negative")
+	}
+
+	if c.Duration <= 0 {
+		return errors.Wrap(ErrInvalidConfig, "duration has to be positive")
+	}
+
+	if c.Subnet == nil || c.Subnet.IP.To4() == nil {
+		return errors.Wrap(ErrInvalidConfig, "an IPv4 UE subnet is required")
+	}
+
+	if _, ok := new(big.Int).SetString(c.IMSIBase, 10); !ok {
+		return errors.Wrapf(ErrInvalidConfig, "%q IMSI base isn't numeric", c.IMSIBase)
+	}
+
+	if _, ok := new(big.Int).SetString(c.MSISDNBase, 10); !ok {
+		return errors.Wrapf(ErrInvalidConfig, "%q MSISDN base isn't numeric", c.MSISDNBase)
+	}
+
+	return nil
+}
+
+// Generator attaches and detaches synthetic subscribers through a simulated S-GW.
+type Generator struct {
+	mutex  sync.Mutex
+	sgw    *sgwsim.SGW
+	config *Config
+	report *Report
+	active []*sgwsim.Session
+	next   int64
+	wg     sync.WaitGroup
+}
+
+// New creates a load generator which uses the given S-GW.
+func New(sgw *sgwsim.SGW, config *Config) (*Generator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Generator{
+		sgw:    sgw,
+		config: config,
+		report: newReport(),
+		active: []*sgwsim.Session{},
+	}, nil
+}
+
+// Run drives the attach and detach rates until the duration is over or the context is done.
+func (g *Generator) Run(ctx context.Context) *Report {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, g.config.Duration)
+
+	defer cancel()
+
+	attach := time.NewTicker(interval(g.config.AttachRate))
+	defer attach.Stop()
+
+	var detach <-chan time.Time
+
+	if g.config.DetachRate > 0 {
+		ticker := time.NewTicker(interval(g.config.DetachRate))
+		defer ticker.Stop()
+
+		detach = ticker.C
+	}
+
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-attach.C:
+			g.startAttach()
+		case <-detach:
+			g.startDetach()
+		}
+	}
+
+	g.wg.Wait()
+
+	if g.config.DetachAll {
+		for g.startDetach() {
+			g.wg.Wait()
+		}
+	}
+
+	g.report.Elapsed = time.Since(start)
+
+	return g.report
+}
+
+func interval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// Subscriber creates the synthetic subscriber with the given index.
+func (c *Config) Subscriber(index int64) *sgwsim.Subscriber {
+	subscriber := sgwsim.NewSubscriber(addDigits(c.IMSIBase, index), hostIP(c.Subnet, index).String())
+	subscriber.MSISDN = addDigits(c.MSISDNBase, index)
+
+	if c.APN != "" {
+		subscriber.APN = c.APN
+	}
+
+	return subscriber
+}
+
+// addDigits adds the index to a numeric identity keeping its length.
+func addDigits(base string, index int64) string {
+	value, _ := new(big.Int).SetString(base, 10)
+	digits := value.Add(value, big.NewInt(index)).String()
+
+	for len(digits) < len(base) {
+		digits = "0" + digits
+	}
+
+	return digits[len(digits)-len(base):]
+}
+
+// hostIP retrieves an usable host address of the subnet, wrapping around when it's exhausted.
+func hostIP(subnet *net.IPNet, index int64) net.IP {
+	ones, bits := subnet.Mask.Size()
+	hosts := int64(1)<<(bits-ones) - 2
+
+	if hosts < 1 {
+		hosts = 1
+	}
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+uint32(index%hosts)+1)
+
+	return ip
+}
+
+func (g *Generator) startAttach() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.config.MaxSessions > 0 && len(g.active) >= g.config.MaxSessions {
+		return
+	}
+
+	subscriber := g.config.Subscriber(g.next)
+	g.next++
+
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		start := time.Now()
+		session, response, err := g.sgw.CreateSession(subscriber)
+		latency := time.Since(start)
+
+		var cause uint8
+		if response != nil {
+			cause = sgwsim.Cause(response.Cause)
+		}
+
+		timeout := errors.Is(err, sgwsim.ErrTimeout)
+		g.report.Attach.Record(latency, cause, timeout, response == nil && !timeout)
+
+		if err != nil {
+			log.WithError(err).Debugf("%s attach failed", subscriber.IMSI)
+
+			return
+		}
+
+		g.mutex.Lock()
+		g.active = append(g.active, session)
+
+		if len(g.active) > g.report.MaxActive {
+			g.report.MaxActive = len(g.active)
+		}
+		g.mutex.Unlock()
+	}()
+}
+
+// startDetach releases the oldest session and reports if there was one.
+func (g *Generator) startDetach() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.active) == 0 {
+		return false
+	}
+
+	session := g.active[0]
+	g.active = g.active[1:]
+
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		start := time.Now()
+		response, err := g.sgw.DeleteSession(session)
+		latency := time.Since(start)
+
+		var cause uint8
+		if response != nil {
+			cause = sgwsim.Cause(response.Cause)
+		}
+
+		timeout := errors.Is(err, sgwsim.ErrTimeout)
+		g.report.Detach.Record(latency, cause, timeout, response == nil && !timeout)
+
+		if err != nil {
+			log.WithError(err).Debugf("%s detach failed", session.Subscriber.IMSI)
+		}
+	}()
+
+	return true
+}
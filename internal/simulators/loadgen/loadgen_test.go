@@ -0,0 +1,111 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen_test
+
+import (
+	"net"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/simulators/loadgen"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+)
+
+var _ = Describe("Load generator", func() {
+	var config *loadgen.Config
+
+	BeforeEach(func() {
+		_, subnet, _ := net.ParseCIDR("10.0.0.0/30")
+		config = &loadgen.Config{
+			AttachRate: 10,
+			Duration:   time.Second,
+			IMSIBase:   "001010000000009",
+			MSISDNBase: "814000000009",
+			Subnet:     subnet,
+		}
+	})
+
+	Describe("validating the configuration", func() {
+		It("should accept a complete configuration", func() {
+			Expect(config.Validate()).To(Succeed())
+		})
+		It("should reject a missing attach rate", func() {
+			config.AttachRate = 0
+
+			Expect(config.Validate()).To(MatchError(loadgen.ErrInvalidConfig))
+		})
+		It("should reject a non numeric IMSI base", func() {
+			config.IMSIBase = "imsi"
+
+			Expect(config.Validate()).To(MatchError(loadgen.ErrInvalidConfig))
+		})
+		It("should reject a missing UE subnet", func() {
+			config.Subnet = nil
+
+			Expect(config.Validate()).To(MatchError(loadgen.ErrInvalidConfig))
+		})
+	})
+
+	Describe("generating subscribers", func() {
+		It("should increase the identities keeping their length", func() {
+			subscriber := config.Subscriber(1)
+
+			Expect(subscriber.IMSI).To(Equal("001010000000010"))
+			Expect(subscriber.MSISDN).To(Equal("814000000010"))
+			Expect(subscriber.APN).To(Equal("internet"))
+		})
+		It("should reuse the host addresses of the UE subnet", func() {
+			Expect(config.Subscriber(0).IP).To(Equal("10.0.0.1"))
+			Expect(config.Subscriber(1).IP).To(Equal("10.0.0.2"))
+			Expect(config.Subscriber(2).IP).To(Equal("10.0.0.1"))
+		})
+	})
+})
+
+var _ = Describe("Stats", func() {
+	var stats *loadgen.Stats
+
+	BeforeEach(func() {
+		stats = loadgen.NewStats()
+	})
+
+	It("should have no latency without answered requests", func() {
+		stats.Record(time.Second, 0, true, false)
+
+		Expect(stats.Percentile(99)).To(BeZero())
+		Expect(stats.Timeouts).To(Equal(1))
+	})
+	It("should calculate the latency percentiles", func() {
+		for i := 1; i <= 100; i++ {
+			stats.Record(time.Duration(i)*time.Millisecond, gtpv2.CauseRequestAccepted, false, false)
+		}
+
+		Expect(stats.Percentile(50)).To(Equal(50 * time.Millisecond))
+		Expect(stats.Percentile(99)).To(Equal(99 * time.Millisecond))
+		Expect(stats.Percentile(100)).To(Equal(100 * time.Millisecond))
+		Expect(stats.Requests).To(Equal(100))
+	})
+	It("should count the answered requests by cause", func() {
+		stats.Record(time.Millisecond, gtpv2.CauseRequestAccepted, false, false)
+		stats.Record(time.Millisecond, gtpv2.CauseAllDynamicAddressesAreOccupied, false, false)
+		stats.Record(time.Millisecond, 0, false, true)
+
+		Expect(stats.Causes).To(Equal(map[uint8]int{
+			gtpv2.CauseRequestAccepted:                1,
+			gtpv2.CauseAllDynamicAddressesAreOccupied: 1,
+		}))
+		Expect(stats.Errors).To(Equal(1))
+	})
+})
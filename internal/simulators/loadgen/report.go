@@ -0,0 +1,120 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats aggregates the results of the requests of a GTP-C procedure.
+type Stats struct {
+	mutex     sync.Mutex
+	Requests  int
+	Timeouts  int
+	Errors    int
+	Causes    map[uint8]int
+	latencies []time.Duration
+}
+
+// NewStats creates an empty set of statistics.
+func NewStats() *Stats {
+	return &Stats{Causes: map[uint8]int{}}
+}
+
+// Record adds the outcome of a request, answered requests are accounted by cause.
+func (s *Stats) Record(latency time.Duration, cause uint8, timeout, failed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Requests++
+
+	switch {
+	case timeout:
+		s.Timeouts++
+	case failed:
+		s.Errors++
+	default:
+		s.Causes[cause]++
+		s.latencies = append(s.latencies, latency)
+	}
+}
+
+// Percentile retrieves the latency below which the given percentage of the answered requests fall.
+func (s *Stats) Percentile(percentage float64) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.latencies) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(float64(len(sorted))*percentage/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+
+	return sorted[index]
+}
+
+// Report stores the results of a load test.
+type Report struct {
+	Attach    *Stats
+	Detach    *Stats
+	Elapsed   time.Duration
+	MaxActive int
+}
+
+func newReport() *Report {
+	return &Report{Attach: NewStats(), Detach: NewStats()}
+}
+
+// Print writes a human readable summary of the report.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Elapsed: %s, max active sessions: %d\n", r.Elapsed.Round(time.Millisecond), r.MaxActive)
+
+	for _, procedure := range []struct {
+		name  string
+		stats *Stats
+	}{{"Create Session", r.Attach}, {"Delete Session", r.Detach}} {
+		stats := procedure.stats
+		rate := float64(stats.Requests) / r.Elapsed.Seconds()
+
+		fmt.Fprintf(w, "%s: %d requests (%.1f/s), %d timeouts, %d errors\n", procedure.name,
+			stats.Requests, rate, stats.Timeouts, stats.Errors)
+		fmt.Fprintf(w, "  latency p50=%s p90=%s p99=%s max=%s\n", stats.Percentile(50),
+			stats.Percentile(90), stats.Percentile(99), stats.Percentile(100))
+
+		causes := make([]int, 0, len(stats.Causes))
+		for cause := range stats.Causes {
+			causes = append(causes, int(cause))
+		}
+
+		sort.Ints(causes)
+
+		for _, cause := range causes {
+			fmt.Fprintf(w, "  cause %d: %d\n", cause, stats.Causes[uint8(cause)])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sgwsim
+
+import (
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// Subscriber stores the identities and the PDN connection requested by an UE.
+type Subscriber struct {
+	IMSI   string
+	MSISDN string
+	MEI    string
+	MCC    string
+	MNC    string
+	APN    string
+	IP     string
+	EBI    uint8
+	// AMBR is the APN Aggregate Maximum Bit Rate expressed in kbps.
+	AMBRUplink   uint32
+	AMBRDownlink uint32
+	// MBR and GBR are the bit rates of the default bearer expressed in kbps.
+	MBRUplink   uint64
+	MBRDownlink uint64
+	GBRUplink   uint64
+	GBRDownlink uint64
+	// ExtraIEs are appended to the Create Session Request.
+	ExtraIEs []*ie.IE
+	// BearerIEs are appended to the context of the default bearer.
+	BearerIEs []*ie.IE
+}
+
+// NewSubscriber creates a subscriber with the default values of the simulator.
+func NewSubscriber(imsi, ip string) *Subscriber {
+	return &Subscriber{
+		IMSI:   imsi,
+		MSISDN: "814000000000",
+		MEI:    "123450000000000",
+		MCC:    "440",
+		MNC:    "10",
+		APN:    "internet",
+		IP:     ip,
+		EBI:    5,
+	}
+}
+
+// Session stores the tunnel endpoints of an attached subscriber.
+type Session struct {
+	Subscriber    *Subscriber
+	LocalTEIDC    uint32
+	LocalTEIDU    uint32
+	LocalAddress  string
+	RemoteTEIDC   uint32
+	RemoteTEIDU   uint32
+	RemoteAddress string
+}
+
+func (s *Subscriber) newCreateSessionRequest(controlIP string, session *Session) *message.CreateSessionRequest {
+	ies := []*ie.IE{
+		ie.NewIMSI(s.IMSI),
+		ie.NewMSISDN(s.MSISDN),
+		ie.NewMobileEquipmentIdentity(s.MEI),
+		ie.NewServingNetwork(s.MCC, s.MNC),
+		ie.NewRATType(gtpv2.RATTypeEUTRAN),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPC, session.LocalTEIDC, controlIP, ""),
+		ie.NewAccessPointName(s.APN),
+		ie.NewSelectionMode(gtpv2.SelectionModeMSorNetworkProvidedAPNSubscribedVerified),
+		ie.NewPDNType(gtpv2.PDNTypeIPv4),
+		ie.NewPDNAddressAllocation(s.IP),
+		ie.NewAPNRestriction(gtpv2.APNRestrictionNoExistingContextsorRestriction),
+		ie.NewBearerContext(append([]*ie.IE{
+			ie.NewEPSBearerID(s.EBI),
+			ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPU, session.LocalTEIDU, session.LocalAddress,
+				"").WithInstance(2),
+			ie.NewBearerQoS(1, 2, 1, 9, s.MBRUplink, s.MBRDownlink, s.GBRUplink, s.GBRDownlink),
+		}, s.BearerIEs...)...),
+	}
+
+	if s.AMBRUplink != 0 || s.AMBRDownlink != 0 {
+		ies = append(ies, ie.NewAggregateMaximumBitRate(s.AMBRUplink, s.AMBRDownlink))
+	}
+
+	return message.NewCreateSessionRequest(0, 0, append(ies, s.ExtraIEs...)...)
+}
+
+func (s *Session) parseCreateSessionResponse(response *message.CreateSessionResponse) error {
+	if response.PGWS5S8FTEIDC == nil {
+		return errors.Wrap(ErrUnexpectedResponse, "no P-GW S5/S8-C F-TEID")
+	}
+
+	s.RemoteTEIDC = response.PGWS5S8FTEIDC.MustTEID()
+
+	if response.BearerContextsCreated == nil {
+		return errors.Wrap(ErrUnexpectedResponse, "no bearer context created")
+	}
+
+	for _, childIE := range response.BearerContextsCreated.ChildIEs {
+		if childIE.Type != ie.FullyQualifiedTEID {
+			continue
+		}
+
+		if it, err := childIE.InterfaceType(); err != nil || it != gtpv2.IFTypeS5S8PGWGTPU {
+			continue
+		}
+
+		s.RemoteTEIDU = childIE.MustTEID()
+
+		ip, err := childIE.IPAddress()
+		if err != nil {
+			return errors.Wrap(err, "failed to get P-GW S5/S8-U address")
+		}
+
+		s.RemoteAddress = ip
+	}
+
+	if s.RemoteTEIDU == 0 {
+		return errors.Wrap(ErrUnexpectedResponse, "no P-GW S5/S8-U F-TEID")
+	}
+
+	return nil
+}
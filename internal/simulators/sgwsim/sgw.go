@@ -0,0 +1,307 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sgwsim
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+// DefaultTimeout is the time waited for the responses of the P-GW.
+const DefaultTimeout = time.Duration(3) * time.Second
+
+var (
+	// ErrTimeout indicates that the P-GW didn't answer the request in time.
+	ErrTimeout = errors.New("response timeout")
+	// ErrUnexpectedResponse indicates that the P-GW answered with another message type.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+	// ErrRejected indicates that the P-GW didn't accept the request.
+	ErrRejected = errors.New("request rejected")
+)
+
+// SGW plays the S-GW role of the S5/S8 interface against a P-GW.
+type SGW struct {
+	mutex      sync.Mutex
+	connection *gtpv2.Conn
+	pgw        net.Addr
+	controlIP  string
+	userIP     string
+	pending    map[uint32]chan message.Message
+	sessions   map[uint32]*Session
+
+	// Timeout is the time waited for every response.
+	Timeout time.Duration
+}
+
+// New creates a S-GW which listens on the local address and sends its requests to the P-GW.
+// The P-GW has to answer the initial Echo Request.
+func New(ctx context.Context, localAddress, pgwAddress string) (*SGW, error) {
+	laddr, err := net.ResolveUDPAddr("udp", localAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %s local address", localAddress)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", pgwAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %s P-GW address", pgwAddress)
+	}
+
+	conn, err := gtpv2.Dial(ctx, laddr, raddr, gtpv2.IFTypeS5S8SGWGTPC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reach the P-GW")
+	}
+
+	// responses carry the TEIDs allocated by the simulator, which aren't registered sessions.
+	conn.DisableValidation()
+
+	sgw := &SGW{
+		connection: conn,
+		pgw:        raddr,
+		controlIP:  laddr.IP.String(),
+		userIP:     laddr.IP.String(),
+		pending:    map[uint32]chan message.Message{},
+		sessions:   map[uint32]*Session{},
+		Timeout:    DefaultTimeout,
+	}
+
+	for _, msgType := range []uint8{
+		message.MsgTypeEchoResponse,
+		message.MsgTypeCreateSessionResponse,
+		message.MsgTypeModifyBearerResponse,
+		message.MsgTypeDeleteSessionResponse,
+	} {
+		conn.AddHandler(msgType, sgw.deliver)
+	}
+
+	conn.AddHandler(message.MsgTypeDeleteBearerRequest, sgw.deleteBearer)
+
+	return sgw, nil
+}
+
+// LocalAddr retrieves the S5/S8-C address of the simulator.
+func (s *SGW) LocalAddr() net.Addr {
+	return s.connection.LocalAddr()
+}
+
+// Close stops serving the S5/S8-C interface.
+func (s *SGW) Close() error {
+	return errors.Wrap(s.connection.Close(), "failed to close S-GW connection")
+}
+
+// Echo checks that the P-GW is alive and retrieves its restart counter.
+func (s *SGW) Echo() (uint8, error) {
+	msg, err := s.request(message.NewEchoRequest(0, ie.NewRecovery(s.connection.RestartCounter)))
+	if err != nil {
+		return 0, err
+	}
+
+	response, ok := msg.(*message.EchoResponse)
+	if !ok {
+		return 0, errors.Wrapf(ErrUnexpectedResponse, "%s message", msg.MessageTypeName())
+	}
+
+	if response.Recovery == nil {
+		return 0, errors.Wrap(ErrUnexpectedResponse, "no recovery")
+	}
+
+	counter, err := response.Recovery.Recovery()
+
+	return counter, errors.Wrap(err, "failed to get the restart counter")
+}
+
+// CreateSession attaches the subscriber. The session is only returned when the P-GW accepts it.
+func (s *SGW) CreateSession(subscriber *Subscriber) (*Session, *message.CreateSessionResponse, error) {
+	session := &Session{
+		Subscriber:   subscriber,
+		LocalTEIDC:   s.connection.NewSenderFTEID(s.controlIP, "").MustTEID(),
+		LocalTEIDU:   s.connection.NewSenderFTEID(s.userIP, "").MustTEID(),
+		LocalAddress: s.userIP,
+	}
+
+	msg, err := s.request(subscriber.newCreateSessionRequest(s.controlIP, session))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, ok := msg.(*message.CreateSessionResponse)
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrUnexpectedResponse, "%s message", msg.MessageTypeName())
+	}
+
+	if err := checkCause(response.Cause); err != nil {
+		return nil, response, err
+	}
+
+	if err := session.parseCreateSessionResponse(response); err != nil {
+		return nil, response, err
+	}
+
+	s.mutex.Lock()
+	s.sessions[session.LocalTEIDC] = session
+	s.mutex.Unlock()
+
+	return session, response, nil
+}
+
+// ModifyBearer moves the default bearer of the session to a new S-GW user plane endpoint.
+func (s *SGW) ModifyBearer(session *Session, userAddress string, teid uint32) (*message.ModifyBearerResponse,
+	error,
+) {
+	msg, err := s.request(message.NewModifyBearerRequest(session.RemoteTEIDC, 0,
+		ie.NewBearerContext(
+			ie.NewEPSBearerID(session.Subscriber.EBI),
+			ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8SGWGTPU, teid, userAddress, "").WithInstance(1),
+		),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	response, ok := msg.(*message.ModifyBearerResponse)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "%s message", msg.MessageTypeName())
+	}
+
+	if err := checkCause(response.Cause); err != nil {
+		return response, err
+	}
+
+	session.LocalTEIDU = teid
+	session.LocalAddress = userAddress
+
+	return response, nil
+}
+
+// DeleteSession detaches the subscriber of the session.
+func (s *SGW) DeleteSession(session *Session) (*message.DeleteSessionResponse, error) {
+	msg, err := s.request(message.NewDeleteSessionRequest(session.RemoteTEIDC, 0,
+		ie.NewEPSBearerID(session.Subscriber.EBI)))
+	if err != nil {
+		return nil, err
+	}
+
+	response, ok := msg.(*message.DeleteSessionResponse)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "%s message", msg.MessageTypeName())
+	}
+
+	s.mutex.Lock()
+	delete(s.sessions, session.LocalTEIDC)
+	s.mutex.Unlock()
+
+	return response, checkCause(response.Cause)
+}
+
+// HasSession indicates if the session is still attached, it's detached when the P-GW deletes
+// its default bearer.
+func (s *SGW) HasSession(session *Session) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.sessions[session.LocalTEIDC]
+
+	return ok
+}
+
+// request sends a message to the P-GW and waits for the response with the same sequence number.
+func (s *SGW) request(msg message.Message) (message.Message, error) {
+	responses := make(chan message.Message, 1)
+
+	// the lock is kept until the sequence is registered, so responses can't be delivered before.
+	s.mutex.Lock()
+	seq, err := s.connection.SendMessageTo(msg, s.pgw)
+	if err != nil {
+		s.mutex.Unlock()
+
+		return nil, errors.Wrapf(err, "failed to send %s", msg.MessageTypeName())
+	}
+	s.pending[seq] = responses
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, seq)
+		s.mutex.Unlock()
+	}()
+
+	select {
+	case response := <-responses:
+		return response, nil
+	case <-time.After(s.Timeout):
+		return nil, errors.Wrapf(ErrTimeout, "%s with %d sequence", msg.MessageTypeName(), seq)
+	}
+}
+
+func (s *SGW) deliver(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if responses, ok := s.pending[msg.Sequence()]; ok {
+		responses <- msg
+	}
+
+	return nil
+}
+
+// deleteBearer accepts the Delete Bearer Requests sent by the P-GW. Only the default bearers are
+// tracked, so their deletion detaches the whole session.
+func (s *SGW) deleteBearer(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	s.mutex.Lock()
+	session, ok := s.sessions[msg.TEID()]
+	delete(s.sessions, msg.TEID())
+	s.mutex.Unlock()
+
+	if !ok {
+		response := message.NewDeleteBearerResponse(0, 0,
+			ie.NewCause(gtpv2.CauseContextNotFound, 0, 0, 0, nil))
+
+		return errors.Wrap(conn.RespondTo(sender, msg, response), "failed to reject the delete bearer request")
+	}
+
+	response := message.NewDeleteBearerResponse(session.RemoteTEIDC, 0,
+		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		ie.NewEPSBearerID(session.Subscriber.EBI),
+	)
+
+	return errors.Wrap(conn.RespondTo(sender, msg, response), "failed to send a delete bearer response")
+}
+
+// Cause retrieves the value of a Cause IE, or zero when it's missing.
+func Cause(cause *ie.IE) uint8 {
+	if cause == nil {
+		return 0
+	}
+
+	value, err := cause.Cause()
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+func checkCause(cause *ie.IE) error {
+	if value := Cause(cause); value != gtpv2.CauseRequestAccepted {
+		return errors.Wrapf(ErrRejected, "%d cause", value)
+	}
+
+	return nil
+}
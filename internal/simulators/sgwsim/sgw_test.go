@@ -0,0 +1,281 @@
+/*
+Copyright 2021
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sgwsim_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gw-tester/pgw/internal/simulators/sgwsim"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wmnsk/go-gtp/gtpv2"
+	"github.com/wmnsk/go-gtp/gtpv2/ie"
+	"github.com/wmnsk/go-gtp/gtpv2/message"
+)
+
+const (
+	pgwTEIDC = 0x100
+	pgwTEIDU = 0x200
+	// rejectedIMSI and droppedIMSI select the answer of the P-GW stub.
+	rejectedIMSI = "123451234567890"
+	droppedIMSI  = "123451234567899"
+)
+
+// pgwStub answers the S-GW requests with fixed tunnel endpoints and keeps the last ones received.
+type pgwStub struct {
+	mutex     sync.Mutex
+	conn      *gtpv2.Conn
+	requests  map[uint8]message.Message
+	responses chan message.Message
+}
+
+func (p *pgwStub) record(msg message.Message) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.requests[msg.MessageType()] = msg
+}
+
+// Request returns the last request of the given type.
+func (p *pgwStub) Request(msgType uint8) message.Message {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.requests[msgType]
+}
+
+func (p *pgwStub) createSession(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	p.record(msg)
+
+	request, _ := msg.(*message.CreateSessionRequest)
+	imsi := request.IMSI.MustIMSI()
+
+	if imsi == droppedIMSI {
+		return nil
+	}
+
+	teid := request.SenderFTEIDC.MustTEID()
+	if imsi == rejectedIMSI {
+		return conn.RespondTo(sender, msg, message.NewCreateSessionResponse(teid, 0,
+			ie.NewCause(gtpv2.CauseUserAuthenticationFailed, 0, 0, 0, nil)))
+	}
+
+	return conn.RespondTo(sender, msg, message.NewCreateSessionResponse(teid, 0,
+		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPC, pgwTEIDC, "127.0.0.1", "").WithInstance(1),
+		ie.NewPDNAddressAllocation(request.PAA.MustIPAddress()),
+		ie.NewBearerContext(
+			ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+			ie.NewEPSBearerID(5),
+			ie.NewFullyQualifiedTEID(gtpv2.IFTypeS5S8PGWGTPU, pgwTEIDU, "127.0.0.1", "").WithInstance(2),
+		),
+	))
+}
+
+func (p *pgwStub) modifyBearer(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	p.record(msg)
+
+	return conn.RespondTo(sender, msg, message.NewModifyBearerResponse(0, 0,
+		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil),
+		ie.NewBearerContext(ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil), ie.NewEPSBearerID(5)),
+	))
+}
+
+func (p *pgwStub) deleteSession(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	p.record(msg)
+
+	return conn.RespondTo(sender, msg, message.NewDeleteSessionResponse(0, 0,
+		ie.NewCause(gtpv2.CauseRequestAccepted, 0, 0, 0, nil)))
+}
+
+func (p *pgwStub) deleteBearer(conn *gtpv2.Conn, sender net.Addr, msg message.Message) error {
+	p.responses <- msg
+
+	return nil
+}
+
+// startPGW serves the P-GW stub on a free port until the context is done.
+func startPGW(ctx context.Context) (*pgwStub, *net.UDPAddr) {
+	// The address is released before serving, the connection doesn't expose the bound port safely
+	free, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	Expect(err).NotTo(HaveOccurred())
+
+	laddr, _ := free.LocalAddr().(*net.UDPAddr)
+	Expect(free.Close()).To(Succeed())
+
+	stub := &pgwStub{
+		conn:      gtpv2.NewConn(laddr, gtpv2.IFTypeS5S8PGWGTPC, 3),
+		requests:  map[uint8]message.Message{},
+		responses: make(chan message.Message, 1),
+	}
+	// The tunnel endpoints of the stub aren't registered sessions
+	stub.conn.DisableValidation()
+	stub.conn.AddHandler(message.MsgTypeCreateSessionRequest, stub.createSession)
+	stub.conn.AddHandler(message.MsgTypeModifyBearerRequest, stub.modifyBearer)
+	stub.conn.AddHandler(message.MsgTypeDeleteSessionRequest, stub.deleteSession)
+	stub.conn.AddHandler(message.MsgTypeDeleteBearerResponse, stub.deleteBearer)
+
+	go func() {
+		_ = stub.conn.ListenAndServe(ctx)
+	}()
+
+	return stub, laddr
+}
+
+// dialPGW creates a S-GW once the P-GW answers the Echo Requests, the S-GW waits several seconds
+// for the answer of its first one.
+func dialPGW(ctx context.Context, pgw *net.UDPAddr) *sgwsim.SGW {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	Expect(err).NotTo(HaveOccurred())
+
+	defer probe.Close()
+
+	echo, err := message.NewEchoRequest(0, ie.NewRecovery(0)).Marshal()
+	Expect(err).NotTo(HaveOccurred())
+
+	buf := make([]byte, 1500)
+
+	Eventually(func() error {
+		if _, err := probe.WriteTo(echo, pgw); err != nil {
+			return err
+		}
+
+		if err := probe.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			return err
+		}
+
+		_, _, err := probe.ReadFrom(buf)
+
+		return err
+	}, 10*time.Second).Should(Succeed())
+
+	sgw, err := sgwsim.New(ctx, "127.0.0.1:0", pgw.String())
+	Expect(err).NotTo(HaveOccurred())
+
+	return sgw
+}
+
+var _ = Describe("SGW", func() {
+	var (
+		cancel context.CancelFunc
+		pgw    *pgwStub
+		sgw    *sgwsim.SGW
+	)
+
+	BeforeEach(func() {
+		var (
+			ctx   context.Context
+			laddr *net.UDPAddr
+		)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		pgw, laddr = startPGW(ctx)
+		sgw = dialPGW(ctx, laddr)
+	})
+
+	AfterEach(func() {
+		Expect(sgw.Close()).To(Succeed())
+		cancel()
+	})
+
+	Describe("sending Echo Requests", func() {
+		It("should receive the P-GW restart counter", func() {
+			counter, err := sgw.Echo()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(counter).To(Equal(uint8(3)))
+		})
+	})
+
+	Describe("attaching a subscriber", func() {
+		It("should request the PDN connection of the subscriber", func() {
+			subscriber := sgwsim.NewSubscriber("123451234567891", "10.0.1.2")
+			subscriber.AMBRUplink, subscriber.AMBRDownlink = 1000, 2000
+			subscriber.ExtraIEs = []*ie.IE{ie.NewProtocolConfigurationOptions(gtpv2.ConfigProtocolPPPWithIP)}
+
+			_, _, err := sgw.CreateSession(subscriber)
+			Expect(err).NotTo(HaveOccurred())
+
+			request, ok := pgw.Request(message.MsgTypeCreateSessionRequest).(*message.CreateSessionRequest)
+			Expect(ok).To(BeTrue())
+			Expect(request.IMSI.MustIMSI()).To(Equal("123451234567891"))
+			Expect(request.APN.MustAccessPointName()).To(Equal("internet"))
+			Expect(request.PAA.MustIPAddress()).To(Equal("10.0.1.2"))
+			Expect(request.AMBR.AggregateMaximumBitRateUp()).To(Equal(uint32(1000)))
+			Expect(request.AMBR.AggregateMaximumBitRateDown()).To(Equal(uint32(2000)))
+			Expect(request.PCO).NotTo(BeNil())
+		})
+		It("should keep the P-GW tunnel endpoints", func() {
+			session, response, err := sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseRequestAccepted))
+			Expect(session.RemoteTEIDC).To(Equal(uint32(pgwTEIDC)))
+			Expect(session.RemoteTEIDU).To(Equal(uint32(pgwTEIDU)))
+			Expect(session.RemoteAddress).To(Equal("127.0.0.1"))
+			Expect(sgw.HasSession(session)).To(BeTrue())
+		})
+		It("should return the cause of the rejections", func() {
+			session, response, err := sgw.CreateSession(sgwsim.NewSubscriber(rejectedIMSI, "10.0.1.2"))
+			Expect(err).To(MatchError(sgwsim.ErrRejected))
+			Expect(session).To(BeNil())
+			Expect(sgwsim.Cause(response.Cause)).To(Equal(gtpv2.CauseUserAuthenticationFailed))
+		})
+		It("should time out when the P-GW doesn't answer", func() {
+			sgw.Timeout = 100 * time.Millisecond
+
+			_, _, err := sgw.CreateSession(sgwsim.NewSubscriber(droppedIMSI, "10.0.1.2"))
+			Expect(err).To(MatchError(sgwsim.ErrTimeout))
+		})
+	})
+
+	Context("when the subscriber is attached", func() {
+		var session *sgwsim.Session
+
+		BeforeEach(func() {
+			var err error
+			session, _, err = sgw.CreateSession(sgwsim.NewSubscriber("123451234567891", "10.0.1.2"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should move the user plane endpoint of the bearer", func() {
+			response, err := sgw.ModifyBearer(session, "127.0.0.2", 1234)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgwsim.Cause(response.BearerContextsModified.ChildIEs[0])).To(Equal(gtpv2.CauseRequestAccepted))
+			Expect(session.LocalTEIDU).To(Equal(uint32(1234)))
+			Expect(session.LocalAddress).To(Equal("127.0.0.2"))
+
+			request, ok := pgw.Request(message.MsgTypeModifyBearerRequest).(*message.ModifyBearerRequest)
+			Expect(ok).To(BeTrue())
+			Expect(request.TEID()).To(Equal(uint32(pgwTEIDC)))
+		})
+		It("should detach the subscriber", func() {
+			_, err := sgw.DeleteSession(session)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sgw.HasSession(session)).To(BeFalse())
+			Expect(pgw.Request(message.MsgTypeDeleteSessionRequest).TEID()).To(Equal(uint32(pgwTEIDC)))
+		})
+		It("should accept the deletion of the default bearer", func() {
+			_, err := pgw.conn.SendMessageTo(message.NewDeleteBearerRequest(session.LocalTEIDC, 0,
+				ie.NewEPSBearerID(5)), sgw.LocalAddr())
+			Expect(err).NotTo(HaveOccurred())
+
+			var response message.Message
+			Eventually(pgw.responses).Should(Receive(&response))
+			Expect(response.TEID()).To(Equal(uint32(pgwTEIDC)))
+			Expect(sgw.HasSession(session)).To(BeFalse())
+		})
+	})
+})